@@ -1 +0,0 @@
-ALTER TABLE task ADD COLUMN IF NOT EXISTS spark_extension JSONB;
@@ -1 +0,0 @@
-ALTER TABLE task RENAME COLUMN executable_request_custom to execution_request_custom;
\ No newline at end of file
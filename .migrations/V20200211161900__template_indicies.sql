@@ -1,2 +0,0 @@
-CREATE INDEX IF NOT EXISTS ix_template_id ON template(template_id);
-CREATE INDEX IF NOT EXISTS ix_template_name ON template(template_name);
@@ -1,2 +0,0 @@
-ALTER TABLE template ADD COLUMN default_payload JSONB;
-ALTER TABLE template ADD COLUMN avatar_uri VARCHAR;
\ No newline at end of file
@@ -1 +0,0 @@
-ALTER TABLE template RENAME COLUMN default_payload to defaults;
\ No newline at end of file
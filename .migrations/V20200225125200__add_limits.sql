@@ -1,2 +0,0 @@
-ALTER TABLE task ADD COLUMN memory_limit integer;
-ALTER TABLE task ADD COLUMN cpu_limit integer;
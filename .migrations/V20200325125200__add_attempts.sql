@@ -1 +0,0 @@
-ALTER TABLE task ADD COLUMN attempt_count integer;
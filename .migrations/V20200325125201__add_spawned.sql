@@ -1 +0,0 @@
-ALTER TABLE task ADD COLUMN spawned_runs jsonb;
@@ -1 +0,0 @@
-ALTER TABLE task ADD COLUMN run_exceptions jsonb;
@@ -1 +0,0 @@
-ALTER TABLE task ADD COLUMN IF NOT EXISTS metrics_uri varchar;
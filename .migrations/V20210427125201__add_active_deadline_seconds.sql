@@ -1 +0,0 @@
-ALTER TABLE task ADD COLUMN active_deadline_seconds integer;
@@ -1 +0,0 @@
-alter table task_def alter column container_name drop not null;
@@ -31,10 +31,12 @@ type RequestExecutor interface {
 	Do(req *http.Request, timeout time.Duration, entity interface{}) error
 }
 
-type defaultExecutor struct{}
+type defaultExecutor struct {
+	transport http.RoundTripper
+}
 
 func (de *defaultExecutor) Do(req *http.Request, timeout time.Duration, entity interface{}) error {
-	client := http.Client{Timeout: timeout}
+	client := http.Client{Timeout: timeout, Transport: de.transport}
 	if client.Timeout == 0 {
 		client.Timeout = time.Second * 10
 	}
@@ -47,6 +49,10 @@ func (de *defaultExecutor) Do(req *http.Request, timeout time.Duration, entity i
 		return err
 	}
 	if r.StatusCode >= 200 && r.StatusCode < 400 {
+		if entity == nil {
+			// The caller doesn't read the response body
+			return nil
+		}
 		return json.NewDecoder(r.Body).Decode(entity)
 	} else if r.StatusCode >= 500 {
 		return HttpRetryableError{fmt.Errorf("Error response: %v", r.Status)}
@@ -61,6 +67,8 @@ type Client struct {
 	Timeout    time.Duration
 	RetryCount int
 	Executor   RequestExecutor
+	// Transport of the default executor; http.DefaultTransport when nil
+	Transport http.RoundTripper
 }
 
 func (c *Client) Get(path string, headers map[string]string, entity interface{}) error {
@@ -150,7 +158,7 @@ func (c *Client) makeRequest(method, path string, headers map[string]string, bod
 
 func (c *Client) doRequestWithRetry(req *http.Request, entity interface{}) error {
 	if c.Executor == nil {
-		c.Executor = &defaultExecutor{}
+		c.Executor = &defaultExecutor{transport: c.Transport}
 	}
 	err := c.retryRequest(3*time.Second, func() error {
 		return c.Executor.Do(req, c.Timeout, entity)
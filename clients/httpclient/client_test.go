@@ -145,3 +145,15 @@ func TestClientDo(t *testing.T) {
 		t.Errorf("Expected err to be nil got %s", err.Error())
 	}
 }
+
+func TestClientDoWithoutEntity(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testServer.Close()
+
+	client := &Client{Host: testServer.URL}
+	if err := client.Post("/", map[string]string{"Content-Type": "application/json"}, Cupcake{}, nil); err != nil {
+		t.Errorf("Expected an empty response to be accepted without an entity but got error %s", err.Error())
+	}
+}
@@ -0,0 +1,134 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// CompositeLogsClient reads logs from the first of several backends that
+// has them, eg. CloudWatch for live runs and S3 for archived ones. Cursors
+// are prefixed with the name of the backend they came from, so paging
+// through a log stays on that backend.
+//
+type CompositeLogsClient struct {
+	names   []string
+	clients []Client
+}
+
+//
+// Name returns the name of the logs client
+//
+func (lc *CompositeLogsClient) Name() string {
+	return "composite"
+}
+
+//
+// Initialize sets up the backends listed in logs.composite.clients
+//
+func (lc *CompositeLogsClient) Initialize(conf config.Config) error {
+	names := conf.GetStringSlice("logs.composite.clients")
+	if len(names) == 0 {
+		return errors.Errorf("CompositeLogsClient needs [logs.composite.clients] set in config")
+	}
+	for _, name := range names {
+		if name == "composite" {
+			return errors.Errorf("CompositeLogsClient can't contain another composite client")
+		}
+		client, err := newLogsBackend(conf, name)
+		if err != nil {
+			return err
+		}
+		lc.names = append(lc.names, name)
+		lc.clients = append(lc.clients, client)
+	}
+	return nil
+}
+
+//
+// Logs returns the logs after lastSeen from the backend the cursor came
+// from, or without one from the first backend that returns logs
+//
+func (lc *CompositeLogsClient) Logs(executable state.Executable, run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
+	if lastSeen != nil {
+		for i, name := range lc.names {
+			if cursor := strings.TrimPrefix(*lastSeen, name+":"); cursor != *lastSeen {
+				log, next, err := lc.clients[i].Logs(executable, run, &cursor, role, facility)
+				return log, lc.tag(name, next), err
+			}
+		}
+	}
+
+	var err error
+	for i, name := range lc.names {
+		var (
+			log  string
+			next *string
+		)
+		if log, next, err = lc.clients[i].Logs(executable, run, lastSeen, role, facility); err == nil {
+			return log, lc.tag(name, next), nil
+		}
+	}
+	return "", nil, err
+}
+
+//
+// LogsText writes the whole log from the first backend that has it
+//
+func (lc *CompositeLogsClient) LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error {
+	var err error
+	for _, client := range lc.clients {
+		if err = client.LogsText(executable, run, w); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//
+// LogsReader returns a reader of the log from the first backend that has it
+//
+func (lc *CompositeLogsClient) LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error) {
+	var err error
+	for _, client := range lc.clients {
+		var r io.ReadCloser
+		if r, err = client.LogsReader(executable, run, role, facility); err == nil {
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+//
+// TailReader returns a reader of the end of the log from the first backend
+// that has it; backends that can't tail read the whole log
+//
+func (lc *CompositeLogsClient) TailReader(executable state.Executable, run state.Run, role *string, facility *string, n int64) (io.ReadCloser, error) {
+	var err error
+	for _, client := range lc.clients {
+		var r io.ReadCloser
+		if t, ok := client.(Tailer); ok {
+			r, err = t.TailReader(executable, run, role, facility, n)
+		} else {
+			r, err = client.LogsReader(executable, run, role, facility)
+		}
+		if err == nil {
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+func (lc *CompositeLogsClient) tag(name string, cursor *string) *string {
+	if cursor == nil {
+		return nil
+	}
+	tagged := fmt.Sprintf("%s:%s", name, *cursor)
+	return &tagged
+}
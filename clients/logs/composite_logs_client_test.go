@@ -0,0 +1,124 @@
+package logs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+type testLogsClient struct {
+	log      string
+	err      error
+	lastSeen []string
+}
+
+func (c *testLogsClient) Name() string                        { return "test" }
+func (c *testLogsClient) Initialize(conf config.Config) error { return nil }
+func (c *testLogsClient) Logs(executable state.Executable, run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
+	seen := ""
+	if lastSeen != nil {
+		seen = *lastSeen
+	}
+	c.lastSeen = append(c.lastSeen, seen)
+	if c.err != nil {
+		return "", nil, c.err
+	}
+	next := seen + "+"
+	return c.log, &next, nil
+}
+func (c *testLogsClient) LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error {
+	if c.err != nil {
+		return c.err
+	}
+	_, err := io.WriteString(w, c.log)
+	return err
+}
+
+func (c *testLogsClient) LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return ioutil.NopCloser(strings.NewReader(c.log)), nil
+}
+
+func TestNewLogsClient(t *testing.T) {
+	cwConf := testLogsConf{
+		"flotilla_mode":          "test",
+		"aws_default_region":     "us-east-1",
+		"eks.log.driver.options": map[string]string{"awslogs-group": "g"},
+	}
+
+	cwConf["logs_client"] = "cloudwatch"
+	if lc, err := NewLogsClient(cwConf, testLogger{}, state.EKSEngine); err != nil || lc.Name() != "eks-cloudwatch" {
+		t.Errorf("Expected the cloudwatch client, got %v %v", lc, err)
+	}
+
+	cwConf["logs_client"] = "composite"
+	cwConf["logs.composite.clients"] = []string{"cloudwatch", "filesystem"}
+	cwConf["logs.filesystem.root"] = "/tmp"
+	lc, err := NewLogsClient(cwConf, testLogger{}, state.EKSEngine)
+	if err != nil || lc.Name() != "composite" {
+		t.Fatalf("Expected the composite client, got %v %v", lc, err)
+	}
+	if names := lc.(*CompositeLogsClient).names; len(names) != 2 || names[0] != "cloudwatch" || names[1] != "filesystem" {
+		t.Errorf("Expected backends in configured order, got %v", names)
+	}
+
+	cwConf["logs.composite.clients"] = []string{"composite"}
+	if _, err = NewLogsClient(cwConf, testLogger{}, state.EKSEngine); err == nil {
+		t.Errorf("Expected nested composite clients to be rejected")
+	}
+
+	if _, err = NewLogsClient(testLogsConf{"logs_client": "nope"}, testLogger{}, state.EKSEngine); err == nil {
+		t.Errorf("Expected unknown logs client to be rejected")
+	}
+}
+
+func TestCompositeLogsClient_Logs(t *testing.T) {
+	live := &testLogsClient{err: exceptions.MissingResource{ErrorString: "no stream"}}
+	archive := &testLogsClient{log: "archived\n"}
+	lc := &CompositeLogsClient{names: []string{"cloudwatch", "s3"}, clients: []Client{live, archive}}
+
+	log, next, err := lc.Logs(nil, state.Run{}, nil, nil, nil)
+	if err != nil || log != "archived\n" || *next != "s3:+" {
+		t.Errorf("Expected logs of the first backend that has them, got [%s] %v %v", log, next, err)
+	}
+
+	// A cursor goes back to its backend only, without the prefix
+	live.err = nil
+	log, next, err = lc.Logs(nil, state.Run{}, next, nil, nil)
+	if err != nil || log != "archived\n" || *next != "s3:++" {
+		t.Errorf("Expected to resume on the cursor's backend, got [%s] %v %v", log, next, err)
+	}
+	if len(live.lastSeen) != 1 || archive.lastSeen[1] != "+" {
+		t.Errorf("Expected only the cursor's backend to be asked, got %v %v", live.lastSeen, archive.lastSeen)
+	}
+
+	archive.err = errors.New("gone")
+	if _, next, err = lc.Logs(nil, state.Run{}, next, nil, nil); err == nil || next != nil {
+		t.Errorf("Expected the error of the cursor's backend, got %v %v", next, err)
+	}
+
+	w := httptest.NewRecorder()
+	live.err = errors.New("no text")
+	archive.err = nil
+	if err = lc.LogsText(nil, state.Run{}, w); err != nil || w.Body.String() != "archived\n" {
+		t.Errorf("Expected text of the first backend that has it, got [%s] %v", w.Body.String(), err)
+	}
+
+	r, err := lc.LogsReader(nil, state.Run{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "archived\n" {
+		t.Errorf("Expected reader of the first backend that has it, got [%s]", b)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -84,6 +85,10 @@ func (lc *EKSCloudWatchLogsClient) Initialize(conf config.Config) error {
 	}
 	lc.logger = log.New(os.Stderr, "[cloudwatchlogs] ",
 		log.Ldate|log.Ltime|log.Lshortfile)
+	if lc.logsClient == nil {
+		// Test mode, there's no CloudWatch to set up
+		return nil
+	}
 	return lc.createNamespaceIfNotExists()
 }
 
@@ -136,6 +141,73 @@ func (lc *EKSCloudWatchLogsClient) LogsText(executable state.Executable, run sta
 	return errors.Errorf("EKSCloudWatchLogsClient does not support LogsText method.")
 }
 
+//
+// LogsReader returns a reader of the whole log stream of a run, paging
+// through its events as it's read
+//
+func (lc *EKSCloudWatchLogsClient) LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error) {
+	if run.PodName == nil {
+		return nil, exceptions.MissingResource{ErrorString: fmt.Sprintf("no pod associated with run [%s]", run.RunID)}
+	}
+	handle := lc.toStreamName(run)
+	r := &cloudWatchLogReader{
+		lc: lc,
+		args: &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  &lc.logNamespace,
+			LogStreamName: &handle,
+			StartFromHead: aws.Bool(true),
+		},
+	}
+	// Fetch the first page so a missing stream is reported here
+	if err := r.fill(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type cloudWatchLogReader struct {
+	lc   *EKSCloudWatchLogsClient
+	args *cloudwatchlogs.GetLogEventsInput
+	buf  []byte
+	done bool
+}
+
+func (r *cloudWatchLogReader) fill() error {
+	result, err := r.lc.logsClient.GetLogEvents(r.args)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return exceptions.MissingResource{ErrorString: err.Error()}
+		}
+		return errors.Wrap(err, "problem getting logs")
+	}
+	// The forward token stays the same at the end of the stream
+	if len(result.Events) == 0 || result.NextForwardToken == nil ||
+		(r.args.NextToken != nil && *r.args.NextToken == *result.NextForwardToken) {
+		r.done = true
+	}
+	r.args.NextToken = result.NextForwardToken
+	r.buf = []byte(r.lc.logsToMessage(result.Events))
+	return nil
+}
+
+func (r *cloudWatchLogReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *cloudWatchLogReader) Close() error {
+	return nil
+}
+
 // Generate stream name
 func (lc *EKSCloudWatchLogsClient) toStreamName(run state.Run) string {
 	return fmt.Sprintf("%s", *run.PodName)
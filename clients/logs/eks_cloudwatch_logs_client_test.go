@@ -0,0 +1,69 @@
+package logs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+type testCloudWatch struct {
+	logsClient
+	pages [][]string
+	err   error
+}
+
+func (c *testCloudWatch) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	page := 0
+	if input.NextToken != nil {
+		fmt.Sscanf(*input.NextToken, "%d", &page)
+	}
+	output := &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: aws.String(fmt.Sprintf("%d", page))}
+	if page < len(c.pages) {
+		for i, message := range c.pages[page] {
+			output.Events = append(output.Events, &cloudwatchlogs.OutputLogEvent{
+				Message:   aws.String(message),
+				Timestamp: aws.Int64(int64(i)),
+			})
+		}
+		output.NextForwardToken = aws.String(fmt.Sprintf("%d", page+1))
+	}
+	return output, nil
+}
+
+func TestEKSCloudWatchLogsClient_LogsReader(t *testing.T) {
+	cw := &testCloudWatch{pages: [][]string{
+		{`{"log":"a\n"}`, `{"log":"b\n"}`},
+		{`{"log":"c\n"}`},
+	}}
+	lc := &EKSCloudWatchLogsClient{logsClient: cw, logNamespace: "ns"}
+	run := state.Run{RunID: "r", PodName: aws.String("pod")}
+
+	r, err := lc.LogsReader(nil, run, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "a\nb\nc\n" {
+		t.Errorf("Expected every page of the stream, got [%s] %v", b, err)
+	}
+
+	if _, err = lc.LogsReader(nil, state.Run{RunID: "r"}, nil, nil); err == nil {
+		t.Errorf("Expected error for a run without a pod")
+	}
+
+	cw.err = awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "no stream", nil)
+	if _, err = lc.LogsReader(nil, run, nil, nil); err == nil {
+		t.Errorf("Expected error for a missing stream")
+	} else if _, ok := err.(exceptions.MissingResource); !ok {
+		t.Errorf("Expected MissingResource, got %v", err)
+	}
+}
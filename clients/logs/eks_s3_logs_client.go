@@ -7,12 +7,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
 	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -95,10 +98,13 @@ func (lc *EKSS3LogsClient) Initialize(conf config.Config) error {
 	return nil
 }
 
-func (lc *EKSS3LogsClient) emrLogsToMessageString(run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
+//
+// Find the latest EMR log object of a role and facility.
+//
+func (lc *EKSS3LogsClient) emrLogKey(run state.Run, role *string, facility *string) (*string, error) {
 	s3DirName, err := lc.emrDriverLogsPath(run)
 	if err != nil {
-		return "", aws.String(""), errors.Errorf("No logs")
+		return nil, errors.Errorf("No logs")
 	}
 
 	result, err := lc.s3Client.ListObjects(&s3.ListObjectsInput{
@@ -107,7 +113,7 @@ func (lc *EKSS3LogsClient) emrLogsToMessageString(run state.Run, lastSeen *strin
 	})
 
 	if err != nil || result == nil || result.Contents == nil || len(result.Contents) == 0 {
-		return "", aws.String(""), errors.Errorf("Problem fetching logs")
+		return nil, errors.Errorf("Problem fetching logs")
 	}
 
 	var key *string
@@ -122,7 +128,15 @@ func (lc *EKSS3LogsClient) emrLogsToMessageString(run state.Run, lastSeen *strin
 
 	if key == nil {
 		lc.logger.Println(fmt.Sprintf("run=%s emr logging key not found for role=%s facility=%s", run.RunID, *role, *facility))
-		return "", aws.String(""), errors.Errorf("No driver logs found")
+		return nil, errors.Errorf("No driver logs found")
+	}
+	return key, nil
+}
+
+func (lc *EKSS3LogsClient) emrLogsToMessageString(run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
+	key, err := lc.emrLogKey(run, role, facility)
+	if err != nil {
+		return "", aws.String(""), err
 	}
 
 	startPosition := int64(0)
@@ -219,10 +233,127 @@ func (lc *EKSS3LogsClient) LogsText(executable state.Executable, run state.Run,
 	return nil
 }
 
+//
+// LogsReader returns a reader of the whole log of a run as text; EMR runs
+// read the log of role and facility
+//
+func (lc *EKSS3LogsClient) LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error) {
+	if run.Engine != nil && *run.Engine == state.EKSSparkEngine {
+		key, err := lc.emrLogKey(run, role, facility)
+		if err != nil {
+			return nil, exceptions.MissingResource{ErrorString: err.Error()}
+		}
+		s3Obj, err := lc.s3Client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(lc.emrS3LogsBucket),
+			Key:    key,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "problem getting logs")
+		}
+		gr, err := gzip.NewReader(s3Obj.Body)
+		if err != nil {
+			_ = s3Obj.Body.Close()
+			return nil, errors.Wrap(err, "problem reading logs")
+		}
+		return gzipBody{Reader: gr, body: s3Obj.Body}, nil
+	}
+
+	result, err := lc.getS3Object(run)
+	if err != nil {
+		return nil, exceptions.MissingResource{ErrorString: err.Error()}
+	}
+	return &s3LogReader{body: result.Body, reader: bufio.NewReader(result.Body)}, nil
+}
+
+//
+// TailReader returns a reader of the messages in the last n bytes of the log
+// object of a run; only that range of the object is fetched. EMR logs are
+// gzipped and can't be read from the middle, so they are read whole.
+//
+func (lc *EKSS3LogsClient) TailReader(executable state.Executable, run state.Run, role *string, facility *string, n int64) (io.ReadCloser, error) {
+	if run.Engine != nil && *run.Engine == state.EKSSparkEngine {
+		return lc.LogsReader(executable, run, role, facility)
+	}
+
+	key, err := lc.getS3ObjectKey(run)
+	if err != nil {
+		return nil, exceptions.MissingResource{ErrorString: err.Error()}
+	}
+	result, err := lc.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(lc.s3Bucket),
+		Key:    key,
+		Range:  aws.String(fmt.Sprintf("bytes=-%d", n)),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+		// The object is empty
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "problem getting logs")
+	}
+	// The range likely starts mid line; the reader skips lines that aren't JSON
+	return &s3LogReader{body: result.Body, reader: bufio.NewReader(result.Body)}, nil
+}
+
+//
+// s3LogReader reads the messages of a log object of JSON lines
+//
+type s3LogReader struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	buf    []byte
+}
+
+func (r *s3LogReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var parsedLine s3Log
+			if json.Unmarshal(line, &parsedLine) == nil {
+				r.buf = []byte(parsedLine.Log)
+			}
+		}
+		if err != nil {
+			if len(r.buf) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *s3LogReader) Close() error {
+	return r.body.Close()
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g gzipBody) Close() error {
+	_ = g.Reader.Close()
+	return g.body.Close()
+}
+
 //
 // Fetch S3Object associated with the pod's log.
 //
 func (lc *EKSS3LogsClient) getS3Object(run state.Run) (*s3.GetObjectOutput, error) {
+	key, err := lc.getS3ObjectKey(run)
+	if err != nil {
+		return nil, err
+	}
+	return lc.getS3Key(key)
+}
+
+//
+// Find the key of the latest S3Object of the pod's log.
+//
+func (lc *EKSS3LogsClient) getS3ObjectKey(run state.Run) (*string, error) {
 	//Pod isn't there yet - dont return a 404
 	if run.PodName == nil {
 		return nil, errors.New("no pod associated with the run.")
@@ -253,7 +384,7 @@ func (lc *EKSS3LogsClient) getS3Object(run state.Run) (*s3.GetObjectOutput, erro
 		}
 	}
 	if key != nil {
-		return lc.getS3Key(key)
+		return key, nil
 	} else {
 		return nil, errors.New("no s3 files associated with the run.")
 	}
@@ -324,6 +455,7 @@ func (lc *EKSS3LogsClient) logsToMessageString(result *s3.GetObjectOutput, start
 	if result == nil {
 		return acc, startingPosition, errors.New("s3 object not present.")
 	}
+	defer result.Body.Close()
 
 	reader := bufio.NewReader(result.Body)
 
@@ -347,7 +479,8 @@ func (lc *EKSS3LogsClient) logsToMessageString(result *s3.GetObjectOutput, start
 			if err == io.EOF {
 				err = nil
 			}
-			return acc, currentPosition, err
+			// The line wasn't read (or is still being written); resume from it
+			return acc, currentPosition - 1, err
 		} else {
 			var parsedLine s3Log
 			err := json.Unmarshal(line, &parsedLine)
@@ -357,6 +490,5 @@ func (lc *EKSS3LogsClient) logsToMessageString(result *s3.GetObjectOutput, start
 		}
 	}
 
-	_ = result.Body.Close()
 	return acc, currentPosition, nil
 }
@@ -0,0 +1,91 @@
+package logs
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+func s3Object(lines ...string) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(strings.Join(lines, "")))}
+}
+
+func TestEKSS3LogsClient_logsToMessageString(t *testing.T) {
+	lc := &EKSS3LogsClient{}
+	a := `{"log":"a\n"}` + "\n"
+	b := `{"log":"b\n"}` + "\n"
+	partial := `{"log":"c`
+
+	acc, position, err := lc.logsToMessageString(s3Object(a, b, partial), 0)
+	if err != nil || acc != "a\nb\n" || position != 2 {
+		t.Errorf("Expected complete lines and position 2, got [%s] %d %v", acc, position, err)
+	}
+
+	// Resuming once more lines are written returns only those
+	c := `{"log":"c\n"}` + "\n"
+	acc, position, err = lc.logsToMessageString(s3Object(a, b, c), position)
+	if err != nil || acc != "c\n" || position != 3 {
+		t.Errorf("Expected the line after position 2, got [%s] %d %v", acc, position, err)
+	}
+
+	acc, position, err = lc.logsToMessageString(s3Object(a, b, c), position)
+	if err != nil || acc != "" || position != 3 {
+		t.Errorf("Expected no new lines, got [%s] %d %v", acc, position, err)
+	}
+}
+
+func TestS3LogReader(t *testing.T) {
+	obj := s3Object(`{"log":"a\n"}`+"\n", "not json\n", `{"log":"b\n"}`+"\n", `{"log":"c\n"}`)
+	r := &s3LogReader{body: obj.Body, reader: bufio.NewReader(obj.Body)}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "a\nb\nc\n" {
+		t.Errorf("Expected the log messages, got [%s] %v", b, err)
+	}
+}
+
+func TestEKSS3LogsClient_TailReader(t *testing.T) {
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != "" {
+			fmt.Fprint(w, `<ListBucketResult><Name>logs</Name><Contents><Key>root/run-a/pod-a.log</Key>`+
+				`<LastModified>2026-10-17T00:00:00.000Z</LastModified></Contents></ListBucketResult>`)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, `g":"a\n"}`+"\n"+`{"log":"b\n"}`+"\n")
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	lc := &EKSS3LogsClient{s3Client: s3.New(sess), s3Bucket: "logs", s3BucketRootDir: "root"}
+
+	podName := "pod-a"
+	r, err := lc.TailReader(nil, state.Run{RunID: "run-a", PodName: &podName}, nil, nil, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "b\n" {
+		t.Errorf("Expected the messages of the complete lines in the range, got [%s] %v", b, err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=-64" {
+		t.Errorf("Expected only the last 64 bytes to be fetched, got %v", ranges)
+	}
+}
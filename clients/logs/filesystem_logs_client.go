@@ -0,0 +1,200 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+const (
+	defaultFilesystemPath    = "{run_id}.log"
+	defaultFilesystemEMRPath = "{run_id}/{role}/{facility}.log"
+)
+
+//
+// FilesystemLogsClient reads run logs from plain text files under a root
+// directory, eg. for local deployments without AWS. The file of a run is
+// found by expanding the logs.filesystem.path template, or for EMR runs
+// logs.filesystem.emr_path, with the placeholders {run_id},
+// {definition_id}, {group_name}, {pod_name}, {role} and {facility}. Files
+// ending in .gz are decompressed.
+//
+type FilesystemLogsClient struct {
+	root    string
+	path    string
+	emrPath string
+}
+
+//
+// Name returns the name of the logs client
+//
+func (lc *FilesystemLogsClient) Name() string {
+	return "filesystem"
+}
+
+//
+// Initialize sets up the FilesystemLogsClient
+//
+func (lc *FilesystemLogsClient) Initialize(conf config.Config) error {
+	lc.root = conf.GetString("logs.filesystem.root")
+	if len(lc.root) == 0 {
+		return errors.Errorf("FilesystemLogsClient needs [logs.filesystem.root] set in config")
+	}
+	lc.path = defaultFilesystemPath
+	if conf.IsSet("logs.filesystem.path") {
+		lc.path = conf.GetString("logs.filesystem.path")
+	}
+	lc.emrPath = defaultFilesystemEMRPath
+	if conf.IsSet("logs.filesystem.emr_path") {
+		lc.emrPath = conf.GetString("logs.filesystem.emr_path")
+	}
+	return nil
+}
+
+//
+// Logs returns up to state.MaxLogLines complete lines of the run's log after
+// lastSeen, a byte offset, and the offset to resume from
+//
+func (lc *FilesystemLogsClient) Logs(executable state.Executable, run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
+	var offset int64
+	if lastSeen != nil && len(*lastSeen) > 0 {
+		parsed, err := strconv.ParseInt(*lastSeen, 10, 64)
+		if err != nil || parsed < 0 {
+			return "", nil, exceptions.MalformedInput{ErrorString: fmt.Sprintf("invalid last_seen [%s]", *lastSeen)}
+		}
+		offset = parsed
+	}
+
+	r, err := lc.open(run, role, facility, offset)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	var acc strings.Builder
+	reader := bufio.NewReader(r)
+	for lines := int64(0); lines < state.MaxLogLines; lines++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A line without a newline is still being written
+			break
+		}
+		acc.WriteString(line)
+	}
+	next := strconv.FormatInt(offset+int64(acc.Len()), 10)
+	return acc.String(), &next, nil
+}
+
+//
+// LogsText writes the whole log of a run to w; EMR runs get the driver's
+// stderr
+//
+func (lc *FilesystemLogsClient) LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error {
+	role, facility := "driver", "stderr"
+	r, err := lc.open(run, &role, &facility, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+//
+// LogsReader returns a reader of the whole log of a run
+//
+func (lc *FilesystemLogsClient) LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error) {
+	return lc.open(run, role, facility, 0)
+}
+
+//
+// open opens the log of a run positioned at offset
+//
+func (lc *FilesystemLogsClient) open(run state.Run, role *string, facility *string, offset int64) (io.ReadCloser, error) {
+	name, err := lc.fileName(run, role, facility)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, exceptions.MissingResource{ErrorString: fmt.Sprintf("no logs for run [%s]", run.RunID)}
+		}
+		return nil, errors.Wrap(err, "problem opening logs")
+	}
+
+	if !strings.HasSuffix(name, ".gz") {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, errors.Wrap(err, "problem reading logs")
+		}
+		return f, nil
+	}
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "problem reading logs")
+	}
+	if _, err = io.CopyN(ioutil.Discard, gr, offset); err != nil && err != io.EOF {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "problem reading logs")
+	}
+	return gzipBody{Reader: gr, body: f}, nil
+}
+
+//
+// fileName expands the path template of a run. The role and facility come
+// from requests, so they may not name other directories.
+//
+func (lc *FilesystemLogsClient) fileName(run state.Run, role *string, facility *string) (string, error) {
+	path := lc.path
+	if run.Engine != nil && *run.Engine == state.EKSSparkEngine {
+		path = lc.emrPath
+	}
+
+	var podName, roleName, facilityName string
+	if run.PodName != nil {
+		podName = *run.PodName
+	}
+	if role != nil {
+		roleName = *role
+	}
+	if facility != nil {
+		facilityName = *facility
+	}
+	for _, part := range []string{run.RunID, podName, roleName, facilityName} {
+		if strings.ContainsAny(part, `/\`) || part == ".." {
+			return "", exceptions.MalformedInput{ErrorString: fmt.Sprintf("invalid log name [%s]", part)}
+		}
+	}
+
+	expanded := strings.NewReplacer(
+		"{run_id}", run.RunID,
+		"{definition_id}", run.DefinitionID,
+		"{group_name}", run.GroupName,
+		"{pod_name}", podName,
+		"{role}", roleName,
+		"{facility}", facilityName,
+	).Replace(path)
+
+	root := filepath.Clean(lc.root)
+	name := filepath.Join(root, expanded)
+	if name != root && !strings.HasPrefix(name, root+string(filepath.Separator)) {
+		return "", exceptions.MalformedInput{ErrorString: fmt.Sprintf("log path [%s] is outside of the logs root", expanded)}
+	}
+	return name, nil
+}
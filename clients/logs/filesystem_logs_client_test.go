@@ -0,0 +1,161 @@
+package logs
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+type testLogsConf map[string]interface{}
+
+func (c testLogsConf) GetString(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+func (c testLogsConf) GetStringSlice(key string) []string {
+	s, _ := c[key].([]string)
+	return s
+}
+func (c testLogsConf) GetStringMapString(key string) map[string]string {
+	m, _ := c[key].(map[string]string)
+	return m
+}
+func (c testLogsConf) GetInt(key string) int         { return 0 }
+func (c testLogsConf) GetBool(key string) bool       { return false }
+func (c testLogsConf) GetFloat64(key string) float64 { return 0 }
+func (c testLogsConf) IsSet(key string) bool {
+	_, ok := c[key]
+	return ok
+}
+
+type testLogger struct{}
+
+func (l testLogger) Log(keyvals ...interface{}) error   { return nil }
+func (l testLogger) Event(keyvals ...interface{}) error { return nil }
+
+func setUpFilesystemLogs(t *testing.T, conf testLogsConf) (*FilesystemLogsClient, string) {
+	root, err := ioutil.TempDir("", "flotilla-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf["logs_client"] = "filesystem"
+	conf["logs.filesystem.root"] = root
+	c, err := NewLogsClient(conf, testLogger{}, state.EKSEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*FilesystemLogsClient), root
+}
+
+func writeLog(t *testing.T, name string, contents string, flag int) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(name, flag|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilesystemLogsClient_Initialize(t *testing.T) {
+	lc := &FilesystemLogsClient{}
+	if err := lc.Initialize(testLogsConf{}); err == nil {
+		t.Errorf("Expected error without logs.filesystem.root")
+	}
+}
+
+func TestFilesystemLogsClient_Logs(t *testing.T) {
+	lc, root := setUpFilesystemLogs(t, testLogsConf{})
+	defer os.RemoveAll(root)
+	run := state.Run{RunID: "run-a"}
+
+	if _, _, err := lc.Logs(nil, run, nil, nil, nil); err == nil {
+		t.Errorf("Expected error before the log exists")
+	} else if _, ok := err.(exceptions.MissingResource); !ok {
+		t.Errorf("Expected MissingResource, got %v", err)
+	}
+
+	name := filepath.Join(root, "run-a.log")
+	writeLog(t, name, "one\ntwo\nthr", os.O_TRUNC)
+	log, lastSeen, err := lc.Logs(nil, run, nil, nil, nil)
+	if err != nil || log != "one\ntwo\n" || *lastSeen != "8" {
+		t.Errorf("Expected the complete lines, got [%s] %v %v", log, lastSeen, err)
+	}
+
+	writeLog(t, name, "ee\n", os.O_APPEND)
+	log, lastSeen, err = lc.Logs(nil, run, lastSeen, nil, nil)
+	if err != nil || log != "three\n" || *lastSeen != "14" {
+		t.Errorf("Expected the lines after last seen, got [%s] %v %v", log, lastSeen, err)
+	}
+
+	log, lastSeen, err = lc.Logs(nil, run, lastSeen, nil, nil)
+	if err != nil || log != "" || *lastSeen != "14" {
+		t.Errorf("Expected no new lines, got [%s] %v %v", log, lastSeen, err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = lc.LogsText(nil, run, w); err != nil || w.Body.String() != "one\ntwo\nthree\n" {
+		t.Errorf("Expected the whole log, got [%s] %v", w.Body.String(), err)
+	}
+}
+
+func TestFilesystemLogsClient_EMR(t *testing.T) {
+	lc, root := setUpFilesystemLogs(t, testLogsConf{})
+	defer os.RemoveAll(root)
+	run := state.Run{RunID: "run-b", Engine: &state.EKSSparkEngine}
+	writeLog(t, filepath.Join(root, "run-b", "driver", "stderr.log"), "driver\n", os.O_TRUNC)
+	writeLog(t, filepath.Join(root, "run-b", "executor", "stdout.log"), "executor\n", os.O_TRUNC)
+
+	role, facility := "executor", "stdout"
+	log, _, err := lc.Logs(nil, run, nil, &role, &facility)
+	if err != nil || log != "executor\n" {
+		t.Errorf("Expected the executor's stdout, got [%s] %v", log, err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = lc.LogsText(nil, run, w); err != nil || w.Body.String() != "driver\n" {
+		t.Errorf("Expected the driver's stderr, got [%s] %v", w.Body.String(), err)
+	}
+
+	role = ".."
+	if _, _, err = lc.Logs(nil, run, nil, &role, &facility); err == nil {
+		t.Errorf("Expected a role naming another directory to be rejected")
+	} else if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected MalformedInput, got %v", err)
+	}
+}
+
+func TestFilesystemLogsClient_Gzip(t *testing.T) {
+	lc, root := setUpFilesystemLogs(t, testLogsConf{"logs.filesystem.path": "{group_name}/{run_id}.log.gz"})
+	defer os.RemoveAll(root)
+	run := state.Run{RunID: "run-c", GroupName: "g"}
+
+	name := filepath.Join(root, "g", "run-c.log.gz")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	_, _ = gw.Write([]byte("one\ntwo\n"))
+	_ = gw.Close()
+	_ = f.Close()
+
+	offset := "4"
+	log, lastSeen, err := lc.Logs(nil, run, &offset, nil, nil)
+	if err != nil || log != "two\n" || *lastSeen != "8" {
+		t.Errorf("Expected the lines after the offset, got [%s] %v %v", log, lastSeen, err)
+	}
+}
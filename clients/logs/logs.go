@@ -7,6 +7,7 @@ import (
 	"github.com/stitchfix/flotilla-os/config"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/state"
+	"io"
 	"net/http"
 )
 
@@ -18,6 +19,15 @@ type Client interface {
 	Initialize(config config.Config) error
 	Logs(executable state.Executable, run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error)
 	LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error
+	LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error)
+}
+
+//
+// Tailer is implemented by clients that can read the end of a log without
+// reading all of it
+//
+type Tailer interface {
+	TailReader(executable state.Executable, run state.Run, role *string, facility *string, n int64) (io.ReadCloser, error)
 }
 
 type logsClient interface {
@@ -34,19 +44,42 @@ func (events byTimestamp) Swap(i, j int)      { events[i], events[j] = events[j]
 func (events byTimestamp) Less(i, j int) bool { return *(events[i].Timestamp) < *(events[j].Timestamp) }
 
 //
-// NewLogsClient creates and initializes a run logs client
+// NewLogsClient creates and initializes a run logs client; `logs_client`
+// selects the backend:
+// - `s3` (the default) reads the logs the log shipper archives to S3
+// - `cloudwatch` reads the log streams of the awslogs driver
+// - `filesystem` reads logs from local files
+// - `composite` tries the backends listed in `logs.composite.clients` in order
 //
 func NewLogsClient(conf config.Config, logger flotillaLog.Logger, name string) (Client, error) {
 	_ = logger.Log("message", "Initializing logs client", "client", name)
-	switch name {
-	case "eks":
-		// awslogs as an ecs log driver sends logs to AWS CloudWatch Logs service
-		ekscw := &EKSS3LogsClient{}
-		if err := ekscw.Initialize(conf); err != nil {
-			return nil, errors.Wrap(err, "problem initializing EKSCloudWatchLogsClient")
-		}
-		return ekscw, nil
-	default:
+	if name != state.EKSEngine {
 		return nil, fmt.Errorf("No Client named [%s] was found", name)
 	}
+
+	backend := "s3"
+	if conf.IsSet("logs_client") {
+		backend = conf.GetString("logs_client")
+	}
+	return newLogsBackend(conf, backend)
+}
+
+func newLogsBackend(conf config.Config, backend string) (Client, error) {
+	var lc Client
+	switch backend {
+	case "s3":
+		lc = &EKSS3LogsClient{}
+	case "cloudwatch":
+		lc = &EKSCloudWatchLogsClient{}
+	case "filesystem":
+		lc = &FilesystemLogsClient{}
+	case "composite":
+		lc = &CompositeLogsClient{}
+	default:
+		return nil, fmt.Errorf("No logs client named [%s] was found", backend)
+	}
+	if err := lc.Initialize(conf); err != nil {
+		return nil, errors.Wrapf(err, "problem initializing %s logs client", backend)
+	}
+	return lc, nil
 }
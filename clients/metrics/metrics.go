@@ -47,6 +47,8 @@ const (
 	StatusWorkerGetJob Metric = "status_worker.get_job"
 	// Engine update run
 	EngineUpdateRun Metric = "engine.update_run"
+	// Metric for run status updates rejected by the run state machine
+	StateIllegalRunTransition Metric = "state.illegal_run_transition"
 )
 
 type MetricTag string
@@ -0,0 +1,70 @@
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+//
+// redisBroker publishes events to a redis pub/sub channel. Each process
+// holds a single redis subscription, opened with its first subscriber, and
+// fans the channel's events out to its own subscribers.
+//
+type redisBroker struct {
+	client  *redis.Client
+	channel string
+	h       *hub
+
+	mu         sync.Mutex
+	subscribed bool
+}
+
+func newRedisBroker(address string, db int, channel string) *redisBroker {
+	return &redisBroker{
+		client:  redis.NewClient(&redis.Options{Addr: address, DB: db}),
+		channel: channel,
+		h:       newHub(),
+	}
+}
+
+//
+// Publish sends e to every process subscribed to the channel
+//
+func (rb *redisBroker) Publish(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return rb.client.Publish(rb.channel, b).Err()
+}
+
+//
+// Subscribe returns a subscription to the events matching f
+//
+func (rb *redisBroker) Subscribe(f Filter) (Subscription, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.subscribed {
+		ps := rb.client.Subscribe(rb.channel)
+		// Wait for the subscription to be confirmed so a failure is reported
+		if _, err := ps.Receive(); err != nil {
+			_ = ps.Close()
+			return nil, err
+		}
+		go rb.receive(ps)
+		rb.subscribed = true
+	}
+	return rb.h.subscribe(f), nil
+}
+
+func (rb *redisBroker) receive(ps *redis.PubSub) {
+	for msg := range ps.Channel() {
+		var e Event
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			continue
+		}
+		rb.h.dispatch(e)
+	}
+}
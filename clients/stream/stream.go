@@ -0,0 +1,274 @@
+package stream
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// Types of run stream events
+//
+const (
+	EventStatus   = "status"
+	EventPodEvent = "pod_event"
+	EventMetrics  = "metrics"
+	EventExit     = "exit"
+)
+
+// defaultChannel is the pub/sub channel used when stream.channel is unset
+const defaultChannel = "flotilla:runs"
+
+// subscriptionBuffer bounds the events queued for a slow subscriber; events
+// past it are dropped for that subscriber
+const subscriptionBuffer = 256
+
+//
+// Event is a change to a run as it was persisted
+//
+type Event struct {
+	Type          string          `json:"type"`
+	RunID         string          `json:"run_id"`
+	DefinitionID  string          `json:"definition_id"`
+	GroupName     string          `json:"group_name"`
+	Status        string          `json:"status"`
+	Timestamp     time.Time       `json:"timestamp"`
+	PodEvent      *state.PodEvent `json:"pod_event,omitempty"`
+	MaxCpuUsed    *int64          `json:"max_cpu_used,omitempty"`
+	MaxMemoryUsed *int64          `json:"max_memory_used,omitempty"`
+	ExitCode      *int64          `json:"exit_code,omitempty"`
+	ExitReason    *string         `json:"exit_reason,omitempty"`
+}
+
+//
+// RunEvents returns the events of an update of a run from before to after:
+// a status transition, each new pod event, changed resource metrics and,
+// once the run has stopped, its exit code
+//
+func RunEvents(before state.Run, after state.Run) []Event {
+	now := time.Now()
+	base := Event{
+		RunID:        after.RunID,
+		DefinitionID: after.DefinitionID,
+		GroupName:    after.GroupName,
+		Status:       after.Status,
+		Timestamp:    now,
+	}
+
+	var events []Event
+	if after.Status != before.Status {
+		e := base
+		e.Type = EventStatus
+		events = append(events, e)
+	}
+
+	var seen int
+	if before.PodEvents != nil {
+		seen = len(*before.PodEvents)
+	}
+	if after.PodEvents != nil && len(*after.PodEvents) > seen {
+		for _, pe := range (*after.PodEvents)[seen:] {
+			pe := pe
+			e := base
+			e.Type = EventPodEvent
+			e.PodEvent = &pe
+			events = append(events, e)
+		}
+	}
+
+	if !sameInt64(before.MaxCpuUsed, after.MaxCpuUsed) || !sameInt64(before.MaxMemoryUsed, after.MaxMemoryUsed) {
+		e := base
+		e.Type = EventMetrics
+		e.MaxCpuUsed = after.MaxCpuUsed
+		e.MaxMemoryUsed = after.MaxMemoryUsed
+		events = append(events, e)
+	}
+
+	if after.Status == state.StatusStopped && after.ExitCode != nil &&
+		(before.Status != state.StatusStopped || before.ExitCode == nil) {
+		e := base
+		e.Type = EventExit
+		e.ExitCode = after.ExitCode
+		e.ExitReason = after.ExitReason
+		events = append(events, e)
+	}
+	return events
+}
+
+func sameInt64(a *int64, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+//
+// Filter selects the events a subscriber receives; empty fields match any
+// event
+//
+type Filter struct {
+	RunID        string
+	DefinitionID []string
+	GroupName    []string
+	Types        []string
+}
+
+//
+// Matches returns whether e passes the filter
+//
+func (f Filter) Matches(e Event) bool {
+	if len(f.RunID) > 0 && e.RunID != f.RunID {
+		return false
+	}
+	return matchesAny(f.DefinitionID, e.DefinitionID) &&
+		matchesAny(f.GroupName, e.GroupName) &&
+		matchesAny(f.Types, e.Type)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Subscription receives the events matching its filter until it is closed
+//
+type Subscription interface {
+	Events() <-chan Event
+	Close()
+}
+
+//
+// Broker publishes run events and fans them out to subscribers, possibly in
+// other processes
+//
+type Broker interface {
+	Publish(e Event) error
+	Subscribe(f Filter) (Subscription, error)
+}
+
+// processBroker is shared by every local broker of the process so the API
+// and the workers running beside it reach each other's subscribers
+var processBroker = NewLocalBroker()
+
+//
+// NewBroker returns the broker selected by stream.driver: "redis" (the
+// default when redis_address is set) fans events out across processes
+// through the redis_address pub/sub channel stream.channel; "local" only
+// reaches subscribers in the same process.
+//
+func NewBroker(conf config.Config) (Broker, error) {
+	driver := "local"
+	if conf.IsSet("redis_address") {
+		driver = "redis"
+	}
+	if conf.IsSet("stream.driver") {
+		driver = conf.GetString("stream.driver")
+	}
+
+	switch driver {
+	case "local":
+		return processBroker, nil
+	case "redis":
+		channel := defaultChannel
+		if conf.IsSet("stream.channel") {
+			channel = conf.GetString("stream.channel")
+		}
+		return newRedisBroker(conf.GetString("redis_address"), conf.GetInt("redis_db"), channel), nil
+	}
+	return nil, fmt.Errorf("No stream driver named [%s] was found", driver)
+}
+
+//
+// hub fans events out to the subscriptions of a process
+//
+type hub struct {
+	mu   sync.RWMutex
+	subs map[*subscription]bool
+}
+
+type subscription struct {
+	h      *hub
+	filter Filter
+	events chan Event
+	once   sync.Once
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[*subscription]bool)}
+}
+
+func (h *hub) subscribe(f Filter) *subscription {
+	s := &subscription{h: h, filter: f, events: make(chan Event, subscriptionBuffer)}
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+func (h *hub) dispatch(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs {
+		if !s.filter.Matches(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			// The subscriber isn't keeping up
+		}
+	}
+}
+
+func (s *subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *subscription) Close() {
+	s.once.Do(func() {
+		s.h.mu.Lock()
+		delete(s.h.subs, s)
+		s.h.mu.Unlock()
+		close(s.events)
+	})
+}
+
+//
+// LocalBroker delivers events to subscribers in the same process
+//
+type LocalBroker struct {
+	h *hub
+}
+
+//
+// NewLocalBroker returns a broker without a pub/sub backend
+//
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{h: newHub()}
+}
+
+//
+// Publish delivers e to the matching subscriptions
+//
+func (lb *LocalBroker) Publish(e Event) error {
+	lb.h.dispatch(e)
+	return nil
+}
+
+//
+// Subscribe returns a subscription to the events matching f
+//
+func (lb *LocalBroker) Subscribe(f Filter) (Subscription, error) {
+	return lb.h.subscribe(f), nil
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+func TestRunEvents(t *testing.T) {
+	cpu := int64(100)
+	exitCode := int64(1)
+	reason := "oom"
+	events := state.PodEvents{{Reason: "Scheduled"}}
+	before := state.Run{RunID: "r", GroupName: "g", Status: state.StatusRunning, PodEvents: &events}
+
+	moreEvents := append(events, state.PodEvent{Reason: "Pulled"}, state.PodEvent{Reason: "Killing"})
+	after := before
+	after.Status = state.StatusStopped
+	after.PodEvents = &moreEvents
+	after.MaxCpuUsed = &cpu
+	after.ExitCode = &exitCode
+	after.ExitReason = &reason
+
+	got := RunEvents(before, after)
+	expected := []string{EventStatus, EventPodEvent, EventPodEvent, EventMetrics, EventExit}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %v", len(expected), len(got), got)
+	}
+	for i, e := range got {
+		if e.Type != expected[i] {
+			t.Errorf("Expected event %d to be [%s], was [%s]", i, expected[i], e.Type)
+		}
+		if e.RunID != "r" || e.GroupName != "g" || e.Status != state.StatusStopped {
+			t.Errorf("Expected event to describe run r, got %v", e)
+		}
+	}
+	if got[1].PodEvent.Reason != "Pulled" || got[2].PodEvent.Reason != "Killing" {
+		t.Errorf("Expected only the new pod events")
+	}
+	if *got[4].ExitCode != 1 || *got[4].ExitReason != "oom" {
+		t.Errorf("Expected exit event to carry exit code and reason")
+	}
+
+	if len(RunEvents(after, after)) != 0 {
+		t.Errorf("Expected no events for an unchanged run")
+	}
+}
+
+func TestFilter_Matches(t *testing.T) {
+	e := Event{Type: EventStatus, RunID: "r", GroupName: "g", DefinitionID: "d"}
+	cases := []struct {
+		f        Filter
+		expected bool
+	}{
+		{Filter{}, true},
+		{Filter{RunID: "r"}, true},
+		{Filter{RunID: "other"}, false},
+		{Filter{GroupName: []string{"x", "g"}}, true},
+		{Filter{GroupName: []string{"x"}}, false},
+		{Filter{DefinitionID: []string{"d"}, Types: []string{EventExit}}, false},
+		{Filter{DefinitionID: []string{"d"}, Types: []string{EventStatus}}, true},
+	}
+	for _, c := range cases {
+		if c.f.Matches(e) != c.expected {
+			t.Errorf("Expected %v to match: %v", c.f, c.expected)
+		}
+	}
+}
+
+func TestLocalBroker(t *testing.T) {
+	b := NewLocalBroker()
+	a, _ := b.Subscribe(Filter{RunID: "a"})
+	all, _ := b.Subscribe(Filter{})
+
+	_ = b.Publish(Event{Type: EventStatus, RunID: "b"})
+	_ = b.Publish(Event{Type: EventStatus, RunID: "a"})
+
+	select {
+	case e := <-a.Events():
+		if e.RunID != "a" {
+			t.Errorf("Expected only events of run a, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected an event")
+	}
+	if len(all.Events()) != 2 {
+		t.Errorf("Expected 2 events for unfiltered subscription, got %d", len(all.Events()))
+	}
+
+	a.Close()
+	a.Close()
+	if _, ok := <-a.Events(); ok {
+		t.Errorf("Expected closed subscription")
+	}
+	// Publishing after a subscription closed must not block or panic
+	_ = b.Publish(Event{Type: EventStatus, RunID: "a"})
+}
+
+func TestLocalBroker_SlowSubscriber(t *testing.T) {
+	b := NewLocalBroker()
+	s, _ := b.Subscribe(Filter{})
+	for i := 0; i < subscriptionBuffer+10; i++ {
+		_ = b.Publish(Event{Type: EventStatus, RunID: "a"})
+	}
+	if len(s.Events()) != subscriptionBuffer {
+		t.Errorf("Expected events past the buffer to be dropped, got %d", len(s.Events()))
+	}
+}
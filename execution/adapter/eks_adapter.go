@@ -14,7 +14,7 @@ import (
 
 type EKSAdapter interface {
 	AdaptJobToFlotillaRun(job *batchv1.Job, run state.Run, pod *corev1.Pod) (state.Run, error)
-	AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, manager state.Manager, araEnabled bool) (batchv1.Job, error)
+	AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, priorityClassName string, manager state.Manager, araEnabled bool) (batchv1.Job, error)
 }
 type eksAdapter struct{}
 
@@ -96,8 +96,10 @@ func (a *eksAdapter) AdaptJobToFlotillaRun(job *batchv1.Job, run state.Run, pod
 // 4. Port mappings.
 // 5. Node lifecycle.
 // 6. Node affinity and anti-affinity
+// 7. Run labels, applied to both the job and its pods.
+// 8. Pod priority class of the run's priority tier, if any.
 //
-func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, manager state.Manager, araEnabled bool) (batchv1.Job, error) {
+func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, priorityClassName string, manager state.Manager, araEnabled bool) (batchv1.Job, error) {
 	cmd := ""
 
 	if run.Command != nil && len(*run.Command) > 0 {
@@ -120,6 +122,7 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 
 	affinity := a.constructAffinity(executable, run, manager)
 	annotations := map[string]string{"cluster-autoscaler.kubernetes.io/safe-to-evict": "false"}
+	labels := a.constructLabels(run)
 
 	jobSpec := batchv1.JobSpec{
 		TTLSecondsAfterFinished: &state.TTLSecondsAfterFinished,
@@ -129,6 +132,7 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 		Template: corev1.PodTemplateSpec{
 			ObjectMeta: v1.ObjectMeta{
 				Annotations: annotations,
+				Labels:      labels,
 			},
 			Spec: corev1.PodSpec{
 				SchedulerName:      schedulerName,
@@ -136,6 +140,7 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 				RestartPolicy:      corev1.RestartPolicyNever,
 				ServiceAccountName: sa,
 				Affinity:           affinity,
+				PriorityClassName:  priorityClassName,
 			},
 		},
 	}
@@ -143,13 +148,25 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 	eksJob := batchv1.Job{
 		Spec: jobSpec,
 		ObjectMeta: v1.ObjectMeta{
-			Name: run.RunID,
+			Name:   run.RunID,
+			Labels: labels,
 		},
 	}
 
 	return eksJob, nil
 }
 
+func (a *eksAdapter) constructLabels(run state.Run) map[string]string {
+	if len(run.Labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(run.Labels))
+	for k, v := range run.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
 func (a *eksAdapter) constructContainerPorts(executable state.Executable) []corev1.ContainerPort {
 	var containerPorts []corev1.ContainerPort
 	executableResources := executable.GetExecutableResources()
@@ -346,7 +363,7 @@ func (a *eksAdapter) getLastRun(manager state.Manager, run state.Run) state.Run
 			time.Now().AddDate(0, 0, -7).Format(time.RFC3339),
 		},
 		"status":        {state.StatusStopped},
-		"command":       {strings.Replace(*run.Command, "'", "''", -1)},
+		"command":       {*run.Command},
 		"executable_id": {*run.ExecutableID},
 	}, nil, []string{state.EKSEngine})
 	if err == nil && len(runList.Runs) > 0 {
@@ -45,6 +45,7 @@ type EKSExecutionEngine struct {
 	s3Bucket        string
 	s3BucketRootDir string
 	statusQueue     string
+	priorities      state.PriorityTiers
 }
 
 //
@@ -71,6 +72,11 @@ func (ee *EKSExecutionEngine) Initialize(conf config.Config) error {
 	}
 
 	ee.jobQueue = conf.GetString("eks.job_queue")
+	priorities, err := state.NewPriorityTiers(conf, ee.jobQueue)
+	if err != nil {
+		return err
+	}
+	ee.priorities = priorities
 	ee.schedulerName = "default-scheduler"
 
 	if conf.IsSet("eks.scheduler_name") {
@@ -115,7 +121,8 @@ func (ee *EKSExecutionEngine) Initialize(conf config.Config) error {
 }
 
 func (ee *EKSExecutionEngine) Execute(executable state.Executable, run state.Run, manager state.Manager) (state.Run, bool, error) {
-	job, err := ee.adapter.AdaptFlotillaDefinitionAndRunToJob(executable, run, ee.jobSA, ee.schedulerName, manager, ee.jobARAEnabled)
+	tier, _ := ee.priorities.Get(run.Priority)
+	job, err := ee.adapter.AdaptFlotillaDefinitionAndRunToJob(executable, run, ee.jobSA, ee.schedulerName, tier.ClassName, manager, ee.jobARAEnabled)
 
 	kClient, err := ee.getKClient(run)
 	if err != nil {
@@ -267,8 +274,12 @@ func (ee *EKSExecutionEngine) Terminate(run state.Run) error {
 }
 
 func (ee *EKSExecutionEngine) Enqueue(run state.Run) error {
-	// Get qurl
-	qurl, err := ee.qm.QurlFor(ee.jobQueue, false)
+	// Get qurl of the run's priority tier
+	jobQueue := ee.jobQueue
+	if tier, ok := ee.priorities.Get(run.Priority); ok {
+		jobQueue = tier.Queue
+	}
+	qurl, err := ee.qm.QurlFor(jobQueue, false)
 	if err != nil {
 		_ = metrics.Increment(metrics.EngineEKSEnqueue, []string{string(metrics.StatusFailure)}, 1)
 		return errors.Wrapf(err, "problem getting queue url for [%s]", run.ClusterName)
@@ -284,32 +295,58 @@ func (ee *EKSExecutionEngine) Enqueue(run state.Run) error {
 	return nil
 }
 
+//
+// PollRuns receives queued runs following the priority dispatch table: each
+// queue, highest tier first, is polled for up to its tier's weight in runs.
+// Every tier is polled on every call, so lower tiers are never starved.
+//
 func (ee *EKSExecutionEngine) PollRuns() ([]RunReceipt, error) {
-	qurl, err := ee.qm.QurlFor(ee.jobQueue, false)
-	if err != nil {
-		return nil, errors.Wrap(err, "problem listing queues to poll")
-	}
-	queues := []string{qurl}
 	var runs []RunReceipt
-	for _, qurl := range queues {
-		//
-		// Get new queued Run
-		//
-		runReceipt, err := ee.qm.ReceiveRun(qurl)
-
+	for _, d := range ee.dispatchTable() {
+		qurl, err := ee.qm.QurlFor(d.Queue, false)
 		if err != nil {
-			return runs, errors.Wrapf(err, "problem receiving run from queue url [%s]", qurl)
+			return runs, errors.Wrap(err, "problem listing queues to poll")
 		}
 
-		if runReceipt.Run == nil {
-			continue
-		}
+		for i := 0; i < d.Weight; i++ {
+			//
+			// Get new queued Run
+			//
+			runReceipt, err := ee.qm.ReceiveRun(qurl)
 
-		runs = append(runs, RunReceipt{runReceipt})
+			if err != nil {
+				return runs, errors.Wrapf(err, "problem receiving run from queue url [%s]", qurl)
+			}
+
+			if runReceipt.Run == nil {
+				break
+			}
+
+			runs = append(runs, RunReceipt{runReceipt})
+		}
 	}
 	return runs, nil
 }
 
+//
+// dispatchTable returns the queues to poll, highest tier first; tiers
+// sharing a queue poll it once with the highest tier's weight
+//
+func (ee *EKSExecutionEngine) dispatchTable() []state.PriorityTier {
+	if len(ee.priorities.Tiers) == 0 {
+		return []state.PriorityTier{{Queue: ee.jobQueue, Weight: 1}}
+	}
+	var table []state.PriorityTier
+	seen := make(map[string]bool)
+	for _, t := range ee.priorities.Tiers {
+		if !seen[t.Queue] {
+			seen[t.Queue] = true
+			table = append(table, t)
+		}
+	}
+	return table
+}
+
 // PollStatus is a dummy function as EKS does not emit task status
 // change events.
 //
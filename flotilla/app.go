@@ -10,6 +10,7 @@ import (
 	"github.com/rs/cors"
 	"github.com/stitchfix/flotilla-os/clients/cluster"
 	"github.com/stitchfix/flotilla-os/clients/logs"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
@@ -57,26 +58,54 @@ func NewApp(conf config.Config,
 	app.logger = log
 	app.configure(conf)
 
-	executionService, err := services.NewExecutionService(conf, eksExecutionEngine, stateManager, eksClusterClient, emrExecutionEngine)
+	executionService, err := services.NewExecutionService(conf, eksExecutionEngine, stateManager, eksClusterClient, emrExecutionEngine, log)
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing execution service")
 	}
-	templateService, err := services.NewTemplateService(conf, stateManager)
+	templateService, err := services.NewTemplateService(conf, stateManager, log)
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing template service")
 	}
-	eksLogService, err := services.NewLogService(stateManager, eksLogsClient)
+	eksLogService, err := services.NewLogService(conf, stateManager, eksLogsClient)
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing eks log service")
 	}
-	workerService, err := services.NewWorkerService(conf, stateManager)
+	workerService, err := services.NewWorkerService(conf, stateManager, log)
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing worker service")
 	}
-	definitionService, err := services.NewDefinitionService(stateManager)
+	definitionService, err := services.NewDefinitionService(stateManager, log)
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing definition service")
 	}
+	auditService, err := services.NewAuditService(stateManager)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing audit service")
+	}
+	scheduleService, err := services.NewScheduleService(stateManager, log)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing schedule service")
+	}
+	workflowService, err := services.NewWorkflowService(stateManager, log)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing workflow service")
+	}
+	quotaService, err := services.NewQuotaService(stateManager, log)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing quota service")
+	}
+	webhookService, err := services.NewWebhookService(conf, stateManager, log)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing webhook service")
+	}
+	exitReasonService, err := services.NewExitReasonService(conf, stateManager, eksLogService, log)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing exit reason service")
+	}
+	streamBroker, err := stream.NewBroker(conf)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing stream broker")
+	}
 
 	ep := endpoints{
 		executionService:  executionService,
@@ -85,10 +114,18 @@ func NewApp(conf config.Config,
 		templateService:   templateService,
 		logger:            log,
 		definitionService: definitionService,
+		auditService:      auditService,
+		scheduleService:   scheduleService,
+		workflowService:   workflowService,
+		quotaService:      quotaService,
+		webhookService:    webhookService,
+		exitReasonService: exitReasonService,
+		streamBroker:      streamBroker,
+		streamTimeout:     app.streamTimeout(),
 	}
 
 	app.configureRoutes(ep)
-	if err = app.initializeEKSWorkers(conf, log, eksExecutionEngine, emrExecutionEngine, stateManager, eksQueueManager); err != nil {
+	if err = app.initializeEKSWorkers(conf, log, eksExecutionEngine, emrExecutionEngine, stateManager, eksQueueManager, executionService); err != nil {
 		return app, errors.Wrap(err, "problem eks initializing workers")
 	}
 
@@ -116,6 +153,18 @@ func (app *App) configure(conf config.Config) {
 	app.corsAllowedOrigins = conf.GetStringSlice("http.server.cors_allowed_origins")
 }
 
+//
+// streamTimeout ends streams whose write deadline can't be lifted just
+// before the server's write timeout would cut them off; clients reconnect
+// to resume
+//
+func (app *App) streamTimeout() time.Duration {
+	if app.writeTimeout > 2*time.Second {
+		return app.writeTimeout - time.Second
+	}
+	return app.writeTimeout
+}
+
 func (app *App) configureRoutes(ep endpoints) {
 	if app.mode == "dev" || app.mode == "test" {
 		app.logger.Log(
@@ -138,8 +187,9 @@ func (app *App) initializeEKSWorkers(
 	ee engine.Engine,
 	emr engine.Engine,
 	sm state.Manager,
-	qm queue.Manager) error {
-	workerManager, err := worker.NewWorker("worker_manager", log, conf, ee, emr, sm, qm)
+	qm queue.Manager,
+	es services.ExecutionService) error {
+	workerManager, err := worker.NewWorker("worker_manager", log, conf, ee, emr, sm, qm, es)
 	_ = app.logger.Log("message", "Starting worker", "name", "worker_manager")
 	if err != nil {
 		return errors.Wrapf(err, "problem initializing worker with name [%s]", "worker_manager")
@@ -154,8 +204,9 @@ func (app *App) initializeEMRWorkers(
 	ee engine.Engine,
 	emr engine.Engine,
 	sm state.Manager,
-	qm queue.Manager) error {
-	workerManager, err := worker.NewWorker("worker_manager", log, conf, ee, emr, sm, qm)
+	qm queue.Manager,
+	es services.ExecutionService) error {
+	workerManager, err := worker.NewWorker("worker_manager", log, conf, ee, emr, sm, qm, es)
 	_ = app.logger.Log("message", "Starting worker", "name", "worker_manager")
 	if err != nil {
 		return errors.Wrapf(err, "problem initializing worker with name [%s]", "worker_manager")
@@ -1,18 +1,22 @@
 package flotilla
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/exceptions"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/utils"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type endpoints struct {
@@ -21,16 +25,35 @@ type endpoints struct {
 	templateService   services.TemplateService
 	eksLogService     services.LogService
 	workerService     services.WorkerService
+	auditService      services.AuditService
+	scheduleService   services.ScheduleService
+	workflowService   services.WorkflowService
+	quotaService      services.QuotaService
+	webhookService    services.WebhookService
+	exitReasonService services.ExitReasonService
+	streamBroker      stream.Broker
+	streamTimeout     time.Duration
 	logger            flotillaLog.Logger
 }
 
+// streamHeartbeat is how often an idle event stream gets a comment
+const streamHeartbeat = 15 * time.Second
+
+// logsLastSeenTrailer carries the cursor of followed text logs
+const logsLastSeenTrailer = "X-Flotilla-Last-Seen"
+
+// logsErrorTrailer carries the error that ended followed text logs
+const logsErrorTrailer = "X-Flotilla-Error"
+
 type listRequest struct {
-	limit      int
-	offset     int
-	sortBy     string
-	order      string
-	filters    map[string][]string
-	envFilters map[string]string
+	limit            int
+	offset           int
+	sortBy           string
+	order            string
+	filters          map[string][]string
+	envFilters       map[string]string
+	cursor           *string
+	approximateTotal bool
 }
 
 type LaunchRequest struct {
@@ -50,6 +73,12 @@ type LaunchRequestV2 struct {
 	SparkExtension        *state.SparkExtension `json:"spark_extension,omitempty"`
 	ClusterName           *string               `json:"cluster,omitempty"`
 	Env                   *state.EnvList        `json:"env,omitempty"`
+	ArraySize             *int64                `json:"array_size,omitempty"`
+	ArrayParameters       []state.EnvList       `json:"array_parameters,omitempty"`
+	MaxParallelism        *int64                `json:"max_parallelism,omitempty"`
+	IdempotencyKey        *string               `json:"idempotency_key,omitempty"`
+	Priority              *string               `json:"priority,omitempty"`
+	Labels                state.RunLabels       `json:"labels,omitempty"`
 }
 
 //
@@ -102,11 +131,21 @@ func (ep *endpoints) decodeListRequest(r *http.Request) listRequest {
 	lr.sortBy = ep.getURLParam(params, "sort_by", "group_name")
 	lr.order = ep.getURLParam(params, "order", "asc")
 	lr.filters, lr.envFilters = ep.getFilters(params, map[string]bool{
-		"limit":   true,
-		"offset":  true,
-		"sort_by": true,
-		"order":   true,
+		"limit":             true,
+		"offset":            true,
+		"sort_by":           true,
+		"order":             true,
+		"cursor":            true,
+		"approximate_total": true,
 	})
+
+	// Presence of the cursor parameter, even empty, selects keyset
+	// pagination; an empty cursor requests the first page
+	if _, ok := params["cursor"]; ok {
+		cursor := ep.getURLParam(params, "cursor", "")
+		lr.cursor = &cursor
+		lr.approximateTotal, _ = strconv.ParseBool(ep.getURLParam(params, "approximate_total", "false"))
+	}
 	return lr
 }
 
@@ -134,12 +173,23 @@ func (ep *endpoints) decodeRequest(r *http.Request, entity interface{}) error {
 	return json.NewDecoder(r.Body).Decode(entity)
 }
 
+//
+// idempotencyKey returns the Idempotency-Key header of an execute request,
+// falling back to the idempotency_key of its body
+//
+func idempotencyKey(r *http.Request, fromBody *string) *string {
+	if key := r.Header.Get("Idempotency-Key"); len(key) > 0 {
+		return &key
+	}
+	return fromBody
+}
+
 func (ep endpoints) encodeError(w http.ResponseWriter, err error) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	switch err.(type) {
 	case exceptions.MalformedInput:
 		w.WriteHeader(http.StatusBadRequest)
-	case exceptions.ConflictingResource:
+	case exceptions.ConflictingResource, state.IllegalTransition:
 		w.WriteHeader(http.StatusConflict)
 	case exceptions.MissingResource:
 		w.WriteHeader(http.StatusNotFound)
@@ -227,7 +277,7 @@ func (ep *endpoints) CreateDefinition(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	created, err := ep.definitionService.Create(&definition)
+	created, err := ep.definitionService.Create(&definition, ep.ExtractUserInfo(r))
 	if err != nil {
 		ep.logger.Log(
 			"message", "problem creating definition",
@@ -249,7 +299,7 @@ func (ep *endpoints) UpdateDefinition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	updated, err := ep.definitionService.Update(vars["definition_id"], definition)
+	updated, err := ep.definitionService.Update(vars["definition_id"], definition, ep.ExtractUserInfo(r))
 
 	if err != nil {
 		ep.logger.Log(
@@ -266,7 +316,7 @@ func (ep *endpoints) UpdateDefinition(w http.ResponseWriter, r *http.Request) {
 // Deletes a defiition.
 func (ep *endpoints) DeleteDefinition(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	err := ep.definitionService.Delete(vars["definition_id"])
+	err := ep.definitionService.Delete(vars["definition_id"], ep.ExtractUserInfo(r))
 	if err != nil {
 		ep.logger.Log(
 			"message", "problem deleting definition",
@@ -279,11 +329,115 @@ func (ep *endpoints) DeleteDefinition(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Lists the revisions of a definition, newest first.
+func (ep *endpoints) ListDefinitionRevisions(w http.ResponseWriter, r *http.Request) {
+	lr := ep.decodeListRequest(r)
+	vars := mux.Vars(r)
+	revisionList, err := ep.definitionService.ListRevisions(vars["definition_id"], lr.limit, lr.offset)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing definition revisions",
+			"operation", "ListDefinitionRevisions",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		response := make(map[string]interface{})
+		response["total"] = revisionList.Total
+		response["revisions"] = revisionList.Revisions
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		if revisionList.Revisions == nil {
+			response["revisions"] = []state.DefinitionRevision{}
+		}
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Gets a single revision of a definition.
+func (ep *endpoints) GetDefinitionRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	revision, err := ep.parseRevision(vars, "revision")
+	if err != nil {
+		ep.encodeError(w, err)
+		return
+	}
+
+	definitionRevision, err := ep.definitionService.GetRevision(vars["definition_id"], revision)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting definition revision",
+			"operation", "GetDefinitionRevision",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, definitionRevision)
+	}
+}
+
+// Diffs two revisions of a definition.
+func (ep *endpoints) DiffDefinitionRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	from, err := ep.parseRevision(vars, "from")
+	if err != nil {
+		ep.encodeError(w, err)
+		return
+	}
+	to, err := ep.parseRevision(vars, "to")
+	if err != nil {
+		ep.encodeError(w, err)
+		return
+	}
+
+	diff, err := ep.definitionService.DiffRevisions(vars["definition_id"], from, to)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem diffing definition revisions",
+			"operation", "DiffDefinitionRevisions",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, diff)
+	}
+}
+
+// Rolls a definition back to an earlier revision.
+func (ep *endpoints) RollbackDefinition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	revision, err := ep.parseRevision(vars, "revision")
+	if err != nil {
+		ep.encodeError(w, err)
+		return
+	}
+
+	definition, err := ep.definitionService.Rollback(vars["definition_id"], revision, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem rolling back definition",
+			"operation", "RollbackDefinition",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, definition)
+	}
+}
+
+func (ep *endpoints) parseRevision(vars map[string]string, key string) (int64, error) {
+	revision, err := strconv.ParseInt(vars[key], 10, 64)
+	if err != nil {
+		return 0, exceptions.MalformedInput{ErrorString: fmt.Sprintf("invalid revision [%s]", vars[key])}
+	}
+	return revision, nil
+}
+
 // List all runs, supports filtering based on environment variables.
 // ListRequest is object used here to construct the query.
 func (ep *endpoints) ListRuns(w http.ResponseWriter, r *http.Request) {
 	lr := ep.decodeListRequest(r)
-	runList, err := ep.executionService.List(lr.limit, lr.offset, lr.order, lr.sortBy, lr.filters, lr.envFilters)
+	runList, err := ep.listRuns(lr)
 	if err != nil {
 		ep.logger.Log(
 			"message", "problem listing runs",
@@ -291,17 +445,7 @@ func (ep *endpoints) ListRuns(w http.ResponseWriter, r *http.Request) {
 			"error", fmt.Sprintf("%+v", err))
 		ep.encodeError(w, err)
 	} else {
-		response := make(map[string]interface{})
-		response["total"] = runList.Total
-		response["history"] = runList.Runs
-		response["limit"] = lr.limit
-		response["offset"] = lr.offset
-		response["sort_by"] = lr.sortBy
-		response["order"] = lr.order
-		response["env_filters"] = lr.envFilters
-		for k, v := range lr.filters {
-			response[k] = v
-		}
+		response := ep.createListRunsResponse(runList, lr)
 		ep.encodeResponse(w, response)
 	}
 }
@@ -316,7 +460,7 @@ func (ep *endpoints) ListDefinitionRuns(w http.ResponseWriter, r *http.Request)
 		lr.filters["definition_id"] = []string{definitionID}
 	}
 
-	runList, err := ep.executionService.List(lr.limit, lr.offset, lr.order, lr.sortBy, lr.filters, lr.envFilters)
+	runList, err := ep.listRuns(lr)
 	if err != nil {
 		ep.logger.Log(
 			"message", "problem listing definition runs",
@@ -352,12 +496,31 @@ func (ep *endpoints) ListTemplateRuns(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//
+// listRuns pages by cursor when the request has a cursor parameter and by
+// offset otherwise
+//
+func (ep *endpoints) listRuns(lr listRequest) (state.RunList, error) {
+	if lr.cursor != nil {
+		return ep.executionService.ListByCursor(
+			lr.limit, *lr.cursor, lr.order, lr.sortBy, lr.filters, lr.envFilters, lr.approximateTotal)
+	}
+	return ep.executionService.List(lr.limit, lr.offset, lr.order, lr.sortBy, lr.filters, lr.envFilters)
+}
+
 func (ep *endpoints) createListRunsResponse(runList state.RunList, req listRequest) map[string]interface{} {
 	response := make(map[string]interface{})
-	response["total"] = runList.Total
 	response["history"] = runList.Runs
 	response["limit"] = req.limit
-	response["offset"] = req.offset
+	if req.cursor != nil {
+		response["next_cursor"] = runList.NextCursor
+		if req.approximateTotal {
+			response["total"] = runList.Total
+		}
+	} else {
+		response["total"] = runList.Total
+		response["offset"] = req.offset
+	}
 	response["sort_by"] = req.sortBy
 	response["order"] = req.order
 	response["env_filters"] = req.envFilters
@@ -403,6 +566,66 @@ func (ep *endpoints) GetPayload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (ep *endpoints) GetRunDefinition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	snapshot, err := ep.executionService.GetRunDefinition(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting run definition",
+			"operation", "GetRunDefinition",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, snapshot)
+	}
+}
+
+func (ep *endpoints) GetRunTransitions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	transitions, err := ep.executionService.ListTransitions(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing run transitions",
+			"operation", "GetRunTransitions",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, transitions)
+	}
+}
+
+func (ep *endpoints) GetRunAttempts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	attempts, err := ep.executionService.ListAttempts(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing run attempts",
+			"operation", "GetRunAttempts",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, attempts)
+	}
+}
+
+func (ep *endpoints) GetArraySummary(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	summary, err := ep.executionService.GetArraySummary(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem summarizing array run",
+			"operation", "GetArraySummary",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, summary)
+	}
+}
+
 // Creates a new Run (deprecated). Only present for legacy support.
 func (ep *endpoints) CreateRun(w http.ResponseWriter, r *http.Request) {
 	var lr LaunchRequest
@@ -534,6 +757,12 @@ func (ep *endpoints) CreateRunV4(w http.ResponseWriter, r *http.Request) {
 			NodeLifecycle:         lr.NodeLifecycle,
 			ActiveDeadlineSeconds: lr.ActiveDeadlineSeconds,
 			SparkExtension:        lr.SparkExtension,
+			ArraySize:             lr.ArraySize,
+			ArrayParameters:       lr.ArrayParameters,
+			MaxParallelism:        lr.MaxParallelism,
+			IdempotencyKey:        idempotencyKey(r, lr.IdempotencyKey),
+			Priority:              lr.Priority,
+			Labels:                lr.Labels,
 		},
 	}
 
@@ -595,6 +824,12 @@ func (ep *endpoints) CreateRunByAlias(w http.ResponseWriter, r *http.Request) {
 			NodeLifecycle:         lr.NodeLifecycle,
 			ActiveDeadlineSeconds: lr.ActiveDeadlineSeconds,
 			SparkExtension:        lr.SparkExtension,
+			ArraySize:             lr.ArraySize,
+			ArrayParameters:       lr.ArrayParameters,
+			MaxParallelism:        lr.MaxParallelism,
+			IdempotencyKey:        idempotencyKey(r, lr.IdempotencyKey),
+			Priority:              lr.Priority,
+			Labels:                lr.Labels,
 		},
 	}
 	run, err := ep.executionService.CreateDefinitionRunByAlias(vars["alias"], &req)
@@ -697,6 +932,7 @@ func (ep *endpoints) GetLogs(w http.ResponseWriter, r *http.Request) {
 
 	lastSeen := ep.getURLParam(params, "last_seen", "")
 	rawText := ep.getStringBoolVal(ep.getURLParam(params, "raw_text", ""))
+	follow := ep.getStringBoolVal(ep.getURLParam(params, "follow", ""))
 	run, err := ep.executionService.Get(vars["run_id"])
 	role := ep.getURLParam(params, "role", "driver")
 	facility := ep.getURLParam(params, "facility", "stderr")
@@ -715,7 +951,9 @@ func (ep *endpoints) GetLogs(w http.ResponseWriter, r *http.Request) {
 		run.Engine = &state.DefaultEngine
 	}
 
-	if rawText == true {
+	if follow {
+		ep.followLogs(w, r, vars["run_id"], lastSeen, role, facility)
+	} else if rawText == true {
 		_ = ep.eksLogService.LogsText(vars["run_id"], w)
 	} else {
 		log, newLastSeen, err := ep.eksLogService.Logs(vars["run_id"], &lastSeen, &role, &facility)
@@ -736,6 +974,142 @@ func (ep *endpoints) GetLogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//
+// followLogs streams a run's logs until it stops. Clients that accept
+// text/event-stream get "log" events whose id is the cursor to resume from,
+// then an "end" event, or an "error" event if the logs can't be read; other
+// clients get the logs as chunked text with the cursor in the
+// X-Flotilla-Last-Seen trailer and any error in the X-Flotilla-Error trailer.
+//
+func (ep *endpoints) followLogs(w http.ResponseWriter, r *http.Request, runID string, lastSeen string, role string, facility string) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if id := r.Header.Get("Last-Event-ID"); sse && len(id) > 0 {
+		// EventSource resumes from the last event it received
+		lastSeen = id
+	}
+
+	var (
+		flusher http.Flusher
+		ok      bool
+	)
+	if sse {
+		if flusher, ok = ep.startStream(w); !ok {
+			return
+		}
+	} else {
+		if flusher, ok = w.(http.Flusher); !ok {
+			ep.encodeError(w, fmt.Errorf("streaming is not supported"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Trailer", logsLastSeenTrailer+", "+logsErrorTrailer)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+	}
+
+	ctx, cancel := ep.streamContext(w, r)
+	defer cancel()
+
+	err := ep.eksLogService.Follow(ctx, runID, &lastSeen, &role, &facility, func(log string, next string) error {
+		lastSeen = next
+		var err error
+		if sse {
+			if _, err = fmt.Fprintf(w, "id: %s\n", next); err == nil {
+				err = writeStreamEvent(w, "log", map[string]string{"log": log, "last_seen": next})
+			}
+		} else {
+			_, err = io.WriteString(w, log)
+		}
+		flusher.Flush()
+		return err
+	})
+	// Streams that time out or lose their client are resumed from the cursor
+	failed := err != nil && err != context.Canceled && err != context.DeadlineExceeded
+	if failed {
+		_ = ep.logger.Log(
+			"message", "problem following logs",
+			"operation", "GetLogs",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", runID)
+	}
+
+	if sse {
+		if err == nil {
+			_ = writeStreamEvent(w, "end", map[string]string{"last_seen": lastSeen})
+		} else if failed {
+			_ = writeStreamEvent(w, "error", map[string]string{"error": err.Error(), "last_seen": lastSeen})
+		}
+		flusher.Flush()
+	} else {
+		w.Header().Set(logsLastSeenTrailer, lastSeen)
+		if failed {
+			w.Header().Set(logsErrorTrailer, err.Error())
+		}
+	}
+}
+
+//
+// SearchLogs scans a run's log for lines matching the regular expression q
+// and streams them back as newline delimited JSON, with up to context lines
+// around each match, followed by a summary of the scan. Errors after the
+// first line are sent in place of the summary.
+//
+func (ep *endpoints) SearchLogs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	params := r.URL.Query()
+
+	query := ep.getURLParam(params, "q", "")
+	if len(query) == 0 {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: "q is required"})
+		return
+	}
+	contextLines, err := strconv.Atoi(ep.getURLParam(params, "context", "0"))
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: "context must be a number"})
+		return
+	}
+	role := ep.getURLParam(params, "role", "driver")
+	facility := ep.getURLParam(params, "facility", "stderr")
+
+	ctx, cancel := ep.streamContext(w, r)
+	defer cancel()
+
+	// Headers are held back until there's a line, so that errors finding
+	// the log get their status code
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	summary, err := ep.eksLogService.Search(ctx, vars["run_id"], query, contextLines, &role, &facility, func(line services.LogSearchLine) error {
+		start()
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		ep.encodeError(w, err)
+		return
+	}
+
+	start()
+	if err != nil {
+		_ = enc.Encode(map[string]string{"error": err.Error()})
+	} else {
+		_ = enc.Encode(map[string]services.LogSearchSummary{"summary": summary})
+	}
+}
+
 // Get list of groups.
 func (ep *endpoints) GetGroups(w http.ResponseWriter, r *http.Request) {
 	response := make(map[string]interface{})
@@ -812,7 +1186,7 @@ func (ep *endpoints) UpdateWorker(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	updated, err := ep.workerService.Update(vars["worker_type"], worker)
+	updated, err := ep.workerService.Update(vars["worker_type"], worker, ep.ExtractUserInfo(r))
 
 	if err != nil {
 		ep.encodeError(w, err)
@@ -831,7 +1205,7 @@ func (ep *endpoints) BatchUpdateWorkers(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	updated, err := ep.workerService.BatchUpdate(wks)
+	updated, err := ep.workerService.BatchUpdate(wks, ep.ExtractUserInfo(r))
 
 	if err != nil {
 		ep.encodeError(w, err)
@@ -877,6 +1251,7 @@ func (ep *endpoints) CreateTemplateRunByName(w http.ResponseWriter, r *http.Requ
 	} else {
 		req.NodeLifecycle = &state.DefaultLifecycle
 	}
+	req.IdempotencyKey = idempotencyKey(r, req.IdempotencyKey)
 	vars := mux.Vars(r)
 
 	run, err := ep.executionService.CreateTemplateRunByTemplateName(vars["template_name"], vars["template_version"], &req)
@@ -919,6 +1294,7 @@ func (ep *endpoints) CreateTemplateRun(w http.ResponseWriter, r *http.Request) {
 	} else {
 		req.NodeLifecycle = &state.DefaultLifecycle
 	}
+	req.IdempotencyKey = idempotencyKey(r, req.IdempotencyKey)
 	vars := mux.Vars(r)
 
 	run, err := ep.executionService.CreateTemplateRunByTemplateID(vars["template_id"], &req)
@@ -971,39 +1347,693 @@ func (ep *endpoints) ListTemplates(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Get a template.
-func (ep *endpoints) GetTemplate(w http.ResponseWriter, r *http.Request) {
+// Lists audit events of mutating operations, supports filtering on the
+// actor, action and target.
+func (ep *endpoints) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	lr := ep.decodeOrderableListRequest(r, &state.AuditEvent{})
+	el, err := ep.auditService.List(lr.limit, lr.offset, lr.sortBy, lr.order, lr.filters)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing audit events",
+			"operation", "ListAuditEvents",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		if el.Events == nil {
+			el.Events = []state.AuditEvent{}
+		}
+		response := make(map[string]interface{})
+		response["total"] = el.Total
+		response["events"] = el.Events
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		response["sort_by"] = lr.sortBy
+		response["order"] = lr.order
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Lists schedules.
+func (ep *endpoints) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	lr := ep.decodeOrderableListRequest(r, &state.Schedule{})
+	sl, err := ep.scheduleService.List(lr.limit, lr.offset, lr.sortBy, lr.order, lr.filters)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing schedules",
+			"operation", "ListSchedules",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		if sl.Schedules == nil {
+			sl.Schedules = []state.Schedule{}
+		}
+		response := make(map[string]interface{})
+		response["total"] = sl.Total
+		response["schedules"] = sl.Schedules
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		response["sort_by"] = lr.sortBy
+		response["order"] = lr.order
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Get a schedule.
+func (ep *endpoints) GetSchedule(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	tpl, err := ep.templateService.GetByID(vars["template_id"])
+	schedule, err := ep.scheduleService.Get(vars["schedule_id"])
 	if err != nil {
 		ep.logger.Log(
-			"message", "problem getting templates",
-			"operation", "GetTemplate",
+			"message", "problem getting schedule",
+			"operation", "GetSchedule",
 			"error", fmt.Sprintf("%+v", err),
-			"template_id", vars["template_id"])
+			"schedule_id", vars["schedule_id"])
 		ep.encodeError(w, err)
 	} else {
-		ep.encodeResponse(w, tpl)
+		ep.encodeResponse(w, schedule)
 	}
 }
 
-// Create a template.
-func (ep *endpoints) CreateTemplate(w http.ResponseWriter, r *http.Request) {
-	var req state.CreateTemplateRequest
-	err := ep.decodeRequest(r, &req)
+// Creates a new schedule.
+func (ep *endpoints) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule state.Schedule
+	err := ep.decodeRequest(r, &schedule)
 	if err != nil {
 		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
 		return
 	}
 
-	created, err := ep.templateService.Create(&req)
+	created, err := ep.scheduleService.Create(&schedule, ep.ExtractUserInfo(r))
 	if err != nil {
 		ep.logger.Log(
-			"message", "problem creating template",
-			"operation", "CreateTemplate",
+			"message", "problem creating schedule",
+			"operation", "CreateSchedule",
 			"error", fmt.Sprintf("%+v", err))
 		ep.encodeError(w, err)
 	} else {
 		ep.encodeResponse(w, created)
 	}
 }
+
+// Updates an existing schedule.
+func (ep *endpoints) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule state.Schedule
+	err := ep.decodeRequest(r, &schedule)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+
+	vars := mux.Vars(r)
+	updated, err := ep.scheduleService.Update(vars["schedule_id"], schedule, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem updating schedule",
+			"operation", "UpdateSchedule",
+			"error", fmt.Sprintf("%+v", err),
+			"schedule_id", vars["schedule_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, updated)
+	}
+}
+
+// Deletes a schedule.
+func (ep *endpoints) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := ep.scheduleService.Delete(vars["schedule_id"], ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem deleting schedule",
+			"operation", "DeleteSchedule",
+			"error", fmt.Sprintf("%+v", err),
+			"schedule_id", vars["schedule_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, map[string]bool{"deleted": true})
+	}
+}
+
+// Lists workflows.
+func (ep *endpoints) ListWorkflows(w http.ResponseWriter, r *http.Request) {
+	lr := ep.decodeOrderableListRequest(r, &state.Workflow{})
+	wl, err := ep.workflowService.List(lr.limit, lr.offset, lr.sortBy, lr.order, lr.filters)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing workflows",
+			"operation", "ListWorkflows",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		if wl.Workflows == nil {
+			wl.Workflows = []state.Workflow{}
+		}
+		response := make(map[string]interface{})
+		response["total"] = wl.Total
+		response["workflows"] = wl.Workflows
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		response["sort_by"] = lr.sortBy
+		response["order"] = lr.order
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Get a workflow.
+func (ep *endpoints) GetWorkflow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workflow, err := ep.workflowService.Get(vars["workflow_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting workflow",
+			"operation", "GetWorkflow",
+			"error", fmt.Sprintf("%+v", err),
+			"workflow_id", vars["workflow_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, workflow)
+	}
+}
+
+// Creates a new workflow.
+func (ep *endpoints) CreateWorkflow(w http.ResponseWriter, r *http.Request) {
+	var workflow state.Workflow
+	err := ep.decodeRequest(r, &workflow)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+
+	created, err := ep.workflowService.Create(&workflow, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem creating workflow",
+			"operation", "CreateWorkflow",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, created)
+	}
+}
+
+// Cancels a workflow.
+func (ep *endpoints) CancelWorkflow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workflow, err := ep.workflowService.Cancel(vars["workflow_id"], ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem cancelling workflow",
+			"operation", "CancelWorkflow",
+			"error", fmt.Sprintf("%+v", err),
+			"workflow_id", vars["workflow_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, workflow)
+	}
+}
+
+// Lists quotas.
+func (ep *endpoints) ListQuotas(w http.ResponseWriter, r *http.Request) {
+	lr := ep.decodeOrderableListRequest(r, &state.Quota{})
+	ql, err := ep.quotaService.List(lr.limit, lr.offset, lr.sortBy, lr.order, lr.filters)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing quotas",
+			"operation", "ListQuotas",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		if ql.Quotas == nil {
+			ql.Quotas = []state.Quota{}
+		}
+		response := make(map[string]interface{})
+		response["total"] = ql.Total
+		response["quotas"] = ql.Quotas
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		response["sort_by"] = lr.sortBy
+		response["order"] = lr.order
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Get a quota with its current usage.
+func (ep *endpoints) GetQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	status, err := ep.quotaService.Get(vars["scope"], vars["name"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting quota",
+			"operation", "GetQuota",
+			"error", fmt.Sprintf("%+v", err),
+			"scope", vars["scope"],
+			"name", vars["name"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, status)
+	}
+}
+
+// Creates or replaces a quota.
+func (ep *endpoints) PutQuota(w http.ResponseWriter, r *http.Request) {
+	var quota state.Quota
+	err := ep.decodeRequest(r, &quota)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+
+	vars := mux.Vars(r)
+	quota.Scope = vars["scope"]
+	quota.Name = vars["name"]
+	stored, err := ep.quotaService.Put(&quota, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem putting quota",
+			"operation", "PutQuota",
+			"error", fmt.Sprintf("%+v", err),
+			"scope", vars["scope"],
+			"name", vars["name"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, stored)
+	}
+}
+
+// Deletes a quota.
+func (ep *endpoints) DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := ep.quotaService.Delete(vars["scope"], vars["name"], ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem deleting quota",
+			"operation", "DeleteQuota",
+			"error", fmt.Sprintf("%+v", err),
+			"scope", vars["scope"],
+			"name", vars["name"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, map[string]bool{"deleted": true})
+	}
+}
+
+// Lists webhooks.
+func (ep *endpoints) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	lr := ep.decodeOrderableListRequest(r, &state.Webhook{})
+	wl, err := ep.webhookService.List(lr.limit, lr.offset, lr.sortBy, lr.order, lr.filters)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing webhooks",
+			"operation", "ListWebhooks",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		if wl.Webhooks == nil {
+			wl.Webhooks = []state.Webhook{}
+		}
+		response := make(map[string]interface{})
+		response["total"] = wl.Total
+		response["webhooks"] = wl.Webhooks
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		response["sort_by"] = lr.sortBy
+		response["order"] = lr.order
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Get a webhook.
+func (ep *endpoints) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	webhook, err := ep.webhookService.Get(vars["webhook_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting webhook",
+			"operation", "GetWebhook",
+			"error", fmt.Sprintf("%+v", err),
+			"webhook_id", vars["webhook_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, webhook)
+	}
+}
+
+// Creates a new webhook; the response is the only one with its secret.
+func (ep *endpoints) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook state.Webhook
+	err := ep.decodeRequest(r, &webhook)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+
+	created, err := ep.webhookService.Create(&webhook, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem creating webhook",
+			"operation", "CreateWebhook",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, created)
+	}
+}
+
+// Deletes a webhook.
+func (ep *endpoints) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := ep.webhookService.Delete(vars["webhook_id"], ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem deleting webhook",
+			"operation", "DeleteWebhook",
+			"error", fmt.Sprintf("%+v", err),
+			"webhook_id", vars["webhook_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, map[string]bool{"deleted": true})
+	}
+}
+
+// Lists the deliveries of a webhook, newest first.
+func (ep *endpoints) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	lr := ep.decodeListRequest(r)
+	dl, err := ep.webhookService.ListDeliveries(vars["webhook_id"], lr.limit, lr.offset)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing webhook deliveries",
+			"operation", "ListWebhookDeliveries",
+			"error", fmt.Sprintf("%+v", err),
+			"webhook_id", vars["webhook_id"])
+		ep.encodeError(w, err)
+	} else {
+		if dl.Deliveries == nil {
+			dl.Deliveries = []state.WebhookDelivery{}
+		}
+		response := make(map[string]interface{})
+		response["total"] = dl.Total
+		response["deliveries"] = dl.Deliveries
+		response["limit"] = lr.limit
+		response["offset"] = lr.offset
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Sends a test event to a webhook.
+func (ep *endpoints) TestWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	delivery, err := ep.webhookService.Test(vars["webhook_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem testing webhook",
+			"operation", "TestWebhook",
+			"error", fmt.Sprintf("%+v", err),
+			"webhook_id", vars["webhook_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, delivery)
+	}
+}
+
+// Lists the exit reason rules in effect and where they come from.
+func (ep *endpoints) ListExitReasonRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := ep.exitReasonService.ListRules()
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing exit reason rules",
+			"operation", "ListExitReasonRules",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, rules)
+	}
+}
+
+// Replaces the exit reason rules; an empty list goes back to the configured ones.
+func (ep *endpoints) PutExitReasonRules(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rules state.ExitReasonRules `json:"rules"`
+	}
+	err := ep.decodeRequest(r, &req)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+
+	rules, err := ep.exitReasonService.PutRules(req.Rules, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem putting exit reason rules",
+			"operation", "PutExitReasonRules",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, rules)
+	}
+}
+
+// Classifies a past run with the given rules, or the rules in effect, without changing it.
+func (ep *endpoints) TestExitReasons(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RunID string                `json:"run_id"`
+		Rules state.ExitReasonRules `json:"rules"`
+	}
+	err := ep.decodeRequest(r, &req)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	if len(req.RunID) == 0 {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: "string [run_id] must be specified"})
+		return
+	}
+
+	result, err := ep.exitReasonService.Test(req.RunID, req.Rules)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem testing exit reason rules",
+			"operation", "TestExitReasons",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", req.RunID)
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, result)
+	}
+}
+
+// Counts the runs in each exit category; takes the same filters as listing runs.
+func (ep *endpoints) ListExitCategories(w http.ResponseWriter, r *http.Request) {
+	filters, _ := ep.getFilters(r.URL.Query(), nil)
+	counts, err := ep.exitReasonService.CountCategories(filters)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem counting exit categories",
+			"operation", "ListExitCategories",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		if counts == nil {
+			counts = []state.ExitCategoryCount{}
+		}
+		ep.encodeResponse(w, map[string]interface{}{"categories": counts})
+	}
+}
+
+// Get a template.
+func (ep *endpoints) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tpl, err := ep.templateService.GetByID(vars["template_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting templates",
+			"operation", "GetTemplate",
+			"error", fmt.Sprintf("%+v", err),
+			"template_id", vars["template_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, tpl)
+	}
+}
+
+// Create a template.
+func (ep *endpoints) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req state.CreateTemplateRequest
+	err := ep.decodeRequest(r, &req)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+
+	created, err := ep.templateService.Create(&req, ep.ExtractUserInfo(r))
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem creating template",
+			"operation", "CreateTemplate",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, created)
+	}
+}
+
+//
+// StreamRun streams the updates of a run as server-sent events: a "run"
+// event with the current state of the run, then its status, pod_event,
+// metrics and exit events as they are persisted. The stream ends after the
+// exit event.
+//
+func (ep *endpoints) StreamRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	// Subscribe before reading the run so updates in between aren't missed
+	sub, err := ep.streamBroker.Subscribe(stream.Filter{RunID: vars["run_id"]})
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem subscribing to run stream",
+			"operation", "StreamRun",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	run, err := ep.executionService.Get(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting run",
+			"operation", "StreamRun",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+		return
+	}
+
+	flusher, ok := ep.startStream(w)
+	if !ok {
+		return
+	}
+	if err = writeStreamEvent(w, "run", run); err != nil || run.Status == state.StatusStopped {
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+	ep.streamEvents(w, r, flusher, sub, true)
+}
+
+//
+// Stream streams the updates of every run matching the group_name,
+// definition_id and type query parameters as server-sent events
+//
+func (ep *endpoints) Stream(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	sub, err := ep.streamBroker.Subscribe(stream.Filter{
+		GroupName:    params["group_name"],
+		DefinitionID: params["definition_id"],
+		Types:        params["type"],
+	})
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem subscribing to run stream",
+			"operation", "Stream",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	flusher, ok := ep.startStream(w)
+	if !ok {
+		return
+	}
+	ep.streamEvents(w, r, flusher, sub, false)
+}
+
+func (ep *endpoints) startStream(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ep.encodeError(w, fmt.Errorf("streaming is not supported"))
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher, true
+}
+
+//
+// streamContext lifts the server's write timeout off a streaming response,
+// which then runs until its client goes away. Where the deadline can't be
+// lifted the context ends after streamTimeout, before the write timeout
+// would cut the response off.
+//
+func (ep *endpoints) streamContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc) {
+	if clearWriteDeadline(w) || ep.streamTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), ep.streamTimeout)
+}
+
+//
+// clearWriteDeadline removes the write deadline of w, unwrapping
+// middleware's writers, and reports whether it could
+//
+func clearWriteDeadline(w http.ResponseWriter) bool {
+	for {
+		switch rw := w.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			return rw.SetWriteDeadline(time.Time{}) == nil
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+//
+// streamEvents writes the events of sub until the client goes away, the
+// stream times out or, with untilExit, an exit event has been written.
+// Idle streams get a comment every streamHeartbeat to keep them open.
+//
+func (ep *endpoints) streamEvents(w http.ResponseWriter, r *http.Request, flusher http.Flusher, sub stream.Subscription, untilExit bool) {
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	ctx, cancel := ep.streamContext(w, r)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := writeStreamEvent(w, e.Type, e); err != nil {
+				return
+			}
+			if untilExit && e.Type == stream.EventExit {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, name string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+	return err
+}
@@ -1,12 +1,21 @@
 package flotilla
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
@@ -14,6 +23,11 @@ import (
 )
 
 func setUp(t *testing.T) *mux.Router {
+	ep, _ := setUpEndpoints(t)
+	return NewRouter(ep)
+}
+
+func setUpEndpoints(t *testing.T) (endpoints, *testutils.ImplementsAllTheThings) {
 	confDir := "../conf"
 	c, _ := config.NewConfig(&confDir)
 	imp := testutils.ImplementsAllTheThings{
@@ -38,11 +52,16 @@ func setUp(t *testing.T) *mux.Router {
 		Groups: []string{"g1", "g2", "g3"},
 		Tags:   []string{"t1", "t2", "t3"},
 	}
-	ds, _ := services.NewDefinitionService(&imp)
-	es, _ := services.NewExecutionService(c, &imp, &imp, &imp, &imp)
-	ls, _ := services.NewLogService(&imp, &imp)
-	ep := endpoints{definitionService: ds, executionService: es, eksLogService: ls}
-	return NewRouter(ep)
+	ds, _ := services.NewDefinitionService(&imp, &imp)
+	es, _ := services.NewExecutionService(c, &imp, &imp, &imp, &imp, &imp)
+	ls, _ := services.NewLogService(c, &imp, &imp)
+	as, _ := services.NewAuditService(&imp)
+	ss, _ := services.NewScheduleService(&imp, &imp)
+	ws, _ := services.NewWorkflowService(&imp, &imp)
+	qs, _ := services.NewQuotaService(&imp, &imp)
+	whs, _ := services.NewWebhookService(c, &imp, &imp)
+	ers, _ := services.NewExitReasonService(c, &imp, ls, &imp)
+	return endpoints{definitionService: ds, executionService: es, eksLogService: ls, auditService: as, scheduleService: ss, workflowService: ws, quotaService: qs, webhookService: whs, exitReasonService: ers, streamBroker: stream.NewLocalBroker(), streamTimeout: 5 * time.Second}, &imp
 }
 
 func TestEndpoints_CreateDefinition(t *testing.T) {
@@ -105,6 +124,138 @@ func TestEndpoints_UpdateDefinition(t *testing.T) {
 	}
 }
 
+func TestEndpoints_DefinitionRevisions(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("PUT", "/api/v6/task/C", bytes.NewBufferString(`{"image":"updatedImage"}`))
+	req.Header.Set("X-User-Email", "somebody@example.com")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/api/v6/task/C/revisions", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var revisions state.DefinitionRevisionList
+	if err := json.NewDecoder(w.Result().Body).Decode(&revisions); err != nil {
+		t.Fatal(err)
+	}
+	if revisions.Total != 2 {
+		t.Fatalf("Expected 2 revisions of C but was %v", revisions.Total)
+	}
+	if revisions.Revisions[0].User.Email != "somebody@example.com" {
+		t.Errorf("Expected latest revision to record the updating user, got %v", revisions.Revisions[0].User)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/task/C/revisions/1/diff/2", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var diff state.DefinitionRevisionDiff
+	if err := json.NewDecoder(w.Result().Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Field != "image" {
+		t.Errorf("Expected a single image change, got %v", diff.Changes)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v6/task/C/revisions/1/rollback", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var rolledBack state.Definition
+	if err := json.NewDecoder(w.Result().Body).Decode(&rolledBack); err != nil {
+		t.Fatal(err)
+	}
+	if w.Result().StatusCode != 200 || rolledBack.Image != "invalidimage" {
+		t.Errorf("Expected rollback to restore image [invalidimage] but was [%s]", rolledBack.Image)
+	}
+}
+
+func TestEndpoints_ListAuditEvents(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("DELETE", "/api/v6/task/B", nil)
+	req.Header.Set("X-User-Email", "somebody@example.com")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/api/v6/audit?action=definition.delete&order=desc", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var el state.AuditEventList
+	if err := json.NewDecoder(resp.Body).Decode(&el); err != nil {
+		t.Fatal(err)
+	}
+	if el.Total != 1 {
+		t.Fatalf("Expected a single audit event but was %v", el.Total)
+	}
+	if e := el.Events[0]; e.TargetID != "B" || e.Actor.Email != "somebody@example.com" {
+		t.Errorf("Expected the delete of B by somebody@example.com to be audited, got %v", e)
+	}
+}
+
+func TestEndpoints_Schedules(t *testing.T) {
+	router := setUp(t)
+
+	newSchedule := `{"name":"nightly", "cron_expression":"0 2 * * *", "time_zone":"America/New_York",
+		"executable_type":"task_definition", "executable_id":"A", "overlap_policy":"skip",
+		"definition_request":{"owner_id":"somebody"}}`
+	req := httptest.NewRequest("POST", "/api/v6/schedules", bytes.NewBufferString(newSchedule))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var created state.Schedule
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.ScheduleID) == 0 || created.NextRunAt == nil {
+		t.Errorf("Expected a schedule id and next run time, got %v", created)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/schedules/"+created.ScheduleID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var fetched state.Schedule
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.OverlapPolicy != state.ScheduleOverlapSkip || fetched.DefinitionRequest.OwnerID != "somebody" {
+		t.Errorf("Expected the stored schedule to be returned, got %v", fetched)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/schedules", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var sl state.ScheduleList
+	if err := json.NewDecoder(resp.Body).Decode(&sl); err != nil {
+		t.Fatal(err)
+	}
+	if sl.Total != 1 {
+		t.Errorf("Expected a single schedule but was %v", sl.Total)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v6/schedules/"+created.ScheduleID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", w.Result().StatusCode)
+	}
+}
+
 func TestEndpoints_CreateRun(t *testing.T) {
 	router := setUp(t)
 
@@ -470,6 +621,242 @@ func TestEndpoints_GetRun2(t *testing.T) {
 	}
 }
 
+func TestEndpoints_GetRunDefinition(t *testing.T) {
+	router := setUp(t)
+
+	newRun := `{"cluster":"cupcake", "run_tags":{"owner_email":"flotilla@github.com", "team_name":"thebest"}}`
+	req := httptest.NewRequest("PUT", "/api/v2/task/A/execute", bytes.NewBufferString(newRun))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var created state.Run
+	if err := json.NewDecoder(w.Result().Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/v6/history/%s/definition", created.RunID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var snapshot state.ExecutableSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Definition == nil || snapshot.Definition.DefinitionID != "A" {
+		t.Errorf("Expected snapshot of definition [A] but was %v", snapshot)
+	}
+	if created.ExecutableSnapshotID == nil || snapshot.SnapshotID != *created.ExecutableSnapshotID {
+		t.Errorf("Expected snapshot id to match the run's executable_snapshot_id")
+	}
+}
+
+func TestEndpoints_GetRunTransitions(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("PUT", "/api/v6/runA/status", bytes.NewBufferString(`{"status":"STOPPED"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Result().StatusCode != 200 {
+		t.Fatalf("Expected status 200 updating run status, was %v", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/history/runA/transitions", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var transitions state.RunStatusTransitionList
+	if err := json.NewDecoder(resp.Body).Decode(&transitions); err != nil {
+		t.Fatal(err)
+	}
+	if transitions.Total != 1 {
+		t.Fatalf("Expected 1 transition but got %v", transitions.Transitions)
+	}
+	tr := transitions.Transitions[0]
+	if tr.FromStatus != state.StatusRunning || tr.ToStatus != state.StatusStopped || tr.Source != state.TransitionSourceAPI {
+		t.Errorf("Expected a RUNNING -> STOPPED transition from the api, got %v", tr)
+	}
+}
+
+func TestEndpoints_GetRunAttempts(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("GET", "/api/v6/history/runA/attempts", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var attempts state.RunList
+	if err := json.NewDecoder(resp.Body).Decode(&attempts); err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Total != 1 || attempts.Runs[0].RunID != "runA" {
+		t.Errorf("Expected runA to be its only attempt, got %v", attempts.Runs)
+	}
+}
+
+func TestEndpoints_ArrayRun(t *testing.T) {
+	router := setUp(t)
+
+	newRun := `{"run_tags":{"owner_id":"flotilla"}, "array_size":3, "max_parallelism":1}`
+	req := httptest.NewRequest("PUT", "/api/v6/task/A/execute", bytes.NewBufferString(newRun))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var parent state.Run
+	if err := json.NewDecoder(resp.Body).Decode(&parent); err != nil {
+		t.Fatal(err)
+	}
+	if parent.TaskType != state.ArrayTaskType || parent.ArraySize == nil || *parent.ArraySize != 3 {
+		t.Errorf("Expected an array parent of size 3, got %v", parent)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/history/"+parent.RunID+"/array", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var summary state.ArraySummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.ArraySize != 3 || summary.Queued != 1 || summary.Held != 2 {
+		t.Errorf("Expected 1 queued and 2 held children, got %+v", summary)
+	}
+}
+
+func TestEndpoints_CreateRunIdempotencyKey(t *testing.T) {
+	router := setUp(t)
+
+	execute := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v6/task/A/execute", bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", "nightly")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var first, repeat state.Run
+	w := execute(`{"run_tags":{"owner_id":"flotilla"}, "command":"echo"}`)
+	if err := json.NewDecoder(w.Body).Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	w = execute(`{"run_tags":{"owner_id":"flotilla"}, "command":"echo"}`)
+	if err := json.NewDecoder(w.Body).Decode(&repeat); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 || len(first.RunID) == 0 || repeat.RunID != first.RunID {
+		t.Errorf("Expected the repeat to return run %s, got %s", first.RunID, repeat.RunID)
+	}
+}
+
+func TestEndpoints_CreateRunLabels(t *testing.T) {
+	ep, imp := setUpEndpoints(t)
+	router := NewRouter(ep)
+
+	for _, path := range []string{"/api/v6/task/A/execute", "/api/v6/task/alias/aliasA/execute"} {
+		body := `{"run_tags":{"owner_id":"flotilla"}, "labels":{"team":"data"}}`
+		req := httptest.NewRequest("PUT", path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var r state.Run
+		if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != 200 || imp.Runs[r.RunID].Labels["team"] != "data" {
+			t.Errorf("Expected %s to save the run's labels, got %v", path, imp.Runs[r.RunID].Labels)
+		}
+	}
+}
+
+func TestEndpoints_Workflows(t *testing.T) {
+	router := setUp(t)
+
+	newWorkflow := `{"name":"etl", "nodes":[
+		{"name":"extract", "executable_type":"task_definition", "executable_id":"A",
+		 "definition_request":{"owner_id":"somebody"}},
+		{"name":"load", "executable_type":"task_definition", "executable_id":"B",
+		 "definition_request":{"owner_id":"somebody"}, "depends_on":[{"node":"extract"}]}]}`
+	req := httptest.NewRequest("POST", "/api/v6/workflows", bytes.NewBufferString(newWorkflow))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var created state.Workflow
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.WorkflowID) == 0 || created.Status != state.WorkflowStatusRunning || len(created.Nodes) != 2 {
+		t.Errorf("Expected a running workflow with 2 nodes, got %v", created)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/workflows/"+created.WorkflowID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var fetched state.Workflow
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Nodes[1].DependsOn[0].Condition != state.WorkflowConditionOnSuccess {
+		t.Errorf("Expected the stored workflow to be returned, got %v", fetched)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/workflows", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var listed map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if listed["total"].(float64) != 1 {
+		t.Errorf("Expected 1 workflow, got %v", listed["total"])
+	}
+
+	req = httptest.NewRequest("POST", "/api/v6/workflows/"+created.WorkflowID+"/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var cancelled state.Workflow
+	if err := json.NewDecoder(resp.Body).Decode(&cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled.CancelRequested {
+		t.Errorf("Expected cancellation to be requested, got %v", cancelled)
+	}
+}
+
 func TestEndpoints_GetTags(t *testing.T) {
 	router := setUp(t)
 
@@ -681,6 +1068,55 @@ func TestEndpoints_ListRuns(t *testing.T) {
 	}
 }
 
+func TestEndpoints_ListRunsByCursor(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest(
+		"GET",
+		"/api/v6/history?status=RUNNING&limit=100&sort_by=started_at&order=desc&cursor=", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	var r map[string]interface{}
+	err := json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if _, ok := r["next_cursor"]; !ok {
+		t.Errorf("Expected next_cursor in response")
+	}
+
+	if _, ok := r["offset"]; ok {
+		t.Errorf("Expected no offset in cursor paginated response")
+	}
+
+	if _, ok := r["total"]; ok {
+		t.Errorf("Expected no total in cursor paginated response without approximate_total")
+	}
+
+	if _, ok := r["cursor"]; ok {
+		t.Errorf("Expected cursor not to be treated as a filter")
+	}
+
+	req = httptest.NewRequest(
+		"GET",
+		"/api/v6/history?limit=100&sort_by=started_at&order=desc&cursor=&approximate_total=true", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	json.NewDecoder(w.Result().Body).Decode(&r)
+
+	if _, ok := r["total"]; !ok {
+		t.Errorf("Expected total in response when approximate_total=true")
+	}
+}
+
 func TestEndpoints_StopRun(t *testing.T) {
 	router := setUp(t)
 
@@ -707,3 +1143,446 @@ func TestEndpoints_StopRun(t *testing.T) {
 		t.Errorf("Expected [terminated] acknowledgement")
 	}
 }
+
+func TestEndpoints_Quotas(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("PUT", "/api/v6/admin/quotas/group/A", bytes.NewBufferString(`{"max_runs":2}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/admin/quotas/group/A", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var status state.QuotaStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Scope != state.QuotaScopeGroup || status.Name != "A" || *status.MaxRuns != 2 {
+		t.Errorf("Expected the stored quota to be returned, got %v", status)
+	}
+	if status.Usage.Runs != 1 {
+		t.Errorf("Expected the group's running run to be counted, got %+v", status.Usage)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/admin/quotas", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var listed map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if listed["total"].(float64) != 1 {
+		t.Errorf("Expected 1 quota, got %v", listed["total"])
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v6/admin/quotas/group/A", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+}
+
+func TestEndpoints_ExitReasons(t *testing.T) {
+	ep, imp := setUpEndpoints(t)
+	// Rejected rules are logged
+	ep.logger = imp
+	router := NewRouter(ep)
+
+	req := httptest.NewRequest("GET", "/api/v6/exit_reasons/rules", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var listed services.ExitReasonRuleList
+	if err := json.NewDecoder(w.Result().Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if listed.Source != services.ExitReasonSourceDefault || len(listed.Rules) == 0 {
+		t.Errorf("Expected the default rules, got %v", listed)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v6/exit_reasons/rules",
+		bytes.NewBufferString(`{"rules":[{"name":"all","pattern":"(syntaxerror|)","target":"exceptions","category":"code","message":"Code error"}]}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a pattern matching the empty string, got %v", w.Code)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v6/exit_reasons/rules",
+		bytes.NewBufferString(`{"rules":[{"name":"key","pattern":"KeyError","target":"exceptions","category":"data","message":"Missing key"}]}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", w.Code)
+	}
+	if len(imp.ExitReasonRules) != 1 {
+		t.Errorf("Expected the rules to be stored, got %v", imp.ExitReasonRules)
+	}
+
+	run := imp.Runs["runB"]
+	runExceptions := state.RunExceptions{"KeyError: 'id'"}
+	run.RunExceptions = &runExceptions
+	imp.Runs["runB"] = run
+	req = httptest.NewRequest("POST", "/api/v6/exit_reasons/test", bytes.NewBufferString(`{"run_id":"runB"}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var tested services.ExitReasonTest
+	if err := json.NewDecoder(w.Result().Body).Decode(&tested); err != nil {
+		t.Fatal(err)
+	}
+	if tested.RunID != "runB" || tested.Match == nil || tested.Match.Rule.Name != "key" {
+		t.Errorf("Expected runB to match the stored rule, got %v", tested)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v6/exit_reasons/test", bytes.NewBufferString(`{}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a run_id, got %v", w.Code)
+	}
+
+	category := "data"
+	run.ExitCategory = &category
+	imp.Runs["runB"] = run
+	req = httptest.NewRequest("GET", "/api/v6/exit_reasons/categories?definition_id=B", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var counted struct {
+		Categories []state.ExitCategoryCount `json:"categories"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&counted); err != nil {
+		t.Fatal(err)
+	}
+	if len(counted.Categories) != 1 || counted.Categories[0] != (state.ExitCategoryCount{Category: "data", Count: 1}) {
+		t.Errorf("Expected one run in the data category, got %v", counted.Categories)
+	}
+}
+
+func TestEndpoints_Webhooks(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("POST", "/api/v6/webhooks",
+		bytes.NewBufferString(`{"scope":"group","name":"A","url":"https://example.com/hook","events":["run.failed"]}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, was %v", resp.StatusCode)
+	}
+	var created state.Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.WebhookID) == 0 || len(created.Secret) == 0 {
+		t.Errorf("Expected the created webhook with its secret, got %v", created)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/webhooks/"+created.WebhookID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var fetched state.Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.WebhookID != created.WebhookID || len(fetched.Secret) != 0 {
+		t.Errorf("Expected the webhook without its secret, got %v", fetched)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/webhooks", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var listed map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if listed["total"].(float64) != 1 {
+		t.Errorf("Expected 1 webhook, got %v", listed["total"])
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/webhooks/"+created.WebhookID+"/deliveries", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	var deliveries map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries["total"].(float64) != 0 {
+		t.Errorf("Expected no deliveries, got %v", deliveries["total"])
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v6/webhooks/"+created.WebhookID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp = w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+}
+
+func readStreamEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected a stream event, got error %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && len(name) > 0:
+			return name, data
+		}
+	}
+}
+
+func TestEndpoints_StreamRun(t *testing.T) {
+	ep, _ := setUpEndpoints(t)
+	srv := httptest.NewServer(NewRouter(ep))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v6/history/runA/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected Content-Type [text/event-stream], but was [%s]", resp.Header.Get("Content-Type"))
+	}
+
+	r := bufio.NewReader(resp.Body)
+	name, data := readStreamEvent(t, r)
+	if name != "run" {
+		t.Fatalf("Expected run snapshot event first, got [%s]", name)
+	}
+	var run state.Run
+	if err = json.Unmarshal([]byte(data), &run); err != nil || run.RunID != "runA" {
+		t.Errorf("Expected snapshot of runA, got [%s]", data)
+	}
+
+	exitCode := int64(0)
+	_ = ep.streamBroker.Publish(stream.Event{Type: stream.EventStatus, RunID: "runB", Status: state.StatusStopped})
+	_ = ep.streamBroker.Publish(stream.Event{Type: stream.EventExit, RunID: "runA", Status: state.StatusStopped, ExitCode: &exitCode})
+	name, data = readStreamEvent(t, r)
+	if name != stream.EventExit {
+		t.Fatalf("Expected exit event, got [%s]", name)
+	}
+	var e stream.Event
+	if err = json.Unmarshal([]byte(data), &e); err != nil || e.RunID != "runA" || e.ExitCode == nil || *e.ExitCode != 0 {
+		t.Errorf("Expected exit event of runA, got [%s]", data)
+	}
+
+	// The stream ends after the exit event
+	if _, err = r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Expected stream to end after exit event, got %v", err)
+	}
+}
+
+func TestEndpoints_Stream(t *testing.T) {
+	ep, _ := setUpEndpoints(t)
+	srv := httptest.NewServer(NewRouter(ep))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v6/stream?group_name=A&type=status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	_ = ep.streamBroker.Publish(stream.Event{Type: stream.EventStatus, RunID: "runB", GroupName: "B", Status: state.StatusRunning})
+	_ = ep.streamBroker.Publish(stream.Event{Type: stream.EventMetrics, RunID: "runA", GroupName: "A", Status: state.StatusRunning})
+	_ = ep.streamBroker.Publish(stream.Event{Type: stream.EventStatus, RunID: "runA", GroupName: "A", Status: state.StatusStopped})
+
+	name, data := readStreamEvent(t, bufio.NewReader(resp.Body))
+	var e stream.Event
+	if err = json.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatal(err)
+	}
+	if name != stream.EventStatus || e.RunID != "runA" || e.Status != state.StatusStopped {
+		t.Errorf("Expected only the status event of group A, got [%s] %s", name, data)
+	}
+}
+
+func TestEndpoints_FollowLogs(t *testing.T) {
+	ep, imp := setUpEndpoints(t)
+	router := NewRouter(ep)
+	imp.LogLines = []string{"one\n", "two\n", "three\n"}
+	run := imp.Runs["runA"]
+	run.Status = state.StatusStopped
+	imp.Runs["runA"] = run
+
+	req := httptest.NewRequest("GET", "/api/v6/runA/logs?follow=true&last_seen=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Expected Content-Type [text/plain; charset=utf-8], but was [%s]", resp.Header.Get("Content-Type"))
+	}
+	if w.Body.String() != "two\nthree\n" {
+		t.Errorf("Expected logs after last_seen, got [%s]", w.Body.String())
+	}
+	if resp.Trailer.Get("X-Flotilla-Last-Seen") != "3" {
+		t.Errorf("Expected last seen trailer [3], got [%s]", resp.Trailer.Get("X-Flotilla-Last-Seen"))
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/runA/logs?follow=true", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "2")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if !strings.HasPrefix(w.Body.String(), "id: 3\n") {
+		t.Errorf("Expected log event with the cursor as id, got [%s]", w.Body.String())
+	}
+	r := bufio.NewReader(w.Body)
+	name, data := readStreamEvent(t, r)
+	if name != "log" || data != `{"last_seen":"3","log":"three\n"}` {
+		t.Errorf("Expected log event resuming from Last-Event-ID, got [%s] %s", name, data)
+	}
+	name, data = readStreamEvent(t, r)
+	if name != "end" || data != `{"last_seen":"3"}` {
+		t.Errorf("Expected end event, got [%s] %s", name, data)
+	}
+}
+
+func TestEndpoints_FollowLogsError(t *testing.T) {
+	ep, imp := setUpEndpoints(t)
+	ep.logger = imp
+	router := NewRouter(ep)
+	imp.LogsError = fmt.Errorf("logs unavailable")
+	run := imp.Runs["runA"]
+	run.Status = state.StatusStopped
+	imp.Runs["runA"] = run
+
+	req := httptest.NewRequest("GET", "/api/v6/runA/logs?follow=true&last_seen=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.Trailer.Get("X-Flotilla-Error") != "logs unavailable" {
+		t.Errorf("Expected error trailer [logs unavailable], got [%s]", resp.Trailer.Get("X-Flotilla-Error"))
+	}
+	if resp.Trailer.Get("X-Flotilla-Last-Seen") != "1" {
+		t.Errorf("Expected last seen trailer [1], got [%s]", resp.Trailer.Get("X-Flotilla-Last-Seen"))
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/runA/logs?follow=true&last_seen=1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	name, data := readStreamEvent(t, bufio.NewReader(w.Body))
+	if name != "error" || data != `{"error":"logs unavailable","last_seen":"1"}` {
+		t.Errorf("Expected error event, got [%s] %s", name, data)
+	}
+}
+
+// slowLogService follows logs that take longer than the write timeout
+type slowLogService struct {
+	services.LogService
+	delay time.Duration
+}
+
+func (ls slowLogService) Follow(ctx context.Context, runID string, lastSeen *string, role *string, facility *string, emit func(log string, lastSeen string) error) error {
+	if err := emit("one\n", "1"); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(ls.delay):
+	}
+	return emit("two\n", "2")
+}
+
+func TestEndpoints_FollowLogsOutlastsWriteTimeout(t *testing.T) {
+	ep, _ := setUpEndpoints(t)
+	ep.eksLogService = slowLogService{LogService: ep.eksLogService, delay: 300 * time.Millisecond}
+	ep.streamTimeout = 100 * time.Millisecond
+	srv := httptest.NewUnstartedServer(NewRouter(ep))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v6/runA/logs?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "one\ntwo\n" {
+		t.Errorf("Expected logs written after the write timeout, got [%s]", body)
+	}
+	if resp.Trailer.Get("X-Flotilla-Last-Seen") != "2" {
+		t.Errorf("Expected last seen trailer [2], got [%s]", resp.Trailer.Get("X-Flotilla-Last-Seen"))
+	}
+}
+
+func TestEndpoints_SearchLogs(t *testing.T) {
+	ep, imp := setUpEndpoints(t)
+	router := NewRouter(ep)
+	imp.LogLines = []string{"one\n", "Traceback\n", "three\n"}
+	run := imp.Runs["runA"]
+	run.Status = state.StatusStopped
+	imp.Runs["runA"] = run
+
+	req := httptest.NewRequest("GET", "/api/v6/runA/logs/search?q=^Trace&context=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Expected Content-Type [application/x-ndjson], but was [%s]", resp.Header.Get("Content-Type"))
+	}
+	expected := `{"line":1,"text":"one","match":false}
+{"line":2,"text":"Traceback","match":true}
+{"line":3,"text":"three","match":false}
+{"summary":{"lines_scanned":3,"bytes_scanned":20,"matches":1,"truncated":false}}
+`
+	if w.Body.String() != expected {
+		t.Errorf("Expected matching lines with context and a summary, got [%s]", w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/runA/logs/search?q=(", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid query, got %v", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/runA/logs/search", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a query, got %v", w.Code)
+	}
+}
@@ -53,10 +53,20 @@ func NewRouter(ep endpoints) *mux.Router {
 	v6.HandleFunc("/task/{definition_id}/execute", ep.CreateRunV4).Methods("PUT")
 	v6.HandleFunc("/task/alias/{alias}", ep.GetDefinitionByAlias).Methods("GET")
 	v6.HandleFunc("/task/alias/{alias}/execute", ep.CreateRunByAlias).Methods("PUT")
+	v6.HandleFunc("/task/{definition_id}/revisions", ep.ListDefinitionRevisions).Methods("GET")
+	v6.HandleFunc("/task/{definition_id}/revisions/{revision:[0-9]+}", ep.GetDefinitionRevision).Methods("GET")
+	v6.HandleFunc("/task/{definition_id}/revisions/{from:[0-9]+}/diff/{to:[0-9]+}", ep.DiffDefinitionRevisions).Methods("GET")
+	v6.HandleFunc("/task/{definition_id}/revisions/{revision:[0-9]+}/rollback", ep.RollbackDefinition).Methods("POST")
 
 	v6.HandleFunc("/history", ep.ListRuns).Methods("GET")
 	v6.HandleFunc("/history/{run_id}", ep.GetRun).Methods("GET")
 	v6.HandleFunc("/history/{run_id}/payload", ep.GetPayload).Methods("GET")
+	v6.HandleFunc("/history/{run_id}/definition", ep.GetRunDefinition).Methods("GET")
+	v6.HandleFunc("/history/{run_id}/transitions", ep.GetRunTransitions).Methods("GET")
+	v6.HandleFunc("/history/{run_id}/attempts", ep.GetRunAttempts).Methods("GET")
+	v6.HandleFunc("/history/{run_id}/array", ep.GetArraySummary).Methods("GET")
+	v6.HandleFunc("/history/{run_id}/stream", ep.StreamRun).Methods("GET")
+	v6.HandleFunc("/stream", ep.Stream).Methods("GET")
 	v6.HandleFunc("/task/history/{run_id}", ep.GetRun).Methods("GET")
 	v6.HandleFunc("/task/{definition_id}/history", ep.ListDefinitionRuns).Methods("GET")
 	v6.HandleFunc("/task/{definition_id}/history/{run_id}", ep.GetRun).Methods("GET")
@@ -64,10 +74,39 @@ func NewRouter(ep endpoints) *mux.Router {
 
 	v6.HandleFunc("/{run_id}/status", ep.UpdateRun).Methods("PUT")
 	v6.HandleFunc("/{run_id}/logs", ep.GetLogs).Methods("GET")
+	v6.HandleFunc("/{run_id}/logs/search", ep.SearchLogs).Methods("GET")
 	v6.HandleFunc("/groups", ep.GetGroups).Methods("GET")
 	v6.HandleFunc("/tags", ep.GetTags).Methods("GET")
 	v6.HandleFunc("/clusters", ep.ListClusters).Methods("GET")
 	v6.HandleFunc("/{run_id}/events", ep.GetEvents).Methods("GET")
+	v6.HandleFunc("/audit", ep.ListAuditEvents).Methods("GET")
+	v6.HandleFunc("/schedules", ep.ListSchedules).Methods("GET")
+	v6.HandleFunc("/schedules", ep.CreateSchedule).Methods("POST")
+	v6.HandleFunc("/schedules/{schedule_id}", ep.GetSchedule).Methods("GET")
+	v6.HandleFunc("/schedules/{schedule_id}", ep.UpdateSchedule).Methods("PUT")
+	v6.HandleFunc("/schedules/{schedule_id}", ep.DeleteSchedule).Methods("DELETE")
+
+	v6.HandleFunc("/workflows", ep.ListWorkflows).Methods("GET")
+	v6.HandleFunc("/workflows", ep.CreateWorkflow).Methods("POST")
+	v6.HandleFunc("/workflows/{workflow_id}", ep.GetWorkflow).Methods("GET")
+	v6.HandleFunc("/workflows/{workflow_id}/cancel", ep.CancelWorkflow).Methods("POST")
+
+	v6.HandleFunc("/webhooks", ep.ListWebhooks).Methods("GET")
+	v6.HandleFunc("/webhooks", ep.CreateWebhook).Methods("POST")
+	v6.HandleFunc("/webhooks/{webhook_id}", ep.GetWebhook).Methods("GET")
+	v6.HandleFunc("/webhooks/{webhook_id}", ep.DeleteWebhook).Methods("DELETE")
+	v6.HandleFunc("/webhooks/{webhook_id}/deliveries", ep.ListWebhookDeliveries).Methods("GET")
+	v6.HandleFunc("/webhooks/{webhook_id}/test", ep.TestWebhook).Methods("POST")
+
+	v6.HandleFunc("/exit_reasons/rules", ep.ListExitReasonRules).Methods("GET")
+	v6.HandleFunc("/exit_reasons/rules", ep.PutExitReasonRules).Methods("PUT")
+	v6.HandleFunc("/exit_reasons/test", ep.TestExitReasons).Methods("POST")
+	v6.HandleFunc("/exit_reasons/categories", ep.ListExitCategories).Methods("GET")
+
+	v6.HandleFunc("/admin/quotas", ep.ListQuotas).Methods("GET")
+	v6.HandleFunc("/admin/quotas/{scope}/{name}", ep.GetQuota).Methods("GET")
+	v6.HandleFunc("/admin/quotas/{scope}/{name}", ep.PutQuota).Methods("PUT")
+	v6.HandleFunc("/admin/quotas/{scope}/{name}", ep.DeleteQuota).Methods("DELETE")
 
 	v7 := r.PathPrefix("/api/v7").Subrouter()
 	v7.HandleFunc("/template/{template_id}/execute", ep.CreateTemplateRun).Methods("PUT")
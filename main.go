@@ -22,9 +22,22 @@ func main() {
 	args := os.Args
 	if len(args) < 2 {
 		fmt.Println("Usage: flotilla-os <conf_dir>")
+		fmt.Println("       flotilla-os migrate up|down|status <conf_dir>")
 		os.Exit(1)
 	}
 
+	if args[1] == "migrate" {
+		if len(args) < 4 {
+			fmt.Println("Usage: flotilla-os migrate up|down|status <conf_dir>")
+			os.Exit(1)
+		}
+		if err := migrate(args[2], args[3]); err != nil {
+			fmt.Printf("%+v\n", errors.Wrap(err, "unable to migrate"))
+			os.Exit(1)
+		}
+		return
+	}
+
 	//
 	// Use go-kit for structured logging
 	//
@@ -125,3 +138,46 @@ func main() {
 
 	log.Fatal(app.Run())
 }
+
+//
+// migrate applies, reverts or reports on the postgres schema migrations
+//
+func migrate(action string, confDir string) error {
+	c, err := config.NewConfig(&confDir)
+	if err != nil {
+		return errors.Wrap(err, "unable to initialize config")
+	}
+
+	migrator, err := state.NewMigratorFromConfig(c)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Println(m)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down()
+		if reverted != nil {
+			fmt.Printf("reverted %d\t%s\n", reverted.Version, reverted.Name)
+		} else if err == nil {
+			fmt.Println("no migrations to revert")
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		for _, m := range statuses {
+			fmt.Println(m)
+		}
+		return err
+	default:
+		return errors.Errorf("unknown migrate action [%s], must be one of up, down, status", action)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// AuditService defines an interface for reading the audit log of mutating
+// operations
+//
+type AuditService interface {
+	List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.AuditEventList, error)
+}
+
+type auditService struct {
+	sm state.Manager
+}
+
+//
+// NewAuditService configures and returns an AuditService
+//
+func NewAuditService(sm state.Manager) (AuditService, error) {
+	as := auditService{sm: sm}
+	return &as, nil
+}
+
+// List lists audit events
+func (as *auditService) List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.AuditEventList, error) {
+	return as.sm.ListAuditEvents(limit, offset, sortBy, order, filters)
+}
+
+//
+// recordAudit writes an audit event for a mutating operation. Services call
+// it after the operation has been committed, so a failure to write the
+// event is logged rather than failing an operation that already happened.
+// Nil before or after are left out of the event.
+//
+func recordAudit(sm state.Manager, logger flotillaLog.Logger, actor state.UserInfo, action string, targetType string, targetID string, before interface{}, after interface{}) {
+	e, err := state.NewAuditEvent(actor, action, targetType, targetID, before, after)
+	if err == nil {
+		err = sm.CreateAuditEvent(e)
+	}
+	if err != nil {
+		_ = logger.Log(
+			"level", "error",
+			"message", "unable to record audit event",
+			"action", action,
+			"target_type", targetType,
+			"target_id", targetID,
+			"error", fmt.Sprintf("%+v", err))
+	}
+}
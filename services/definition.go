@@ -1,9 +1,13 @@
 package services
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/state"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -13,14 +17,20 @@ import (
 // * Like the ExecutionService, is an intermediary layer between state and the execution engine
 //
 type DefinitionService interface {
-	Create(definition *state.Definition) (state.Definition, error)
+	Create(definition *state.Definition, userInfo state.UserInfo) (state.Definition, error)
 	Get(definitionID string) (state.Definition, error)
 	GetByAlias(alias string) (state.Definition, error)
 	List(limit int, offset int, sortBy string,
 		order string, filters map[string][]string,
 		envFilters map[string]string) (state.DefinitionList, error)
-	Update(definitionID string, updates state.Definition) (state.Definition, error)
-	Delete(definitionID string) error
+	Update(definitionID string, updates state.Definition, userInfo state.UserInfo) (state.Definition, error)
+	Delete(definitionID string, userInfo state.UserInfo) error
+
+	// Revision oriented
+	ListRevisions(definitionID string, limit int, offset int) (state.DefinitionRevisionList, error)
+	GetRevision(definitionID string, revision int64) (state.DefinitionRevision, error)
+	DiffRevisions(definitionID string, from int64, to int64) (state.DefinitionRevisionDiff, error)
+	Rollback(definitionID string, revision int64, userInfo state.UserInfo) (state.Definition, error)
 
 	// Metadata oriented
 	ListGroups(limit int, offset int, name *string) (state.GroupsList, error)
@@ -28,14 +38,15 @@ type DefinitionService interface {
 }
 
 type definitionService struct {
-	sm state.Manager
+	sm     state.Manager
+	logger flotillaLog.Logger
 }
 
 //
 // NewDefinitionService configures and returns a DefinitionService
 //
-func NewDefinitionService(stateManager state.Manager) (DefinitionService, error) {
-	ds := definitionService{sm: stateManager}
+func NewDefinitionService(stateManager state.Manager, logger flotillaLog.Logger) (DefinitionService, error) {
+	ds := definitionService{sm: stateManager, logger: logger}
 	return &ds, nil
 }
 
@@ -44,8 +55,9 @@ func NewDefinitionService(stateManager state.Manager) (DefinitionService, error)
 // * Allocates new definition id
 // * Defines definition with execution engine
 // * Stores definition using state manager
+// * Records the definition as its first revision
 //
-func (ds *definitionService) Create(definition *state.Definition) (state.Definition, error) {
+func (ds *definitionService) Create(definition *state.Definition, userInfo state.UserInfo) (state.Definition, error) {
 	if valid, reasons := definition.IsValid(); !valid {
 		return state.Definition{}, exceptions.MalformedInput{strings.Join(reasons, "\n")}
 	}
@@ -65,7 +77,15 @@ func (ds *definitionService) Create(definition *state.Definition) (state.Definit
 		return state.Definition{}, err
 	}
 	definition.DefinitionID = definitionID
-	return *definition, ds.sm.CreateDefinition(*definition)
+	if err = ds.sm.CreateDefinition(*definition); err != nil {
+		return *definition, err
+	}
+	if _, err = ds.sm.CreateDefinitionRevision(definitionID, *definition, userInfo); err != nil {
+		return *definition, err
+	}
+	recordAudit(ds.sm, ds.logger, userInfo,
+		state.AuditActionDefinitionCreate, state.AuditTargetDefinition, definitionID, nil, *definition)
+	return *definition, nil
 }
 
 func (ds *definitionService) aliasExists(alias string) (bool, error) {
@@ -103,20 +123,167 @@ func (ds *definitionService) List(limit int, offset int, sortBy string,
 	return ds.sm.ListDefinitions(limit, offset, sortBy, order, filters, envFilters)
 }
 
-// UpdateStatus updates the definition specified by definitionID with the given updates
-func (ds *definitionService) Update(definitionID string, updates state.Definition) (state.Definition, error) {
+// Update updates the definition specified by definitionID with the given
+// updates and records the result as a new revision
+func (ds *definitionService) Update(definitionID string, updates state.Definition, userInfo state.UserInfo) (state.Definition, error) {
 	definition, err := ds.sm.GetDefinition(definitionID)
 	if err != nil {
 		return definition, err
 	}
 
+	if err = ds.ensureBaseRevision(definition); err != nil {
+		return definition, err
+	}
+
+	before := definition
 	definition.UpdateWith(updates)
-	return ds.sm.UpdateDefinition(definitionID, definition)
+	return ds.updateAndRecord(definitionID, before, definition, userInfo, state.AuditActionDefinitionUpdate)
+}
+
+//
+// ensureBaseRevision records the current state of definitions created before
+// revisions were tracked so their first update can be diffed and rolled back
+//
+func (ds *definitionService) ensureBaseRevision(definition state.Definition) error {
+	revisions, err := ds.sm.ListDefinitionRevisions(definition.DefinitionID, 1, 0)
+	if err != nil {
+		return err
+	}
+	if revisions.Total == 0 {
+		_, err = ds.sm.CreateDefinitionRevision(definition.DefinitionID, definition, state.UserInfo{})
+	}
+	return err
+}
+
+//
+// updateAndRecord saves definition, records the result as a new revision and
+// audits the change from before
+//
+func (ds *definitionService) updateAndRecord(definitionID string, before state.Definition, definition state.Definition, userInfo state.UserInfo, action string) (state.Definition, error) {
+	updated, err := ds.sm.UpdateDefinition(definitionID, definition)
+	if err != nil {
+		return updated, err
+	}
+	return ds.recordUpdate(definitionID, before, updated, userInfo, action)
+}
+
+//
+// recordUpdate records a saved definition as a new revision and audits the
+// change from before
+//
+func (ds *definitionService) recordUpdate(definitionID string, before state.Definition, updated state.Definition, userInfo state.UserInfo, action string) (state.Definition, error) {
+	if _, err := ds.sm.CreateDefinitionRevision(definitionID, updated, userInfo); err != nil {
+		return updated, err
+	}
+	recordAudit(ds.sm, ds.logger, userInfo, action, state.AuditTargetDefinition, definitionID, before, updated)
+	return updated, nil
+}
+
+// ListRevisions lists the revisions of a definition, newest first
+func (ds *definitionService) ListRevisions(definitionID string, limit int, offset int) (state.DefinitionRevisionList, error) {
+	return ds.sm.ListDefinitionRevisions(definitionID, limit, offset)
+}
+
+// GetRevision returns a single revision of a definition
+func (ds *definitionService) GetRevision(definitionID string, revision int64) (state.DefinitionRevision, error) {
+	return ds.sm.GetDefinitionRevision(definitionID, revision)
+}
+
+//
+// DiffRevisions returns the fields that changed going from revision `from`
+// to revision `to`, keyed by their json names
+//
+func (ds *definitionService) DiffRevisions(definitionID string, from int64, to int64) (state.DefinitionRevisionDiff, error) {
+	diff := state.DefinitionRevisionDiff{
+		DefinitionID: definitionID,
+		From:         from,
+		To:           to,
+		Changes:      []state.DefinitionRevisionChange{},
+	}
+
+	fromRevision, err := ds.sm.GetDefinitionRevision(definitionID, from)
+	if err != nil {
+		return diff, err
+	}
+	toRevision, err := ds.sm.GetDefinitionRevision(definitionID, to)
+	if err != nil {
+		return diff, err
+	}
+
+	fromFields, err := definitionFields(fromRevision.Definition)
+	if err != nil {
+		return diff, err
+	}
+	toFields, err := definitionFields(toRevision.Definition)
+	if err != nil {
+		return diff, err
+	}
+
+	var names []string
+	for name := range fromFields {
+		names = append(names, name)
+	}
+	for name := range toFields {
+		if _, ok := fromFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !reflect.DeepEqual(fromFields[name], toFields[name]) {
+			diff.Changes = append(diff.Changes, state.DefinitionRevisionChange{
+				Field: name, From: fromFields[name], To: toFields[name]})
+		}
+	}
+	return diff, nil
+}
+
+func definitionFields(d state.Definition) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(b, &fields)
+}
+
+//
+// Rollback restores the definition to the contents of an earlier revision.
+// The rollback itself is recorded as a new revision so history is never
+// rewritten.
+//
+func (ds *definitionService) Rollback(definitionID string, revision int64, userInfo state.UserInfo) (state.Definition, error) {
+	target, err := ds.sm.GetDefinitionRevision(definitionID, revision)
+	if err != nil {
+		return state.Definition{}, err
+	}
+	before, err := ds.sm.GetDefinition(definitionID)
+	if err != nil {
+		return before, err
+	}
+
+	// Replace rather than update, so fields unset at the target revision are
+	// cleared instead of keeping their current values
+	updated, err := ds.sm.ReplaceDefinition(definitionID, target.Definition)
+	if err != nil {
+		return updated, err
+	}
+	return ds.recordUpdate(definitionID, before, updated, userInfo, state.AuditActionDefinitionRollback)
 }
 
 // Delete deletes and deregisters the definition specified by definitionID
-func (ds *definitionService) Delete(definitionID string) error {
-	return ds.sm.DeleteDefinition(definitionID)
+func (ds *definitionService) Delete(definitionID string, userInfo state.UserInfo) error {
+	before, err := ds.sm.GetDefinition(definitionID)
+	if err != nil {
+		return err
+	}
+	if err = ds.sm.DeleteDefinition(definitionID); err != nil {
+		return err
+	}
+	recordAudit(ds.sm, ds.logger, userInfo,
+		state.AuditActionDefinitionDelete, state.AuditTargetDefinition, definitionID, before, nil)
+	return nil
 }
 
 func (ds *definitionService) ListGroups(limit int, offset int, name *string) (state.GroupsList, error) {
@@ -23,7 +23,7 @@ func setUpDefinitionServiceTest(t *testing.T) (DefinitionService, *testutils.Imp
 			"B": "b/",
 		},
 	}
-	ds, _ := NewDefinitionService(&imp)
+	ds, _ := NewDefinitionService(&imp, &imp)
 	return ds, &imp
 }
 
@@ -43,13 +43,13 @@ func TestDefinitionService_Create(t *testing.T) {
 		},
 	}
 
-	created, _ := ds.Create(&newValidDef)
+	created, _ := ds.Create(&newValidDef, state.UserInfo{Name: "somebody"})
 	if len(created.DefinitionID) == 0 {
 		t.Errorf("Expected non-empty definition id")
 	}
 
 	// order matters
-	expected := []string{"ListDefinitions", "CreateDefinition"}
+	expected := []string{"ListDefinitions", "CreateDefinition", "CreateDefinitionRevision", "CreateAuditEvent"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of create calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -77,7 +77,7 @@ func TestDefinitionService_Create2(t *testing.T) {
 		GroupName:           "group-cupcake",
 		ExecutableResources: state.ExecutableResources{Memory: &memory},
 	}
-	_, err = ds.Create(&invalid4)
+	_, err = ds.Create(&invalid4, state.UserInfo{})
 	if err == nil {
 		t.Errorf("Expected invalid definition with no image to result in error")
 	}
@@ -89,10 +89,12 @@ func TestDefinitionService_Update(t *testing.T) {
 	d := state.Definition{
 		ExecutableResources: state.ExecutableResources{Memory: &memory},
 	}
-	ds.Update("A", d)
+	ds.Update("A", d, state.UserInfo{Name: "somebody"})
 
-	// order matters
-	expected := []string{"GetDefinition", "UpdateDefinition"}
+	// order matters; A predates revisions so its current state is recorded first
+	expected := []string{
+		"GetDefinition", "ListDefinitionRevisions", "CreateDefinitionRevision",
+		"UpdateDefinition", "CreateDefinitionRevision", "CreateAuditEvent"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of create calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -104,12 +106,64 @@ func TestDefinitionService_Update(t *testing.T) {
 	}
 }
 
+func TestDefinitionService_Revisions(t *testing.T) {
+	ds, imp := setUpDefinitionServiceTest(t)
+	user := state.UserInfo{Name: "somebody", Email: "somebody@example.com"}
+
+	for _, image := range []string{"image:v1", "image:v2"} {
+		if _, err := ds.Update("C", state.Definition{
+			ExecutableResources: state.ExecutableResources{Image: image}}, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, _ := ds.ListRevisions("C", 10, 0)
+	if revisions.Total != 3 {
+		t.Fatalf("Expected 3 revisions of C but was %v", revisions.Total)
+	}
+	if revisions.Revisions[0].Revision != 3 || revisions.Revisions[0].User != user {
+		t.Errorf("Expected newest revision 3 to record user %v, got %v", user, revisions.Revisions[0])
+	}
+
+	diff, err := ds.DiffRevisions("C", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Field != "image" ||
+		diff.Changes[0].From != "invalidimage" || diff.Changes[0].To != "image:v2" {
+		t.Errorf("Expected a single image change from invalidimage to image:v2, got %v", diff.Changes)
+	}
+
+	memory := int64(1024)
+	current := imp.Definitions["C"]
+	current.Memory = &memory
+	imp.Definitions["C"] = current
+
+	rolledBack, err := ds.Rollback("C", 2, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack.Image != "image:v1" {
+		t.Errorf("Expected rollback to revision 2 to restore image:v1 but was %s", rolledBack.Image)
+	}
+	if rolledBack.Memory != nil || imp.Definitions["C"].Memory != nil {
+		t.Errorf("Expected rollback to revision 2 to clear the memory it didn't have")
+	}
+	if len(imp.Revisions["C"]) != 4 {
+		t.Errorf("Expected rollback to be recorded as revision 4, have %v revisions", len(imp.Revisions["C"]))
+	}
+
+	if _, err = ds.Rollback("C", 9, user); err == nil {
+		t.Errorf("Expected rolling back to a missing revision to produce an error")
+	}
+}
+
 func TestDefinitionService_Delete(t *testing.T) {
 	ds, imp := setUpDefinitionServiceTest(t)
-	ds.Delete("A")
+	ds.Delete("A", state.UserInfo{Email: "somebody@example.com"})
 
 	// order matters
-	expected := []string{"DeleteDefinition"}
+	expected := []string{"GetDefinition", "DeleteDefinition", "CreateAuditEvent"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of create calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -119,4 +173,15 @@ func TestDefinitionService_Delete(t *testing.T) {
 			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
 		}
 	}
+
+	if len(imp.AuditEvents) != 1 {
+		t.Fatalf("Expected a single audit event but was %v", len(imp.AuditEvents))
+	}
+	e := imp.AuditEvents[0]
+	if e.Action != state.AuditActionDefinitionDelete || e.TargetID != "A" || e.Actor.Email != "somebody@example.com" {
+		t.Errorf("Expected a delete of A by somebody@example.com, got %v", e)
+	}
+	if len(e.Before) == 0 || len(e.After) != 0 {
+		t.Errorf("Expected a delete to record only the before state")
+	}
 }
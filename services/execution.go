@@ -1,19 +1,22 @@
 package services
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"math/rand"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/stitchfix/flotilla-os/clients/cluster"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/execution/engine"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/state"
 )
 
@@ -32,7 +35,17 @@ type ExecutionService interface {
 		sortField string,
 		filters map[string][]string,
 		envFilters map[string]string) (state.RunList, error)
+	ListByCursor(
+		limit int,
+		cursor string,
+		sortOrder string,
+		sortField string,
+		filters map[string][]string,
+		envFilters map[string]string,
+		approximateTotal bool) (state.RunList, error)
 	Get(runID string) (state.Run, error)
+	GetRunDefinition(runID string) (state.ExecutableSnapshot, error)
+	ListTransitions(runID string) (state.RunStatusTransitionList, error)
 	UpdateStatus(runID string, status string, exitCode *int64, runExceptions *state.RunExceptions, exitReason *string) error
 	Terminate(runID string, userInfo state.UserInfo) error
 	ReservedVariables() []string
@@ -40,6 +53,12 @@ type ExecutionService interface {
 	GetEvents(run state.Run) (state.PodEventList, error)
 	CreateTemplateRunByTemplateID(templateID string, req *state.TemplateExecutionRequest) (state.Run, error)
 	CreateTemplateRunByTemplateName(templateName string, templateVersion string, req *state.TemplateExecutionRequest) (state.Run, error)
+	CreateScheduledRun(s state.Schedule) (state.Run, error)
+	CreateWorkflowRun(workflowID string, node state.WorkflowNode) (state.Run, error)
+	RetryRun(failed state.Run) (state.Run, error)
+	ListAttempts(runID string) (state.RunList, error)
+	AdvanceArrayRun(parent state.Run) (state.Run, error)
+	GetArraySummary(runID string) (state.ArraySummary, error)
 }
 
 type executionService struct {
@@ -57,8 +76,17 @@ type executionService struct {
 	eksSpotOverride          bool
 	spotThresholdMinutes     float64
 	terminateJobChannel      chan state.TerminateJob
+	idempotencyWindow        time.Duration
+	priorities               state.PriorityTiers
+	exitReasons              ExitReasonService
+	broker                   stream.Broker
+	webhooks                 WebhookService
+	logger                   flotillaLog.Logger
 }
 
+// defaultIdempotencyWindow is used when idempotency_window is unset
+var defaultIdempotencyWindow = 24 * time.Hour
+
 func (es *executionService) GetEvents(run state.Run) (state.PodEventList, error) {
 	return es.eksExecutionEngine.GetEvents(run)
 }
@@ -66,12 +94,13 @@ func (es *executionService) GetEvents(run state.Run) (state.PodEventList, error)
 //
 // NewExecutionService configures and returns an ExecutionService
 //
-func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, sm state.Manager, eksClusterClient cluster.Client, emrExecutionEngine engine.Engine) (ExecutionService, error) {
+func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, sm state.Manager, eksClusterClient cluster.Client, emrExecutionEngine engine.Engine, logger flotillaLog.Logger) (ExecutionService, error) {
 	es := executionService{
 		stateManager:       sm,
 		eksClusterClient:   eksClusterClient,
 		eksExecutionEngine: eksExecutionEngine,
 		emrExecutionEngine: emrExecutionEngine,
+		logger:             logger,
 	}
 	//
 	// Reserved environment variables dynamically generated
@@ -114,6 +143,31 @@ func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, s
 		es.spotThresholdMinutes = 30.0
 	}
 
+	es.idempotencyWindow = defaultIdempotencyWindow
+	if conf.IsSet("idempotency_window") {
+		window, err := time.ParseDuration(conf.GetString("idempotency_window"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid idempotency_window: %v", err)
+		}
+		es.idempotencyWindow = window
+	}
+
+	priorities, err := state.NewPriorityTiers(conf, conf.GetString("eks.job_queue"))
+	if err != nil {
+		return nil, err
+	}
+	es.priorities = priorities
+
+	if es.exitReasons, err = NewExitReasonService(conf, sm, nil, logger); err != nil {
+		return nil, err
+	}
+	if es.broker, err = stream.NewBroker(conf); err != nil {
+		return nil, err
+	}
+	if es.webhooks, err = NewWebhookService(conf, sm, logger); err != nil {
+		return nil, err
+	}
+
 	es.reservedEnv = map[string]func(run state.Run) string{
 		"FLOTILLA_SERVER_MODE": func(run state.Run) string {
 			return conf.GetString("flotilla_mode")
@@ -127,6 +181,12 @@ func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, s
 		ownerKey: func(run state.Run) string {
 			return run.User
 		},
+		"FLOTILLA_ARRAY_INDEX": func(run state.Run) string {
+			if run.ArrayIndex == nil {
+				return ""
+			}
+			return strconv.FormatInt(*run.ArrayIndex, 10)
+		},
 	}
 
 	es.terminateJobChannel = make(chan state.TerminateJob, 100)
@@ -177,6 +237,11 @@ func (es *executionService) createFromDefinition(definition state.Definition, re
 		err error
 	)
 	fields := req.GetExecutionRequestCommon()
+	// Hash the request as submitted, before it is filled in below
+	requestHash, err := idempotencyRequestHash(definition.DefinitionID, req)
+	if err != nil {
+		return run, err
+	}
 	rand.Seed(time.Now().Unix())
 	fields.ClusterName = es.eksClusterOverride[rand.Intn(len(es.eksClusterOverride))]
 	es.sanitizeExecutionRequestCommonFields(fields)
@@ -187,7 +252,7 @@ func (es *executionService) createFromDefinition(definition state.Definition, re
 		return run, err
 	}
 
-	return es.createAndEnqueueRun(run)
+	return es.createRun(run, definition, fields, requestHash)
 }
 
 func (es *executionService) constructRunFromDefinition(definition state.Definition, req *state.DefinitionExecutionRequest) (state.Run, error) {
@@ -214,6 +279,19 @@ func (es *executionService) constructBaseRunFromExecutable(executable state.Exec
 
 	fields.Engine = req.GetExecutionRequestCommon().Engine
 
+	if valid, reasons := fields.Labels.IsValid(); !valid {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	if reasons := es.validateArrayFields(fields); len(reasons) > 0 {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	if reasons := fields.ValidateIdempotencyKey(); len(reasons) > 0 {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	if reasons := es.priorities.Validate(fields.Priority); len(reasons) > 0 {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+
 	// Compute the executable command based on the execution request. If the
 	// execution request did not specify an overriding command, use the computed
 	// `executableCmd` as the Run's Command.
@@ -278,6 +356,12 @@ func (es *executionService) constructBaseRunFromExecutable(executable state.Exec
 		ActiveDeadlineSeconds: fields.ActiveDeadlineSeconds,
 		TaskType:              state.DefaultTaskType,
 		SparkExtension:        fields.SparkExtension,
+		Labels:                fields.Labels,
+		ScheduleID:            fields.ScheduleID,
+		RetryPolicy:           resources.RetryPolicy,
+	}
+	if tier, ok := es.priorities.Get(fields.Priority); ok {
+		run.Priority = &tier.Name
 	}
 
 	runEnv := es.constructEnviron(run, fields.Env)
@@ -318,14 +402,39 @@ func (es *executionService) List(
 	sortField string,
 	filters map[string][]string,
 	envFilters map[string]string) (state.RunList, error) {
+	if err := es.validateListFilters(filters); err != nil {
+		return state.RunList{}, err
+	}
+	return es.stateManager.ListRuns(limit, offset, sortField, sortOrder, filters, envFilters, []string{state.EKSEngine, state.EKSSparkEngine})
+}
+
+//
+// ListByCursor returns a page of Runs after the opaque cursor returned by a
+// previous call; an empty cursor returns the first page
+// * validates definition_id and status filters
+//
+func (es *executionService) ListByCursor(
+	limit int,
+	cursor string,
+	sortOrder string,
+	sortField string,
+	filters map[string][]string,
+	envFilters map[string]string,
+	approximateTotal bool) (state.RunList, error) {
+	if err := es.validateListFilters(filters); err != nil {
+		return state.RunList{}, err
+	}
+	return es.stateManager.ListRunsByCursor(limit, cursor, sortField, sortOrder, filters, envFilters, []string{state.EKSEngine, state.EKSSparkEngine}, approximateTotal)
+}
 
+func (es *executionService) validateListFilters(filters map[string][]string) error {
 	// If definition_id is present in filters, validate its
 	// existence first
 	definitionID, ok := filters["definition_id"]
 	if ok {
 		_, err := es.stateManager.GetDefinition(definitionID[0])
 		if err != nil {
-			return state.RunList{}, err
+			return err
 		}
 	}
 
@@ -333,13 +442,12 @@ func (es *executionService) List(
 		for _, status := range statusFilters {
 			if !state.IsValidStatus(status) {
 				// Status filter is invalid
-				err := exceptions.MalformedInput{
+				return exceptions.MalformedInput{
 					ErrorString: fmt.Sprintf("invalid status [%s]", status)}
-				return state.RunList{}, err
 			}
 		}
 	}
-	return es.stateManager.ListRuns(limit, offset, sortField, sortOrder, filters, envFilters, []string{state.EKSEngine, state.EKSSparkEngine})
+	return nil
 }
 
 //
@@ -349,6 +457,33 @@ func (es *executionService) Get(runID string) (state.Run, error) {
 	return es.stateManager.GetRun(runID)
 }
 
+//
+// GetRunDefinition returns the executable snapshot the run with the given
+// runID was created from
+//
+func (es *executionService) GetRunDefinition(runID string) (state.ExecutableSnapshot, error) {
+	run, err := es.stateManager.GetRun(runID)
+	if err != nil {
+		return state.ExecutableSnapshot{}, err
+	}
+	if run.ExecutableSnapshotID == nil {
+		return state.ExecutableSnapshot{}, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("run with id %s has no executable snapshot", runID)}
+	}
+	return es.stateManager.GetExecutableSnapshot(*run.ExecutableSnapshotID)
+}
+
+//
+// ListTransitions returns the status transitions of the run with the given
+// runID, oldest first
+//
+func (es *executionService) ListTransitions(runID string) (state.RunStatusTransitionList, error) {
+	if _, err := es.stateManager.GetRun(runID); err != nil {
+		return state.RunStatusTransitionList{}, err
+	}
+	return es.stateManager.ListRunTransitions(runID)
+}
+
 //
 // UpdateStatus is for supporting some legacy runs that still manually update their status
 //
@@ -368,44 +503,58 @@ func (es *executionService) UpdateStatus(runID string, status string, exitCode *
 	}
 	finishedAt := time.Now()
 
+	var exitCategory *string
 	if exitReason == nil {
-		extractedExitReason := es.extractExitReason(runExceptions)
-		exitReason = &extractedExitReason
+		classified := run
+		classified.RunExceptions = runExceptions
+		match, err := es.exitReasons.Classify(classified)
+		if err != nil {
+			return err
+		}
+		reason := "Runtime exception encountered"
+		if match != nil {
+			reason = match.Rule.Message
+			exitCategory = &match.Rule.Category
+		}
+		exitReason = &reason
 	}
 
-	_, err = es.stateManager.UpdateRun(runID, state.Run{Status: status, ExitCode: exitCode, ExitReason: exitReason, RunExceptions: runExceptions, FinishedAt: &finishedAt, StartedAt: startedAt})
-	return err
+	updated, err := es.stateManager.UpdateRun(runID, state.Run{Status: status, ExitCode: exitCode, ExitReason: exitReason, ExitCategory: exitCategory, RunExceptions: runExceptions, FinishedAt: &finishedAt, StartedAt: startedAt}, state.TransitionSourceAPI)
+	if err != nil {
+		return err
+	}
+	es.runUpdated(run, updated)
+	return nil
 }
 
-func (es *executionService) extractExitReason(runExceptions *state.RunExceptions) string {
-	connectionError := regexp.MustCompile(`(?i).*(timeout|gatewayerror|socketerror|\s503\s|\s502\s|\s500\s|\s504\s|connectionerror).*`)
-	pipError := regexp.MustCompile(`(?i).*(could\snot\sfind\sa\sversion|package\snot\sfound|ModuleNotFoundError|No\smatching\sdistribution\sfound).*`)
-	yumError := regexp.MustCompile(`(?i).*(Nothing\sto\sdo).*`)
-	gitError := regexp.MustCompile(`(?i).*(Could\snot\sread\sfrom\sremote\srepository|correct\saccess\srights|Repository\snot\sfound).*`)
-	argumentError := regexp.MustCompile(`(?i).*(404|400|keyerror|column\smissing|RuntimeError).*`)
-	syntaxError := regexp.MustCompile(`(?i).*(syntaxerror|typeerror|).*`)
-
-	value, _ := json.Marshal(runExceptions)
-	if value != nil {
-		errorMsg := string(value)
-		switch {
-		case connectionError.MatchString(errorMsg):
-			return "Connection error to downstream uri"
-		case pipError.MatchString(errorMsg):
-			return "Python pip package installation error"
-		case yumError.MatchString(errorMsg):
-			return "Yum installation error"
-		case gitError.MatchString(errorMsg):
-			return "Git clone error"
-		case argumentError.MatchString(errorMsg):
-			return "Data or argument error"
-		case syntaxError.MatchString(errorMsg):
-			return "Code or syntax error"
-		default:
-			return "Runtime exception encountered"
+//
+// runUpdated queues the webhook deliveries and publishes the stream events of
+// a persisted update of a run from before to after; failures are logged and
+// don't fail the update
+//
+func (es *executionService) runUpdated(before state.Run, after state.Run) {
+	if es.webhooks != nil {
+		if err := es.webhooks.Notify(after, before.Status); err != nil {
+			_ = es.logger.Log(
+				"level", "error",
+				"message", "unable to queue webhook deliveries",
+				"run_id", after.RunID,
+				"error", fmt.Sprintf("%+v", err))
+		}
+	}
+	if es.broker == nil {
+		return
+	}
+	for _, e := range stream.RunEvents(before, after) {
+		if err := es.broker.Publish(e); err != nil {
+			_ = es.logger.Log(
+				"level", "error",
+				"message", "unable to publish run stream event",
+				"run_id", after.RunID,
+				"error", fmt.Sprintf("%+v", err))
+			return
 		}
 	}
-	return "Runtime exception encountered"
 }
 
 func (es *executionService) terminateWorker(jobChan <-chan state.TerminateJob) {
@@ -434,7 +583,11 @@ func (es *executionService) terminateWorker(jobChan <-chan state.TerminateJob) {
 		}
 
 		if run.Status != state.StatusStopped {
-			if *run.Engine == state.EKSSparkEngine {
+			if run.TaskType == state.ArrayTaskType {
+				// The parent of an array has no job of its own, its
+				// children were queued for termination above
+				err = nil
+			} else if *run.Engine == state.EKSSparkEngine {
 				err = es.emrExecutionEngine.Terminate(run)
 			} else {
 				err = es.eksExecutionEngine.Terminate(run)
@@ -447,12 +600,20 @@ func (es *executionService) terminateWorker(jobChan <-chan state.TerminateJob) {
 
 				exitCode := int64(1)
 				finishedAt := time.Now()
-				_, err = es.stateManager.UpdateRun(run.RunID, state.Run{
+				var stopped state.Run
+				// Terminated runs aren't retried, whatever their policy
+				stopped, err = es.stateManager.UpdateRun(run.RunID, state.Run{
 					Status:     state.StatusStopped,
 					ExitReason: &exitReason,
 					ExitCode:   &exitCode,
 					FinishedAt: &finishedAt,
-				})
+					RetryState: state.RetryStateNone,
+				}, state.TransitionSourceAPI)
+				if err == nil {
+					es.runUpdated(run, stopped)
+					recordAudit(es.stateManager, es.logger, userInfo,
+						state.AuditActionRunTerminate, state.AuditTargetRun, run.RunID, run, stopped)
+				}
 				break
 			}
 			break
@@ -498,11 +659,95 @@ func (es *executionService) sanitizeExecutionRequestCommonFields(fields *state.E
 }
 
 //
-// createAndEnqueueRun creates a run object in the DB, enqueues it, then
-// updates the db's run object with a new `queued_at` field.
+// createRun creates and queues run, or the array of runs it fans out into.
+// A request with an idempotency key its owner already used within the
+// idempotency window returns the run created for the key instead.
 //
-func (es *executionService) createAndEnqueueRun(run state.Run) (state.Run, error) {
+func (es *executionService) createRun(run state.Run, executable state.Executable, fields *state.ExecutionRequestCommon, requestHash string) (state.Run, error) {
+	var key *state.IdempotencyKey
+	if fields.IdempotencyKey != nil {
+		key = &state.IdempotencyKey{
+			OwnerID:     run.User,
+			Key:         *fields.IdempotencyKey,
+			RequestHash: requestHash,
+			RunID:       run.RunID,
+			CreatedAt:   time.Now(),
+		}
+		original, found, err := es.claimIdempotencyKey(*key)
+		if err != nil || found {
+			return original, err
+		}
+	}
+
 	var err error
+	if fields.IsArray() {
+		run, err = es.createArrayRun(run, executable, fields)
+	} else {
+		run, err = es.createAndEnqueueRun(run, executable)
+	}
+	if err != nil && key != nil {
+		// Let the client retry with the same key
+		_ = es.stateManager.ReleaseIdempotencyKey(*key)
+	}
+	return run, err
+}
+
+//
+// claimIdempotencyKey claims key for its run. When the key's owner already
+// used it for the same request the run created then is returned and found is
+// true; using it for a different request is a conflict.
+//
+func (es *executionService) claimIdempotencyKey(key state.IdempotencyKey) (state.Run, bool, error) {
+	stored, err := es.stateManager.ClaimIdempotencyKey(key, es.idempotencyWindow)
+	if err != nil {
+		return state.Run{}, false, err
+	}
+	if stored.RunID == key.RunID {
+		return state.Run{}, false, nil
+	}
+	if stored.RequestHash != key.RequestHash {
+		return state.Run{}, true, exceptions.ConflictingResource{ErrorString: fmt.Sprintf(
+			"idempotency key [%s] was already used with a different request", key.Key)}
+	}
+
+	original, err := es.stateManager.GetRun(stored.RunID)
+	if _, missing := err.(exceptions.MissingResource); missing {
+		return original, true, exceptions.ConflictingResource{ErrorString: fmt.Sprintf(
+			"run [%s] for idempotency key [%s] is still being created", stored.RunID, key.Key)}
+	}
+	return original, true, err
+}
+
+//
+// idempotencyRequestHash returns a hash identifying a request to execute the
+// executable with the given id
+//
+func idempotencyRequestHash(executableID string, req state.ExecutionRequest) (string, error) {
+	body, err := json.Marshal(struct {
+		ExecutableID string                 `json:"executable_id"`
+		Request      state.ExecutionRequest `json:"request"`
+	}{executableID, req})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(body)), nil
+}
+
+//
+// createAndEnqueueRun snapshots the executable, creates a run object pinned
+// to the snapshot in the DB, enqueues it, then updates the db's run object
+// with a new `queued_at` field.
+//
+func (es *executionService) createAndEnqueueRun(run state.Run, executable state.Executable) (state.Run, error) {
+	snapshot, err := state.NewExecutableSnapshot(executable)
+	if err != nil {
+		return run, err
+	}
+	if err = es.stateManager.CreateExecutableSnapshot(snapshot); err != nil {
+		return run, err
+	}
+	run.ExecutableSnapshotID = &snapshot.SnapshotID
+
 	// Save run to source of state - it is *CRITICAL* to do this
 	// -before- queuing to avoid processing unsaved runs
 	if err = es.stateManager.CreateRun(run); err != nil {
@@ -522,10 +767,12 @@ func (es *executionService) createAndEnqueueRun(run state.Run) (state.Run, error
 	}
 
 	// UpdateStatus the run's QueuedAt field
-	if run, err = es.stateManager.UpdateRun(run.RunID, state.Run{QueuedAt: &queuedAt}); err != nil {
+	if run, err = es.stateManager.UpdateRun(run.RunID, state.Run{QueuedAt: &queuedAt}, state.TransitionSourceAPI); err != nil {
 		return run, err
 	}
 
+	recordAudit(es.stateManager, es.logger, state.UserInfo{Name: run.User},
+		state.AuditActionRunCreate, state.AuditTargetRun, run.RunID, nil, run)
 	return run, nil
 }
 func (es *executionService) CreateTemplateRunByTemplateName(templateName string, templateVersion string, req *state.TemplateExecutionRequest) (state.Run, error) {
@@ -567,6 +814,11 @@ func (es *executionService) createFromTemplate(template state.Template, req *sta
 	)
 
 	fields := req.GetExecutionRequestCommon()
+	// Hash the request as submitted, before it is filled in below
+	requestHash, err := idempotencyRequestHash(template.TemplateID, req)
+	if err != nil {
+		return run, err
+	}
 	es.sanitizeExecutionRequestCommonFields(fields)
 
 	// Construct run object with StatusQueued and new UUID4 run id
@@ -575,7 +827,7 @@ func (es *executionService) createFromTemplate(template state.Template, req *sta
 		return run, err
 	}
 	if !req.DryRun {
-		return es.createAndEnqueueRun(run)
+		return es.createRun(run, template, fields, requestHash)
 	}
 	return run, nil
 }
@@ -594,3 +846,412 @@ func (es *executionService) constructRunFromTemplate(template state.Template, re
 
 	return run, nil
 }
+
+//
+// CreateScheduledRun constructs and queues a new Run from the execution
+// request stored on a schedule; the run is linked back to the schedule
+//
+func (es *executionService) CreateScheduledRun(s state.Schedule) (state.Run, error) {
+	switch s.ExecutableType {
+	case state.ExecutableTypeDefinition:
+		req := *s.DefinitionRequest
+		req.ExecutionRequestCommon = scheduledRequestCommon(s, req.ExecutionRequestCommon)
+		return es.CreateDefinitionRunByDefinitionID(s.ExecutableID, &req)
+	case state.ExecutableTypeTemplate:
+		req := *s.TemplateRequest
+		req.ExecutionRequestCommon = scheduledRequestCommon(s, req.ExecutionRequestCommon)
+		req.DryRun = false
+		return es.CreateTemplateRunByTemplateID(s.ExecutableID, &req)
+	}
+	return state.Run{}, exceptions.MalformedInput{
+		ErrorString: fmt.Sprintf("schedule [%s] has invalid executable type [%s]", s.ScheduleID, s.ExecutableType)}
+}
+
+func scheduledRequestCommon(s state.Schedule, fields *state.ExecutionRequestCommon) *state.ExecutionRequestCommon {
+	common := copyRequestCommon(fields)
+	scheduleID := s.ScheduleID
+	common.ScheduleID = &scheduleID
+	return common
+}
+
+//
+// CreateWorkflowRun constructs and queues a new Run for a workflow node from
+// the execution request stored on the node. The run is keyed by the workflow
+// and node, so starting a node again before the workflow saved its run
+// returns the run already created.
+//
+func (es *executionService) CreateWorkflowRun(workflowID string, node state.WorkflowNode) (state.Run, error) {
+	key := workflowIdempotencyKey(workflowID, node.Name)
+	switch node.ExecutableType {
+	case state.ExecutableTypeDefinition:
+		req := *node.DefinitionRequest
+		req.ExecutionRequestCommon = copyRequestCommon(req.ExecutionRequestCommon)
+		req.IdempotencyKey = &key
+		return es.CreateDefinitionRunByDefinitionID(node.ExecutableID, &req)
+	case state.ExecutableTypeTemplate:
+		req := *node.TemplateRequest
+		req.ExecutionRequestCommon = copyRequestCommon(req.ExecutionRequestCommon)
+		req.IdempotencyKey = &key
+		req.DryRun = false
+		return es.CreateTemplateRunByTemplateID(node.ExecutableID, &req)
+	}
+	return state.Run{}, exceptions.MalformedInput{
+		ErrorString: fmt.Sprintf("node [%s] of workflow [%s] has invalid executable type [%s]", node.Name, workflowID, node.ExecutableType)}
+}
+
+//
+// workflowIdempotencyKey returns the idempotency key of the run of a workflow
+// node; node names are unbounded, so the key is hashed
+//
+func workflowIdempotencyKey(workflowID string, nodeName string) string {
+	return fmt.Sprintf("workflow-%x", sha256.Sum256([]byte(workflowID+"/"+nodeName)))
+}
+
+//
+// copyRequestCommon copies a stored request's common fields, which creating
+// a run modifies. Stored requests create many runs, so their idempotency key
+// is dropped.
+//
+func copyRequestCommon(fields *state.ExecutionRequestCommon) *state.ExecutionRequestCommon {
+	var common state.ExecutionRequestCommon
+	if fields != nil {
+		common = *fields
+	}
+	common.IdempotencyKey = nil
+	return &common
+}
+
+//
+// RetryRun queues a new attempt of a stopped run from the executable snapshot
+// it ran with. The attempt keeps the run's request fields, gets fresh
+// reserved environment variables and is linked to the first run of the chain.
+//
+func (es *executionService) RetryRun(failed state.Run) (state.Run, error) {
+	executable, err := es.retryExecutable(failed)
+	if err != nil {
+		return state.Run{}, err
+	}
+
+	runID, err := state.NewRunID(failed.Engine)
+	if err != nil {
+		return state.Run{}, err
+	}
+
+	originalRunID := failed.RunID
+	if failed.OriginalRunID != nil {
+		originalRunID = *failed.OriginalRunID
+	}
+
+	run := state.Run{
+		RunID:                  runID,
+		DefinitionID:           failed.DefinitionID,
+		Alias:                  failed.Alias,
+		Image:                  failed.Image,
+		ClusterName:            failed.ClusterName,
+		Status:                 state.StatusQueued,
+		GroupName:              failed.GroupName,
+		User:                   failed.User,
+		TaskType:               failed.TaskType,
+		Command:                failed.Command,
+		Memory:                 failed.Memory,
+		Cpu:                    failed.Cpu,
+		Gpu:                    failed.Gpu,
+		Engine:                 failed.Engine,
+		NodeLifecycle:          failed.NodeLifecycle,
+		EphemeralStorage:       failed.EphemeralStorage,
+		ExecutableID:           failed.ExecutableID,
+		ExecutableType:         failed.ExecutableType,
+		ExecutionRequestCustom: failed.ExecutionRequestCustom,
+		ActiveDeadlineSeconds:  failed.ActiveDeadlineSeconds,
+		SparkExtension:         failed.SparkExtension,
+		Labels:                 failed.Labels,
+		ScheduleID:             failed.ScheduleID,
+		RetryPolicy:            failed.RetryPolicy,
+		OriginalRunID:          &originalRunID,
+		RetryAttempt:           failed.RetryAttempt + 1,
+		ArrayParentID:          failed.ArrayParentID,
+		ArrayIndex:             failed.ArrayIndex,
+		Priority:               failed.Priority,
+	}
+
+	// Reserved variables are regenerated for the new run id
+	var userEnv state.EnvList
+	if failed.Env != nil {
+		for _, e := range *failed.Env {
+			if _, reserved := es.reservedEnv[e.Name]; !reserved {
+				userEnv = append(userEnv, e)
+			}
+		}
+	}
+	runEnv := es.constructEnviron(run, &userEnv)
+	run.Env = &runEnv
+
+	return es.createAndEnqueueRun(run, executable)
+}
+
+//
+// retryExecutable returns the executable a run was created from, preferring
+// its snapshot so that later edits do not change what is retried
+//
+func (es *executionService) retryExecutable(run state.Run) (state.Executable, error) {
+	if run.ExecutableSnapshotID != nil {
+		snapshot, err := es.stateManager.GetExecutableSnapshot(*run.ExecutableSnapshotID)
+		if err == nil {
+			return snapshot.Executable()
+		}
+	}
+	if run.ExecutableType == nil || run.ExecutableID == nil {
+		return nil, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("run with id %s has no executable to retry", run.RunID)}
+	}
+	return es.stateManager.GetExecutableByTypeAndID(*run.ExecutableType, *run.ExecutableID)
+}
+
+//
+// ListAttempts returns every attempt in the retry chain of the run with the
+// given runID, starting with the original run
+//
+func (es *executionService) ListAttempts(runID string) (state.RunList, error) {
+	run, err := es.stateManager.GetRun(runID)
+	if err != nil {
+		return state.RunList{}, err
+	}
+
+	original := run
+	if run.OriginalRunID != nil {
+		if original, err = es.stateManager.GetRun(*run.OriginalRunID); err != nil {
+			return state.RunList{}, err
+		}
+	}
+
+	retries, err := es.stateManager.ListRuns(
+		state.MaxRetryAttempts, 0, "retry_attempt", "asc",
+		map[string][]string{"original_run_id": {original.RunID}}, nil, state.Engines)
+	if err != nil {
+		return state.RunList{}, err
+	}
+
+	attempts := append([]state.Run{original}, retries.Runs...)
+	return state.RunList{Total: len(attempts), Runs: attempts}, nil
+}
+
+//
+// validateArrayFields returns the reasons the array fields of a request are
+// invalid; parameter sets may not set reserved variables
+//
+func (es *executionService) validateArrayFields(fields *state.ExecutionRequestCommon) []string {
+	reasons := fields.ValidateArray()
+	for _, params := range fields.ArrayParameters {
+		for _, e := range params {
+			if _, reserved := es.reservedEnv[e.Name]; reserved {
+				reasons = append(reasons, fmt.Sprintf("array [array_parameters] must not set reserved variable [%s]", e.Name))
+			}
+		}
+	}
+	return reasons
+}
+
+//
+// createArrayRun snapshots the executable and creates the parent run of an
+// array plus one child run per index, all pinned to the snapshot. Children
+// carry the parent's id in PARENT_FLOTILLA_RUN_ID, so terminating the parent
+// terminates them, and their parameter set in their environment. Children
+// up to the array's max parallelism are queued right away; the array worker
+// queues the rest as earlier ones finish. If a child can't be created, the
+// runs created so far are stopped.
+//
+func (es *executionService) createArrayRun(run state.Run, executable state.Executable, fields *state.ExecutionRequestCommon) (state.Run, error) {
+	snapshot, err := state.NewExecutableSnapshot(executable)
+	if err != nil {
+		return run, err
+	}
+	if err = es.stateManager.CreateExecutableSnapshot(snapshot); err != nil {
+		return run, err
+	}
+	run.ExecutableSnapshotID = &snapshot.SnapshotID
+
+	size := int64(fields.ArrayLength())
+	queuedAt := time.Now()
+	parent := run
+	parent.TaskType = state.ArrayTaskType
+	parent.QueuedAt = &queuedAt
+	parent.ArraySize = &size
+	parent.MaxParallelism = fields.MaxParallelism
+	// Children are retried individually
+	parent.RetryPolicy = nil
+	if err = es.stateManager.CreateRun(parent); err != nil {
+		return parent, err
+	}
+
+	var children []state.Run
+	for i := int64(0); i < size; i++ {
+		child, err := es.constructArrayChild(run, fields, i)
+		if err == nil {
+			err = es.stateManager.CreateRun(child)
+		}
+		if err != nil {
+			es.abandonArrayRun(parent, children, err)
+			return parent, err
+		}
+		children = append(children, child)
+	}
+
+	if err = es.releaseArrayRuns(parent); err != nil {
+		return parent, err
+	}
+	recordAudit(es.stateManager, es.logger, state.UserInfo{Name: parent.User},
+		state.AuditActionRunCreate, state.AuditTargetRun, parent.RunID, nil, parent)
+	return parent, nil
+}
+
+//
+// abandonArrayRun stops the parent of an array run and the children created
+// so far when creating the rest of them failed, so that none of the partial
+// array is released
+//
+func (es *executionService) abandonArrayRun(parent state.Run, children []state.Run, cause error) {
+	exitReason := fmt.Sprintf("Array run could not be created: %v", cause)
+	exitCode := int64(1)
+	finishedAt := time.Now()
+	for _, r := range append(children, parent) {
+		_, err := es.stateManager.UpdateRun(r.RunID, state.Run{
+			Status:     state.StatusStopped,
+			ExitReason: &exitReason,
+			ExitCode:   &exitCode,
+			FinishedAt: &finishedAt,
+			RetryState: state.RetryStateNone,
+		}, state.TransitionSourceAPI)
+		if err != nil {
+			_ = es.logger.Log(
+				"level", "error",
+				"message", "unable to stop run of a partially created array",
+				"run_id", r.RunID,
+				"error", fmt.Sprintf("%+v", err))
+		}
+	}
+}
+
+//
+// constructArrayChild returns the child run at index of the array whose
+// parent is run
+//
+func (es *executionService) constructArrayChild(run state.Run, fields *state.ExecutionRequestCommon, index int64) (state.Run, error) {
+	runID, err := state.NewRunID(run.Engine)
+	if err != nil {
+		return run, err
+	}
+
+	parentID := run.RunID
+	child := run
+	child.RunID = runID
+	child.ArrayParentID = &parentID
+	child.ArrayIndex = &index
+
+	var env state.EnvList
+	if fields.Env != nil {
+		env = append(env, *fields.Env...)
+	}
+	if len(fields.ArrayParameters) > 0 {
+		env = append(env, fields.ArrayParameters[index]...)
+	}
+	env = append(env, state.EnvVar{Name: "PARENT_FLOTILLA_RUN_ID", Value: parentID})
+	runEnv := es.constructEnviron(child, &env)
+	child.Env = &runEnv
+	return child, nil
+}
+
+//
+// releaseArrayRuns queues as many held children of parent as its max
+// parallelism allows
+//
+func (es *executionService) releaseArrayRuns(parent state.Run) error {
+	maxActive := 0
+	if parent.MaxParallelism != nil {
+		maxActive = int(*parent.MaxParallelism)
+	}
+	released, err := es.stateManager.ReleaseArrayRuns(parent.RunID, maxActive, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, r := range released {
+		if err = es.eksExecutionEngine.Enqueue(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// AdvanceArrayRun queues held children of an array run as its max
+// parallelism allows, then sets the parent's status from its children: it
+// is RUNNING once any child has started and STOPPED once every child has
+// finished, exiting 0 only if every child succeeded
+//
+func (es *executionService) AdvanceArrayRun(parent state.Run) (state.Run, error) {
+	if err := es.releaseArrayRuns(parent); err != nil {
+		return parent, err
+	}
+
+	summary, err := es.summarizeArray(parent)
+	if err != nil {
+		return parent, err
+	}
+
+	now := time.Now()
+	var updates state.Run
+	switch {
+	case summary.IsFinished():
+		exitCode := int64(0)
+		if summary.Failed > 0 {
+			exitCode = 1
+			exitReason := fmt.Sprintf("%d of %d array runs failed", summary.Failed, summary.ArraySize)
+			updates.ExitReason = &exitReason
+		}
+		updates.Status = state.StatusStopped
+		updates.ExitCode = &exitCode
+		updates.FinishedAt = &now
+	case summary.IsStarted() && parent.Status == state.StatusQueued:
+		updates.Status = state.StatusRunning
+		updates.StartedAt = &now
+	default:
+		return parent, nil
+	}
+	updated, err := es.stateManager.UpdateRun(parent.RunID, updates, state.TransitionSourceArrayWorker)
+	if err != nil {
+		return parent, err
+	}
+	es.runUpdated(parent, updated)
+	return updated, nil
+}
+
+//
+// GetArraySummary counts the children of the array run with the given runID
+// by progress
+//
+func (es *executionService) GetArraySummary(runID string) (state.ArraySummary, error) {
+	parent, err := es.stateManager.GetRun(runID)
+	if err != nil {
+		return state.ArraySummary{}, err
+	}
+	if parent.TaskType != state.ArrayTaskType {
+		return state.ArraySummary{}, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Array run with id %s not found", runID)}
+	}
+	return es.summarizeArray(parent)
+}
+
+func (es *executionService) summarizeArray(parent state.Run) (state.ArraySummary, error) {
+	var children []state.Run
+	filters := map[string][]string{"array_parent_id": {parent.RunID}}
+	for {
+		page, err := es.stateManager.ListRuns(state.MaxArraySize, len(children), "run_id", "asc", filters, nil, nil)
+		if err != nil {
+			return state.ArraySummary{}, err
+		}
+		children = append(children, page.Runs...)
+		if len(page.Runs) == 0 || len(children) >= page.Total {
+			break
+		}
+	}
+	return state.SummarizeArray(parent, children), nil
+}
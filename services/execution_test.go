@@ -1,9 +1,14 @@
 package services
 
 import (
+	"errors"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/testutils"
 )
@@ -27,7 +32,7 @@ func setUp(t *testing.T) (ExecutionService, *testutils.ImplementsAllTheThings) {
 			"B": "b/",
 		},
 	}
-	es, _ := NewExecutionService(c, &imp, &imp, &imp, &imp)
+	es, _ := NewExecutionService(c, &imp, &imp, &imp, &imp, &imp)
 	return es, &imp
 }
 
@@ -39,11 +44,13 @@ func TestExecutionService_CreateDefinitionRunByDefinitionID(t *testing.T) {
 	}
 	expectedCalls := map[string]bool{
 		"GetDefinition":            true,
+		"CreateExecutableSnapshot": true,
 		"CreateRun":                true,
 		"UpdateRun":                true,
 		"GetTaskHistoricalRuntime": true,
 		"GetPodReAttemptRate":      true,
 		"Enqueue":                  true,
+		"CreateAuditEvent":         true,
 	}
 
 	cmd := "_test_cmd_"
@@ -60,6 +67,7 @@ func TestExecutionService_CreateDefinitionRunByDefinitionID(t *testing.T) {
 			Engine:           &engine,
 			EphemeralStorage: nil,
 			NodeLifecycle:    nil,
+			Labels:           state.RunLabels{"pipeline": "nightly"},
 		},
 	}
 	run, err := es.CreateDefinitionRunByDefinitionID("B", &req)
@@ -137,6 +145,439 @@ func TestExecutionService_CreateDefinitionRunByDefinitionID(t *testing.T) {
 	if !includesExpected {
 		t.Errorf("Expected K1:V1 in run environment")
 	}
+
+	if run.ExecutableSnapshotID == nil {
+		t.Errorf("Expected new run to be pinned to an executable snapshot")
+	} else if snapshot, ok := imp.Snapshots[*run.ExecutableSnapshotID]; !ok || snapshot.Definition == nil ||
+		snapshot.Definition.DefinitionID != "B" {
+		t.Errorf("Expected run to be pinned to a snapshot of definition B, got %v", snapshot)
+	}
+
+	if run.Labels["pipeline"] != "nightly" {
+		t.Errorf("Expected new run to carry the requested labels but was %v", run.Labels)
+	}
+}
+
+func TestExecutionService_CreateDefinitionRunInvalidLabels(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	req := state.DefinitionExecutionRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{
+			OwnerID: "somebody",
+			Engine:  &engine,
+			Labels:  state.RunLabels{"bad key!": "v", "job-name": "mine"},
+		},
+	}
+	_, err := es.CreateDefinitionRunByDefinitionID("B", &req)
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected invalid labels to produce MalformedInput but was %v", err)
+	}
+	for _, call := range imp.Calls {
+		if call == "CreateRun" {
+			t.Errorf("Expected no run to be created with invalid labels")
+		}
+	}
+}
+
+func TestExecutionService_CreateScheduledRun(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	s := state.Schedule{
+		ScheduleID:     "sch-a",
+		ExecutableType: state.ExecutableTypeDefinition,
+		ExecutableID:   "B",
+		DefinitionRequest: &state.DefinitionExecutionRequest{
+			ExecutionRequestCommon: &state.ExecutionRequestCommon{OwnerID: "somebody", Engine: &engine},
+		},
+	}
+	run, err := es.CreateScheduledRun(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ScheduleID == nil || *run.ScheduleID != "sch-a" {
+		t.Errorf("Expected run to be linked to schedule sch-a, got %v", run.ScheduleID)
+	}
+	if stored := imp.Runs[run.RunID]; stored.ScheduleID == nil || *stored.ScheduleID != "sch-a" {
+		t.Errorf("Expected stored run to be linked to schedule sch-a")
+	}
+	if s.DefinitionRequest.ScheduleID != nil {
+		t.Errorf("Expected the stored execution request to be left untouched")
+	}
+}
+
+func TestExecutionService_RetryRun(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	code := int64(137)
+	policy := &state.RetryPolicy{MaxAttempts: 3, ExitCodes: []int64{137}}
+	definition := state.ExecutableTypeDefinition
+	definitionID := "A"
+	failed := state.Run{
+		RunID: "runA", DefinitionID: "A", ClusterName: "A", GroupName: "A",
+		Status: state.StatusStopped, ExitCode: &code, Engine: &engine,
+		ExecutableType: &definition, ExecutableID: &definitionID,
+		Env:         &state.EnvList{{Name: "FLOTILLA_RUN_ID", Value: "runA"}, {Name: "K1", Value: "V1"}},
+		RetryPolicy: policy,
+	}
+	imp.Runs["runA"] = failed
+
+	retry, err := es.RetryRun(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.RunID == "runA" || retry.Status != state.StatusQueued {
+		t.Errorf("Expected a new queued run, got %s with status %s", retry.RunID, retry.Status)
+	}
+	if retry.OriginalRunID == nil || *retry.OriginalRunID != "runA" || retry.RetryAttempt != 1 {
+		t.Errorf("Expected first retry of runA, got attempt %d of %v", retry.RetryAttempt, retry.OriginalRunID)
+	}
+	if retry.RetryPolicy == nil || retry.ExitCode != nil {
+		t.Errorf("Expected retry to keep the policy and clear the exit code")
+	}
+	for _, e := range *retry.Env {
+		if e.Name == "FLOTILLA_RUN_ID" && e.Value != retry.RunID {
+			t.Errorf("Expected FLOTILLA_RUN_ID to be regenerated, was %s", e.Value)
+		}
+	}
+
+	second, err := es.RetryRun(retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *second.OriginalRunID != "runA" || second.RetryAttempt != 2 {
+		t.Errorf("Expected second retry of runA, got attempt %d of %s", second.RetryAttempt, *second.OriginalRunID)
+	}
+
+	attempts, err := es.ListAttempts(second.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Total != 3 {
+		t.Fatalf("Expected 3 attempts, got %v", attempts.Runs)
+	}
+	for i, expected := range []string{"runA", retry.RunID, second.RunID} {
+		if attempts.Runs[i].RunID != expected {
+			t.Errorf("Expected attempt %d to be %s but was %s", i, expected, attempts.Runs[i].RunID)
+		}
+	}
+}
+
+func TestExecutionService_ListAttemptsSpark(t *testing.T) {
+	_, imp := setUp(t)
+	sm := &state.MemoryStateManager{}
+	if err := sm.Initialize(nil); err != nil {
+		t.Fatal(err)
+	}
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	es, err := NewExecutionService(c, imp, sm, imp, imp, imp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := state.EKSSparkEngine
+	original := "sparkA"
+	for _, run := range []state.Run{
+		{RunID: original, DefinitionID: "A", Status: state.StatusStopped, Engine: &engine},
+		{RunID: "sparkB", DefinitionID: "A", Status: state.StatusStopped, Engine: &engine, OriginalRunID: &original, RetryAttempt: 1},
+	} {
+		if err = sm.CreateRun(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attempts, err := es.ListAttempts("sparkB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Total != 2 || attempts.Runs[1].RunID != "sparkB" {
+		t.Errorf("Expected both attempts of the eks-spark run, got %v", attempts.Runs)
+	}
+}
+
+func TestExecutionService_TerminateIsNotRetried(t *testing.T) {
+	_, imp := setUp(t)
+	sm := &state.MemoryStateManager{}
+	if err := sm.Initialize(nil); err != nil {
+		t.Fatal(err)
+	}
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	es, err := NewExecutionService(c, imp, sm, imp, imp, imp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Terminated runs exit with code 1
+	engine := state.DefaultEngine
+	if err = sm.CreateRun(state.Run{
+		RunID: "runT", DefinitionID: "A", Status: state.StatusRunning, Engine: &engine,
+		RetryPolicy: &state.RetryPolicy{MaxAttempts: 3, ExitCodes: []int64{1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = es.Terminate("runT", state.UserInfo{Email: "somebody@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var run state.Run
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if run, _ = sm.GetRun("runT"); run.Status == state.StatusStopped {
+			break
+		}
+	}
+	if run.Status != state.StatusStopped || run.ExitCode == nil || *run.ExitCode != 1 {
+		t.Fatalf("Expected runT to be terminated, got status %s", run.Status)
+	}
+	if run.RetryState != state.RetryStateNone || run.RetryAt != nil {
+		t.Errorf("Expected a terminated run not to be scheduled for retry, got state [%s]", run.RetryState)
+	}
+	if due, _ := sm.ClaimDueRetries(time.Now().Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("Expected no retries to be due, got %v", due)
+	}
+}
+
+func TestExecutionService_UpdateStatusNotifies(t *testing.T) {
+	es, imp := setUp(t)
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	broker, err := stream.NewBroker(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := broker.Subscribe(stream.Filter{RunID: "runA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	run := imp.Runs["runA"]
+	run.Status = state.StatusRunning
+	imp.Runs["runA"] = run
+	imp.Webhooks = map[string]state.Webhook{"w": {WebhookID: "w", Scope: state.WebhookScopeRun, Name: "runA"}}
+	code := int64(0)
+	if err = es.UpdateStatus("runA", state.StatusStopped, &code, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	for len(types) < 2 {
+		select {
+		case e := <-sub.Events():
+			types = append(types, e.Type)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected status and exit events, got %v", types)
+		}
+	}
+	if types[0] != stream.EventStatus || types[1] != stream.EventExit {
+		t.Errorf("Expected status and exit events, got %v", types)
+	}
+	for _, d := range imp.WebhookDeliveries {
+		if d.Event != state.WebhookEventSucceeded {
+			t.Errorf("Expected a run.succeeded delivery, got %v", d)
+		}
+	}
+	if len(imp.WebhookDeliveries) != 1 {
+		t.Errorf("Expected the stopped run to be delivered to its webhook, got %v", imp.WebhookDeliveries)
+	}
+}
+
+func TestExecutionService_CreateArrayRun(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	maxParallelism := int64(2)
+	req := state.DefinitionExecutionRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{
+			OwnerID: "somebody",
+			Engine:  &engine,
+			Env:     &state.EnvList{{Name: "K1", Value: "V1"}},
+			ArrayParameters: []state.EnvList{
+				{{Name: "SHARD", Value: "a"}},
+				{{Name: "SHARD", Value: "b"}},
+				{{Name: "SHARD", Value: "c"}},
+			},
+			MaxParallelism: &maxParallelism,
+		},
+	}
+
+	parent, err := es.CreateDefinitionRunByDefinitionID("B", &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.TaskType != state.ArrayTaskType || parent.ArraySize == nil || *parent.ArraySize != 3 {
+		t.Fatalf("Expected an array parent of size 3, got task type %s", parent.TaskType)
+	}
+	if len(imp.Queued) != 2 {
+		t.Errorf("Expected max parallelism of 2 children to be queued, got %v", imp.Queued)
+	}
+
+	children := make([]state.Run, 3)
+	for _, r := range imp.Runs {
+		if r.ArrayParentID != nil && *r.ArrayParentID == parent.RunID {
+			children[*r.ArrayIndex] = r
+		}
+	}
+	for i, c := range children {
+		env := make(map[string]string)
+		for _, e := range *c.Env {
+			env[e.Name] = e.Value
+		}
+		if env["FLOTILLA_ARRAY_INDEX"] != strconv.Itoa(i) || env["PARENT_FLOTILLA_RUN_ID"] != parent.RunID ||
+			env["SHARD"] != string(rune('a'+i)) || env["K1"] != "V1" {
+			t.Errorf("Unexpected environment for child %d: %v", i, env)
+		}
+	}
+	if !state.IsHeldArrayRun(imp.Runs[children[2].RunID]) {
+		t.Errorf("Expected child 2 to be held")
+	}
+
+	succeeded, failed := int64(0), int64(1)
+	imp.UpdateRun(children[0].RunID, state.Run{Status: state.StatusStopped, ExitCode: &succeeded}, state.TransitionSourceStatusWorker)
+	if parent, err = es.AdvanceArrayRun(imp.Runs[parent.RunID]); err != nil {
+		t.Fatal(err)
+	}
+	if parent.Status != state.StatusRunning || len(imp.Queued) != 3 {
+		t.Errorf("Expected parent to run and child 2 to be queued, got %s and %v", parent.Status, imp.Queued)
+	}
+
+	imp.UpdateRun(children[1].RunID, state.Run{Status: state.StatusStopped, ExitCode: &failed}, state.TransitionSourceStatusWorker)
+	imp.UpdateRun(children[2].RunID, state.Run{Status: state.StatusStopped, ExitCode: &succeeded}, state.TransitionSourceStatusWorker)
+	if parent, err = es.AdvanceArrayRun(imp.Runs[parent.RunID]); err != nil {
+		t.Fatal(err)
+	}
+	if parent.Status != state.StatusStopped || parent.ExitCode == nil || *parent.ExitCode != 1 {
+		t.Errorf("Expected parent to stop with exit code 1, got %s", parent.Status)
+	}
+
+	summary, err := es.GetArraySummary(parent.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Succeeded != 2 || summary.Failed != 1 || summary.Held != 0 {
+		t.Errorf("Expected 2 succeeded and 1 failed children, got %+v", summary)
+	}
+}
+
+func TestExecutionService_CreateArrayRunPartialFailure(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	size := int64(3)
+	// The parent and the first child are created, the second child fails
+	imp.CreateRunFailAt = 3
+	_, err := es.CreateDefinitionRunByDefinitionID("B", &state.DefinitionExecutionRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{OwnerID: "somebody", Engine: &engine, ArraySize: &size},
+	})
+	if err == nil {
+		t.Fatal("Expected the failure to create a child to fail the array")
+	}
+
+	var parent state.Run
+	var children []state.Run
+	for _, r := range imp.Runs {
+		if r.TaskType == state.ArrayTaskType {
+			parent = r
+		} else if r.ArrayParentID != nil {
+			children = append(children, r)
+		}
+	}
+	if len(children) != 1 || *children[0].ArrayParentID != parent.RunID {
+		t.Fatalf("Expected the parent and one child to be created, got %v", imp.Runs)
+	}
+	for _, r := range []state.Run{parent, children[0]} {
+		if r.Status != state.StatusStopped || r.ExitCode == nil || *r.ExitCode != 1 || r.ExitReason == nil {
+			t.Errorf("Expected run %s of the partial array to be stopped, got %s", r.RunID, r.Status)
+		}
+	}
+	if len(imp.Queued) != 0 {
+		t.Errorf("Expected no child to be queued, got %v", imp.Queued)
+	}
+}
+
+func TestExecutionService_CreateArrayRunInvalid(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	size := int64(2)
+	for _, fields := range []state.ExecutionRequestCommon{
+		{OwnerID: "somebody", Engine: &engine, ArraySize: new(int64)},
+		{OwnerID: "somebody", Engine: &engine, ArraySize: &size, ArrayParameters: []state.EnvList{{}}},
+		{OwnerID: "somebody", Engine: &engine, ArrayParameters: []state.EnvList{{{Name: "FLOTILLA_ARRAY_INDEX", Value: "9"}}}},
+	} {
+		f := fields
+		_, err := es.CreateDefinitionRunByDefinitionID("B", &state.DefinitionExecutionRequest{ExecutionRequestCommon: &f})
+		if _, ok := err.(exceptions.MalformedInput); !ok {
+			t.Errorf("Expected MalformedInput but was %v", err)
+		}
+	}
+	for _, call := range imp.Calls {
+		if call == "CreateRun" {
+			t.Errorf("Expected no run to be created for an invalid array")
+		}
+	}
+}
+
+func TestExecutionService_CreateRunIdempotencyKey(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	key := "nightly-2026-10-17"
+	request := func(owner string, cmd string) *state.DefinitionExecutionRequest {
+		return &state.DefinitionExecutionRequest{
+			ExecutionRequestCommon: &state.ExecutionRequestCommon{
+				OwnerID: owner, Engine: &engine, Command: &cmd, IdempotencyKey: &key,
+			},
+		}
+	}
+
+	first, err := es.CreateDefinitionRunByDefinitionID("B", request("somebody", "echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repeat, err := es.CreateDefinitionRunByDefinitionID("B", request("somebody", "echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repeat.RunID != first.RunID || len(imp.Queued) != 1 {
+		t.Errorf("Expected the repeat to return run %s without queueing, got %s and %v", first.RunID, repeat.RunID, imp.Queued)
+	}
+
+	_, err = es.CreateDefinitionRunByDefinitionID("B", request("somebody", "echo different"))
+	if _, ok := err.(exceptions.ConflictingResource); !ok {
+		t.Errorf("Expected reusing the key with a different request to conflict, got %v", err)
+	}
+
+	other, err := es.CreateDefinitionRunByDefinitionID("B", request("somebody-else", "echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.RunID == first.RunID {
+		t.Errorf("Expected keys to be scoped to their owner")
+	}
+}
+
+func TestExecutionService_CreateRunAuditFailure(t *testing.T) {
+	es, imp := setUp(t)
+	imp.AuditError = errors.New("audit table unavailable")
+	engine := state.DefaultEngine
+	key := "audit-failure"
+	request := func() *state.DefinitionExecutionRequest {
+		return &state.DefinitionExecutionRequest{
+			ExecutionRequestCommon: &state.ExecutionRequestCommon{
+				OwnerID: "somebody", Engine: &engine, IdempotencyKey: &key,
+			},
+		}
+	}
+
+	// The run is queued by the time it's audited
+	run, err := es.CreateDefinitionRunByDefinitionID("B", request())
+	if err != nil {
+		t.Fatalf("Expected a failed audit not to fail the run, got %v", err)
+	}
+	if len(imp.Queued) != 1 {
+		t.Errorf("Expected the run to be queued, got %v", imp.Queued)
+	}
+	repeat, err := es.CreateDefinitionRunByDefinitionID("B", request())
+	if err != nil || repeat.RunID != run.RunID || len(imp.Queued) != 1 {
+		t.Errorf("Expected the idempotency key to be kept, got %s, %v", repeat.RunID, err)
+	}
 }
 
 func TestExecutionService_CreateDefinitionRunByAlias(t *testing.T) {
@@ -147,11 +588,13 @@ func TestExecutionService_CreateDefinitionRunByAlias(t *testing.T) {
 	}
 	expectedCalls := map[string]bool{
 		"GetDefinitionByAlias":     true,
+		"CreateExecutableSnapshot": true,
 		"CreateRun":                true,
 		"UpdateRun":                true,
 		"GetTaskHistoricalRuntime": true,
 		"GetPodReAttemptRate":      true,
 		"Enqueue":                  true,
+		"CreateAuditEvent":         true,
 	}
 	mem := int64(1024)
 	engine := state.DefaultEngine
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// Where the exit reason rules in effect come from
+//
+const (
+	ExitReasonSourceAPI     = "api"
+	ExitReasonSourceConfig  = "config"
+	ExitReasonSourceDefault = "default"
+)
+
+//
+// ExitReasonRuleList is the exit reason rules in effect and where they come
+// from
+//
+type ExitReasonRuleList struct {
+	Rules  state.ExitReasonRules `json:"rules"`
+	Source string                `json:"source"`
+}
+
+//
+// ExitReasonTest is the outcome of classifying a past run: the rule that
+// matched, if any, next to the exit reason and category stored on the run
+//
+type ExitReasonTest struct {
+	RunID        string                 `json:"run_id"`
+	ExitCode     *int64                 `json:"exit_code"`
+	ExitReason   *string                `json:"exit_reason"`
+	ExitCategory *string                `json:"exit_category"`
+	Match        *state.ExitReasonMatch `json:"match"`
+}
+
+//
+// ExitReasonService defines an interface for managing the rules that
+// classify why runs failed and applying them
+//
+type ExitReasonService interface {
+	ListRules() (ExitReasonRuleList, error)
+	PutRules(rules state.ExitReasonRules, userInfo state.UserInfo) (ExitReasonRuleList, error)
+	Classify(run state.Run) (*state.ExitReasonMatch, error)
+	Test(runID string, rules state.ExitReasonRules) (ExitReasonTest, error)
+	CountCategories(filters map[string][]string) ([]state.ExitCategoryCount, error)
+}
+
+// exitReasonRulesTTL is how long the rules in effect are cached for
+// classifying runs; rules put on another replica apply after at most this long
+var exitReasonRulesTTL = time.Minute
+
+type exitReasonService struct {
+	sm         state.Manager
+	logger     flotillaLog.Logger
+	ls         LogService
+	rules      state.ExitReasonRules
+	source     string
+	configured state.CompiledExitReasonRules
+
+	mu       sync.Mutex
+	current  ExitReasonRuleList
+	compiled state.CompiledExitReasonRules
+	loadedAt time.Time
+}
+
+//
+// NewExitReasonService configures and returns an ExitReasonService. Rules
+// put through the API take precedence over the exit_reasons config, which
+// takes precedence over the default rules. Without a LogService, rules that
+// target the log tail never match.
+//
+func NewExitReasonService(conf config.Config, sm state.Manager, ls LogService, logger flotillaLog.Logger) (ExitReasonService, error) {
+	rules, err := state.NewExitReasonRules(conf)
+	if err != nil {
+		return nil, err
+	}
+	ers := exitReasonService{sm: sm, logger: logger, ls: ls, rules: rules, source: ExitReasonSourceDefault, configured: rules.Compile()}
+	if conf != nil && conf.IsSet("exit_reasons.rules") {
+		ers.source = ExitReasonSourceConfig
+	}
+	return &ers, nil
+}
+
+//
+// ListRules returns the rules in effect
+//
+func (ers *exitReasonService) ListRules() (ExitReasonRuleList, error) {
+	current, _, err := ers.load()
+	return current, err
+}
+
+//
+// load reads the rules in effect and caches them along with their compiled
+// patterns
+//
+func (ers *exitReasonService) load() (ExitReasonRuleList, state.CompiledExitReasonRules, error) {
+	stored, err := ers.sm.ListExitReasonRules()
+	if err != nil {
+		return ExitReasonRuleList{}, state.CompiledExitReasonRules{}, err
+	}
+	current := ExitReasonRuleList{Rules: ers.rules, Source: ers.source}
+	compiled := ers.configured
+	if len(stored) > 0 {
+		current = ExitReasonRuleList{Rules: stored, Source: ExitReasonSourceAPI}
+		compiled = stored.Compile()
+	}
+
+	ers.mu.Lock()
+	defer ers.mu.Unlock()
+	ers.current, ers.compiled, ers.loadedAt = current, compiled, time.Now()
+	return current, compiled, nil
+}
+
+//
+// cached returns the cached rules in effect, loading them once they are
+// older than exitReasonRulesTTL
+//
+func (ers *exitReasonService) cached() (ExitReasonRuleList, state.CompiledExitReasonRules, error) {
+	ers.mu.Lock()
+	current, compiled, loadedAt := ers.current, ers.compiled, ers.loadedAt
+	ers.mu.Unlock()
+	if !loadedAt.IsZero() && time.Since(loadedAt) < exitReasonRulesTTL {
+		return current, compiled, nil
+	}
+	return ers.load()
+}
+
+//
+// PutRules replaces the rules put through the API; an empty list goes back
+// to the configured rules
+//
+func (ers *exitReasonService) PutRules(rules state.ExitReasonRules, userInfo state.UserInfo) (ExitReasonRuleList, error) {
+	if valid, reasons := rules.IsValid(); !valid {
+		return ExitReasonRuleList{}, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	before, err := ers.sm.ListExitReasonRules()
+	if err != nil {
+		return ExitReasonRuleList{}, err
+	}
+	if err = ers.sm.PutExitReasonRules(rules); err != nil {
+		return ExitReasonRuleList{}, err
+	}
+	recordAudit(ers.sm, ers.logger, userInfo,
+		state.AuditActionExitReasonsPut, state.AuditTargetExitReason, "rules", before, rules)
+	return ers.ListRules()
+}
+
+//
+// Classify returns the first rule in effect that matches run, or nil if
+// none do
+//
+func (ers *exitReasonService) Classify(run state.Run) (*state.ExitReasonMatch, error) {
+	_, compiled, err := ers.cached()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Classify(ers.input(run)), nil
+}
+
+//
+// Test classifies a past run with rules, or the rules in effect when rules
+// is empty, without changing the run
+//
+func (ers *exitReasonService) Test(runID string, rules state.ExitReasonRules) (ExitReasonTest, error) {
+	var compiled state.CompiledExitReasonRules
+	if len(rules) == 0 {
+		_, current, err := ers.cached()
+		if err != nil {
+			return ExitReasonTest{}, err
+		}
+		compiled = current
+	} else if valid, reasons := rules.IsValid(); !valid {
+		return ExitReasonTest{}, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	} else {
+		compiled = rules.Compile()
+	}
+
+	run, err := ers.sm.GetRun(runID)
+	if err != nil {
+		return ExitReasonTest{}, err
+	}
+	return ExitReasonTest{
+		RunID:        run.RunID,
+		ExitCode:     run.ExitCode,
+		ExitReason:   run.ExitReason,
+		ExitCategory: run.ExitCategory,
+		Match:        compiled.Classify(ers.input(run)),
+	}, nil
+}
+
+//
+// CountCategories counts the runs matching filters in each exit category
+//
+func (ers *exitReasonService) CountCategories(filters map[string][]string) ([]state.ExitCategoryCount, error) {
+	return ers.sm.CountRunsByExitCategory(filters)
+}
+
+func (ers *exitReasonService) input(run state.Run) state.ExitReasonInput {
+	in := state.NewExitReasonInput(run)
+	in.LogTail = func() string {
+		if ers.ls == nil || len(run.RunID) == 0 {
+			return ""
+		}
+		// Runs without readable logs just don't match log rules
+		tail, _ := ers.ls.Tail(run.RunID)
+		return tail
+	}
+	return in
+}
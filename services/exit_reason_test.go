@@ -0,0 +1,129 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpExitReasonService(t *testing.T) (ExitReasonService, *testutils.ImplementsAllTheThings) {
+	exitCode := int64(1)
+	runExceptions := state.RunExceptions{"KeyError: 'id'"}
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Definitions: map[string]state.Definition{
+			"A": {DefinitionID: "A"},
+		},
+		Runs: map[string]state.Run{
+			"failed": {DefinitionID: "A", RunID: "failed", Status: state.StatusStopped, ExitCode: &exitCode, RunExceptions: &runExceptions},
+		},
+		LogLines: []string{"starting\n", "OSError: [Errno 28] No space left on device\n"},
+	}
+	ls, _ := NewLogService(nil, &imp, &imp)
+	ers, _ := NewExitReasonService(nil, &imp, ls, &imp)
+	return ers, &imp
+}
+
+func TestExitReasonService_ListRules(t *testing.T) {
+	ers, imp := setUpExitReasonService(t)
+
+	current, err := ers.ListRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Source != ExitReasonSourceDefault || len(current.Rules) != len(state.DefaultExitReasonRules()) {
+		t.Errorf("Expected the default rules, got %v", current)
+	}
+
+	imp.ExitReasonRules = state.ExitReasonRules{{Name: "a", Pattern: "a+", Target: state.ExitReasonTargetExceptions, Category: "c", Message: "m"}}
+	if current, _ = ers.ListRules(); current.Source != ExitReasonSourceAPI || len(current.Rules) != 1 {
+		t.Errorf("Expected the stored rules to take precedence, got %v", current)
+	}
+}
+
+func TestExitReasonService_PutRules(t *testing.T) {
+	ers, imp := setUpExitReasonService(t)
+
+	rules := state.ExitReasonRules{{Name: "disk", Pattern: "No space left", Target: state.ExitReasonTargetLogTail, Category: "disk", Message: "Out of disk"}}
+	current, err := ers.PutRules(rules, state.UserInfo{Email: "somebody@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Source != ExitReasonSourceAPI || len(current.Rules) != 1 {
+		t.Errorf("Expected the rules that were put, got %v", current)
+	}
+	if len(imp.AuditEvents) != 1 || imp.AuditEvents[0].Action != state.AuditActionExitReasonsPut {
+		t.Errorf("Expected the rules to be audited, got %v", imp.AuditEvents)
+	}
+
+	match, err := ers.Classify(imp.Runs["failed"])
+	if err != nil || match == nil || match.Rule.Category != "disk" {
+		t.Errorf("Expected the run to be classified by its log, got %v %v", match, err)
+	}
+
+	_, err = ers.PutRules(state.ExitReasonRules{{Name: "all", Pattern: ".*", Target: state.ExitReasonTargetLogTail, Category: "c", Message: "m"}}, state.UserInfo{})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected a pattern matching the empty string to produce MalformedInput but was %v", err)
+	}
+
+	if current, _ = ers.PutRules(nil, state.UserInfo{}); current.Source != ExitReasonSourceDefault {
+		t.Errorf("Expected no rules to go back to the defaults, got %v", current)
+	}
+}
+
+func TestExitReasonService_ClassifyCachesRules(t *testing.T) {
+	ers, imp := setUpExitReasonService(t)
+
+	for i := 0; i < 2; i++ {
+		if match, err := ers.Classify(imp.Runs["failed"]); err != nil || match == nil || match.Rule.Category != "data" {
+			t.Errorf("Expected the default rules to classify the run, got %v %v", match, err)
+		}
+	}
+	loads := 0
+	for _, call := range imp.Calls {
+		if call == "ListExitReasonRules" {
+			loads++
+		}
+	}
+	if loads != 1 {
+		t.Errorf("Expected the rules to be loaded once, were loaded %d times", loads)
+	}
+
+	imp.ExitReasonRules = state.ExitReasonRules{{Name: "disk", Pattern: "No space left", Target: state.ExitReasonTargetLogTail, Category: "disk", Message: "Out of disk"}}
+	if match, _ := ers.Classify(imp.Runs["failed"]); match == nil || match.Rule.Category != "data" {
+		t.Errorf("Expected the cached rules to classify the run, got %v", match)
+	}
+
+	defer func(ttl time.Duration) { exitReasonRulesTTL = ttl }(exitReasonRulesTTL)
+	exitReasonRulesTTL = 0
+	if match, _ := ers.Classify(imp.Runs["failed"]); match == nil || match.Rule.Category != "disk" {
+		t.Errorf("Expected the stored rules to classify the run once the cache expired, got %v", match)
+	}
+}
+
+func TestExitReasonService_Test(t *testing.T) {
+	ers, imp := setUpExitReasonService(t)
+
+	result, err := ers.Test("failed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Match == nil || result.Match.Rule.Category != "data" || result.Match.Match != "KeyError" {
+		t.Errorf("Expected the default rules to classify the run, got %v", result.Match)
+	}
+
+	rules := state.ExitReasonRules{{Name: "disk", Pattern: "No space left", Target: state.ExitReasonTargetLogTail, Category: "disk", Message: "Out of disk"}}
+	if result, _ = ers.Test("failed", rules); result.Match == nil || result.Match.Rule.Name != "disk" {
+		t.Errorf("Expected the given rules to classify the run, got %v", result.Match)
+	}
+	if imp.Runs["failed"].ExitCategory != nil {
+		t.Errorf("Expected testing rules not to change the run")
+	}
+
+	if _, err = ers.Test("missing", nil); err == nil {
+		t.Errorf("Expected testing a missing run to produce an error")
+	}
+}
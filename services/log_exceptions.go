@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+//
+// Kinds of exceptions found in run logs
+//
+const (
+	ExceptionPython = "python"
+	ExceptionJava   = "java"
+	ExceptionGo     = "go"
+	ExceptionOOM    = "oom"
+)
+
+const (
+	// maxLogExceptions bounds the exceptions kept from one log; the last
+	// ones are kept as they are closest to the failure
+	maxLogExceptions = 10
+	// maxLogExceptionLines bounds the lines kept of one stack trace
+	maxLogExceptionLines = 100
+)
+
+var (
+	pythonTraceback     = regexp.MustCompile(`^Traceback \(most recent call last\):\s*$`)
+	pythonExceptionLine = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?::\s?(.*))?$`)
+	pythonChain         = regexp.MustCompile(`^(During handling of the above exception|The above exception was the direct cause)`)
+	javaException       = regexp.MustCompile(`((?:[A-Za-z_$][\w$]*\.)+[A-Za-z_$][\w$]*(?:Exception|Error|Throwable))(?::\s?(.*))?`)
+	javaFrame           = regexp.MustCompile(`^\s+at \S`)
+	javaTraceLine       = regexp.MustCompile(`^(\s+at \S|\s*\.\.\. \d+ (more|common frames omitted)|\s*Caused by: |\s+Suppressed: )`)
+	goPanic             = regexp.MustCompile(`^(panic|fatal error): (.+)$`)
+	goTraceLine         = regexp.MustCompile(`^(\s*$|goroutine \d+ \[|\t|\[signal |created by |exit status \d+|\.\.\.additional frames elided\.\.\.|\S+\(.*\)$)`)
+	outOfMemory         = regexp.MustCompile(`(?i)(OutOfMemoryError|\bMemoryError\b|out of memory|OOMKilled|Killed process \d+|Cannot allocate memory|exceeding memory limits|std::bad_alloc)`)
+)
+
+//
+// LogException is an exception found in a run's log. Trace holds its lines
+// as they were logged, including the exception line itself.
+//
+type LogException struct {
+	Kind    string
+	Type    string
+	Message string
+	Trace   []string
+}
+
+//
+// String returns the exception as it was logged
+//
+func (e LogException) String() string {
+	return strings.Join(e.Trace, "\n")
+}
+
+func (e *LogException) addTrace(line string) {
+	if len(e.Trace) < maxLogExceptionLines {
+		e.Trace = append(e.Trace, line)
+	}
+}
+
+//
+// ExtractExceptions finds the Python tracebacks, Java and Scala stack
+// traces, Go panics and out of memory errors in log. Exceptions caused by
+// running out of memory have the kind ExceptionOOM whatever their language.
+// Repeated exceptions are only returned once.
+//
+func ExtractExceptions(log string) []LogException {
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+
+	var found []LogException
+	for i := 0; i < len(lines); {
+		var (
+			e    LogException
+			next int
+			ok   bool
+		)
+		for _, parse := range []func([]string, int) (LogException, int, bool){
+			parsePythonException, parseJavaException, parseGoPanic,
+		} {
+			if e, next, ok = parse(lines, i); ok {
+				break
+			}
+		}
+		if !ok {
+			next = i + 1
+			if m := outOfMemory.FindString(lines[i]); len(m) > 0 {
+				e, ok = LogException{Kind: ExceptionOOM, Type: m, Message: strings.TrimSpace(lines[i])}, true
+				e.addTrace(lines[i])
+			}
+		}
+		if ok {
+			if outOfMemory.MatchString(e.Type) || outOfMemory.MatchString(e.Message) {
+				e.Kind = ExceptionOOM
+			}
+			found = append(found, e)
+		}
+		i = next
+	}
+	return lastUniqueExceptions(found)
+}
+
+//
+// parsePythonException parses a traceback starting at lines[i], along with
+// the tracebacks chained to it; the type and message are of the last one
+//
+func parsePythonException(lines []string, i int) (LogException, int, bool) {
+	if !pythonTraceback.MatchString(lines[i]) {
+		return LogException{}, i, false
+	}
+
+	e := LogException{Kind: ExceptionPython}
+	for {
+		e.addTrace(lines[i])
+		i++
+		for i < len(lines) && len(lines[i]) > 0 && (lines[i][0] == ' ' || lines[i][0] == '\t') {
+			e.addTrace(lines[i])
+			i++
+		}
+		if i < len(lines) {
+			if m := pythonExceptionLine.FindStringSubmatch(lines[i]); m != nil {
+				e.Type, e.Message = m[1], m[2]
+				e.addTrace(lines[i])
+				i++
+			}
+		}
+
+		// Look past the "During handling of the above exception" lines
+		// for another traceback
+		j := skipBlank(lines, i)
+		if j == len(lines) || !pythonChain.MatchString(lines[j]) {
+			return e, i, true
+		}
+		k := skipBlank(lines, j+1)
+		if k == len(lines) || !pythonTraceback.MatchString(lines[k]) {
+			return e, i, true
+		}
+		for ; i < k; i++ {
+			e.addTrace(lines[i])
+		}
+	}
+}
+
+//
+// parseJavaException parses a Java or Scala stack trace starting at
+// lines[i], including its causes. The exception line may have a prefix,
+// eg. `Exception in thread "main"`.
+//
+func parseJavaException(lines []string, i int) (LogException, int, bool) {
+	if i+1 >= len(lines) || !javaFrame.MatchString(lines[i+1]) {
+		return LogException{}, i, false
+	}
+	m := javaException.FindStringSubmatch(lines[i])
+	if m == nil {
+		return LogException{}, i, false
+	}
+
+	e := LogException{Kind: ExceptionJava, Type: m[1], Message: m[2]}
+	e.addTrace(lines[i])
+	for i++; i < len(lines) && javaTraceLine.MatchString(lines[i]); i++ {
+		e.addTrace(lines[i])
+	}
+	return e, i, true
+}
+
+//
+// parseGoPanic parses a Go panic or fatal error starting at lines[i] and
+// the goroutine traces after it
+//
+func parseGoPanic(lines []string, i int) (LogException, int, bool) {
+	m := goPanic.FindStringSubmatch(lines[i])
+	if m == nil {
+		return LogException{}, i, false
+	}
+
+	e := LogException{Kind: ExceptionGo, Type: m[1], Message: m[2]}
+	e.addTrace(lines[i])
+	goroutines := false
+	for i++; i < len(lines) && goTraceLine.MatchString(lines[i]); i++ {
+		goroutines = goroutines || strings.HasPrefix(lines[i], "goroutine ")
+		e.addTrace(lines[i])
+		if strings.HasPrefix(lines[i], "exit status ") {
+			i++
+			break
+		}
+	}
+	if !goroutines {
+		// Just a log line that starts with panic
+		return LogException{}, i, false
+	}
+	for len(e.Trace) > 1 && len(strings.TrimSpace(e.Trace[len(e.Trace)-1])) == 0 {
+		e.Trace = e.Trace[:len(e.Trace)-1]
+	}
+	return e, i, true
+}
+
+func skipBlank(lines []string, i int) int {
+	for i < len(lines) && len(strings.TrimSpace(lines[i])) == 0 {
+		i++
+	}
+	return i
+}
+
+//
+// lastUniqueExceptions drops all but the last of repeated exceptions and
+// keeps the last maxLogExceptions
+//
+func lastUniqueExceptions(found []LogException) []LogException {
+	seen := make(map[string]bool)
+	var unique []LogException
+	for i := len(found) - 1; i >= 0 && len(unique) < maxLogExceptions; i-- {
+		key := found[i].String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, found[i])
+	}
+	for i, j := 0, len(unique)-1; i < j; i, j = i+1, j-1 {
+		unique[i], unique[j] = unique[j], unique[i]
+	}
+	return unique
+}
+
+//
+// tailLog returns up to the last n bytes of r, from the start of a line.
+// Readers that can seek, eg. local files, skip straight to the tail.
+//
+func tailLog(r io.Reader, n int64) (string, error) {
+	var (
+		buf       []byte
+		truncated bool
+	)
+	if s, ok := r.(io.Seeker); ok {
+		if size, err := s.Seek(0, io.SeekEnd); err == nil && size > n {
+			if _, err = s.Seek(size-n, io.SeekStart); err != nil {
+				return "", err
+			}
+			truncated = true
+		} else if _, err = s.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+
+	chunk := make([]byte, 32*1024)
+	for {
+		read, err := r.Read(chunk)
+		buf = append(buf, chunk[:read]...)
+		if int64(len(buf)) > 2*n {
+			buf = append(buf[:0], buf[int64(len(buf))-n:]...)
+			truncated = true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if int64(len(buf)) > n {
+		buf = buf[int64(len(buf))-n:]
+		truncated = true
+	}
+	if truncated {
+		if newline := bytes.IndexByte(buf, '\n'); newline >= 0 {
+			buf = buf[newline+1:]
+		}
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractExceptions(t *testing.T) {
+	log := strings.Join([]string{
+		"starting",
+		"Traceback (most recent call last):",
+		`  File "job.py", line 3, in <module>`,
+		"    main()",
+		"KeyError: 'a'",
+		"",
+		"During handling of the above exception, another exception occurred:",
+		"",
+		"Traceback (most recent call last):",
+		`  File "job.py", line 5, in <module>`,
+		"    raise ValueError(\"bad\")",
+		"ValueError: bad",
+		"some other output",
+		`Exception in thread "main" java.lang.IllegalStateException: boom`,
+		"\tat com.example.Job.run(Job.scala:10)",
+		"\tat com.example.Job.main(Job.scala:3)",
+		"Caused by: java.io.IOException: disk",
+		"\t... 2 more",
+		"panic: runtime error: index out of range [1] with length 1",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/app/main.go:8 +0x1d",
+		"exit status 2",
+		"",
+		"panic: not a go panic, no goroutines",
+		"java.lang.OutOfMemoryError: Java heap space",
+		"\tat java.util.Arrays.copyOf(Arrays.java:3332)",
+		"Killed process 1234 (python) total-vm:1024kB",
+	}, "\n")
+
+	found := ExtractExceptions(log)
+	if len(found) != 5 {
+		t.Fatalf("Expected 5 exceptions, got %d: %+v", len(found), found)
+	}
+
+	expected := []LogException{
+		{Kind: ExceptionPython, Type: "ValueError", Message: "bad"},
+		{Kind: ExceptionJava, Type: "java.lang.IllegalStateException", Message: "boom"},
+		{Kind: ExceptionGo, Type: "panic", Message: "runtime error: index out of range [1] with length 1"},
+		{Kind: ExceptionOOM, Type: "java.lang.OutOfMemoryError", Message: "Java heap space"},
+		{Kind: ExceptionOOM, Type: "Killed process 1234", Message: "Killed process 1234 (python) total-vm:1024kB"},
+	}
+	for i, e := range expected {
+		if found[i].Kind != e.Kind || found[i].Type != e.Type || found[i].Message != e.Message {
+			t.Errorf("Expected exception %d to be %+v, got %+v", i, e, found[i])
+		}
+	}
+
+	if len(found[0].Trace) != 11 || !strings.HasPrefix(found[0].String(), "Traceback") {
+		t.Errorf("Expected the chained tracebacks as one exception, got [%s]", found[0].String())
+	}
+	if len(found[1].Trace) != 5 {
+		t.Errorf("Expected the java trace with its cause, got [%s]", found[1].String())
+	}
+	if len(found[2].Trace) != 6 || !strings.HasSuffix(found[2].String(), "exit status 2") {
+		t.Errorf("Expected the goroutine trace, got [%s]", found[2].String())
+	}
+}
+
+func TestExtractExceptions_Repeated(t *testing.T) {
+	var lines []string
+	for i := 0; i < 3; i++ {
+		lines = append(lines, "Traceback (most recent call last):", `  File "job.py", line 1`, "RuntimeError: retry")
+	}
+	lines = append(lines, "MemoryError")
+
+	found := ExtractExceptions(strings.Join(lines, "\n"))
+	if len(found) != 2 || found[0].Type != "RuntimeError" || found[1].Kind != ExceptionOOM {
+		t.Errorf("Expected the repeated exception once and the memory error, got %+v", found)
+	}
+
+	if found := ExtractExceptions("all good\n"); len(found) != 0 {
+		t.Errorf("Expected no exceptions, got %+v", found)
+	}
+}
+
+func TestTailLog(t *testing.T) {
+	log := "first line\nsecond line\nthird line\n"
+
+	tail, err := tailLog(strings.NewReader(log), 15)
+	if err != nil || tail != "third line\n" {
+		t.Errorf("Expected the complete lines in the tail, got [%s] %v", tail, err)
+	}
+
+	tail, err = tailLog(strings.NewReader(log), 1024)
+	if err != nil || tail != log {
+		t.Errorf("Expected the whole of a short log, got [%s] %v", tail, err)
+	}
+
+	// Files are read from the tail
+	dir, err := ioutil.TempDir("", "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "run.log")
+	if err = ioutil.WriteFile(name, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tail, err = tailLog(f, 15)
+	if err != nil || tail != "third line\n" {
+		t.Errorf("Expected the complete lines in the tail of the file, got [%s] %v", tail, err)
+	}
+}
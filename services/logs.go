@@ -1,25 +1,115 @@
 package services
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/stitchfix/flotilla-os/clients/logs"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
+	"io"
 	"net/http"
+	"regexp"
+	"time"
 )
 
+var defaultLogFollowInterval = 5 * time.Second
+
+const (
+	defaultLogSearchMaxBytes   = 1 << 30
+	defaultLogSearchMaxMatches = 1000
+	defaultExceptionsTailBytes = 256 * 1024
+	// MaxLogSearchContext is the most context lines a search may ask for
+	MaxLogSearchContext = 50
+	// Longer lines end a search rather than being buffered
+	maxLogSearchLineLength = 1 << 20
+)
+
+//
+// Reasons a log search stopped before the end of the log
+//
+const (
+	LogSearchMaxBytes    = "max_bytes"
+	LogSearchMaxMatches  = "max_matches"
+	LogSearchLineTooLong = "line_too_long"
+	LogSearchTimeout     = "timeout"
+)
+
+//
+// LogSearchLine is a line of a log search result; Line is its 1-based line
+// number in the log and Match is false for context lines
+//
+type LogSearchLine struct {
+	Line  int64  `json:"line"`
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+//
+// LogSearchSummary describes how much of a log a search scanned and, when
+// Truncated, why it stopped early
+//
+type LogSearchSummary struct {
+	LinesScanned    int64  `json:"lines_scanned"`
+	BytesScanned    int64  `json:"bytes_scanned"`
+	Matches         int64  `json:"matches"`
+	Truncated       bool   `json:"truncated"`
+	TruncatedReason string `json:"truncated_reason,omitempty"`
+}
+
 type LogService interface {
 	Logs(runID string, lastSeen *string, role *string, facility *string) (string, *string, error)
 	LogsText(runID string, w http.ResponseWriter) error
+	Follow(ctx context.Context, runID string, lastSeen *string, role *string, facility *string, emit func(log string, lastSeen string) error) error
+	Search(ctx context.Context, runID string, query string, contextLines int, role *string, facility *string, emit func(line LogSearchLine) error) (LogSearchSummary, error)
+	Exceptions(runID string) (state.RunExceptions, error)
+	Tail(runID string) (string, error)
 }
 
 type logService struct {
-	sm state.Manager
-	lc logs.Client
+	sm               state.Manager
+	lc               logs.Client
+	followInterval   time.Duration
+	searchMaxBytes   int64
+	searchMaxMatches int64
+	exceptionsTail   int64
 }
 
 // Initialize a Log service.
-func NewLogService(sm state.Manager, lc logs.Client) (LogService, error) {
-	return &logService{sm: sm, lc: lc}, nil
+func NewLogService(conf config.Config, sm state.Manager, lc logs.Client) (LogService, error) {
+	ls := logService{
+		sm:               sm,
+		lc:               lc,
+		followInterval:   defaultLogFollowInterval,
+		searchMaxBytes:   defaultLogSearchMaxBytes,
+		searchMaxMatches: defaultLogSearchMaxMatches,
+		exceptionsTail:   defaultExceptionsTailBytes,
+	}
+	if conf == nil {
+		return &ls, nil
+	}
+	if conf.IsSet("logs.follow_interval") {
+		interval, err := time.ParseDuration(conf.GetString("logs.follow_interval"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid logs.follow_interval: %v", err)
+		}
+		ls.followInterval = interval
+	}
+	if conf.IsSet("logs.search.max_bytes") {
+		ls.searchMaxBytes = int64(conf.GetInt("logs.search.max_bytes"))
+	}
+	if conf.IsSet("logs.search.max_matches") {
+		ls.searchMaxMatches = int64(conf.GetInt("logs.search.max_matches"))
+	}
+	if conf.IsSet("logs.exceptions.tail_bytes") {
+		ls.exceptionsTail = int64(conf.GetInt("logs.exceptions.tail_bytes"))
+		if ls.exceptionsTail <= 0 {
+			return nil, fmt.Errorf("invalid logs.exceptions.tail_bytes: must be positive")
+		}
+	}
+	return &ls, nil
 }
 
 // Returns logs associated with a RunId
@@ -34,16 +124,7 @@ func (ls *logService) Logs(runID string, lastSeen *string, role *string, facilit
 		return "", aws.String(""), nil
 	}
 
-	if run.ExecutableType == nil {
-		defaultExecutableType := state.ExecutableTypeDefinition
-		run.ExecutableType = &defaultExecutableType
-	}
-
-	if run.ExecutableID == nil {
-		run.ExecutableID = &run.DefinitionID
-	}
-	executable, err := ls.sm.GetExecutableByTypeAndID(*run.ExecutableType, *run.ExecutableID)
-
+	executable, err := ls.executable(&run)
 	return ls.lc.Logs(executable, run, lastSeen, role, facility)
 }
 
@@ -59,6 +140,222 @@ func (ls *logService) LogsText(runID string, w http.ResponseWriter) error {
 		return nil
 	}
 
+	executable, err := ls.executable(&run)
+	return ls.lc.LogsText(executable, run, w)
+}
+
+//
+// Follow passes the logs of a run after lastSeen to emit as they arrive,
+// with the cursor to resume from, polling every logs.follow_interval. Once
+// the run has stopped its remaining logs are passed on and Follow returns.
+// Runs that haven't started yet are waited on. Errors reading the logs of a
+// running run are retried on the next poll; once it has stopped they're
+// returned. Follow also returns, with the context's error, when ctx is done.
+//
+func (ls *logService) Follow(ctx context.Context, runID string, lastSeen *string, role *string, facility *string, emit func(log string, lastSeen string) error) error {
+	var cursor string
+	if lastSeen != nil {
+		cursor = *lastSeen
+	}
+
+	for {
+		run, err := ls.sm.GetRun(runID)
+		if err != nil {
+			return err
+		}
+
+		stopped := run.Status == state.StatusStopped
+		if run.Status == state.StatusRunning || stopped {
+			executable, err := ls.executable(&run)
+			if err != nil {
+				return err
+			}
+			// Clients return a bounded chunk per call; read until caught up
+			for {
+				log, next, err := ls.lc.Logs(executable, run, &cursor, role, facility)
+				if err != nil && stopped {
+					return err
+				}
+				if err != nil || next == nil || *next == cursor {
+					// No logs yet, or none since the cursor
+					break
+				}
+				cursor = *next
+				if len(log) == 0 {
+					break
+				}
+				if err = emit(log, cursor); err != nil {
+					return err
+				}
+			}
+		}
+		if stopped {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(ls.followInterval):
+		}
+	}
+}
+
+//
+// Search scans the log of a run for lines matching the regular expression
+// query and passes each to emit in order, along with up to contextLines
+// lines before and after it. The scan stops early, with the reason in the
+// summary, after logs.search.max_bytes bytes or logs.search.max_matches
+// matches, or when ctx is done.
+//
+func (ls *logService) Search(ctx context.Context, runID string, query string, contextLines int, role *string, facility *string, emit func(line LogSearchLine) error) (LogSearchSummary, error) {
+	var summary LogSearchSummary
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return summary, exceptions.MalformedInput{ErrorString: fmt.Sprintf("invalid search query [%s]: %v", query, err)}
+	}
+	if contextLines < 0 || contextLines > MaxLogSearchContext {
+		return summary, exceptions.MalformedInput{ErrorString: fmt.Sprintf("context must be between 0 and %d", MaxLogSearchContext)}
+	}
+
+	run, err := ls.sm.GetRun(runID)
+	if err != nil {
+		return summary, err
+	}
+	if run.Status != state.StatusRunning && run.Status != state.StatusStopped {
+		// Won't have logs yet
+		return summary, nil
+	}
+	executable, err := ls.executable(&run)
+	if err != nil {
+		return summary, err
+	}
+
+	r, err := ls.lc.LogsReader(executable, run, role, facility)
+	if err != nil {
+		return summary, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogSearchLineLength)
+	before := make([]LogSearchLine, 0, contextLines)
+	after := 0
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			summary.Truncated, summary.TruncatedReason = true, LogSearchTimeout
+			return summary, nil
+		}
+		summary.LinesScanned++
+		summary.BytesScanned += int64(len(scanner.Bytes())) + 1
+		line := LogSearchLine{Line: summary.LinesScanned, Text: scanner.Text()}
+
+		switch {
+		case summary.Matches < ls.searchMaxMatches && re.MatchString(line.Text):
+			line.Match = true
+			summary.Matches++
+			for _, b := range before {
+				if err = emit(b); err != nil {
+					return summary, err
+				}
+			}
+			before = before[:0]
+			if err = emit(line); err != nil {
+				return summary, err
+			}
+			after = contextLines
+		case after > 0:
+			after--
+			if err = emit(line); err != nil {
+				return summary, err
+			}
+		case summary.Matches >= ls.searchMaxMatches:
+			// The context of the last match has been sent
+			summary.Truncated, summary.TruncatedReason = true, LogSearchMaxMatches
+			return summary, nil
+		case contextLines > 0:
+			if len(before) == contextLines {
+				copy(before, before[1:])
+				before = before[:contextLines-1]
+			}
+			before = append(before, line)
+		}
+
+		if summary.BytesScanned >= ls.searchMaxBytes {
+			summary.Truncated, summary.TruncatedReason = true, LogSearchMaxBytes
+			return summary, nil
+		}
+	}
+
+	if err = scanner.Err(); err == bufio.ErrTooLong {
+		summary.Truncated, summary.TruncatedReason = true, LogSearchLineTooLong
+		return summary, nil
+	}
+	return summary, err
+}
+
+//
+// Exceptions returns the exceptions found in the last
+// logs.exceptions.tail_bytes of a stopped run's log, or of an EMR run's
+// driver stderr. Runs that haven't stopped have none yet.
+//
+func (ls *logService) Exceptions(runID string) (state.RunExceptions, error) {
+	run, err := ls.sm.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status != state.StatusStopped {
+		return nil, nil
+	}
+	log, err := ls.tail(run)
+	if err != nil {
+		return nil, err
+	}
+	runExceptions := state.RunExceptions{}
+	for _, e := range ExtractExceptions(log) {
+		runExceptions = append(runExceptions, e.String())
+	}
+	return runExceptions, nil
+}
+
+//
+// Tail returns the last logs.exceptions.tail_bytes of a run's log, or of an
+// EMR run's driver stderr, from the start of a line
+//
+func (ls *logService) Tail(runID string) (string, error) {
+	run, err := ls.sm.GetRun(runID)
+	if err != nil {
+		return "", err
+	}
+	if run.Status != state.StatusRunning && run.Status != state.StatusStopped {
+		// Won't have logs yet
+		return "", nil
+	}
+	return ls.tail(run)
+}
+
+func (ls *logService) tail(run state.Run) (string, error) {
+	executable, err := ls.executable(&run)
+	if err != nil {
+		return "", err
+	}
+
+	// Clients that can tail the log only fetch its end
+	role, facility := "driver", "stderr"
+	var r io.ReadCloser
+	if t, ok := ls.lc.(logs.Tailer); ok {
+		r, err = t.TailReader(executable, run, &role, &facility, ls.exceptionsTail)
+	} else {
+		r, err = ls.lc.LogsReader(executable, run, &role, &facility)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return tailLog(r, ls.exceptionsTail)
+}
+
+func (ls *logService) executable(run *state.Run) (state.Executable, error) {
 	if run.ExecutableType == nil {
 		defaultExecutableType := state.ExecutableTypeDefinition
 		run.ExecutableType = &defaultExecutableType
@@ -66,7 +363,5 @@ func (ls *logService) LogsText(runID string, w http.ResponseWriter) error {
 	if run.ExecutableID == nil {
 		run.ExecutableID = &run.DefinitionID
 	}
-	executable, err := ls.sm.GetExecutableByTypeAndID(*run.ExecutableType, *run.ExecutableID)
-
-	return ls.lc.LogsText(executable, run, w)
+	return ls.sm.GetExecutableByTypeAndID(*run.ExecutableType, *run.ExecutableID)
 }
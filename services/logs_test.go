@@ -1,9 +1,15 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/testutils"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func setUpLogServiceTest(t *testing.T) (LogService, *testutils.ImplementsAllTheThings) {
@@ -17,7 +23,7 @@ func setUpLogServiceTest(t *testing.T) (LogService, *testutils.ImplementsAllTheT
 			"running":  {DefinitionID: "B", RunID: "running", Status: state.StatusRunning},
 		},
 	}
-	ls, _ := NewLogService(&imp, &imp)
+	ls, _ := NewLogService(nil, &imp, &imp)
 	return ls, &imp
 }
 
@@ -75,3 +81,186 @@ func TestLogService_Logs(t *testing.T) {
 		}
 	}
 }
+
+func TestLogService_Follow(t *testing.T) {
+	ls, imp := setUpLogServiceTest(t)
+	ls.(*logService).followInterval = time.Millisecond
+	imp.LogLines = []string{"a\n"}
+
+	var chunks, cursors []string
+	err := ls.Follow(context.Background(), "running", nil, nil, nil, func(log string, lastSeen string) error {
+		chunks = append(chunks, log)
+		cursors = append(cursors, lastSeen)
+		// The run writes another line and stops
+		if len(chunks) == 1 {
+			imp.LogLines = append(imp.LogLines, "b\n")
+			run := imp.Runs["running"]
+			run.Status = state.StatusStopped
+			imp.Runs["running"] = run
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chunks, []string{"a\n", "b\n"}) || !reflect.DeepEqual(cursors, []string{"1", "2"}) {
+		t.Errorf("Expected each line once with its cursor, got %v %v", chunks, cursors)
+	}
+}
+
+func TestLogService_FollowCanceled(t *testing.T) {
+	ls, _ := setUpLogServiceTest(t)
+	ls.(*logService).followInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Queued runs are waited on until ctx is done
+	err := ls.Follow(ctx, "isQueued", nil, nil, nil, func(log string, lastSeen string) error {
+		t.Errorf("Expected no logs for a queued run")
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestLogService_FollowError(t *testing.T) {
+	ls, imp := setUpLogServiceTest(t)
+	ls.(*logService).followInterval = time.Millisecond
+	imp.LogsError = errors.New("logs unavailable")
+	emit := func(log string, lastSeen string) error {
+		t.Errorf("Expected no logs when the logs client fails")
+		return nil
+	}
+
+	// Running runs are polled again
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ls.Follow(ctx, "running", nil, nil, nil, emit); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	polls := 0
+	for _, call := range imp.Calls {
+		if call == "Logs" {
+			polls++
+		}
+	}
+	if polls < 2 {
+		t.Errorf("Expected the logs of a running run to be retried, got %d polls", polls)
+	}
+
+	// Stopped runs won't get any more logs
+	run := imp.Runs["running"]
+	run.Status = state.StatusStopped
+	imp.Runs["running"] = run
+	if err := ls.Follow(context.Background(), "running", nil, nil, nil, emit); err != imp.LogsError {
+		t.Errorf("Expected the logs client error, got %v", err)
+	}
+}
+
+func TestLogService_Search(t *testing.T) {
+	ls, imp := setUpLogServiceTest(t)
+	imp.LogLines = []string{"a\n", "b\n", "error 1\n", "c\n", "d\n", "e\n", "error 2\n", "f\n"}
+
+	var lines []LogSearchLine
+	summary, err := ls.Search(context.Background(), "running", "^error", 1, nil, nil, func(line LogSearchLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []LogSearchLine{
+		{Line: 2, Text: "b"},
+		{Line: 3, Text: "error 1", Match: true},
+		{Line: 4, Text: "c"},
+		{Line: 6, Text: "e"},
+		{Line: 7, Text: "error 2", Match: true},
+		{Line: 8, Text: "f"},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected matches with one line of context, got %v", lines)
+	}
+	if summary.Matches != 2 || summary.LinesScanned != 8 || summary.Truncated {
+		t.Errorf("Expected the whole log scanned with 2 matches, got %+v", summary)
+	}
+
+	// Scanning stops once the context of the last allowed match is sent
+	ls.(*logService).searchMaxMatches = 1
+	lines = nil
+	summary, err = ls.Search(context.Background(), "running", "^error", 1, nil, nil, func(line LogSearchLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || !summary.Truncated || summary.TruncatedReason != LogSearchMaxMatches {
+		t.Errorf("Expected the search to stop after the first match, got %v %+v", lines, summary)
+	}
+
+	ls.(*logService).searchMaxMatches = defaultLogSearchMaxMatches
+	ls.(*logService).searchMaxBytes = 4
+	summary, err = ls.Search(context.Background(), "running", "^error", 0, nil, nil, func(line LogSearchLine) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.LinesScanned != 2 || summary.TruncatedReason != LogSearchMaxBytes {
+		t.Errorf("Expected the search to stop after max bytes, got %+v", summary)
+	}
+}
+
+func TestLogService_SearchInvalid(t *testing.T) {
+	ls, _ := setUpLogServiceTest(t)
+	emit := func(line LogSearchLine) error { return nil }
+
+	if _, err := ls.Search(context.Background(), "running", "(", 0, nil, nil, emit); err == nil {
+		t.Errorf("Expected error for an invalid regular expression")
+	}
+	if _, err := ls.Search(context.Background(), "running", "a", MaxLogSearchContext+1, nil, nil, emit); err == nil {
+		t.Errorf("Expected error for too much context")
+	}
+
+	// Queued runs don't have logs to search
+	summary, err := ls.Search(context.Background(), "isQueued", "a", 0, nil, nil, emit)
+	if err != nil || summary.LinesScanned != 0 {
+		t.Errorf("Expected an empty search of a queued run, got %+v %v", summary, err)
+	}
+}
+
+func TestLogService_Exceptions(t *testing.T) {
+	ls, imp := setUpLogServiceTest(t)
+	imp.LogLines = []string{"Traceback (most recent call last):\n", "  File \"job.py\", line 1\n", "ValueError: bad\n"}
+
+	// Running runs may log more exceptions
+	runExceptions, err := ls.Exceptions("running")
+	if err != nil || runExceptions != nil {
+		t.Errorf("Expected no exceptions before the run stops, got %v %v", runExceptions, err)
+	}
+
+	run := imp.Runs["running"]
+	run.Status = state.StatusStopped
+	imp.Runs["running"] = run
+	runExceptions, err = ls.Exceptions("running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := state.RunExceptions{"Traceback (most recent call last):\n  File \"job.py\", line 1\nValueError: bad"}
+	if !reflect.DeepEqual(runExceptions, expected) {
+		t.Errorf("Expected the traceback, got %v", runExceptions)
+	}
+}
+
+func TestNewLogService_InvalidTailBytes(t *testing.T) {
+	confDir := "../conf"
+	for _, n := range []string{"0", "-1"} {
+		os.Setenv("LOGS_EXCEPTIONS_TAIL_BYTES", n)
+		c, _ := config.NewConfig(&confDir)
+		if _, err := NewLogService(c, nil, nil); err == nil {
+			t.Errorf("Expected logs.exceptions.tail_bytes of %s to be rejected", n)
+		}
+	}
+	os.Unsetenv("LOGS_EXCEPTIONS_TAIL_BYTES")
+}
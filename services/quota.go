@@ -0,0 +1,93 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// QuotaService defines an interface for managing the concurrency quotas of
+// groups, owners and definitions
+//
+type QuotaService interface {
+	Put(q *state.Quota, userInfo state.UserInfo) (state.Quota, error)
+	Get(scope string, name string) (state.QuotaStatus, error)
+	List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.QuotaList, error)
+	Delete(scope string, name string, userInfo state.UserInfo) error
+}
+
+type quotaService struct {
+	sm     state.Manager
+	logger flotillaLog.Logger
+}
+
+//
+// NewQuotaService configures and returns a QuotaService
+//
+func NewQuotaService(sm state.Manager, logger flotillaLog.Logger) (QuotaService, error) {
+	qs := quotaService{sm: sm, logger: logger}
+	return &qs, nil
+}
+
+//
+// Put validates and saves a quota, replacing the limits of an existing quota
+// with the same scope and name. Lowered limits only hold back runs that
+// haven't started; runs in flight are not stopped.
+//
+func (qs *quotaService) Put(q *state.Quota, userInfo state.UserInfo) (state.Quota, error) {
+	if valid, reasons := q.IsValid(); !valid {
+		return state.Quota{}, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+
+	var before interface{}
+	if existing, err := qs.sm.GetQuota(q.Scope, q.Name); err == nil {
+		before = existing
+	}
+
+	stored, err := qs.sm.PutQuota(*q)
+	if err != nil {
+		return stored, err
+	}
+	recordAudit(qs.sm, qs.logger, userInfo,
+		state.AuditActionQuotaPut, state.AuditTargetQuota, stored.Key(), before, stored)
+	return stored, nil
+}
+
+//
+// Get returns the quota specified by scope and name with its current usage
+//
+func (qs *quotaService) Get(scope string, name string) (state.QuotaStatus, error) {
+	q, err := qs.sm.GetQuota(scope, name)
+	if err != nil {
+		return state.QuotaStatus{}, err
+	}
+	usage, err := qs.sm.GetQuotaUsage(q)
+	if err != nil {
+		return state.QuotaStatus{}, err
+	}
+	return state.QuotaStatus{Quota: q, Usage: usage}, nil
+}
+
+// List lists quotas
+func (qs *quotaService) List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.QuotaList, error) {
+	return qs.sm.ListQuotas(limit, offset, sortBy, order, filters)
+}
+
+//
+// Delete deletes a quota; the submit worker releases the runs it held back
+//
+func (qs *quotaService) Delete(scope string, name string, userInfo state.UserInfo) error {
+	before, err := qs.sm.GetQuota(scope, name)
+	if err != nil {
+		return err
+	}
+	if err = qs.sm.DeleteQuota(scope, name); err != nil {
+		return err
+	}
+	recordAudit(qs.sm, qs.logger, userInfo,
+		state.AuditActionQuotaDelete, state.AuditTargetQuota, before.Key(), before, nil)
+	return nil
+}
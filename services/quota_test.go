@@ -0,0 +1,78 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpQuotaService(t *testing.T) (QuotaService, *testutils.ImplementsAllTheThings) {
+	cpu := int64(500)
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"running": {RunID: "running", GroupName: "g", Status: state.StatusRunning, Cpu: &cpu},
+			"stopped": {RunID: "stopped", GroupName: "g", Status: state.StatusStopped, Cpu: &cpu},
+			"other":   {RunID: "other", GroupName: "h", Status: state.StatusRunning, Cpu: &cpu},
+		},
+	}
+	qs, _ := NewQuotaService(&imp, &imp)
+	return qs, &imp
+}
+
+func TestQuotaService_Put(t *testing.T) {
+	qs, imp := setUpQuotaService(t)
+
+	maxRuns := int64(2)
+	if _, err := qs.Put(&state.Quota{Scope: state.QuotaScopeGroup, Name: "g", MaxRuns: &maxRuns}, state.UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.AuditEvents) != 1 || imp.AuditEvents[0].Action != state.AuditActionQuotaPut || imp.AuditEvents[0].TargetID != "group/g" {
+		t.Errorf("Expected the quota to be audited, got %v", imp.AuditEvents)
+	}
+
+	_, err := qs.Put(&state.Quota{Scope: "team", Name: "g", MaxRuns: &maxRuns}, state.UserInfo{})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected an invalid scope to produce MalformedInput but was %v", err)
+	}
+}
+
+func TestQuotaService_Get(t *testing.T) {
+	qs, _ := setUpQuotaService(t)
+
+	maxCpu := int64(1000)
+	if _, err := qs.Put(&state.Quota{Scope: state.QuotaScopeGroup, Name: "g", MaxCpu: &maxCpu}, state.UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := qs.Get(state.QuotaScopeGroup, "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Usage.Runs != 1 || status.Usage.Cpu != 500 {
+		t.Errorf("Expected the group's running run to be counted, got %+v", status.Usage)
+	}
+
+	if _, err = qs.Get(state.QuotaScopeGroup, "missing"); err == nil {
+		t.Errorf("Expected getting a missing quota to produce an error")
+	}
+}
+
+func TestQuotaService_Delete(t *testing.T) {
+	qs, imp := setUpQuotaService(t)
+
+	maxRuns := int64(2)
+	if _, err := qs.Put(&state.Quota{Scope: state.QuotaScopeOwner, Name: "somebody", MaxRuns: &maxRuns}, state.UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := qs.Delete(state.QuotaScopeOwner, "somebody", state.UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.Quotas) != 0 {
+		t.Errorf("Expected the quota to be deleted, got %v", imp.Quotas)
+	}
+	if err := qs.Delete(state.QuotaScopeOwner, "somebody", state.UserInfo{}); err == nil {
+		t.Errorf("Expected deleting a missing quota to produce an error")
+	}
+}
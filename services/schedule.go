@@ -0,0 +1,165 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// ScheduleService defines an interface for operations involving cron
+// schedules of definitions and templates
+//
+type ScheduleService interface {
+	Create(s *state.Schedule, userInfo state.UserInfo) (state.Schedule, error)
+	Get(scheduleID string) (state.Schedule, error)
+	List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.ScheduleList, error)
+	Update(scheduleID string, updates state.Schedule, userInfo state.UserInfo) (state.Schedule, error)
+	Delete(scheduleID string, userInfo state.UserInfo) error
+}
+
+type scheduleService struct {
+	sm     state.Manager
+	logger flotillaLog.Logger
+}
+
+//
+// NewScheduleService configures and returns a ScheduleService
+//
+func NewScheduleService(sm state.Manager, logger flotillaLog.Logger) (ScheduleService, error) {
+	ss := scheduleService{sm: sm, logger: logger}
+	return &ss, nil
+}
+
+//
+// Create validates and saves a new schedule
+// * Allocates new schedule id
+// * Checks the scheduled definition or template exists
+// * Computes the first fire time
+//
+func (ss *scheduleService) Create(s *state.Schedule, userInfo state.UserInfo) (state.Schedule, error) {
+	if len(s.TimeZone) == 0 {
+		s.TimeZone = "UTC"
+	}
+	if len(s.OverlapPolicy) == 0 {
+		s.OverlapPolicy = state.ScheduleOverlapAllow
+	}
+	if err := ss.validate(s); err != nil {
+		return state.Schedule{}, err
+	}
+
+	scheduleID, err := state.NewScheduleID()
+	if err != nil {
+		return state.Schedule{}, err
+	}
+	s.ScheduleID = scheduleID
+
+	next, err := s.NextAfter(time.Now())
+	if err != nil {
+		return state.Schedule{}, err
+	}
+	s.NextRunAt = &next
+
+	if err = ss.sm.CreateSchedule(*s); err != nil {
+		return *s, err
+	}
+	recordAudit(ss.sm, ss.logger, userInfo,
+		state.AuditActionScheduleCreate, state.AuditTargetSchedule, scheduleID, nil, *s)
+	return *s, nil
+}
+
+//
+// Get returns the schedule specified by scheduleID
+//
+func (ss *scheduleService) Get(scheduleID string) (state.Schedule, error) {
+	return ss.sm.GetSchedule(scheduleID)
+}
+
+// List lists schedules
+func (ss *scheduleService) List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.ScheduleList, error) {
+	return ss.sm.ListSchedules(limit, offset, sortBy, order, filters)
+}
+
+//
+// Update applies updates to the schedule specified by scheduleID. The next
+// fire time is recomputed when the cron expression or time zone change or the
+// schedule is re-enabled.
+//
+func (ss *scheduleService) Update(scheduleID string, updates state.Schedule, userInfo state.UserInfo) (state.Schedule, error) {
+	before, err := ss.sm.GetSchedule(scheduleID)
+	if err != nil {
+		return before, err
+	}
+
+	updated := before
+	updated.UpdateWith(updates)
+	if err = ss.validate(&updated); err != nil {
+		return before, err
+	}
+
+	if updated.CronExpression != before.CronExpression ||
+		updated.TimeZone != before.TimeZone ||
+		(updated.IsEnabled() && !before.IsEnabled()) ||
+		updated.NextRunAt == nil {
+		next, err := updated.NextAfter(time.Now())
+		if err != nil {
+			return before, err
+		}
+		updates.NextRunAt = &next
+	}
+
+	updated, err = ss.sm.UpdateSchedule(scheduleID, updates)
+	if err != nil {
+		return updated, err
+	}
+	recordAudit(ss.sm, ss.logger, userInfo,
+		state.AuditActionScheduleUpdate, state.AuditTargetSchedule, scheduleID, before, updated)
+	return updated, nil
+}
+
+//
+// Delete deletes the schedule specified by scheduleID; runs it created are
+// left untouched
+//
+func (ss *scheduleService) Delete(scheduleID string, userInfo state.UserInfo) error {
+	before, err := ss.sm.GetSchedule(scheduleID)
+	if err != nil {
+		return err
+	}
+	if err = ss.sm.DeleteSchedule(scheduleID); err != nil {
+		return err
+	}
+	recordAudit(ss.sm, ss.logger, userInfo,
+		state.AuditActionScheduleDelete, state.AuditTargetSchedule, scheduleID, before, nil)
+	return nil
+}
+
+func (ss *scheduleService) validate(s *state.Schedule) error {
+	if valid, reasons := s.IsValid(); !valid {
+		return exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+
+	req := s.ExecutionRequest()
+	if req.GetExecutionRequestCommon() == nil {
+		return exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("object [%s_request] must not be empty", executableTypeName(s.ExecutableType))}
+	}
+	if valid, reasons := req.GetExecutionRequestCommon().Labels.IsValid(); !valid {
+		return exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+
+	// Ensure the scheduled executable exists
+	_, err := ss.sm.GetExecutableByTypeAndID(s.ExecutableType, s.ExecutableID)
+	return err
+}
+
+func executableTypeName(t state.ExecutableType) string {
+	if t == state.ExecutableTypeTemplate {
+		return "template"
+	}
+	return "definition"
+}
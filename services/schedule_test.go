@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpScheduleService(t *testing.T) (ScheduleService, *testutils.ImplementsAllTheThings) {
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Definitions: map[string]state.Definition{
+			"A": {DefinitionID: "A", Alias: "aliasA"},
+		},
+	}
+	ss, _ := NewScheduleService(&imp, &imp)
+	return ss, &imp
+}
+
+func newDefinitionSchedule(cron string, definitionID string) *state.Schedule {
+	return &state.Schedule{
+		Name:           "nightly",
+		CronExpression: cron,
+		ExecutableType: state.ExecutableTypeDefinition,
+		ExecutableID:   definitionID,
+		DefinitionRequest: &state.DefinitionExecutionRequest{
+			ExecutionRequestCommon: &state.ExecutionRequestCommon{OwnerID: "somebody"},
+		},
+	}
+}
+
+func TestScheduleService_Create(t *testing.T) {
+	ss, imp := setUpScheduleService(t)
+
+	created, err := ss.Create(newDefinitionSchedule("0 2 * * *", "A"), state.UserInfo{Email: "somebody@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.ScheduleID) == 0 || created.NextRunAt == nil {
+		t.Errorf("Expected a schedule id and next run time to be set, got %v", created)
+	}
+	if created.TimeZone != "UTC" || created.OverlapPolicy != state.ScheduleOverlapAllow {
+		t.Errorf("Expected UTC and allow defaults, got %s and %s", created.TimeZone, created.OverlapPolicy)
+	}
+	if len(imp.AuditEvents) != 1 || imp.AuditEvents[0].Action != state.AuditActionScheduleCreate {
+		t.Errorf("Expected schedule creation to be audited, got %v", imp.AuditEvents)
+	}
+
+	_, err = ss.Create(newDefinitionSchedule("0 25 * * *", "A"), state.UserInfo{})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected an invalid cron expression to produce MalformedInput but was %v", err)
+	}
+
+	if _, err = ss.Create(newDefinitionSchedule("0 2 * * *", "Z"), state.UserInfo{}); err == nil {
+		t.Errorf("Expected scheduling a missing definition to produce an error")
+	}
+}
+
+func TestScheduleService_Update(t *testing.T) {
+	ss, _ := setUpScheduleService(t)
+
+	created, err := ss.Create(newDefinitionSchedule("0 2 * * *", "A"), state.UserInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := ss.Update(created.ScheduleID, state.Schedule{CronExpression: "30 * * * *"}, state.UserInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.NextRunAt.Minute() != 30 {
+		t.Errorf("Expected next run time to follow the new cron expression, was %v", updated.NextRunAt)
+	}
+
+	_, err = ss.Update(created.ScheduleID, state.Schedule{OverlapPolicy: "queue"}, state.UserInfo{})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected an invalid overlap policy to produce MalformedInput but was %v", err)
+	}
+}
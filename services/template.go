@@ -6,6 +6,7 @@ import (
 
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/state"
 )
 
@@ -15,21 +16,22 @@ type TemplateService interface {
 	GetLatestByName(templateName string) (bool, state.Template, error)
 	List(limit int, offset int, sortBy string, order string) (state.TemplateList, error)
 	ListLatestOnly(limit int, offset int, sortBy string, order string) (state.TemplateList, error)
-	Create(tpl *state.CreateTemplateRequest) (state.CreateTemplateResponse, error)
+	Create(tpl *state.CreateTemplateRequest, userInfo state.UserInfo) (state.CreateTemplateResponse, error)
 }
 
 type templateService struct {
-	sm state.Manager
+	sm     state.Manager
+	logger flotillaLog.Logger
 }
 
 // NewTemplateService configures and returns a TemplateService.
-func NewTemplateService(conf config.Config, sm state.Manager) (TemplateService, error) {
-	ts := templateService{sm: sm}
+func NewTemplateService(conf config.Config, sm state.Manager, logger flotillaLog.Logger) (TemplateService, error) {
+	ts := templateService{sm: sm, logger: logger}
 	return &ts, nil
 }
 
 // Create fully initialize and save the new template.
-func (ts *templateService) Create(req *state.CreateTemplateRequest) (state.CreateTemplateResponse, error) {
+func (ts *templateService) Create(req *state.CreateTemplateRequest, userInfo state.UserInfo) (state.CreateTemplateResponse, error) {
 	res := state.CreateTemplateResponse{
 		DidCreate: false,
 		Template:  state.Template{},
@@ -64,7 +66,7 @@ func (ts *templateService) Create(req *state.CreateTemplateRequest) (state.Creat
 		curr.Version = 1
 		res.Template = curr
 		res.DidCreate = true
-		return res, ts.sm.CreateTemplate(curr)
+		return res, ts.createAndRecord(curr, nil, userInfo)
 	}
 
 	// Check if prev and curr are diff, if they are, write curr to DB (increment)
@@ -73,13 +75,24 @@ func (ts *templateService) Create(req *state.CreateTemplateRequest) (state.Creat
 		curr.Version = prev.Version + 1
 		res.Template = curr
 		res.DidCreate = true
-		return res, ts.sm.CreateTemplate(curr)
+		return res, ts.createAndRecord(curr, prev, userInfo)
 	}
 
 	res.Template = prev
 	return res, nil
 }
 
+// createAndRecord saves a new template version and audits it; before is the
+// previous version of the template, if any.
+func (ts *templateService) createAndRecord(tpl state.Template, before interface{}, userInfo state.UserInfo) error {
+	if err := ts.sm.CreateTemplate(tpl); err != nil {
+		return err
+	}
+	recordAudit(ts.sm, ts.logger, userInfo,
+		state.AuditActionTemplateCreate, state.AuditTargetTemplate, tpl.TemplateID, before, tpl)
+	return nil
+}
+
 // Get returns the template specified by id.
 func (ts *templateService) GetByID(id string) (state.Template, error) {
 	return ts.sm.GetTemplateByID(id)
@@ -179,6 +192,10 @@ func (ts *templateService) diff(prev state.Template, curr state.Template) bool {
 		return true
 	}
 
+	if reflect.DeepEqual(prev.RetryPolicy, curr.RetryPolicy) == false {
+		return true
+	}
+
 	return false
 }
 
@@ -229,6 +246,9 @@ func (ts *templateService) constructTemplateFromCreateTemplateRequest(req *state
 	if req.Tags != nil {
 		tpl.Tags = req.Tags
 	}
+	if req.RetryPolicy != nil {
+		tpl.RetryPolicy = req.RetryPolicy
+	}
 	if req.Defaults != nil {
 		tpl.Defaults = req.Defaults
 	} else {
@@ -0,0 +1,451 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/clients/httpclient"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// Headers sent with every webhook delivery
+//
+const (
+	WebhookEventHeader     = "X-Flotilla-Event"
+	WebhookDeliveryHeader  = "X-Flotilla-Delivery"
+	WebhookSignatureHeader = "X-Flotilla-Signature"
+)
+
+var (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookRetryCount  = 2
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookBackoff     = 30 * time.Second
+)
+
+// privateWebhookNetworks are the private address ranges webhooks can't
+// deliver to
+var privateWebhookNetworks = parseWebhookNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+//
+// WebhookService defines an interface for managing webhook subscriptions and
+// delivering the run lifecycle events they subscribe to
+//
+type WebhookService interface {
+	Create(w *state.Webhook, userInfo state.UserInfo) (state.Webhook, error)
+	Get(webhookID string) (state.Webhook, error)
+	List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.WebhookList, error)
+	Delete(webhookID string, userInfo state.UserInfo) error
+	ListDeliveries(webhookID string, limit int, offset int) (state.WebhookDeliveryList, error)
+	Test(webhookID string) (state.WebhookDelivery, error)
+	Notify(run state.Run, previousStatus string) error
+	Deliver(d state.WebhookDelivery) (state.WebhookDelivery, error)
+}
+
+type webhookService struct {
+	sm          state.Manager
+	logger      flotillaLog.Logger
+	timeout     time.Duration
+	retryCount  int
+	maxAttempts int
+	backoff     time.Duration
+
+	allowedHosts map[string]bool
+	transport    http.RoundTripper
+	lookupIP     func(host string) ([]net.IP, error)
+}
+
+//
+// NewWebhookService configures and returns a WebhookService
+//
+func NewWebhookService(conf config.Config, sm state.Manager, logger flotillaLog.Logger) (WebhookService, error) {
+	ws := webhookService{
+		sm:          sm,
+		logger:      logger,
+		timeout:     defaultWebhookTimeout,
+		retryCount:  defaultWebhookRetryCount,
+		maxAttempts: defaultWebhookMaxAttempts,
+		backoff:     defaultWebhookBackoff,
+
+		allowedHosts: map[string]bool{},
+		transport:    newWebhookTransport(),
+		lookupIP:     net.LookupIP,
+	}
+	if conf == nil {
+		return &ws, nil
+	}
+
+	if conf.IsSet("webhook.timeout") {
+		timeout, err := time.ParseDuration(conf.GetString("webhook.timeout"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook.timeout: %v", err)
+		}
+		ws.timeout = timeout
+	}
+	if conf.IsSet("webhook.backoff") {
+		backoff, err := time.ParseDuration(conf.GetString("webhook.backoff"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook.backoff: %v", err)
+		}
+		ws.backoff = backoff
+	}
+	if conf.IsSet("webhook.retry_count") {
+		ws.retryCount = conf.GetInt("webhook.retry_count")
+	}
+	if conf.IsSet("webhook.max_attempts") {
+		ws.maxAttempts = conf.GetInt("webhook.max_attempts")
+	}
+	for _, host := range conf.GetStringSlice("webhook.allowed_hosts") {
+		ws.allowedHosts[strings.ToLower(host)] = true
+	}
+	return &ws, nil
+}
+
+//
+// Create validates and saves a new webhook
+// * Allocates new webhook id
+// * Generates a signing secret unless one is given
+// * Returns the secret; it isn't returned again
+//
+func (ws *webhookService) Create(w *state.Webhook, userInfo state.UserInfo) (state.Webhook, error) {
+	if valid, reasons := w.IsValid(); !valid {
+		return state.Webhook{}, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	u, _ := url.Parse(w.URL)
+	if err := ws.checkHost(u.Hostname()); err != nil {
+		return state.Webhook{}, err
+	}
+
+	webhookID, err := state.NewWebhookID()
+	if err != nil {
+		return state.Webhook{}, err
+	}
+	w.WebhookID = webhookID
+	if len(w.Secret) == 0 {
+		if w.Secret, err = newWebhookSecret(); err != nil {
+			return state.Webhook{}, err
+		}
+	}
+	w.CreatedBy = userInfo.Email
+
+	created, err := ws.sm.CreateWebhook(*w)
+	if err != nil {
+		return created, err
+	}
+	recordAudit(ws.sm, ws.logger, userInfo,
+		state.AuditActionWebhookCreate, state.AuditTargetWebhook, created.WebhookID, nil, redactWebhook(created))
+	return created, nil
+}
+
+//
+// Get returns the webhook specified by id without its secret
+//
+func (ws *webhookService) Get(webhookID string) (state.Webhook, error) {
+	w, err := ws.sm.GetWebhook(webhookID)
+	return redactWebhook(w), err
+}
+
+//
+// List lists webhooks without their secrets
+//
+func (ws *webhookService) List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.WebhookList, error) {
+	wl, err := ws.sm.ListWebhooks(limit, offset, sortBy, order, filters)
+	for i := range wl.Webhooks {
+		wl.Webhooks[i] = redactWebhook(wl.Webhooks[i])
+	}
+	return wl, err
+}
+
+//
+// Delete deletes a webhook; pending deliveries to it are dropped
+//
+func (ws *webhookService) Delete(webhookID string, userInfo state.UserInfo) error {
+	before, err := ws.sm.GetWebhook(webhookID)
+	if err != nil {
+		return err
+	}
+	if err = ws.sm.DeleteWebhook(webhookID); err != nil {
+		return err
+	}
+	recordAudit(ws.sm, ws.logger, userInfo,
+		state.AuditActionWebhookDelete, state.AuditTargetWebhook, webhookID, redactWebhook(before), nil)
+	return nil
+}
+
+//
+// ListDeliveries lists the deliveries of a webhook, newest first
+//
+func (ws *webhookService) ListDeliveries(webhookID string, limit int, offset int) (state.WebhookDeliveryList, error) {
+	if _, err := ws.sm.GetWebhook(webhookID); err != nil {
+		return state.WebhookDeliveryList{}, err
+	}
+	return ws.sm.ListWebhookDeliveries(webhookID, limit, offset)
+}
+
+//
+// Test sends a test event to a webhook right away and returns the delivery;
+// test deliveries aren't retried
+//
+func (ws *webhookService) Test(webhookID string) (state.WebhookDelivery, error) {
+	w, err := ws.sm.GetWebhook(webhookID)
+	if err != nil {
+		return state.WebhookDelivery{}, err
+	}
+	d, err := newWebhookDelivery(w, state.WebhookEventTest, nil)
+	if err != nil {
+		return d, err
+	}
+	// Sent below rather than by the webhook worker
+	d.NextAttemptAt = nil
+	if _, err = ws.sm.CreateWebhookDelivery(d); err != nil {
+		return d, err
+	}
+	return ws.Deliver(d)
+}
+
+//
+// Notify queues a delivery to every webhook subscribed to the event of run's
+// transition from previousStatus. Each webhook gets an event at most once per
+// transition of the run, so repeated or concurrent notifications of a
+// transition are dropped while a requeued run's later transitions aren't.
+//
+func (ws *webhookService) Notify(run state.Run, previousStatus string) error {
+	event, ok := state.WebhookEventFor(run)
+	if !ok || run.Status == previousStatus {
+		return nil
+	}
+
+	hooks, err := ws.sm.ListRunWebhooks(run)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	transitions, err := ws.sm.ListRunTransitions(run.RunID)
+	if err != nil {
+		return err
+	}
+	sequence := 0
+	for _, t := range transitions.Transitions {
+		if t.ToStatus == run.Status {
+			sequence++
+		}
+	}
+
+	for _, w := range hooks {
+		if !w.Subscribes(event) {
+			continue
+		}
+		d, err := newWebhookDelivery(w, event, &run)
+		if err != nil {
+			return err
+		}
+		d.Sequence = sequence
+		if _, err = ws.sm.CreateWebhookDelivery(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// Deliver attempts a delivery and records the outcome. The request itself
+// is retried on server errors; a failed attempt is rescheduled with
+// exponential backoff until webhook.max_attempts is reached.
+//
+func (ws *webhookService) Deliver(d state.WebhookDelivery) (state.WebhookDelivery, error) {
+	w, err := ws.sm.GetWebhook(d.WebhookID)
+	if err == nil {
+		err = ws.send(w, d)
+	}
+
+	now := time.Now()
+	d.Attempts++
+	d.NextAttemptAt = nil
+	if err == nil {
+		d.Status = state.WebhookDeliveryDelivered
+		d.DeliveredAt = &now
+		d.LastError = nil
+	} else {
+		msg := err.Error()
+		d.LastError = &msg
+		d.Status = state.WebhookDeliveryFailed
+		if _, missing := err.(exceptions.MissingResource); !missing &&
+			d.Event != state.WebhookEventTest && d.Attempts < ws.maxAttempts {
+			next := now.Add(ws.backoff * time.Duration(1<<uint(d.Attempts-1)))
+			d.Status = state.WebhookDeliveryPending
+			d.NextAttemptAt = &next
+		}
+	}
+	return d, ws.sm.UpdateWebhookDelivery(d)
+}
+
+func (ws *webhookService) send(w state.Webhook, d state.WebhookDelivery) error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return err
+	}
+	client := httpclient.Client{
+		Host:       fmt.Sprintf("%s://%s", u.Scheme, u.Host),
+		Timeout:    ws.timeout,
+		RetryCount: ws.retryCount,
+	}
+	if !ws.allowedHosts[strings.ToLower(u.Hostname())] {
+		client.Transport = ws.transport
+	}
+	headers := map[string]string{
+		"Content-Type":         "application/json",
+		WebhookEventHeader:     d.Event,
+		WebhookDeliveryHeader:  d.DeliveryID,
+		WebhookSignatureHeader: SignWebhookPayload(w.Secret, d.Payload),
+	}
+	return client.Post(u.RequestURI(), headers, d.Payload, nil)
+}
+
+//
+// checkHost rejects webhook hosts that resolve to internal addresses unless
+// they're in webhook.allowed_hosts. Hosts that don't resolve are left to the
+// same check made when deliveries connect, which also catches hosts whose
+// addresses change after the webhook is created.
+//
+func (ws *webhookService) checkHost(host string) error {
+	if ws.allowedHosts[strings.ToLower(host)] {
+		return nil
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = ws.lookupIP(host); err != nil {
+			return nil
+		}
+	}
+	for _, ip := range ips {
+		if internalWebhookIP(ip) {
+			return exceptions.MalformedInput{ErrorString: fmt.Sprintf(
+				"string [url] must not point to an internal address, %s resolves to %s", host, ip)}
+		}
+	}
+	return nil
+}
+
+//
+// newWebhookTransport returns a transport that refuses to connect to
+// internal addresses. The check is made on the address being dialed, after
+// the host has been resolved.
+//
+func newWebhookTransport() http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalWebhookIP(ip) {
+				return fmt.Errorf("webhook address %s is internal", host)
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+//
+// internalWebhookIP returns whether ip is a loopback, link-local, private or
+// unspecified address
+//
+func internalWebhookIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateWebhookNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseWebhookNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = n
+	}
+	return networks
+}
+
+//
+// SignWebhookPayload returns the signature sent in the X-Flotilla-Signature
+// header: the hex HMAC-SHA256 of the payload keyed with the webhook's
+// secret, prefixed with "sha256="
+//
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookDelivery(w state.Webhook, event string, run *state.Run) (state.WebhookDelivery, error) {
+	deliveryID, err := state.NewWebhookDeliveryID()
+	if err != nil {
+		return state.WebhookDelivery{}, err
+	}
+	now := time.Now()
+	payload := state.WebhookPayload{
+		DeliveryID: deliveryID,
+		WebhookID:  w.WebhookID,
+		Event:      event,
+		Timestamp:  now,
+	}
+	d := state.WebhookDelivery{
+		DeliveryID:    deliveryID,
+		WebhookID:     w.WebhookID,
+		Event:         event,
+		Status:        state.WebhookDeliveryPending,
+		NextAttemptAt: &now,
+	}
+	if run != nil {
+		payload.Run = state.NewWebhookRun(*run)
+		d.RunID = run.RunID
+	}
+	if d.Payload, err = json.Marshal(payload); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func redactWebhook(w state.Webhook) state.Webhook {
+	w.Secret = ""
+	return w
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpWebhookService(t *testing.T) (WebhookService, *testutils.ImplementsAllTheThings) {
+	imp := testutils.ImplementsAllTheThings{T: t}
+	ws, _ := NewWebhookService(nil, &imp, &imp)
+	return ws, &imp
+}
+
+func TestWebhookService_Create(t *testing.T) {
+	ws, imp := setUpWebhookService(t)
+
+	created, err := ws.Create(&state.Webhook{Scope: state.WebhookScopeGroup, Name: "g", URL: "https://example.com/hook"}, state.UserInfo{Email: "a@b.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.WebhookID) == 0 || len(created.Secret) == 0 {
+		t.Errorf("Expected the webhook to get an id and a secret, got %+v", created)
+	}
+	if len(imp.AuditEvents) != 1 || imp.AuditEvents[0].Action != state.AuditActionWebhookCreate {
+		t.Errorf("Expected the webhook to be audited, got %v", imp.AuditEvents)
+	}
+
+	fetched, err := ws.Get(created.WebhookID)
+	if err != nil || len(fetched.Secret) != 0 {
+		t.Errorf("Expected the webhook without its secret, got %+v, %v", fetched, err)
+	}
+	wl, _ := ws.List(10, 0, "created_at", "asc", nil)
+	if wl.Total != 1 || len(wl.Webhooks[0].Secret) != 0 {
+		t.Errorf("Expected the listed webhook without its secret, got %+v", wl)
+	}
+
+	_, err = ws.Create(&state.Webhook{Scope: "team", Name: "g", URL: "https://example.com/hook"}, state.UserInfo{})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected an invalid scope to produce MalformedInput but was %v", err)
+	}
+}
+
+func TestWebhookService_CreateInternalHost(t *testing.T) {
+	ws, _ := setUpWebhookService(t)
+	ws.(*webhookService).lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "localhost":
+			return []net.IP{net.ParseIP("127.0.0.1")}, nil
+		case "internal.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("192.168.1.10")}, nil
+		case "public.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	for _, u := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.1.2.3/hook",
+		"http://172.20.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"https://internal.example.com/hook",
+	} {
+		_, err := ws.Create(&state.Webhook{Scope: state.WebhookScopeGroup, Name: "g", URL: u}, state.UserInfo{})
+		if _, ok := err.(exceptions.MalformedInput); !ok {
+			t.Errorf("Expected a webhook to %s to produce MalformedInput but was %v", u, err)
+		}
+	}
+
+	// Hosts that don't resolve yet are checked when deliveries connect
+	for _, u := range []string{"https://public.example.com/hook", "https://unknown.example.com/hook"} {
+		if _, err := ws.Create(&state.Webhook{Scope: state.WebhookScopeGroup, Name: "g", URL: u}, state.UserInfo{}); err != nil {
+			t.Errorf("Expected a webhook to %s to be created, got %v", u, err)
+		}
+	}
+
+	ws.(*webhookService).allowedHosts["localhost"] = true
+	if _, err := ws.Create(&state.Webhook{Scope: state.WebhookScopeGroup, Name: "g", URL: "http://localhost:8080/hook"}, state.UserInfo{}); err != nil {
+		t.Errorf("Expected an allowed host to be created, got %v", err)
+	}
+}
+
+func TestWebhookService_DeliverInternalAddress(t *testing.T) {
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// As if the webhook's host had resolved to a public address when it was
+	// created and to the server's since
+	ws, imp := setUpWebhookService(t)
+	imp.Webhooks = map[string]state.Webhook{
+		"wh-1": {WebhookID: "wh-1", Scope: state.WebhookScopeRun, Name: "r", URL: server.URL, Secret: "s"},
+	}
+	d, err := ws.Test("wh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != state.WebhookDeliveryFailed || d.LastError == nil || !strings.Contains(*d.LastError, "internal") || received != 0 {
+		t.Errorf("Expected the delivery to an internal address to be refused, got %+v with %d requests", d, received)
+	}
+}
+
+func TestWebhookService_NotifyAndDeliver(t *testing.T) {
+	var received []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ws, imp := setUpWebhookService(t)
+	// httptest servers listen on loopback
+	ws.(*webhookService).allowedHosts["127.0.0.1"] = true
+	hook, err := ws.Create(&state.Webhook{
+		Scope: state.WebhookScopeDefinition, Name: "def", URL: server.URL + "/hook?team=a",
+		Events: state.WebhookEventList{state.WebhookEventFailed}}, state.UserInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode := int64(1)
+	run := state.Run{RunID: "r", DefinitionID: "def", Status: state.StatusRunning}
+	imp.Runs = map[string]state.Run{"r": run}
+	if err = ws.Notify(run, state.StatusPending); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.WebhookDeliveries) != 0 {
+		t.Errorf("Expected no delivery of an event the webhook doesn't subscribe to")
+	}
+
+	run.Status, run.ExitCode = state.StatusStopped, &exitCode
+	for i := 0; i < 2; i++ {
+		if err = ws.Notify(run, state.StatusRunning); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(imp.WebhookDeliveries) != 1 {
+		t.Fatalf("Expected 1 delivery of the failure, got %v", imp.WebhookDeliveries)
+	}
+
+	for _, d := range imp.WebhookDeliveries {
+		delivered, err := ws.Deliver(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivered.Status != state.WebhookDeliveryDelivered || delivered.Attempts != 1 {
+			t.Errorf("Expected the delivery to be delivered, got %+v", delivered)
+		}
+	}
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(received))
+	}
+	r := received[0]
+	if r.URL.Path != "/hook" || r.URL.Query().Get("team") != "a" {
+		t.Errorf("Expected the webhook's url to be requested, got %s", r.URL)
+	}
+	if r.Header.Get(WebhookEventHeader) != state.WebhookEventFailed {
+		t.Errorf("Expected the event header to be %s, got %s", state.WebhookEventFailed, r.Header.Get(WebhookEventHeader))
+	}
+	if r.Header.Get(WebhookSignatureHeader) != SignWebhookPayload(hook.Secret, bodies[0]) {
+		t.Errorf("Expected the payload to be signed with the webhook's secret")
+	}
+}
+
+func TestWebhookService_NotifyRequeued(t *testing.T) {
+	ws, imp := setUpWebhookService(t)
+	if _, err := ws.Create(&state.Webhook{Scope: state.WebhookScopeRun, Name: "r", URL: "https://example.com/hook"}, state.UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+
+	run := state.Run{RunID: "r", Status: state.StatusRunning}
+	imp.Runs = map[string]state.Run{"r": run}
+	imp.Transitions = map[string][]state.RunStatusTransition{
+		"r": {{RunID: "r", FromStatus: state.StatusQueued, ToStatus: state.StatusRunning}},
+	}
+	notify := func(previousStatus string) {
+		if err := ws.Notify(run, previousStatus); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notify(state.StatusQueued)
+	notify(state.StatusQueued)
+	if len(imp.WebhookDeliveries) != 1 {
+		t.Fatalf("Expected repeated notifications of a transition to be dropped, got %v", imp.WebhookDeliveries)
+	}
+
+	imp.Transitions["r"] = append(imp.Transitions["r"],
+		state.RunStatusTransition{RunID: "r", FromStatus: state.StatusRunning, ToStatus: state.StatusNeedsRetry},
+		state.RunStatusTransition{RunID: "r", FromStatus: state.StatusNeedsRetry, ToStatus: state.StatusQueued},
+		state.RunStatusTransition{RunID: "r", FromStatus: state.StatusQueued, ToStatus: state.StatusRunning})
+	notify(state.StatusQueued)
+	if len(imp.WebhookDeliveries) != 2 {
+		t.Errorf("Expected the requeued run to be running again, got %v", imp.WebhookDeliveries)
+	}
+}
+
+func TestWebhookService_DeliverFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	ws, imp := setUpWebhookService(t)
+	ws.(*webhookService).allowedHosts["127.0.0.1"] = true
+	hook, err := ws.Create(&state.Webhook{Scope: state.WebhookScopeRun, Name: "r", URL: server.URL}, state.UserInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp.Runs = map[string]state.Run{"r": {RunID: "r", Status: state.StatusNeedsRetry}}
+	if err = ws.Notify(imp.Runs["r"], state.StatusRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	var d state.WebhookDelivery
+	for _, queued := range imp.WebhookDeliveries {
+		d = queued
+	}
+	for attempt := 1; attempt <= defaultWebhookMaxAttempts; attempt++ {
+		if d, err = ws.Deliver(d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Attempts != attempt || d.LastError == nil {
+			t.Errorf("Expected attempt %d to fail, got %+v", attempt, d)
+		}
+		if attempt < defaultWebhookMaxAttempts && (d.Status != state.WebhookDeliveryPending || d.NextAttemptAt == nil) {
+			t.Errorf("Expected attempt %d to be retried, got %+v", attempt, d)
+		}
+	}
+	if d.Status != state.WebhookDeliveryFailed || d.NextAttemptAt != nil {
+		t.Errorf("Expected the delivery to fail after %d attempts, got %+v", defaultWebhookMaxAttempts, d)
+	}
+
+	test, err := ws.Test(hook.WebhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Event != state.WebhookEventTest || test.Status != state.WebhookDeliveryFailed {
+		t.Errorf("Expected the failed test delivery not to be retried, got %+v", test)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/state"
 )
 
@@ -13,19 +14,20 @@ import (
 type WorkerService interface {
 	List(engine string) (state.WorkersList, error)
 	Get(workerType string, engine string) (state.Worker, error)
-	Update(workerType string, updates state.Worker) (state.Worker, error)
-	BatchUpdate(updates []state.Worker) (state.WorkersList, error)
+	Update(workerType string, updates state.Worker, userInfo state.UserInfo) (state.Worker, error)
+	BatchUpdate(updates []state.Worker, userInfo state.UserInfo) (state.WorkersList, error)
 }
 
 type workerService struct {
-	sm state.Manager
+	sm     state.Manager
+	logger flotillaLog.Logger
 }
 
 //
 // NewWorkerService configures and returns a WorkerService
 //
-func NewWorkerService(conf config.Config, sm state.Manager) (WorkerService, error) {
-	ws := workerService{sm: sm}
+func NewWorkerService(conf config.Config, sm state.Manager, logger flotillaLog.Logger) (WorkerService, error) {
+	ws := workerService{sm: sm, logger: logger}
 	return &ws, nil
 }
 
@@ -41,23 +43,53 @@ func (ws *workerService) Get(workerType string, engine string) (state.Worker, er
 	return ws.sm.GetWorker(workerType, engine)
 }
 
-func (ws *workerService) Update(workerType string, updates state.Worker) (state.Worker, error) {
+func (ws *workerService) Update(workerType string, updates state.Worker, userInfo state.UserInfo) (state.Worker, error) {
 	var w state.Worker
 	if err := ws.validate(workerType); err != nil {
 		return w, err
 	}
 
-	return ws.sm.UpdateWorker(workerType, updates)
+	before, err := ws.sm.GetWorker(workerType, state.DefaultEngine)
+	if err != nil {
+		return w, err
+	}
+	if w, err = ws.sm.UpdateWorker(workerType, updates); err != nil {
+		return w, err
+	}
+	recordAudit(ws.sm, ws.logger, userInfo,
+		state.AuditActionWorkerUpdate, state.AuditTargetWorker, workerType, before, w)
+	return w, nil
 }
 
-func (ws *workerService) BatchUpdate(updates []state.Worker) (state.WorkersList, error) {
+func (ws *workerService) BatchUpdate(updates []state.Worker, userInfo state.UserInfo) (state.WorkersList, error) {
 	var wl state.WorkersList
 	for _, update := range updates {
 		if err := ws.validate(update.WorkerType); err != nil {
 			return wl, err
 		}
 	}
-	return ws.sm.BatchUpdateWorkers(updates)
+
+	before, err := ws.sm.ListWorkers(state.DefaultEngine)
+	if err != nil {
+		return wl, err
+	}
+	if wl, err = ws.sm.BatchUpdateWorkers(updates); err != nil {
+		return wl, err
+	}
+
+	// One event per worker type whose count changed
+	previous := make(map[string]state.Worker)
+	for _, w := range before.Workers {
+		previous[w.WorkerType] = w
+	}
+	for _, w := range wl.Workers {
+		if p, ok := previous[w.WorkerType]; ok && p == w {
+			continue
+		}
+		recordAudit(ws.sm, ws.logger, userInfo,
+			state.AuditActionWorkerUpdate, state.AuditTargetWorker, w.WorkerType, previous[w.WorkerType], w)
+	}
+	return wl, nil
 }
 
 func (ws *workerService) validate(workerType string) error {
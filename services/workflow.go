@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// WorkflowService defines an interface for operations involving DAG
+// workflows of definition and template runs
+//
+type WorkflowService interface {
+	Create(w *state.Workflow, userInfo state.UserInfo) (state.Workflow, error)
+	Get(workflowID string) (state.Workflow, error)
+	List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.WorkflowList, error)
+	Cancel(workflowID string, userInfo state.UserInfo) (state.Workflow, error)
+}
+
+type workflowService struct {
+	sm     state.Manager
+	logger flotillaLog.Logger
+}
+
+//
+// NewWorkflowService configures and returns a WorkflowService
+//
+func NewWorkflowService(sm state.Manager, logger flotillaLog.Logger) (WorkflowService, error) {
+	ws := workflowService{sm: sm, logger: logger}
+	return &ws, nil
+}
+
+//
+// Create validates and saves a new workflow; the workflow worker starts its
+// nodes
+// * Allocates new workflow id
+// * Defaults dependency conditions to on_success
+// * Checks every node's definition or template exists
+//
+func (ws *workflowService) Create(w *state.Workflow, userInfo state.UserInfo) (state.Workflow, error) {
+	for i := range w.Nodes {
+		n := &w.Nodes[i]
+		for j := range n.DependsOn {
+			if len(n.DependsOn[j].Condition) == 0 {
+				n.DependsOn[j].Condition = state.WorkflowConditionOnSuccess
+			}
+		}
+		n.Status = state.WorkflowNodePending
+		n.RunID = nil
+		n.Message = nil
+	}
+	if err := ws.validate(w); err != nil {
+		return state.Workflow{}, err
+	}
+
+	workflowID, err := state.NewWorkflowID()
+	if err != nil {
+		return state.Workflow{}, err
+	}
+	w.WorkflowID = workflowID
+	w.Status = state.WorkflowStatusRunning
+	w.CancelRequested = false
+	w.FinishedAt = nil
+
+	if err = ws.sm.CreateWorkflow(*w); err != nil {
+		return *w, err
+	}
+	recordAudit(ws.sm, ws.logger, userInfo,
+		state.AuditActionWorkflowCreate, state.AuditTargetWorkflow, workflowID, nil, *w)
+	return *w, nil
+}
+
+//
+// Get returns the workflow specified by workflowID
+//
+func (ws *workflowService) Get(workflowID string) (state.Workflow, error) {
+	return ws.sm.GetWorkflow(workflowID)
+}
+
+// List lists workflows
+func (ws *workflowService) List(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.WorkflowList, error) {
+	return ws.sm.ListWorkflows(limit, offset, sortBy, order, filters)
+}
+
+//
+// Cancel requests cancellation of a running workflow. The workflow worker
+// terminates the runs of its running nodes and cancels the nodes that
+// haven't started; cancelling a finished workflow has no effect.
+//
+func (ws *workflowService) Cancel(workflowID string, userInfo state.UserInfo) (state.Workflow, error) {
+	before, err := ws.sm.GetWorkflow(workflowID)
+	if err != nil {
+		return before, err
+	}
+	if before.IsFinished() {
+		return before, nil
+	}
+
+	updated, err := ws.sm.RequestWorkflowCancel(workflowID)
+	if err != nil {
+		return updated, err
+	}
+	recordAudit(ws.sm, ws.logger, userInfo,
+		state.AuditActionWorkflowCancel, state.AuditTargetWorkflow, workflowID, before, updated)
+	return updated, nil
+}
+
+func (ws *workflowService) validate(w *state.Workflow) error {
+	if valid, reasons := w.IsValid(); !valid {
+		return exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+
+	for _, n := range w.Nodes {
+		req := n.ExecutionRequest()
+		if req.GetExecutionRequestCommon() == nil {
+			return exceptions.MalformedInput{ErrorString: fmt.Sprintf(
+				"object [%s_request] of node [%s] must not be empty", executableTypeName(n.ExecutableType), n.Name)}
+		}
+		if valid, reasons := req.GetExecutionRequestCommon().Labels.IsValid(); !valid {
+			return exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+		}
+
+		// Ensure the node's executable exists
+		if _, err := ws.sm.GetExecutableByTypeAndID(n.ExecutableType, n.ExecutableID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpWorkflowService(t *testing.T) (WorkflowService, *testutils.ImplementsAllTheThings) {
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Definitions: map[string]state.Definition{
+			"A": {DefinitionID: "A", Alias: "aliasA"},
+		},
+	}
+	ws, _ := NewWorkflowService(&imp, &imp)
+	return ws, &imp
+}
+
+func newWorkflowNode(name string, definitionID string, deps ...state.WorkflowDependency) state.WorkflowNode {
+	return state.WorkflowNode{
+		Name:           name,
+		ExecutableType: state.ExecutableTypeDefinition,
+		ExecutableID:   definitionID,
+		DefinitionRequest: &state.DefinitionExecutionRequest{
+			ExecutionRequestCommon: &state.ExecutionRequestCommon{OwnerID: "somebody"},
+		},
+		DependsOn: deps,
+	}
+}
+
+func TestWorkflowService_Create(t *testing.T) {
+	ws, imp := setUpWorkflowService(t)
+
+	created, err := ws.Create(&state.Workflow{Name: "etl", Nodes: state.WorkflowNodes{
+		newWorkflowNode("extract", "A"),
+		newWorkflowNode("load", "A", state.WorkflowDependency{Node: "extract"}),
+	}}, state.UserInfo{Email: "somebody@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.WorkflowID) == 0 || created.Status != state.WorkflowStatusRunning {
+		t.Errorf("Expected a running workflow with an id, got %v", created)
+	}
+	for _, n := range created.Nodes {
+		if n.Status != state.WorkflowNodePending {
+			t.Errorf("Expected node %s to be pending, was %s", n.Name, n.Status)
+		}
+	}
+	if created.Nodes[1].DependsOn[0].Condition != state.WorkflowConditionOnSuccess {
+		t.Errorf("Expected conditions to default to on_success, was %s", created.Nodes[1].DependsOn[0].Condition)
+	}
+	if len(imp.AuditEvents) != 1 || imp.AuditEvents[0].Action != state.AuditActionWorkflowCreate {
+		t.Errorf("Expected workflow creation to be audited, got %v", imp.AuditEvents)
+	}
+
+	_, err = ws.Create(&state.Workflow{Nodes: state.WorkflowNodes{
+		newWorkflowNode("a", "A", state.WorkflowDependency{Node: "b"}),
+		newWorkflowNode("b", "A", state.WorkflowDependency{Node: "a"}),
+	}}, state.UserInfo{})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected a cycle to produce MalformedInput but was %v", err)
+	}
+
+	if _, err = ws.Create(&state.Workflow{Nodes: state.WorkflowNodes{newWorkflowNode("a", "Z")}}, state.UserInfo{}); err == nil {
+		t.Errorf("Expected a node running a missing definition to produce an error")
+	}
+}
+
+func TestWorkflowService_Cancel(t *testing.T) {
+	ws, imp := setUpWorkflowService(t)
+	imp.Workflows = map[string]state.Workflow{
+		"wf-a": {WorkflowID: "wf-a", Status: state.WorkflowStatusRunning},
+		"wf-b": {WorkflowID: "wf-b", Status: state.WorkflowStatusSucceeded},
+	}
+
+	cancelled, err := ws.Cancel("wf-a", state.UserInfo{Name: "somebody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled.CancelRequested {
+		t.Errorf("Expected cancellation of wf-a to be requested")
+	}
+	if len(imp.AuditEvents) != 1 || imp.AuditEvents[0].Action != state.AuditActionWorkflowCancel {
+		t.Errorf("Expected workflow cancellation to be audited, got %v", imp.AuditEvents)
+	}
+
+	finished, err := ws.Cancel("wf-b", state.UserInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.CancelRequested || len(imp.AuditEvents) != 1 {
+		t.Errorf("Expected cancelling a finished workflow to have no effect")
+	}
+
+	if _, err = ws.Cancel("wf-missing", state.UserInfo{}); err == nil {
+		t.Errorf("Expected cancelling a missing workflow to produce an error")
+	}
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	"fmt"
+)
+
+// ArrayTaskType is the task type of the parent run of an array; parent runs
+// are never executed, their status aggregates the status of their children
+var ArrayTaskType = "array"
+
+// MaxArraySize bounds the number of child runs of an array
+const MaxArraySize = 1000
+
+//
+// IsArray returns whether the request fans out into an array of runs
+//
+func (f *ExecutionRequestCommon) IsArray() bool {
+	return f.ArraySize != nil || len(f.ArrayParameters) > 0
+}
+
+//
+// ArrayLength returns the number of child runs an array request creates
+//
+func (f *ExecutionRequestCommon) ArrayLength() int {
+	if f.ArraySize != nil {
+		return int(*f.ArraySize)
+	}
+	return len(f.ArrayParameters)
+}
+
+//
+// ValidateArray returns the reasons the array fields of the request are
+// invalid, if any
+//
+func (f *ExecutionRequestCommon) ValidateArray() []string {
+	var reasons []string
+	if !f.IsArray() {
+		if f.MaxParallelism != nil {
+			reasons = append(reasons, "int [max_parallelism] requires [array_size] or [array_parameters]")
+		}
+		return reasons
+	}
+
+	if f.ArraySize != nil && (*f.ArraySize < 1 || *f.ArraySize > MaxArraySize) {
+		reasons = append(reasons, fmt.Sprintf("int [array_size] must be between 1 and %d", MaxArraySize))
+	}
+	if len(f.ArrayParameters) > MaxArraySize {
+		reasons = append(reasons, fmt.Sprintf("array [array_parameters] must not have more than %d entries", MaxArraySize))
+	}
+	if f.ArraySize != nil && len(f.ArrayParameters) > 0 && int(*f.ArraySize) != len(f.ArrayParameters) {
+		reasons = append(reasons, "int [array_size] must match the length of [array_parameters]")
+	}
+	if f.MaxParallelism != nil && *f.MaxParallelism < 1 {
+		reasons = append(reasons, "int [max_parallelism] must be at least 1")
+	}
+	if f.Engine != nil && *f.Engine != EKSEngine {
+		reasons = append(reasons, fmt.Sprintf("array runs are only supported by the %s engine", EKSEngine))
+	}
+	return reasons
+}
+
+//
+// ArraySummary counts the children of an array run by progress. Only the
+// latest attempt of a retried child is counted; a child waiting on its retry
+// policy counts as queued.
+//
+type ArraySummary struct {
+	RunID          string `json:"run_id"`
+	Status         string `json:"status"`
+	ArraySize      int64  `json:"array_size"`
+	MaxParallelism *int64 `json:"max_parallelism,omitempty"`
+	Held           int64  `json:"held"`
+	Queued         int64  `json:"queued"`
+	Running        int64  `json:"running"`
+	Succeeded      int64  `json:"succeeded"`
+	Failed         int64  `json:"failed"`
+}
+
+//
+// SummarizeArray counts the children of parent; children holds every
+// attempt of every child
+//
+func SummarizeArray(parent Run, children []Run) ArraySummary {
+	summary := ArraySummary{
+		RunID:          parent.RunID,
+		Status:         parent.Status,
+		MaxParallelism: parent.MaxParallelism,
+	}
+	if parent.ArraySize != nil {
+		summary.ArraySize = *parent.ArraySize
+	}
+	for _, c := range children {
+		switch {
+		case c.RetryRunID != nil:
+			// Superseded by a later attempt
+		case IsHeldArrayRun(c):
+			summary.Held++
+		case c.Status == StatusRunning:
+			summary.Running++
+		case c.Status != StatusStopped || IsAwaitingRetry(c):
+			summary.Queued++
+		case c.ExitCode != nil && *c.ExitCode == 0:
+			summary.Succeeded++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+//
+// IsHeldArrayRun returns whether run is a child of an array that is waiting
+// for the array's max parallelism to allow it to be queued
+//
+func IsHeldArrayRun(run Run) bool {
+	return run.ArrayParentID != nil && run.RetryAttempt == 0 &&
+		run.Status == StatusQueued && run.QueuedAt == nil
+}
+
+//
+// IsFinished returns whether every child of the array has finished
+//
+func (s ArraySummary) IsFinished() bool {
+	return s.Succeeded+s.Failed >= s.ArraySize
+}
+
+//
+// IsStarted returns whether any child of the array has left the queue
+//
+func (s ArraySummary) IsStarted() bool {
+	return s.Running+s.Succeeded+s.Failed > 0
+}
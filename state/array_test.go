@@ -0,0 +1,63 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExecutionRequestCommon_ValidateArray(t *testing.T) {
+	size, zero := int64(2), int64(0)
+	spark := EKSSparkEngine
+	cases := []struct {
+		fields  ExecutionRequestCommon
+		invalid bool
+	}{
+		{ExecutionRequestCommon{}, false},
+		{ExecutionRequestCommon{ArraySize: &size}, false},
+		{ExecutionRequestCommon{ArrayParameters: []EnvList{{}, {}}, MaxParallelism: &size}, false},
+		{ExecutionRequestCommon{ArraySize: &zero}, true},
+		{ExecutionRequestCommon{ArraySize: &size, ArrayParameters: []EnvList{{}}}, true},
+		{ExecutionRequestCommon{ArraySize: &size, MaxParallelism: &zero}, true},
+		{ExecutionRequestCommon{MaxParallelism: &size}, true},
+		{ExecutionRequestCommon{ArraySize: &size, Engine: &spark}, true},
+	}
+	for i, c := range cases {
+		if reasons := c.fields.ValidateArray(); (len(reasons) > 0) != c.invalid {
+			t.Errorf("Case %d: expected invalid to be %v, got %v", i, c.invalid, reasons)
+		}
+	}
+}
+
+func TestSummarizeArray(t *testing.T) {
+	size := int64(5)
+	parentID := "parent"
+	now := time.Now()
+	succeeded, failed := int64(0), int64(1)
+	retryRunID := "retried"
+	child := func(status string, queuedAt *time.Time, exitCode *int64) Run {
+		return Run{ArrayParentID: &parentID, Status: status, QueuedAt: queuedAt, ExitCode: exitCode}
+	}
+
+	retried := child(StatusStopped, &now, &failed)
+	retried.RetryRunID = &retryRunID
+	waiting := child(StatusStopped, &now, &failed)
+	waiting.RetryState = RetryStateScheduled
+	attempt := child(StatusStopped, &now, &succeeded)
+	attempt.RetryAttempt = 1
+
+	summary := SummarizeArray(Run{RunID: parentID, ArraySize: &size}, []Run{
+		child(StatusQueued, nil, nil),
+		child(StatusRunning, &now, nil),
+		waiting,
+		retried,
+		attempt,
+		child(StatusStopped, &now, &failed),
+	})
+	expected := ArraySummary{RunID: parentID, ArraySize: 5, Held: 1, Queued: 1, Running: 1, Succeeded: 1, Failed: 1}
+	if summary != expected {
+		t.Errorf("Expected %+v, got %+v", expected, summary)
+	}
+	if summary.IsFinished() || !summary.IsStarted() {
+		t.Errorf("Expected a started, unfinished array")
+	}
+}
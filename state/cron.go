@@ -0,0 +1,220 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+//
+// CronSchedule is a parsed five field cron expression
+// (minute hour day-of-month month day-of-week). Each field is a bitmask of
+// the values it matches.
+//
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type cronBounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	cronMinutes = cronBounds{0, 59, nil}
+	cronHours   = cronBounds{0, 23, nil}
+	cronDom     = cronBounds{1, 31, nil}
+	cronMonths  = cronBounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as Sunday and folded onto 0
+	cronDow = cronBounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//
+// ParseCronExpression parses a standard five field cron expression. Fields
+// accept *, values, ranges (1-5), steps (*/15, 1-30/5), lists (1,15) and
+// month and weekday names; @hourly, @daily, @weekly, @monthly and @yearly
+// are accepted as well.
+//
+func ParseCronExpression(expr string) (CronSchedule, error) {
+	var c CronSchedule
+	spec := strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return c, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("cron expression [%s] must have 5 fields, has %d", expr, len(fields))}
+	}
+
+	var err error
+	if c.minute, _, err = parseCronField(fields[0], cronMinutes); err != nil {
+		return c, err
+	}
+	if c.hour, _, err = parseCronField(fields[1], cronHours); err != nil {
+		return c, err
+	}
+	if c.dom, c.domStar, err = parseCronField(fields[2], cronDom); err != nil {
+		return c, err
+	}
+	if c.month, _, err = parseCronField(fields[3], cronMonths); err != nil {
+		return c, err
+	}
+	if c.dow, c.dowStar, err = parseCronField(fields[4], cronDow); err != nil {
+		return c, err
+	}
+	if c.dow&(1<<7) > 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	return c, nil
+}
+
+//
+// parseCronField returns the bitmask of the values matched by a field and
+// whether the field is a bare wildcard
+//
+func parseCronField(field string, b cronBounds) (uint64, bool, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeAndStep := strings.Split(part, "/")
+		if len(rangeAndStep) > 2 {
+			return 0, false, cronFieldError(field, "too many steps")
+		}
+
+		start, end := b.min, b.max
+		switch lowHigh := strings.Split(rangeAndStep[0], "-"); {
+		case rangeAndStep[0] == "*" || rangeAndStep[0] == "?":
+		case len(lowHigh) == 1:
+			v, err := parseCronValue(lowHigh[0], b)
+			if err != nil {
+				return 0, false, cronFieldError(field, err.Error())
+			}
+			start = v
+			if len(rangeAndStep) == 1 {
+				end = v
+			}
+		case len(lowHigh) == 2:
+			var err error
+			if start, err = parseCronValue(lowHigh[0], b); err != nil {
+				return 0, false, cronFieldError(field, err.Error())
+			}
+			if end, err = parseCronValue(lowHigh[1], b); err != nil {
+				return 0, false, cronFieldError(field, err.Error())
+			}
+		default:
+			return 0, false, cronFieldError(field, "invalid range")
+		}
+
+		step := uint(1)
+		if len(rangeAndStep) == 2 {
+			s, err := strconv.ParseUint(rangeAndStep[1], 10, 8)
+			if err != nil || s == 0 {
+				return 0, false, cronFieldError(field, "invalid step")
+			}
+			step = uint(s)
+		}
+		if start > end {
+			return 0, false, cronFieldError(field, "range start is after its end")
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, field == "*" || field == "?", nil
+}
+
+func parseCronValue(s string, b cronBounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	if uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+func cronFieldError(field string, reason string) error {
+	return exceptions.MalformedInput{
+		ErrorString: fmt.Sprintf("invalid cron field [%s]: %s", field, reason)}
+}
+
+//
+// Next returns the first time strictly after t matched by the schedule, in
+// t's location. The zero time is returned if nothing matches within five
+// years (eg. February 30th).
+//
+func (c CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for (1<<uint(t.Month()))&c.month == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !c.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for (1<<uint(t.Hour()))&c.hour == 0 {
+		t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for (1<<uint(t.Minute()))&c.minute == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+//
+// dayMatches follows cron semantics: when both day fields are restricted a
+// day matching either one matches
+//
+func (c CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := (1<<uint(t.Day()))&c.dom > 0
+	dowMatch := (1<<uint(t.Weekday()))&c.dow > 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+func TestParseCronExpression_Next(t *testing.T) {
+	from := time.Date(2020, 1, 31, 10, 7, 30, 0, time.UTC) // a Friday
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2020, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2020, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2020, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * mon-wed", time.Date(2020, 2, 3, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2020, 2, 2, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * 5", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * jun *", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		s, err := ParseCronExpression(c.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing [%s]: %v", c.expr, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(c.expected) {
+			t.Errorf("Expected [%s] after %v to be %v, was %v", c.expr, from, c.expected, next)
+		}
+	}
+}
+
+func TestParseCronExpression_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		_, err := ParseCronExpression(expr)
+		if _, ok := err.(exceptions.MalformedInput); !ok {
+			t.Errorf("Expected [%s] to be rejected as malformed input, got %v", expr, err)
+		}
+	}
+
+	s, _ := ParseCronExpression("0 0 30 2 *")
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Expected February 30th to never match, got %v", next)
+	}
+}
+
+func TestSchedule_NextAfter(t *testing.T) {
+	s := Schedule{CronExpression: "0 9 * * *", TimeZone: "America/New_York"}
+	next, err := s.NextAfter(time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9am EDT is 13:00 UTC
+	if expected := time.Date(2020, 7, 1, 13, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected %v, was %v", expected, next)
+	}
+
+	s.TimeZone = "Not/AZone"
+	if _, err = s.NextAfter(time.Now()); err == nil {
+		t.Errorf("Expected an invalid time zone to produce an error")
+	}
+}
@@ -0,0 +1,95 @@
+package state
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+//
+// runCursor is the position of the last run of a page when paginating runs
+// by keyset. It is handed to clients as an opaque base64 string.
+//
+type runCursor struct {
+	SortBy string  `json:"s"`
+	Order  string  `json:"o"`
+	Value  *string `json:"v,omitempty"`
+	RunID  string  `json:"id"`
+}
+
+//
+// encodeRunCursor returns the cursor pointing just after run in the
+// ordering given by sortBy and order
+//
+func encodeRunCursor(sortBy string, order string, run Run) string {
+	c := runCursor{SortBy: sortBy, Order: order, RunID: run.RunID}
+	switch v := runColumns[sortBy](run).(type) {
+	case string:
+		c.Value = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		c.Value = &s
+	case time.Time:
+		s := v.Format(time.RFC3339Nano)
+		c.Value = &s
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//
+// decodeRunCursor validates a cursor against the requested ordering and
+// returns it along with its typed sort value (nil for NULL)
+//
+func decodeRunCursor(cursor string, sortBy string, order string) (runCursor, interface{}, error) {
+	var c runCursor
+	invalid := exceptions.MalformedInput{ErrorString: fmt.Sprintf("invalid cursor [%s]", cursor)}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, nil, invalid
+	}
+	if err = json.Unmarshal(b, &c); err != nil || len(c.RunID) == 0 {
+		return c, nil, invalid
+	}
+	if c.SortBy != sortBy || c.Order != order {
+		return c, nil, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf(
+				"cursor was issued for sort_by=%s&order=%s, not sort_by=%s&order=%s", c.SortBy, c.Order, sortBy, order)}
+	}
+	if c.Value == nil {
+		return c, nil, nil
+	}
+
+	value, err := parseFilterValue(sortBy, runFilterColumns[sortBy], *c.Value)
+	if err != nil {
+		return c, nil, invalid
+	}
+	return c, value, nil
+}
+
+//
+// addKeyset restricts results to rows strictly after the cursor position,
+// ordered by expr (NULLS LAST) and then by idExpr as a tie breaker
+//
+func (wb *whereBuilder) addKeyset(expr string, idExpr string, order string, value interface{}, id string) {
+	op := ">"
+	if order == "desc" {
+		op = "<"
+	}
+
+	idParam := wb.bind(id)
+	if value == nil {
+		wb.clauses = append(wb.clauses, fmt.Sprintf("(%s is null and %s %s %s)", expr, idExpr, op, idParam))
+		return
+	}
+
+	valueParam := wb.bind(value)
+	wb.clauses = append(wb.clauses, fmt.Sprintf(
+		"(%s %s %s or (%s = %s and %s %s %s) or %s is null)",
+		expr, op, valueParam, expr, valueParam, idExpr, op, idParam, expr))
+}
@@ -0,0 +1,308 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/config"
+)
+
+//
+// Fields of a run an exit reason rule can match: its exceptions, the end of
+// its log, or its pod events
+//
+const (
+	ExitReasonTargetExceptions = "exceptions"
+	ExitReasonTargetLogTail    = "log_tail"
+	ExitReasonTargetPodEvents  = "pod_events"
+)
+
+// ExitReasonTargets are the valid exit reason rule targets
+var ExitReasonTargets = []string{ExitReasonTargetExceptions, ExitReasonTargetLogTail, ExitReasonTargetPodEvents}
+
+//
+// ExitReasonRule classifies failed runs: a run whose Target matches Pattern
+// gets Category as its exit category and Message as its exit reason
+//
+type ExitReasonRule struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Target   string `json:"target"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+//
+// ExitReasonRules are evaluated in order; the first rule that matches a run
+// classifies it
+//
+type ExitReasonRules []ExitReasonRule
+
+//
+// ExitReasonMatch is the rule that classified a run and the text it matched
+//
+type ExitReasonMatch struct {
+	Rule  ExitReasonRule `json:"rule"`
+	Match string         `json:"match"`
+}
+
+//
+// ExitReasonInput is the text of a run that rules match against. LogTail is
+// only called when a rule targets the log, and at most once.
+//
+type ExitReasonInput struct {
+	Exceptions string
+	PodEvents  string
+	LogTail    func() string
+}
+
+//
+// ExitCategoryCount is the number of runs with an exit category
+//
+type ExitCategoryCount struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+//
+// DefaultExitReasonRules are used when no rules are configured
+//
+func DefaultExitReasonRules() ExitReasonRules {
+	return ExitReasonRules{
+		{
+			Name:     "oom_exception",
+			Pattern:  `(?i)(OutOfMemoryError|\bMemoryError\b|out of memory|Killed process \d+|Cannot allocate memory)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "out_of_memory",
+			Message:  "Out of memory",
+		},
+		{
+			Name:     "oom_killed",
+			Pattern:  `(?i)(OOMKill|out of memory)`,
+			Target:   ExitReasonTargetPodEvents,
+			Category: "out_of_memory",
+			Message:  "Out of memory",
+		},
+		{
+			Name:     "connection",
+			Pattern:  `(?i)(timeout|gatewayerror|socketerror|\s503\s|\s502\s|\s500\s|\s504\s|connectionerror)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "connection",
+			Message:  "Connection error to downstream uri",
+		},
+		{
+			Name:     "pip",
+			Pattern:  `(?i)(could\snot\sfind\sa\sversion|package\snot\sfound|ModuleNotFoundError|No\smatching\sdistribution\sfound)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "dependency",
+			Message:  "Python pip package installation error",
+		},
+		{
+			Name:     "yum",
+			Pattern:  `(?i)(Nothing\sto\sdo)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "dependency",
+			Message:  "Yum installation error",
+		},
+		{
+			Name:     "git",
+			Pattern:  `(?i)(Could\snot\sread\sfrom\sremote\srepository|correct\saccess\srights|Repository\snot\sfound)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "git",
+			Message:  "Git clone error",
+		},
+		{
+			Name:     "argument",
+			Pattern:  `(?i)(404|400|keyerror|column\smissing|RuntimeError)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "data",
+			Message:  "Data or argument error",
+		},
+		{
+			Name:     "syntax",
+			Pattern:  `(?i)(syntaxerror|typeerror)`,
+			Target:   ExitReasonTargetExceptions,
+			Category: "code",
+			Message:  "Code or syntax error",
+		},
+	}
+}
+
+//
+// NewExitReasonRules reads the rules named in exit_reasons.rules, in order,
+// from the exit_reasons section of conf: the maps exit_reasons.patterns,
+// exit_reasons.targets (default exceptions), exit_reasons.categories
+// (default the rule's name) and exit_reasons.messages, keyed by rule name.
+// The default rules are returned when exit_reasons.rules is unset.
+//
+func NewExitReasonRules(conf config.Config) (ExitReasonRules, error) {
+	if conf == nil || !conf.IsSet("exit_reasons.rules") {
+		return DefaultExitReasonRules(), nil
+	}
+
+	patterns := conf.GetStringMapString("exit_reasons.patterns")
+	targets := conf.GetStringMapString("exit_reasons.targets")
+	categories := conf.GetStringMapString("exit_reasons.categories")
+	messages := conf.GetStringMapString("exit_reasons.messages")
+	var rules ExitReasonRules
+	for _, name := range conf.GetStringSlice("exit_reasons.rules") {
+		// Config map keys are case insensitive
+		key := strings.ToLower(name)
+		rule := ExitReasonRule{
+			Name:     name,
+			Pattern:  patterns[key],
+			Target:   ExitReasonTargetExceptions,
+			Category: name,
+			Message:  messages[key],
+		}
+		if target, ok := targets[key]; ok {
+			rule.Target = target
+		}
+		if category, ok := categories[key]; ok {
+			rule.Category = category
+		}
+		rules = append(rules, rule)
+	}
+	if ok, reasons := rules.IsValid(); !ok {
+		return nil, fmt.Errorf("invalid exit_reasons config: %s", strings.Join(reasons, "; "))
+	}
+	return rules, nil
+}
+
+//
+// IsValid returns whether the rule is valid and the reasons it isn't
+//
+func (r *ExitReasonRule) IsValid() (bool, []string) {
+	var reasons []string
+	if len(r.Name) == 0 {
+		reasons = append(reasons, "string [name] must be specified")
+	}
+	if re, err := regexp.Compile(r.Pattern); err != nil {
+		reasons = append(reasons, fmt.Sprintf("string [pattern] of rule [%s] is not a valid regular expression: %v", r.Name, err))
+	} else if re.MatchString("") {
+		// Such a rule would classify every run it gets to
+		reasons = append(reasons, fmt.Sprintf("string [pattern] of rule [%s] must not match the empty string", r.Name))
+	}
+	if !validExitReasonTarget(r.Target) {
+		reasons = append(reasons, fmt.Sprintf("string [target] of rule [%s] must be one of %v", r.Name, ExitReasonTargets))
+	}
+	if len(r.Category) == 0 {
+		reasons = append(reasons, fmt.Sprintf("string [category] of rule [%s] must be specified", r.Name))
+	}
+	if len(r.Message) == 0 {
+		reasons = append(reasons, fmt.Sprintf("string [message] of rule [%s] must be specified", r.Name))
+	}
+	return len(reasons) == 0, reasons
+}
+
+//
+// IsValid returns whether every rule is valid and their names are unique,
+// and the reasons they aren't
+//
+func (rules ExitReasonRules) IsValid() (bool, []string) {
+	var reasons []string
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if _, ruleReasons := r.IsValid(); len(ruleReasons) > 0 {
+			reasons = append(reasons, ruleReasons...)
+		}
+		if names[r.Name] {
+			reasons = append(reasons, fmt.Sprintf("rule name [%s] is used more than once", r.Name))
+		}
+		names[r.Name] = true
+	}
+	return len(reasons) == 0, reasons
+}
+
+func validExitReasonTarget(target string) bool {
+	for _, t := range ExitReasonTargets {
+		if target == t {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Classify returns the first rule that matches in, or nil if none do. Rules
+// that classify many runs should be compiled once instead.
+//
+func (rules ExitReasonRules) Classify(in ExitReasonInput) *ExitReasonMatch {
+	return rules.Compile().Classify(in)
+}
+
+//
+// CompiledExitReasonRules are exit reason rules with their patterns compiled
+//
+type CompiledExitReasonRules struct {
+	rules    ExitReasonRules
+	patterns []*regexp.Regexp
+}
+
+//
+// Compile compiles the patterns of the rules; rules whose pattern doesn't
+// compile never match
+//
+func (rules ExitReasonRules) Compile() CompiledExitReasonRules {
+	compiled := CompiledExitReasonRules{rules: rules, patterns: make([]*regexp.Regexp, len(rules))}
+	for i, r := range rules {
+		if re, err := regexp.Compile(r.Pattern); err == nil {
+			compiled.patterns[i] = re
+		}
+	}
+	return compiled
+}
+
+//
+// Classify returns the first rule that matches in, or nil if none do
+//
+func (c CompiledExitReasonRules) Classify(in ExitReasonInput) *ExitReasonMatch {
+	var (
+		logTail     string
+		logTailRead bool
+	)
+	for i, r := range c.rules {
+		re := c.patterns[i]
+		if re == nil {
+			continue
+		}
+
+		var text string
+		switch r.Target {
+		case ExitReasonTargetExceptions:
+			text = in.Exceptions
+		case ExitReasonTargetPodEvents:
+			text = in.PodEvents
+		case ExitReasonTargetLogTail:
+			if !logTailRead && in.LogTail != nil {
+				logTail = in.LogTail()
+			}
+			logTailRead = true
+			text = logTail
+		}
+		if match := re.FindString(text); len(match) > 0 {
+			return &ExitReasonMatch{Rule: r, Match: match}
+		}
+	}
+	return nil
+}
+
+//
+// NewExitReasonInput returns the exceptions and pod events of run as text
+// for rules to match; the log tail is left to the caller
+//
+func NewExitReasonInput(run Run) ExitReasonInput {
+	var in ExitReasonInput
+	if run.RunExceptions != nil {
+		in.Exceptions = strings.Join(*run.RunExceptions, "\n")
+	}
+	if run.PodEvents != nil {
+		events := make([]string, 0, len(*run.PodEvents))
+		for _, e := range *run.PodEvents {
+			events = append(events, fmt.Sprintf("%s: %s", e.Reason, e.Message))
+		}
+		in.PodEvents = strings.Join(events, "\n")
+	}
+	return in
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestDefaultExitReasonRules(t *testing.T) {
+	rules := DefaultExitReasonRules()
+	if ok, reasons := rules.IsValid(); !ok {
+		t.Errorf("Expected the default rules to be valid, got %v", reasons)
+	}
+	for _, r := range rules {
+		if regexp.MustCompile(r.Pattern).MatchString("") {
+			t.Errorf("Expected rule [%s] not to match the empty string", r.Name)
+		}
+	}
+
+	if m := rules.Classify(ExitReasonInput{Exceptions: "ValueError: bad input"}); m != nil {
+		t.Errorf("Expected an unknown exception not to be classified, got %v", m)
+	}
+	m := rules.Classify(ExitReasonInput{Exceptions: "requests.exceptions.ConnectionError: refused"})
+	if m == nil || m.Rule.Category != "connection" || m.Match != "ConnectionError" {
+		t.Errorf("Expected a connection error, got %v", m)
+	}
+	m = rules.Classify(ExitReasonInput{Exceptions: "TypeError: unsupported operand"})
+	if m == nil || m.Rule.Message != "Code or syntax error" {
+		t.Errorf("Expected a code error, got %v", m)
+	}
+	m = rules.Classify(ExitReasonInput{PodEvents: "OOMKilling: Memory cgroup out of memory"})
+	if m == nil || m.Rule.Name != "oom_killed" {
+		t.Errorf("Expected an out of memory pod event, got %v", m)
+	}
+}
+
+func TestNewExitReasonRules(t *testing.T) {
+	rules, err := NewExitReasonRules(testPriorityConf{})
+	if err != nil || len(rules) != len(DefaultExitReasonRules()) {
+		t.Errorf("Expected the default rules when unconfigured, got %v, %v", rules, err)
+	}
+
+	conf := testPriorityConf{
+		"exit_reasons.rules":      []string{"Spot", "quota"},
+		"exit_reasons.patterns":   map[string]string{"spot": "(?i)spot interruption", "quota": "QuotaExceeded"},
+		"exit_reasons.targets":    map[string]string{"spot": ExitReasonTargetPodEvents},
+		"exit_reasons.categories": map[string]string{"spot": "infrastructure"},
+		"exit_reasons.messages":   map[string]string{"spot": "Spot instance reclaimed", "quota": "Quota exceeded"},
+	}
+	rules, err = NewExitReasonRules(conf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := ExitReasonRules{
+		{Name: "Spot", Pattern: "(?i)spot interruption", Target: ExitReasonTargetPodEvents, Category: "infrastructure", Message: "Spot instance reclaimed"},
+		{Name: "quota", Pattern: "QuotaExceeded", Target: ExitReasonTargetExceptions, Category: "quota", Message: "Quota exceeded"},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %v", len(expected), rules)
+	}
+	for i, r := range expected {
+		if rules[i] != r {
+			t.Errorf("Expected rule %v, got %v", r, rules[i])
+		}
+	}
+
+	conf["exit_reasons.patterns"] = map[string]string{"spot": "(syntaxerror|)", "quota": "QuotaExceeded"}
+	if _, err = NewExitReasonRules(conf); err == nil {
+		t.Errorf("Expected a pattern matching the empty string to be rejected")
+	}
+}
+
+func TestExitReasonRules_IsValid(t *testing.T) {
+	valid := ExitReasonRule{Name: "a", Pattern: "a+", Target: ExitReasonTargetLogTail, Category: "c", Message: "m"}
+	if ok, reasons := (ExitReasonRules{valid}).IsValid(); !ok {
+		t.Errorf("Expected rule to be valid, got %v", reasons)
+	}
+
+	invalid := []ExitReasonRule{
+		{Pattern: "a+", Target: ExitReasonTargetLogTail, Category: "c", Message: "m"},
+		{Name: "a", Pattern: "(", Target: ExitReasonTargetLogTail, Category: "c", Message: "m"},
+		{Name: "a", Pattern: "a*", Target: ExitReasonTargetLogTail, Category: "c", Message: "m"},
+		{Name: "a", Pattern: "a+", Target: "stdout", Category: "c", Message: "m"},
+		{Name: "a", Pattern: "a+", Target: ExitReasonTargetLogTail, Message: "m"},
+		{Name: "a", Pattern: "a+", Target: ExitReasonTargetLogTail, Category: "c"},
+	}
+	for _, r := range invalid {
+		if ok, _ := (ExitReasonRules{r}).IsValid(); ok {
+			t.Errorf("Expected rule %v to be invalid", r)
+		}
+	}
+	if ok, _ := (ExitReasonRules{valid, valid}).IsValid(); ok {
+		t.Errorf("Expected rules with the same name to be invalid")
+	}
+}
+
+func TestExitReasonRules_Classify(t *testing.T) {
+	rules := ExitReasonRules{
+		{Name: "disk", Pattern: "No space left", Target: ExitReasonTargetLogTail, Category: "disk", Message: "Out of disk"},
+		{Name: "later", Pattern: "space", Target: ExitReasonTargetLogTail, Category: "later", Message: "Later"},
+		{Name: "key", Pattern: "KeyError", Target: ExitReasonTargetExceptions, Category: "data", Message: "Missing key"},
+	}
+
+	reads := 0
+	in := ExitReasonInput{
+		Exceptions: "KeyError: 'id'",
+		LogTail: func() string {
+			reads++
+			return "writing output\nOSError: [Errno 28] disk full"
+		},
+	}
+	m := rules.Classify(in)
+	if m == nil || m.Rule.Name != "key" {
+		t.Errorf("Expected the exceptions rule to match, got %v", m)
+	}
+	if reads != 1 {
+		t.Errorf("Expected the log tail to be read once, was read %d times", reads)
+	}
+
+	in.LogTail = func() string { return "No space left on device" }
+	if m = rules.Classify(in); m == nil || m.Rule.Name != "disk" || m.Match != "No space left" {
+		t.Errorf("Expected the first matching rule to win, got %v", m)
+	}
+
+	reads = 0
+	in.LogTail = func() string {
+		reads++
+		return ""
+	}
+	if m = rules[2:].Classify(in); m == nil || reads != 0 {
+		t.Errorf("Expected the log tail not to be read without log rules, got %v after %d reads", m, reads)
+	}
+}
+
+func TestNewExitReasonInput(t *testing.T) {
+	runExceptions := RunExceptions{"KeyError: 'id'", "ValueError"}
+	podEvents := PodEvents{{Reason: "OOMKilling", Message: "out of memory"}}
+	in := NewExitReasonInput(Run{RunExceptions: &runExceptions, PodEvents: &podEvents})
+	if in.Exceptions != "KeyError: 'id'\nValueError" {
+		t.Errorf("Expected exceptions joined by newlines, got [%s]", in.Exceptions)
+	}
+	if in.PodEvents != "OOMKilling: out of memory" {
+		t.Errorf("Expected pod events as reason and message, got [%s]", in.PodEvents)
+	}
+}
+
+func TestExitReasonRules_Compile(t *testing.T) {
+	compiled := ExitReasonRules{
+		{Name: "invalid", Pattern: "(", Target: ExitReasonTargetExceptions, Category: "c", Message: "m"},
+		{Name: "key", Pattern: "KeyError", Target: ExitReasonTargetExceptions, Category: "data", Message: "Missing key"},
+	}.Compile()
+
+	in := ExitReasonInput{Exceptions: "KeyError: 'id' ("}
+	for i := 0; i < 2; i++ {
+		if m := compiled.Classify(in); m == nil || m.Rule.Name != "key" {
+			t.Errorf("Expected the rule after the invalid one to match, got %v", m)
+		}
+	}
+	if m := compiled.Classify(ExitReasonInput{Exceptions: "ValueError"}); m != nil {
+		t.Errorf("Expected no match, got %v", m)
+	}
+}
@@ -0,0 +1,494 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+//
+// FilterOperator is the comparison a Filter applies to a column
+//
+type FilterOperator string
+
+const (
+	FilterEqual       FilterOperator = "eq"
+	FilterLike        FilterOperator = "like"
+	FilterIn          FilterOperator = "in"
+	FilterNotIn       FilterOperator = "not_in"
+	FilterGreaterThan FilterOperator = "gt"
+	FilterLessThan    FilterOperator = "lt"
+	FilterIsNull      FilterOperator = "is_null"
+)
+
+type columnType int
+
+const (
+	stringColumn columnType = iota
+	numericColumn
+	timeColumn
+)
+
+//
+// filterColumn describes a column that may be filtered on
+//
+type filterColumn struct {
+	expr string
+	kind columnType
+	like bool
+}
+
+//
+// filterSuffixes map query parameter suffixes to operators, eg.
+// exit_code_gt=0 or finished_at_is_null=true. Order matters: _not_in must be
+// checked before _in.
+//
+var filterSuffixes = []struct {
+	suffix   string
+	operator FilterOperator
+}{
+	{"_not_in", FilterNotIn},
+	{"_in", FilterIn},
+	{"_is_null", FilterIsNull},
+	{"_gt", FilterGreaterThan},
+	{"_lt", FilterLessThan},
+	{"_since", FilterGreaterThan},
+	{"_until", FilterLessThan},
+}
+
+var runFilterColumns = map[string]filterColumn{
+	"run_id":            {expr: "t.run_id"},
+	"definition_id":     {expr: "t.definition_id"},
+	"alias":             {expr: "t.alias", like: true},
+	"image":             {expr: "t.image", like: true},
+	"cluster_name":      {expr: "t.cluster_name"},
+	"exit_code":         {expr: "t.exit_code", kind: numericColumn},
+	"exit_reason":       {expr: "t.exit_reason", like: true},
+	"status":            {expr: "t.status"},
+	"queued_at":         {expr: "t.queued_at", kind: timeColumn},
+	"started_at":        {expr: "t.started_at", kind: timeColumn},
+	"finished_at":       {expr: "t.finished_at", kind: timeColumn},
+	"instance_id":       {expr: "t.instance_id"},
+	"instance_dns_name": {expr: "t.instance_dns_name"},
+	"group_name":        {expr: "t.group_name", like: true},
+	"user":              {expr: `t."user"`},
+	"task_type":         {expr: "t.task_type"},
+	"task_arn":          {expr: "t.task_arn"},
+	"command":           {expr: "t.command", like: true},
+	"command_hash":      {expr: "t.command_hash"},
+	"memory":            {expr: "t.memory", kind: numericColumn},
+	"cpu":               {expr: "t.cpu", kind: numericColumn},
+	"gpu":               {expr: "t.gpu", kind: numericColumn},
+	"engine":            {expr: "t.engine"},
+	"node_lifecycle":    {expr: "t.node_lifecycle"},
+	"pod_name":          {expr: "t.pod_name"},
+	"namespace":         {expr: "t.namespace"},
+	"max_cpu_used":      {expr: "t.max_cpu_used", kind: numericColumn},
+	"max_memory_used":   {expr: "t.max_memory_used", kind: numericColumn},
+	"attempt_count":     {expr: "t.attempt_count", kind: numericColumn},
+	"executable_id":     {expr: "t.executable_id"},
+	"executable_type":   {expr: "t.executable_type"},
+	"schedule_id":       {expr: "t.schedule_id"},
+	"original_run_id":   {expr: "t.original_run_id"},
+	"retry_attempt":     {expr: "t.retry_attempt", kind: numericColumn},
+	"retry_state":       {expr: "t.retry_state"},
+	"array_parent_id":   {expr: "t.array_parent_id"},
+	"array_index":       {expr: "t.array_index", kind: numericColumn},
+	"priority":          {expr: "t.priority"},
+	"exit_category":     {expr: "t.exit_category"},
+}
+
+var definitionFilterColumns = map[string]filterColumn{
+	"definition_id": {expr: "td.definition_id"},
+	"alias":         {expr: "td.alias", like: true},
+	"image":         {expr: "td.image", like: true},
+	"group_name":    {expr: "td.group_name", like: true},
+	"command":       {expr: "td.command", like: true},
+	"task_type":     {expr: "td.task_type"},
+	"memory":        {expr: "td.memory", kind: numericColumn},
+	"cpu":           {expr: "td.cpu", kind: numericColumn},
+	"gpu":           {expr: "td.gpu", kind: numericColumn},
+}
+
+var auditFilterColumns = map[string]filterColumn{
+	"event_id":    {expr: "event_id"},
+	"actor_name":  {expr: "actor_name", like: true},
+	"actor_email": {expr: "actor_email"},
+	"action":      {expr: "action"},
+	"target_type": {expr: "target_type"},
+	"target_id":   {expr: "target_id"},
+	"created_at":  {expr: "created_at", kind: timeColumn},
+}
+
+var scheduleFilterColumns = map[string]filterColumn{
+	"schedule_id":     {expr: "schedule_id"},
+	"name":            {expr: "name", like: true},
+	"executable_type": {expr: "executable_type"},
+	"executable_id":   {expr: "executable_id"},
+	"overlap_policy":  {expr: "overlap_policy"},
+	"next_run_at":     {expr: "next_run_at", kind: timeColumn},
+}
+
+var workflowFilterColumns = map[string]filterColumn{
+	"workflow_id": {expr: "workflow_id"},
+	"name":        {expr: "name", like: true},
+	"status":      {expr: "status"},
+	"created_at":  {expr: "created_at", kind: timeColumn},
+	"finished_at": {expr: "finished_at", kind: timeColumn},
+}
+
+var quotaFilterColumns = map[string]filterColumn{
+	"scope": {expr: "scope"},
+	"name":  {expr: "name", like: true},
+}
+
+var webhookFilterColumns = map[string]filterColumn{
+	"scope": {expr: "scope"},
+	"name":  {expr: "name", like: true},
+	"url":   {expr: "url", like: true},
+}
+
+var groupFilterColumns = map[string]filterColumn{
+	"group_name": {expr: "group_name", like: true},
+}
+
+var tagFilterColumns = map[string]filterColumn{
+	"text": {expr: "text", like: true},
+}
+
+//
+// Filter is a single validated, typed predicate on a whitelisted column.
+// Values hold string, int64, time.Time or bool depending on the column and
+// operator.
+//
+type Filter struct {
+	Field    string
+	Operator FilterOperator
+	Values   []interface{}
+}
+
+//
+// parseFilterValue converts a raw query parameter to the type of the column
+//
+func parseFilterValue(field string, column filterColumn, raw string) (interface{}, error) {
+	switch column.kind {
+	case numericColumn:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, exceptions.MalformedInput{
+				ErrorString: fmt.Sprintf("invalid value [%s] for numeric filter [%s]", raw, field)}
+		}
+		return v, nil
+	case timeColumn:
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if v, err := time.Parse(layout, raw); err == nil {
+				return v, nil
+			}
+		}
+		return nil, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("invalid value [%s] for time filter [%s], must be RFC3339", raw, field)}
+	}
+	return raw, nil
+}
+
+//
+// parseFilter resolves the column and operator for a single query parameter
+//
+func parseFilter(columns map[string]filterColumn, key string, raw []string) (Filter, error) {
+	f := Filter{Field: key, Operator: FilterEqual}
+	column, ok := columns[key]
+	if !ok {
+		for _, s := range filterSuffixes {
+			if strings.HasSuffix(key, s.suffix) {
+				if c, found := columns[strings.TrimSuffix(key, s.suffix)]; found {
+					f.Field = strings.TrimSuffix(key, s.suffix)
+					f.Operator = s.operator
+					column = c
+					ok = true
+					break
+				}
+			}
+		}
+	}
+	if !ok {
+		return f, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("invalid filter field [%s]", key)}
+	}
+
+	switch f.Operator {
+	case FilterEqual:
+		if len(raw) > 1 {
+			// No like queries for multiple filters with same key
+			f.Operator = FilterIn
+		} else if column.like {
+			f.Operator = FilterLike
+		}
+	case FilterIn, FilterNotIn:
+		var split []string
+		for _, v := range raw {
+			split = append(split, strings.Split(v, ",")...)
+		}
+		raw = split
+	case FilterGreaterThan, FilterLessThan:
+		if column.kind == stringColumn {
+			return f, exceptions.MalformedInput{
+				ErrorString: fmt.Sprintf("filter [%s] only supports numeric and time fields", key)}
+		}
+		if len(raw) != 1 {
+			return f, exceptions.MalformedInput{
+				ErrorString: fmt.Sprintf("filter [%s] takes a single value", key)}
+		}
+	case FilterIsNull:
+		if len(raw) != 1 {
+			return f, exceptions.MalformedInput{
+				ErrorString: fmt.Sprintf("filter [%s] takes a single value", key)}
+		}
+		isNull, err := strconv.ParseBool(raw[0])
+		if err != nil {
+			return f, exceptions.MalformedInput{
+				ErrorString: fmt.Sprintf("invalid value [%s] for filter [%s], must be true or false", raw[0], key)}
+		}
+		f.Values = []interface{}{isNull}
+		return f, nil
+	}
+
+	for _, v := range raw {
+		if f.Operator == FilterLike {
+			f.Values = append(f.Values, v)
+			continue
+		}
+		typed, err := parseFilterValue(key, column, v)
+		if err != nil {
+			return f, err
+		}
+		f.Values = append(f.Values, typed)
+	}
+	return f, nil
+}
+
+//
+// parseFilters validates query parameter style filters against the columns
+// of a model and returns typed filters. The supported forms are:
+//
+//	field=value                  equality, or substring match for text fields
+//	field=a&field=b              in
+//	field_in=a,b                 in
+//	field_not_in=a,b             not in
+//	field_gt=1, field_since=...  greater than (numeric and time fields)
+//	field_lt=1, field_until=...  less than (numeric and time fields)
+//	field_is_null=true|false     null check
+//
+func parseFilters(columns map[string]filterColumn, filters map[string][]string) ([]Filter, error) {
+	var result []Filter
+	for k, v := range filters {
+		if len(v) == 0 {
+			continue
+		}
+		f, err := parseFilter(columns, k, v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+//
+// LabelFilterPrefix marks run filters on labels, eg. label.pipeline=nightly
+//
+const LabelFilterPrefix = "label."
+
+//
+// splitLabelFilters separates label filters, keyed by label, from field
+// filters
+//
+func splitLabelFilters(filters map[string][]string) (map[string][]string, map[string][]string) {
+	var fields, labels map[string][]string
+	for k, v := range filters {
+		if strings.HasPrefix(k, LabelFilterPrefix) && len(k) > len(LabelFilterPrefix) {
+			if labels == nil {
+				labels = make(map[string][]string)
+			}
+			labels[strings.TrimPrefix(k, LabelFilterPrefix)] = v
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string][]string, len(filters))
+		}
+		fields[k] = v
+	}
+	return fields, labels
+}
+
+//
+// TagFilter is the definition filter on tags; several values match
+// definitions with any of the tags, eg. tag=etl&tag=ml
+//
+const TagFilter = "tag"
+
+//
+// splitTagFilters separates the tag filter from field filters
+//
+func splitTagFilters(filters map[string][]string) (map[string][]string, []string) {
+	tags, ok := filters[TagFilter]
+	if !ok {
+		return filters, nil
+	}
+	fields := make(map[string][]string, len(filters))
+	for k, v := range filters {
+		if k != TagFilter {
+			fields[k] = v
+		}
+	}
+	return fields, tags
+}
+
+//
+// withEngines returns a copy of filters restricted to engines, or to the
+// default engine when engines is nil
+//
+func withEngines(filters map[string][]string, engines []string) map[string][]string {
+	result := make(map[string][]string, len(filters)+1)
+	for k, v := range filters {
+		result[k] = v
+	}
+	if engines != nil {
+		result["engine"] = engines
+	} else {
+		result["engine"] = []string{DefaultEngine}
+	}
+	return result
+}
+
+//
+// whereBuilder accumulates sql predicates along with their bind parameters.
+// Placeholders are numbered starting after the ones already used by the
+// enclosing query (eg. limit and offset).
+//
+type whereBuilder struct {
+	columns map[string]filterColumn
+	clauses []string
+	args    []interface{}
+	offset  int
+}
+
+func newWhereBuilder(columns map[string]filterColumn, offset int) *whereBuilder {
+	return &whereBuilder{columns: columns, offset: offset}
+}
+
+func (wb *whereBuilder) bind(v interface{}) string {
+	wb.args = append(wb.args, v)
+	return fmt.Sprintf("$%d", wb.offset+len(wb.args))
+}
+
+//
+// addFilters parses and adds field filters
+//
+func (wb *whereBuilder) addFilters(filters map[string][]string) error {
+	parsed, err := parseFilters(wb.columns, filters)
+	if err != nil {
+		return err
+	}
+	for _, f := range parsed {
+		wb.add(f)
+	}
+	return nil
+}
+
+func (wb *whereBuilder) add(f Filter) {
+	expr := wb.columns[f.Field].expr
+	switch f.Operator {
+	case FilterLike:
+		wb.clauses = append(wb.clauses, fmt.Sprintf("%s like '%%' || %s || '%%'", expr, wb.bind(f.Values[0])))
+	case FilterIn, FilterNotIn:
+		placeholders := make([]string, len(f.Values))
+		for i, v := range f.Values {
+			placeholders[i] = wb.bind(v)
+		}
+		op := "in"
+		if f.Operator == FilterNotIn {
+			op = "not in"
+		}
+		wb.clauses = append(wb.clauses, fmt.Sprintf("%s %s (%s)", expr, op, strings.Join(placeholders, ",")))
+	case FilterGreaterThan:
+		wb.clauses = append(wb.clauses, fmt.Sprintf("%s > %s", expr, wb.bind(f.Values[0])))
+	case FilterLessThan:
+		wb.clauses = append(wb.clauses, fmt.Sprintf("%s < %s", expr, wb.bind(f.Values[0])))
+	case FilterIsNull:
+		if f.Values[0].(bool) {
+			wb.clauses = append(wb.clauses, fmt.Sprintf("%s is null", expr))
+		} else {
+			wb.clauses = append(wb.clauses, fmt.Sprintf("%s is not null", expr))
+		}
+	default:
+		wb.clauses = append(wb.clauses, fmt.Sprintf("%s = %s", expr, wb.bind(f.Values[0])))
+	}
+}
+
+//
+// addEnvFilters adds a containment check on the jsonb env column for each
+// environment variable
+//
+func (wb *whereBuilder) addEnvFilters(column string, envFilters map[string]string) error {
+	for k, v := range envFilters {
+		env, err := json.Marshal(EnvList{{Name: k, Value: v}})
+		if err != nil {
+			return err
+		}
+		wb.clauses = append(wb.clauses, fmt.Sprintf("%s @> %s::jsonb", column, wb.bind(string(env))))
+	}
+	return nil
+}
+
+//
+// addLabelFilters adds a containment check on the jsonb labels column for
+// each label, which the gin index on the column serves. Several values for
+// the same label match any of them.
+//
+func (wb *whereBuilder) addLabelFilters(column string, labelFilters map[string][]string) error {
+	for k, values := range labelFilters {
+		var checks []string
+		for _, v := range values {
+			label, err := json.Marshal(RunLabels{k: v})
+			if err != nil {
+				return err
+			}
+			checks = append(checks, fmt.Sprintf("%s @> %s::jsonb", column, wb.bind(string(label))))
+		}
+		if len(checks) > 0 {
+			wb.clauses = append(wb.clauses, fmt.Sprintf("(%s)", strings.Join(checks, " or ")))
+		}
+	}
+	return nil
+}
+
+//
+// addTagFilters restricts definitions to those with any of the tags
+//
+func (wb *whereBuilder) addTagFilters(tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	placeholders := make([]string, len(tags))
+	for i, t := range tags {
+		placeholders[i] = wb.bind(t)
+	}
+	wb.clauses = append(wb.clauses, fmt.Sprintf(
+		"td.definition_id in (select task_def_id from task_def_tags where tag_id in (%s))",
+		strings.Join(placeholders, ",")))
+}
+
+//
+// String renders the where clause, or an empty string if there are no
+// predicates
+//
+func (wb *whereBuilder) String() string {
+	if len(wb.clauses) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("where %s", strings.Join(wb.clauses, " and "))
+}
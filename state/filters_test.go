@@ -0,0 +1,101 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+func TestWhereBuilder_AddFilters(t *testing.T) {
+	wb := newWhereBuilder(runFilterColumns, 2)
+	err := wb.addFilters(map[string][]string{
+		"exit_code_gt": {"0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wb.String() != "where t.exit_code > $3" {
+		t.Errorf("Unexpected where clause [%s]", wb.String())
+	}
+	if len(wb.args) != 1 || wb.args[0].(int64) != 0 {
+		t.Errorf("Expected exit_code_gt to bind a single int64, got %v", wb.args)
+	}
+
+	wb = newWhereBuilder(runFilterColumns, 2)
+	wb.addFilters(map[string][]string{"status_not_in": {"STOPPED,QUEUED"}})
+	if wb.String() != "where t.status not in ($3,$4)" {
+		t.Errorf("Unexpected where clause [%s]", wb.String())
+	}
+
+	wb = newWhereBuilder(runFilterColumns, 2)
+	wb.addFilters(map[string][]string{"finished_at_is_null": {"true"}})
+	if wb.String() != "where t.finished_at is null" || len(wb.args) != 0 {
+		t.Errorf("Unexpected where clause [%s] with args %v", wb.String(), wb.args)
+	}
+
+	wb = newWhereBuilder(runFilterColumns, 2)
+	wb.addFilters(map[string][]string{"command": {"echo 'hi'; drop table task;"}})
+	if wb.String() != "where t.command like '%' || $3 || '%'" {
+		t.Errorf("Unexpected where clause [%s]", wb.String())
+	}
+}
+
+func TestWhereBuilder_AddFiltersInvalid(t *testing.T) {
+	for _, filters := range []map[string][]string{
+		{"not_a_column": {"x"}},
+		{"status; drop table task": {"x"}},
+		{"exit_code_gt": {"zero"}},
+		{"status_gt": {"RUNNING"}},
+		{"started_at_since": {"yesterday"}},
+		{"finished_at_is_null": {"maybe"}},
+	} {
+		err := newWhereBuilder(runFilterColumns, 2).addFilters(filters)
+		if _, ok := err.(exceptions.MalformedInput); !ok {
+			t.Errorf("Expected MalformedInput for filters %v but got %v", filters, err)
+		}
+	}
+}
+
+func TestWhereBuilder_AddEnvFilters(t *testing.T) {
+	wb := newWhereBuilder(runFilterColumns, 2)
+	wb.addEnvFilters("t.env", map[string]string{"K": `V"'`})
+	if wb.String() != "where t.env @> $3::jsonb" {
+		t.Errorf("Unexpected where clause [%s]", wb.String())
+	}
+	if wb.args[0].(string) != `[{"name":"K","value":"V\"'"}]` {
+		t.Errorf("Unexpected env filter arg %v", wb.args[0])
+	}
+}
+
+func TestWhereBuilder_AddLabelFilters(t *testing.T) {
+	fields, labels := splitLabelFilters(map[string][]string{
+		"status":         {StatusQueued},
+		"label.pipeline": {"nightly", "hourly"},
+	})
+	if len(fields) != 1 || len(fields["status"]) != 1 {
+		t.Errorf("Expected only status to remain a field filter, got %v", fields)
+	}
+
+	wb := newWhereBuilder(runFilterColumns, 2)
+	wb.addLabelFilters("t.labels", labels)
+	if wb.String() != "where (t.labels @> $3::jsonb or t.labels @> $4::jsonb)" {
+		t.Errorf("Unexpected where clause [%s]", wb.String())
+	}
+	if wb.args[0].(string) != `{"pipeline":"nightly"}` || wb.args[1].(string) != `{"pipeline":"hourly"}` {
+		t.Errorf("Unexpected label filter args %v", wb.args)
+	}
+}
+
+func TestWhereBuilder_AddTagFilters(t *testing.T) {
+	fields, tags := splitTagFilters(map[string][]string{"alias": {"a"}, "tag": {"etl", "ml"}})
+	if _, ok := fields["tag"]; ok || len(fields) != 1 {
+		t.Errorf("Expected only alias to remain a field filter, got %v", fields)
+	}
+
+	wb := newWhereBuilder(definitionFilterColumns, 2)
+	wb.addTagFilters(tags)
+	expected := "where td.definition_id in (select task_def_id from task_def_tags where tag_id in ($3,$4))"
+	if wb.String() != expected {
+		t.Errorf("Unexpected where clause [%s]", wb.String())
+	}
+}
@@ -0,0 +1,36 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxIdempotencyKeyLength bounds the length of an idempotency key
+const MaxIdempotencyKeyLength = 255
+
+//
+// IdempotencyKey records the run created for a request carrying an
+// idempotency key. Keys are unique per owner; a key can be used for a new
+// request once it is older than the idempotency window.
+//
+type IdempotencyKey struct {
+	OwnerID     string    `json:"owner_id"`
+	Key         string    `json:"idempotency_key"`
+	RequestHash string    `json:"request_hash"`
+	RunID       string    `json:"run_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+//
+// ValidateIdempotencyKey returns the reasons the idempotency key of the
+// request is invalid, if any
+//
+func (f *ExecutionRequestCommon) ValidateIdempotencyKey() []string {
+	if f.IdempotencyKey == nil {
+		return nil
+	}
+	if n := len(*f.IdempotencyKey); n == 0 || n > MaxIdempotencyKeyLength {
+		return []string{fmt.Sprintf("string [idempotency_key] must be between 1 and %d characters", MaxIdempotencyKeyLength)}
+	}
+	return nil
+}
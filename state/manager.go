@@ -3,6 +3,7 @@ package state
 import (
 	"github.com/pkg/errors"
 	"github.com/stitchfix/flotilla-os/config"
+	"time"
 )
 
 //
@@ -20,15 +21,69 @@ type Manager interface {
 	GetDefinition(definitionID string) (Definition, error)
 	GetDefinitionByAlias(alias string) (Definition, error)
 	UpdateDefinition(definitionID string, updates Definition) (Definition, error)
+	ReplaceDefinition(definitionID string, replacement Definition) (Definition, error)
 	CreateDefinition(d Definition) error
 	DeleteDefinition(definitionID string) error
+	CreateDefinitionRevision(definitionID string, d Definition, userInfo UserInfo) (DefinitionRevision, error)
+	ListDefinitionRevisions(definitionID string, limit int, offset int) (DefinitionRevisionList, error)
+	GetDefinitionRevision(definitionID string, revision int64) (DefinitionRevision, error)
 
 	ListRuns(limit int, offset int, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string) (RunList, error)
+	ListRunsByCursor(limit int, cursor string, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string, approximateTotal bool) (RunList, error)
 	EstimateRunResources(executableID string, commandHash string) (TaskResources, error)
 
 	GetRun(runID string) (Run, error)
 	CreateRun(r Run) error
-	UpdateRun(runID string, updates Run) (Run, error)
+	UpdateRun(runID string, updates Run, source string) (Run, error)
+	ListRunTransitions(runID string) (RunStatusTransitionList, error)
+	ClaimDueRetries(now time.Time, limit int) ([]Run, error)
+	RecordRetryRun(runID string, retryRunID string) error
+	RescheduleRetry(runID string, retryAt time.Time) error
+	ReleaseArrayRuns(parentID string, maxActive int, now time.Time) ([]Run, error)
+	ClaimIdempotencyKey(k IdempotencyKey, window time.Duration) (IdempotencyKey, error)
+	ReleaseIdempotencyKey(k IdempotencyKey) error
+	CreateExecutableSnapshot(s ExecutableSnapshot) error
+	GetExecutableSnapshot(snapshotID string) (ExecutableSnapshot, error)
+
+	CreateAuditEvent(e AuditEvent) error
+	ListAuditEvents(limit int, offset int, sortBy string, order string, filters map[string][]string) (AuditEventList, error)
+
+	CreateSchedule(s Schedule) error
+	GetSchedule(scheduleID string) (Schedule, error)
+	ListSchedules(limit int, offset int, sortBy string, order string, filters map[string][]string) (ScheduleList, error)
+	UpdateSchedule(scheduleID string, updates Schedule) (Schedule, error)
+	DeleteSchedule(scheduleID string) error
+	ClaimDueSchedules(now time.Time, limit int) ([]Schedule, error)
+	RecordScheduleRun(scheduleID string, runID string) error
+	CreateWorkflow(w Workflow) error
+	GetWorkflow(workflowID string) (Workflow, error)
+	ListWorkflows(limit int, offset int, sortBy string, order string, filters map[string][]string) (WorkflowList, error)
+	ClaimActiveWorkflows(now time.Time, lease time.Duration, limit int) ([]Workflow, error)
+	UpdateWorkflow(w Workflow) error
+	RequestWorkflowCancel(workflowID string) (Workflow, error)
+
+	PutQuota(q Quota) (Quota, error)
+	GetQuota(scope string, name string) (Quota, error)
+	ListQuotas(limit int, offset int, sortBy string, order string, filters map[string][]string) (QuotaList, error)
+	DeleteQuota(scope string, name string) error
+	GetQuotaUsage(q Quota) (QuotaUsage, error)
+	ListQuotaHeldRuns(limit int) ([]Run, error)
+	HoldRunForQuota(runID string, heldAt time.Time) error
+	ReleaseQuotaHold(runID string) (bool, error)
+
+	CreateWebhook(w Webhook) (Webhook, error)
+	GetWebhook(webhookID string) (Webhook, error)
+	ListWebhooks(limit int, offset int, sortBy string, order string, filters map[string][]string) (WebhookList, error)
+	DeleteWebhook(webhookID string) error
+	ListRunWebhooks(run Run) ([]Webhook, error)
+	CreateWebhookDelivery(d WebhookDelivery) (bool, error)
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(d WebhookDelivery) error
+	ListWebhookDeliveries(webhookID string, limit int, offset int) (WebhookDeliveryList, error)
+
+	ListExitReasonRules() (ExitReasonRules, error)
+	PutExitReasonRules(rules ExitReasonRules) error
+	CountRunsByExitCategory(filters map[string][]string) ([]ExitCategoryCount, error)
 
 	ListGroups(limit int, offset int, name *string) (GroupsList, error)
 	ListTags(limit int, offset int, name *string) (TagsList, error)
@@ -72,6 +127,13 @@ func NewStateManager(conf config.Config) (Manager, error) {
 			return nil, errors.Wrap(err, "problem initializing SQLStateManager")
 		}
 		return pgm, nil
+	case "memory":
+		mm := &MemoryStateManager{}
+		err := mm.Initialize(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "problem initializing MemoryStateManager")
+		}
+		return mm, nil
 	default:
 		return nil, errors.Errorf("state.Manager named [%s] not found", name)
 	}
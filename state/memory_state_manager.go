@@ -0,0 +1,2223 @@
+package state
+
+import (
+	"crypto/md5"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+//
+// MemoryStateManager keeps all state in process memory. It is meant for
+// local development and tests where a postgres instance is not available;
+// nothing is persisted across restarts.
+//
+type MemoryStateManager struct {
+	mu              sync.RWMutex
+	definitions     map[string]Definition
+	runs            map[string]Run
+	templates       map[string]Template
+	snapshots       map[string]ExecutableSnapshot
+	revisions       map[string][]DefinitionRevision
+	audit           []AuditEvent
+	transitions     map[string][]RunStatusTransition
+	schedules       map[string]Schedule
+	workflows       map[string]Workflow
+	leases          map[string]time.Time
+	idempotencyKeys map[string]IdempotencyKey
+	quotas          map[string]Quota
+	webhooks        map[string]Webhook
+	deliveries      map[string]WebhookDelivery
+	exitReasonRules ExitReasonRules
+	workers         []Worker
+}
+
+//
+// Name is the name of the state manager - matches value in configuration
+//
+func (mm *MemoryStateManager) Name() string {
+	return "memory"
+}
+
+//
+// Initialize sets up the in-memory stores and populates the workers
+//
+func (mm *MemoryStateManager) Initialize(conf config.Config) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.definitions = make(map[string]Definition)
+	mm.runs = make(map[string]Run)
+	mm.templates = make(map[string]Template)
+	mm.snapshots = make(map[string]ExecutableSnapshot)
+	mm.revisions = make(map[string][]DefinitionRevision)
+	mm.audit = []AuditEvent{}
+	mm.transitions = make(map[string][]RunStatusTransition)
+	mm.schedules = make(map[string]Schedule)
+	mm.workflows = make(map[string]Workflow)
+	mm.leases = make(map[string]time.Time)
+	mm.idempotencyKeys = make(map[string]IdempotencyKey)
+	mm.quotas = make(map[string]Quota)
+	mm.webhooks = make(map[string]Webhook)
+	mm.deliveries = make(map[string]WebhookDelivery)
+	mm.workers = []Worker{}
+
+	for _, engine := range Engines {
+		for _, workerType := range []string{"retry", "submit", "status", "scheduler", "workflow", "array", "webhook"} {
+			count := 1
+			key := fmt.Sprintf("worker.%s.%s_worker_count_per_instance", engine, workerType)
+			if conf != nil && conf.IsSet(key) {
+				count = conf.GetInt(key)
+			}
+			mm.workers = append(mm.workers, Worker{
+				WorkerType:       workerType,
+				CountPerInstance: count,
+				Engine:           engine,
+			})
+		}
+	}
+	return nil
+}
+
+//
+// Cleanup is a no-op for the in-memory state manager
+//
+func (mm *MemoryStateManager) Cleanup() error {
+	return nil
+}
+
+//
+// memoryColumn extracts the value of a single column from a stored object.
+// Values are one of: string, int64, time.Time or nil (for NULL).
+//
+type memoryColumn func(obj interface{}) interface{}
+
+var definitionColumns = map[string]memoryColumn{
+	"definition_id": func(o interface{}) interface{} { return o.(Definition).DefinitionID },
+	"alias":         func(o interface{}) interface{} { return o.(Definition).Alias },
+	"image":         func(o interface{}) interface{} { return o.(Definition).Image },
+	"group_name":    func(o interface{}) interface{} { return o.(Definition).GroupName },
+	"command":       func(o interface{}) interface{} { return o.(Definition).Command },
+	"task_type":     func(o interface{}) interface{} { return o.(Definition).TaskType },
+	"memory":        func(o interface{}) interface{} { return int64Value(o.(Definition).Memory) },
+	"cpu":           func(o interface{}) interface{} { return int64Value(o.(Definition).Cpu) },
+	"gpu":           func(o interface{}) interface{} { return int64Value(o.(Definition).Gpu) },
+}
+
+var runColumns = map[string]memoryColumn{
+	"run_id":            func(o interface{}) interface{} { return o.(Run).RunID },
+	"definition_id":     func(o interface{}) interface{} { return o.(Run).DefinitionID },
+	"alias":             func(o interface{}) interface{} { return o.(Run).Alias },
+	"image":             func(o interface{}) interface{} { return o.(Run).Image },
+	"cluster_name":      func(o interface{}) interface{} { return o.(Run).ClusterName },
+	"exit_code":         func(o interface{}) interface{} { return int64Value(o.(Run).ExitCode) },
+	"exit_reason":       func(o interface{}) interface{} { return stringValue(o.(Run).ExitReason) },
+	"status":            func(o interface{}) interface{} { return o.(Run).Status },
+	"queued_at":         func(o interface{}) interface{} { return timeValue(o.(Run).QueuedAt) },
+	"started_at":        func(o interface{}) interface{} { return timeValue(o.(Run).StartedAt) },
+	"finished_at":       func(o interface{}) interface{} { return timeValue(o.(Run).FinishedAt) },
+	"instance_id":       func(o interface{}) interface{} { return o.(Run).InstanceID },
+	"instance_dns_name": func(o interface{}) interface{} { return o.(Run).InstanceDNSName },
+	"group_name":        func(o interface{}) interface{} { return o.(Run).GroupName },
+	"user":              func(o interface{}) interface{} { return o.(Run).User },
+	"task_type":         func(o interface{}) interface{} { return o.(Run).TaskType },
+	"command":           func(o interface{}) interface{} { return stringValue(o.(Run).Command) },
+	"command_hash":      func(o interface{}) interface{} { return stringValue(o.(Run).CommandHash) },
+	"memory":            func(o interface{}) interface{} { return int64Value(o.(Run).Memory) },
+	"cpu":               func(o interface{}) interface{} { return int64Value(o.(Run).Cpu) },
+	"gpu":               func(o interface{}) interface{} { return int64Value(o.(Run).Gpu) },
+	"engine":            func(o interface{}) interface{} { return stringValue(o.(Run).Engine) },
+	"node_lifecycle":    func(o interface{}) interface{} { return stringValue(o.(Run).NodeLifecycle) },
+	"pod_name":          func(o interface{}) interface{} { return stringValue(o.(Run).PodName) },
+	"namespace":         func(o interface{}) interface{} { return stringValue(o.(Run).Namespace) },
+	"max_cpu_used":      func(o interface{}) interface{} { return int64Value(o.(Run).MaxCpuUsed) },
+	"max_memory_used":   func(o interface{}) interface{} { return int64Value(o.(Run).MaxMemoryUsed) },
+	"attempt_count":     func(o interface{}) interface{} { return int64Value(o.(Run).AttemptCount) },
+	"executable_id":     func(o interface{}) interface{} { return stringValue(o.(Run).ExecutableID) },
+	"schedule_id":       func(o interface{}) interface{} { return stringValue(o.(Run).ScheduleID) },
+	"original_run_id":   func(o interface{}) interface{} { return stringValue(o.(Run).OriginalRunID) },
+	"retry_attempt":     func(o interface{}) interface{} { return o.(Run).RetryAttempt },
+	"retry_state":       func(o interface{}) interface{} { return o.(Run).RetryState },
+	"array_parent_id":   func(o interface{}) interface{} { return stringValue(o.(Run).ArrayParentID) },
+	"array_index":       func(o interface{}) interface{} { return int64Value(o.(Run).ArrayIndex) },
+	"priority":          func(o interface{}) interface{} { return stringValue(o.(Run).Priority) },
+	"exit_category":     func(o interface{}) interface{} { return stringValue(o.(Run).ExitCategory) },
+	"task_arn":          func(o interface{}) interface{} { return nil },
+	"executable_type": func(o interface{}) interface{} {
+		if t := o.(Run).ExecutableType; t != nil {
+			return string(*t)
+		}
+		return nil
+	},
+}
+
+var auditColumns = map[string]memoryColumn{
+	"event_id":    func(o interface{}) interface{} { return o.(AuditEvent).EventID },
+	"actor_name":  func(o interface{}) interface{} { return o.(AuditEvent).Actor.Name },
+	"actor_email": func(o interface{}) interface{} { return o.(AuditEvent).Actor.Email },
+	"action":      func(o interface{}) interface{} { return o.(AuditEvent).Action },
+	"target_type": func(o interface{}) interface{} { return o.(AuditEvent).TargetType },
+	"target_id":   func(o interface{}) interface{} { return o.(AuditEvent).TargetID },
+	"created_at":  func(o interface{}) interface{} { return timeValue(o.(AuditEvent).CreatedAt) },
+}
+
+var scheduleColumns = map[string]memoryColumn{
+	"schedule_id":     func(o interface{}) interface{} { return o.(Schedule).ScheduleID },
+	"name":            func(o interface{}) interface{} { return o.(Schedule).Name },
+	"executable_type": func(o interface{}) interface{} { return string(o.(Schedule).ExecutableType) },
+	"executable_id":   func(o interface{}) interface{} { return o.(Schedule).ExecutableID },
+	"overlap_policy":  func(o interface{}) interface{} { return o.(Schedule).OverlapPolicy },
+	"next_run_at":     func(o interface{}) interface{} { return timeValue(o.(Schedule).NextRunAt) },
+	"last_run_at":     func(o interface{}) interface{} { return timeValue(o.(Schedule).LastRunAt) },
+	"created_at":      func(o interface{}) interface{} { return timeValue(o.(Schedule).CreatedAt) },
+}
+
+var workflowColumns = map[string]memoryColumn{
+	"workflow_id": func(o interface{}) interface{} { return o.(Workflow).WorkflowID },
+	"name":        func(o interface{}) interface{} { return o.(Workflow).Name },
+	"status":      func(o interface{}) interface{} { return o.(Workflow).Status },
+	"created_at":  func(o interface{}) interface{} { return timeValue(o.(Workflow).CreatedAt) },
+	"updated_at":  func(o interface{}) interface{} { return timeValue(o.(Workflow).UpdatedAt) },
+	"finished_at": func(o interface{}) interface{} { return timeValue(o.(Workflow).FinishedAt) },
+}
+
+var quotaColumns = map[string]memoryColumn{
+	"scope":      func(o interface{}) interface{} { return o.(Quota).Scope },
+	"name":       func(o interface{}) interface{} { return o.(Quota).Name },
+	"created_at": func(o interface{}) interface{} { return timeValue(o.(Quota).CreatedAt) },
+	"updated_at": func(o interface{}) interface{} { return timeValue(o.(Quota).UpdatedAt) },
+}
+
+var webhookColumns = map[string]memoryColumn{
+	"scope":      func(o interface{}) interface{} { return o.(Webhook).Scope },
+	"name":       func(o interface{}) interface{} { return o.(Webhook).Name },
+	"url":        func(o interface{}) interface{} { return o.(Webhook).URL },
+	"created_at": func(o interface{}) interface{} { return timeValue(o.(Webhook).CreatedAt) },
+}
+
+var templateColumns = map[string]memoryColumn{
+	"template_id":   func(o interface{}) interface{} { return o.(Template).TemplateID },
+	"template_name": func(o interface{}) interface{} { return o.(Template).TemplateName },
+	"version":       func(o interface{}) interface{} { return o.(Template).Version },
+}
+
+func int64Value(v *int64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func stringValue(v *string) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func timeValue(v *time.Time) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+//
+// compareColumnValue compares a column value with a typed filter value; ok is
+// false when the comparison involves NULL, as it would in postgres
+//
+func compareColumnValue(value interface{}, filterValue interface{}) (cmp int, ok bool) {
+	switch v := value.(type) {
+	case string:
+		fv, isString := filterValue.(string)
+		if !isString {
+			return 0, false
+		}
+		return strings.Compare(v, fv), true
+	case int64:
+		fv, isInt := filterValue.(int64)
+		if !isInt {
+			return 0, false
+		}
+		switch {
+		case v < fv:
+			return -1, true
+		case v > fv:
+			return 1, true
+		}
+		return 0, true
+	case time.Time:
+		fv, isTime := filterValue.(time.Time)
+		if !isTime {
+			return 0, false
+		}
+		switch {
+		case v.Before(fv):
+			return -1, true
+		case v.After(fv):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+//
+// matchesFilters applies parsed filters to a single stored object with the
+// same semantics as the where clause SQLStateManager builds
+//
+func (mm *MemoryStateManager) matchesFilters(
+	obj interface{}, columns map[string]memoryColumn, filters []Filter) bool {
+	for _, f := range filters {
+		value := columns[f.Field](obj)
+
+		switch f.Operator {
+		case FilterLike:
+			s, isString := value.(string)
+			if !isString || !strings.Contains(s, f.Values[0].(string)) {
+				return false
+			}
+		case FilterIn, FilterNotIn:
+			if value == nil {
+				return false
+			}
+			found := false
+			for _, fv := range f.Values {
+				if cmp, ok := compareColumnValue(value, fv); ok && cmp == 0 {
+					found = true
+					break
+				}
+			}
+			if found != (f.Operator == FilterIn) {
+				return false
+			}
+		case FilterIsNull:
+			if (value == nil) != f.Values[0].(bool) {
+				return false
+			}
+		case FilterGreaterThan:
+			if cmp, ok := compareColumnValue(value, f.Values[0]); !ok || cmp <= 0 {
+				return false
+			}
+		case FilterLessThan:
+			if cmp, ok := compareColumnValue(value, f.Values[0]); !ok || cmp >= 0 {
+				return false
+			}
+		default:
+			if cmp, ok := compareColumnValue(value, f.Values[0]); !ok || cmp != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//
+// matchesEnvFilters checks that every key/value pair is present in env
+//
+func (mm *MemoryStateManager) matchesEnvFilters(env *EnvList, envFilters map[string]string) bool {
+	for k, v := range envFilters {
+		if env == nil {
+			return false
+		}
+		found := false
+		for _, e := range *env {
+			if e.Name == k && e.Value == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// matchesLabelFilters checks that every label has one of the filtered values
+//
+func (mm *MemoryStateManager) matchesLabelFilters(labels RunLabels, labelFilters map[string][]string) bool {
+	for k, values := range labelFilters {
+		v, ok := labels[k]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, fv := range values {
+			if v == fv {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// matchesTagFilters checks that tags include any of the filtered tags
+//
+func (mm *MemoryStateManager) matchesTagFilters(tags *Tags, tagFilters []string) bool {
+	if len(tagFilters) == 0 {
+		return true
+	}
+	if tags == nil {
+		return false
+	}
+	for _, t := range *tags {
+		for _, ft := range tagFilters {
+			if t == ft {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// compareSortValues compares two column values in the given order with
+// NULLS LAST, mirroring the order by clause generated by
+// SQLStateManager.orderBy
+//
+func compareSortValues(a interface{}, b interface{}, order string) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		}
+		return -1
+	}
+	cmp, _ := compareColumnValue(a, b)
+	if order == "desc" {
+		return -cmp
+	}
+	return cmp
+}
+
+//
+// sortByColumn orders objects by a column
+//
+func (mm *MemoryStateManager) sortByColumn(
+	objs []interface{}, column memoryColumn, order string) {
+	sort.SliceStable(objs, func(i, j int) bool {
+		return compareSortValues(column(objs[i]), column(objs[j]), order) < 0
+	})
+}
+
+func (mm *MemoryStateManager) validateOrder(obj IOrderable, field string, order string) error {
+	if order != "asc" && order != "desc" {
+		return errors.Errorf("Invalid order string, must be one of ('asc', 'desc'), was %s", order)
+	}
+	if !obj.ValidOrderField(field) {
+		return errors.Errorf("Invalid field to order by [%s], must be one of [%s]",
+			field,
+			strings.Join(obj.ValidOrderFields(), ", "))
+	}
+	return nil
+}
+
+//
+// paginate returns the [offset, offset+limit) window of a slice length
+//
+func paginate(total int, limit int, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit >= 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return offset, end
+}
+
+//
+// ListDefinitions returns a DefinitionList
+// limit: limit the result to this many definitions
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on Definition - joined with AND
+// envFilters: map of environment variable filters - joined with AND
+//
+func (mm *MemoryStateManager) ListDefinitions(
+	limit int, offset int, sortBy string,
+	order string, filters map[string][]string,
+	envFilters map[string]string) (DefinitionList, error) {
+	var result DefinitionList
+
+	if err := mm.validateOrder(&Definition{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	fieldFilters, tagFilters := splitTagFilters(filters)
+	parsed, err := parseFilters(definitionFilterColumns, fieldFilters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, d := range mm.definitions {
+		if mm.matchesFilters(d, definitionColumns, parsed) && mm.matchesEnvFilters(d.Env, envFilters) &&
+			mm.matchesTagFilters(d.Tags, tagFilters) {
+			matched = append(matched, d)
+		}
+	}
+	mm.sortByColumn(matched, definitionColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, d := range matched[start:end] {
+		result.Definitions = append(result.Definitions, d.(Definition))
+	}
+	return result, nil
+}
+
+//
+// GetDefinition returns a single definition by id
+//
+func (mm *MemoryStateManager) GetDefinition(definitionID string) (Definition, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	d, ok := mm.definitions[definitionID]
+	if !ok {
+		return d, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Definition with ID %s not found", definitionID)}
+	}
+	return d, nil
+}
+
+//
+// GetDefinitionByAlias returns a single definition by alias
+//
+func (mm *MemoryStateManager) GetDefinitionByAlias(alias string) (Definition, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	for _, d := range mm.definitions {
+		if d.Alias == alias {
+			return d, nil
+		}
+	}
+	return Definition{}, exceptions.MissingResource{
+		ErrorString: fmt.Sprintf("Definition with alias %s not found", alias)}
+}
+
+//
+// UpdateDefinition updates a definition
+// - updates can be partial
+//
+func (mm *MemoryStateManager) UpdateDefinition(definitionID string, updates Definition) (Definition, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	existing, ok := mm.definitions[definitionID]
+	if !ok {
+		return existing, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Definition with ID %s not found", definitionID)}
+	}
+	existing.UpdateWith(updates)
+	mm.definitions[definitionID] = existing
+	return existing, nil
+}
+
+//
+// ReplaceDefinition replaces the contents of a definition wholesale; fields
+// that are unset in replacement are cleared
+//
+func (mm *MemoryStateManager) ReplaceDefinition(definitionID string, replacement Definition) (Definition, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	existing, ok := mm.definitions[definitionID]
+	if !ok {
+		return existing, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Definition with ID %s not found", definitionID)}
+	}
+	replacement.DefinitionID = existing.DefinitionID
+	replacement.GroupName = existing.GroupName
+	mm.definitions[definitionID] = replacement
+	return replacement, nil
+}
+
+//
+// CreateDefinition creates the passed in definition object
+// - error if definition or alias already exists
+//
+func (mm *MemoryStateManager) CreateDefinition(d Definition) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.definitions[d.DefinitionID]; ok {
+		return errors.Errorf(
+			"issue creating new task definition with alias [%s] and id [%s]: already exists", d.Alias, d.DefinitionID)
+	}
+	for _, existing := range mm.definitions {
+		if existing.Alias == d.Alias {
+			return errors.Errorf(
+				"issue creating new task definition with alias [%s] and id [%s]: alias already exists", d.Alias, d.DefinitionID)
+		}
+	}
+	mm.definitions[d.DefinitionID] = d
+	return nil
+}
+
+//
+// DeleteDefinition deletes definition and associated runs
+//
+func (mm *MemoryStateManager) DeleteDefinition(definitionID string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for runID, r := range mm.runs {
+		if r.DefinitionID == definitionID {
+			delete(mm.runs, runID)
+		}
+	}
+	delete(mm.definitions, definitionID)
+	return nil
+}
+
+//
+// CreateDefinitionRevision records d as the next revision of the definition
+//
+func (mm *MemoryStateManager) CreateDefinitionRevision(definitionID string, d Definition, userInfo UserInfo) (DefinitionRevision, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	now := time.Now()
+	r := DefinitionRevision{
+		DefinitionID: definitionID,
+		Revision:     int64(len(mm.revisions[definitionID]) + 1),
+		Definition:   d,
+		User:         userInfo,
+		CreatedAt:    &now,
+	}
+	mm.revisions[definitionID] = append(mm.revisions[definitionID], r)
+	return r, nil
+}
+
+//
+// ListDefinitionRevisions returns the revisions of a definition, newest first
+//
+func (mm *MemoryStateManager) ListDefinitionRevisions(definitionID string, limit int, offset int) (DefinitionRevisionList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	revisions := mm.revisions[definitionID]
+	start, end := paginate(len(revisions), limit, offset)
+	result := DefinitionRevisionList{Total: len(revisions)}
+	for i := start; i < end; i++ {
+		result.Revisions = append(result.Revisions, revisions[len(revisions)-1-i])
+	}
+	return result, nil
+}
+
+//
+// GetDefinitionRevision gets a single revision of a definition
+//
+func (mm *MemoryStateManager) GetDefinitionRevision(definitionID string, revision int64) (DefinitionRevision, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	revisions := mm.revisions[definitionID]
+	if revision < 1 || revision > int64(len(revisions)) {
+		return DefinitionRevision{}, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Revision %d of definition %s not found", revision, definitionID)}
+	}
+	return revisions[revision-1], nil
+}
+
+//
+// ListRuns returns a RunList
+// limit: limit the result to this many runs
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on Run - joined with AND
+// envFilters: map of environment variable filters - joined with AND
+//
+func (mm *MemoryStateManager) ListRuns(limit int, offset int, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string) (RunList, error) {
+	var result RunList
+
+	if err := mm.validateOrder(&Run{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	fieldFilters, labelFilters := splitLabelFilters(withEngines(filters, engines))
+	parsed, err := parseFilters(runFilterColumns, fieldFilters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, r := range mm.runs {
+		if mm.matchesFilters(r, runColumns, parsed) && mm.matchesEnvFilters(r.Env, envFilters) &&
+			mm.matchesLabelFilters(r.Labels, labelFilters) {
+			matched = append(matched, r)
+		}
+	}
+	mm.sortByColumn(matched, runColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, r := range matched[start:end] {
+		result.Runs = append(result.Runs, r.(Run))
+	}
+	return result, nil
+}
+
+//
+// ListRunsByCursor returns a page of runs after cursor, ordered by sortBy and
+// then run_id. Total is exact regardless of approximateTotal.
+//
+func (mm *MemoryStateManager) ListRunsByCursor(limit int, cursor string, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string, approximateTotal bool) (RunList, error) {
+	var result RunList
+
+	if limit <= 0 {
+		return result, exceptions.MalformedInput{ErrorString: "limit must be positive when paginating by cursor"}
+	}
+	if err := mm.validateOrder(&Run{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	fieldFilters, labelFilters := splitLabelFilters(withEngines(filters, engines))
+	parsed, err := parseFilters(runFilterColumns, fieldFilters)
+	if err != nil {
+		return result, err
+	}
+
+	var (
+		after       bool
+		cursorID    string
+		cursorValue interface{}
+	)
+	if len(cursor) > 0 {
+		c, value, err := decodeRunCursor(cursor, sortBy, order)
+		if err != nil {
+			return result, err
+		}
+		after, cursorID, cursorValue = true, c.RunID, value
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	column := runColumns[sortBy]
+	compare := func(value interface{}, id string, otherValue interface{}, otherID string) int {
+		if cmp := compareSortValues(value, otherValue, order); cmp != 0 {
+			return cmp
+		}
+		return compareSortValues(id, otherID, order)
+	}
+
+	var matched []Run
+	for _, r := range mm.runs {
+		if !mm.matchesFilters(r, runColumns, parsed) || !mm.matchesEnvFilters(r.Env, envFilters) ||
+			!mm.matchesLabelFilters(r.Labels, labelFilters) {
+			continue
+		}
+		if approximateTotal {
+			result.Total++
+		}
+		if after && compare(column(r), r.RunID, cursorValue, cursorID) <= 0 {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return compare(column(matched[i]), matched[i].RunID, column(matched[j]), matched[j].RunID) < 0
+	})
+
+	if len(matched) > limit {
+		matched = matched[:limit]
+		next := encodeRunCursor(sortBy, order, matched[limit-1])
+		result.NextCursor = &next
+	}
+	result.Runs = matched
+	return result, nil
+}
+
+//
+// historicalRuns returns stopped eks runs of an executable queued in the last
+// 7 days sharing the command hash of runID
+//
+func (mm *MemoryStateManager) historicalRuns(executableID string, runID string) []Run {
+	var hash *string
+	if r, ok := mm.runs[runID]; ok {
+		hash = r.CommandHash
+	}
+
+	var result []Run
+	cutoff := time.Now().AddDate(0, 0, -7)
+	for _, r := range mm.runs {
+		if r.DefinitionID != executableID ||
+			r.Engine == nil || *r.Engine != EKSEngine ||
+			r.QueuedAt == nil || r.QueuedAt.Before(cutoff) ||
+			r.CommandHash == nil || hash == nil || *r.CommandHash != *hash {
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+//
+// percentileDisc returns the first value whose cumulative distribution is
+// at least p, like postgres' percentile_disc
+//
+func percentileDisc(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	idx := int(math.Ceil(p*float64(len(values)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return values[idx]
+}
+
+//
+// EstimateRunResources estimates cpu and memory from recent successful
+// (or OOM killed) runs with the same command
+//
+func (mm *MemoryStateManager) EstimateRunResources(executableID string, runID string) (TaskResources, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var taskResources TaskResources
+	var memory, cpu []float64
+	for _, r := range mm.historicalRuns(executableID, runID) {
+		if r.ExitCode == nil || (*r.ExitCode != 0 && *r.ExitCode != 137) ||
+			r.MaxMemoryUsed == nil || r.MaxCpuUsed == nil {
+			continue
+		}
+		maxMemoryUsed := *r.MaxMemoryUsed
+		if *r.ExitCode == 137 && r.Memory != nil {
+			maxMemoryUsed = *r.Memory * 2
+		}
+		memory = append(memory, float64(maxMemoryUsed))
+		cpu = append(cpu, float64(*r.MaxCpuUsed))
+		if len(memory) == 30 {
+			break
+		}
+	}
+
+	if len(memory) == 0 {
+		return taskResources, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Resource usage with executable %s not found", executableID)}
+	}
+	taskResources.Memory = int64(percentileDisc(memory, 0.99) * 1.75)
+	taskResources.Cpu = int64(percentileDisc(cpu, 0.99) * 1.25)
+	return taskResources, nil
+}
+
+//
+// GetTaskHistoricalRuntime returns the p95 runtime in minutes of recent
+// successful runs with the same command
+//
+func (mm *MemoryStateManager) GetTaskHistoricalRuntime(executableID string, runID string) (float32, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var minutes []float64
+	for _, r := range mm.historicalRuns(executableID, runID) {
+		if r.ExitCode == nil || *r.ExitCode != 0 || r.StartedAt == nil || r.FinishedAt == nil {
+			continue
+		}
+		minutes = append(minutes, r.FinishedAt.Sub(*r.StartedAt).Minutes())
+		if len(minutes) == 30 {
+			break
+		}
+	}
+
+	if len(minutes) == 0 {
+		return 0, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Error fetching TaskRuntime rate")}
+	}
+	return float32(percentileDisc(minutes, 0.95)), nil
+}
+
+//
+// ListFailingNodes returns hosts with recent control plane errors or
+// repeated terminations
+//
+func (mm *MemoryStateManager) ListFailingNodes() (NodeList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	failingReasons := map[string]bool{
+		"Failed":                 true,
+		"FailedSync":             true,
+		"OutOfmemory":            true,
+		"FailedCreatePodSandBox": true,
+	}
+
+	var nodeList NodeList
+	seen := make(map[string]bool)
+	timeouts := make(map[string]int)
+	cutoff := time.Now().Add(-12 * time.Hour)
+	for _, r := range mm.runs {
+		if r.Engine == nil || *r.Engine != EKSEngine || r.QueuedAt == nil || r.QueuedAt.Before(cutoff) {
+			continue
+		}
+
+		failing := r.ExitCode != nil && (*r.ExitCode == 128 || (*r.ExitCode == 1 && r.ExitReason == nil))
+		if r.PodEvents != nil {
+			for _, e := range *r.PodEvents {
+				if failingReasons[e.Reason] {
+					failing = true
+				}
+			}
+		}
+		if failing && !seen[r.InstanceDNSName] {
+			seen[r.InstanceDNSName] = true
+			nodeList = append(nodeList, r.InstanceDNSName)
+		}
+
+		if r.ExitReason != nil && strings.HasPrefix(*r.ExitReason, "Task terminated by - ") {
+			timeouts[r.InstanceDNSName]++
+		}
+	}
+
+	for instanceDNSName, c := range timeouts {
+		if c > 5 {
+			nodeList = append(nodeList, instanceDNSName)
+		}
+	}
+	return nodeList, nil
+}
+
+//
+// GetPodReAttemptRate returns the ratio of recent spot runs that needed
+// more than one pod attempt
+//
+func (mm *MemoryStateManager) GetPodReAttemptRate() (float32, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var single, multiple float32
+	cutoff := time.Now().Add(-30 * time.Minute)
+	for _, r := range mm.runs {
+		if r.Engine == nil || *r.Engine != EKSEngine ||
+			r.QueuedAt == nil || r.QueuedAt.Before(cutoff) ||
+			r.NodeLifecycle == nil || *r.NodeLifecycle != SpotLifecycle ||
+			r.AttemptCount == nil {
+			continue
+		}
+		if *r.AttemptCount == 1 {
+			single++
+		} else {
+			multiple++
+		}
+	}
+
+	if single == 0 {
+		single = 1
+	}
+	return multiple / single, nil
+}
+
+//
+// GetRun gets run by id
+//
+func (mm *MemoryStateManager) GetRun(runID string) (Run, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	r, ok := mm.runs[runID]
+	if !ok {
+		return r, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Run with id %s not found", runID)}
+	}
+	return r, nil
+}
+
+//
+// GetRunByEMRJobId gets run by the id of its EMR job
+//
+func (mm *MemoryStateManager) GetRunByEMRJobId(emrJobId string) (Run, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	for _, r := range mm.runs {
+		if r.SparkExtension != nil && r.SparkExtension.EMRJobId != nil && *r.SparkExtension.EMRJobId == emrJobId {
+			return r, nil
+		}
+	}
+	return Run{}, exceptions.MissingResource{
+		ErrorString: fmt.Sprintf("Run with emrjobid %s not found", emrJobId)}
+}
+
+//
+// CreateRun creates the passed in run
+//
+func (mm *MemoryStateManager) CreateRun(r Run) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.runs[r.RunID]; ok {
+		return errors.Errorf("issue creating new task run with id [%s]: already exists", r.RunID)
+	}
+	if r.Command != nil {
+		hash := fmt.Sprintf("%x", md5.Sum([]byte(*r.Command)))
+		r.CommandHash = &hash
+	}
+	mm.runs[r.RunID] = r
+	mm.recordTransition(&RunStatusTransition{
+		RunID: r.RunID, ToStatus: r.Status, Source: TransitionSourceAPI})
+	return nil
+}
+
+//
+// UpdateRun updates run with updates - can be partial. Illegal status
+// transitions are rejected.
+//
+func (mm *MemoryStateManager) UpdateRun(runID string, updates Run, source string) (Run, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	existing, ok := mm.runs[runID]
+	if !ok {
+		return existing, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Run with id %s not found", runID)}
+	}
+	transition, err := checkTransition(existing, updates, source)
+	if err != nil {
+		return existing, err
+	}
+	existing.UpdateWith(updates)
+	scheduleRetry(&existing, time.Now())
+	mm.runs[runID] = existing
+	mm.recordTransition(transition)
+	return existing, nil
+}
+
+func (mm *MemoryStateManager) recordTransition(t *RunStatusTransition) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.CreatedAt = &now
+	mm.transitions[t.RunID] = append(mm.transitions[t.RunID], *t)
+}
+
+//
+// ListRunTransitions returns the status transitions of a run, oldest first
+//
+func (mm *MemoryStateManager) ListRunTransitions(runID string) (RunStatusTransitionList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	transitions := mm.transitions[runID]
+	return RunStatusTransitionList{Total: len(transitions), Transitions: transitions}, nil
+}
+
+//
+// CreateExecutableSnapshot stores an executable snapshot, keeping the first
+// copy stored under a given id
+//
+func (mm *MemoryStateManager) CreateExecutableSnapshot(s ExecutableSnapshot) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.snapshots[s.SnapshotID]; ok {
+		return nil
+	}
+	if s.CreatedAt == nil {
+		now := time.Now()
+		s.CreatedAt = &now
+	}
+	mm.snapshots[s.SnapshotID] = s
+	return nil
+}
+
+//
+// GetExecutableSnapshot gets an executable snapshot by id
+//
+func (mm *MemoryStateManager) GetExecutableSnapshot(snapshotID string) (ExecutableSnapshot, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	s, ok := mm.snapshots[snapshotID]
+	if !ok {
+		return s, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Executable snapshot with id %s not found", snapshotID)}
+	}
+	return s, nil
+}
+
+//
+// CreateAuditEvent stores an audit event
+//
+func (mm *MemoryStateManager) CreateAuditEvent(e AuditEvent) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if e.CreatedAt == nil {
+		now := time.Now()
+		e.CreatedAt = &now
+	}
+	mm.audit = append(mm.audit, e)
+	return nil
+}
+
+//
+// ListAuditEvents returns an AuditEventList
+//
+func (mm *MemoryStateManager) ListAuditEvents(limit int, offset int, sortBy string, order string, filters map[string][]string) (AuditEventList, error) {
+	var result AuditEventList
+
+	if err := mm.validateOrder(&AuditEvent{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	parsed, err := parseFilters(auditFilterColumns, filters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, e := range mm.audit {
+		if mm.matchesFilters(e, auditColumns, parsed) {
+			matched = append(matched, e)
+		}
+	}
+	mm.sortByColumn(matched, auditColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, e := range matched[start:end] {
+		result.Events = append(result.Events, e.(AuditEvent))
+	}
+	return result, nil
+}
+
+//
+// CreateSchedule stores a schedule
+//
+func (mm *MemoryStateManager) CreateSchedule(s Schedule) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.schedules[s.ScheduleID]; ok {
+		return exceptions.ConflictingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s already exists", s.ScheduleID)}
+	}
+	now := time.Now()
+	enabled := s.IsEnabled()
+	s.Enabled = &enabled
+	s.CreatedAt = &now
+	s.UpdatedAt = &now
+	mm.schedules[s.ScheduleID] = s
+	return nil
+}
+
+//
+// GetSchedule gets a schedule by id
+//
+func (mm *MemoryStateManager) GetSchedule(scheduleID string) (Schedule, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	s, ok := mm.schedules[scheduleID]
+	if !ok {
+		return s, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+	}
+	return s, nil
+}
+
+//
+// ListSchedules returns a ScheduleList
+//
+func (mm *MemoryStateManager) ListSchedules(limit int, offset int, sortBy string, order string, filters map[string][]string) (ScheduleList, error) {
+	var result ScheduleList
+
+	if err := mm.validateOrder(&Schedule{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	parsed, err := parseFilters(scheduleFilterColumns, filters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, s := range mm.schedules {
+		if mm.matchesFilters(s, scheduleColumns, parsed) {
+			matched = append(matched, s)
+		}
+	}
+	mm.sortByColumn(matched, scheduleColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, s := range matched[start:end] {
+		result.Schedules = append(result.Schedules, s.(Schedule))
+	}
+	return result, nil
+}
+
+//
+// UpdateSchedule applies updates to a schedule
+//
+func (mm *MemoryStateManager) UpdateSchedule(scheduleID string, updates Schedule) (Schedule, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	existing, ok := mm.schedules[scheduleID]
+	if !ok {
+		return existing, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+	}
+	existing.UpdateWith(updates)
+	now := time.Now()
+	existing.UpdatedAt = &now
+	mm.schedules[scheduleID] = existing
+	return existing, nil
+}
+
+//
+// DeleteSchedule deletes a schedule
+//
+func (mm *MemoryStateManager) DeleteSchedule(scheduleID string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.schedules[scheduleID]; !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+	}
+	delete(mm.schedules, scheduleID)
+	return nil
+}
+
+//
+// ClaimDueSchedules returns up to limit enabled schedules due at or before
+// now, advancing each to its next fire time
+//
+func (mm *MemoryStateManager) ClaimDueSchedules(now time.Time, limit int) ([]Schedule, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var due []Schedule
+	for _, s := range mm.schedules {
+		if s.IsEnabled() && s.NextRunAt != nil && !s.NextRunAt.After(now) {
+			due = append(due, s)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextRunAt.Before(*due[j].NextRunAt) })
+	if limit >= 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, s := range due {
+		claimed := mm.schedules[s.ScheduleID]
+		claimed.LastRunAt = s.NextRunAt
+		claimed.NextRunAt = nil
+		if next, err := s.NextAfter(now); err == nil {
+			claimed.NextRunAt = &next
+		}
+		mm.schedules[s.ScheduleID] = claimed
+	}
+	return due, nil
+}
+
+//
+// RecordScheduleRun links a schedule to the last run it created
+//
+func (mm *MemoryStateManager) RecordScheduleRun(scheduleID string, runID string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	s, ok := mm.schedules[scheduleID]
+	if !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+	}
+	s.LastRunID = &runID
+	mm.schedules[scheduleID] = s
+	return nil
+}
+
+//
+// ClaimDueRetries returns up to limit stopped runs whose retry is due at or
+// before now, marking each retried
+//
+func (mm *MemoryStateManager) ClaimDueRetries(now time.Time, limit int) ([]Run, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var due []Run
+	for _, r := range mm.runs {
+		if r.RetryState == RetryStateScheduled && r.RetryAt != nil && !r.RetryAt.After(now) {
+			due = append(due, r)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RetryAt.Before(*due[j].RetryAt) })
+	if limit >= 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, r := range due {
+		claimed := mm.runs[r.RunID]
+		claimed.RetryState = RetryStateRetried
+		mm.runs[r.RunID] = claimed
+	}
+	return due, nil
+}
+
+//
+// RecordRetryRun links a run to the attempt that retried it
+//
+func (mm *MemoryStateManager) RecordRetryRun(runID string, retryRunID string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	r, ok := mm.runs[runID]
+	if !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Run with id %s not found", runID)}
+	}
+	r.RetryRunID = &retryRunID
+	mm.runs[runID] = r
+	return nil
+}
+
+//
+// RescheduleRetry schedules the retry of a run claimed by ClaimDueRetries
+// again at retryAt, for when its attempt couldn't be queued. Runs whose
+// attempt has been recorded are left alone.
+//
+func (mm *MemoryStateManager) RescheduleRetry(runID string, retryAt time.Time) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	r, ok := mm.runs[runID]
+	if !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Run with id %s not found", runID)}
+	}
+	if r.RetryState == RetryStateRetried && r.RetryRunID == nil {
+		r.RetryState = RetryStateScheduled
+		r.RetryAt = &retryAt
+		mm.runs[runID] = r
+	}
+	return nil
+}
+
+//
+// ReleaseArrayRuns marks held children of the array run parentID queued,
+// lowest index first, until maxActive children are queued or running; a
+// maxActive of zero releases every held child
+//
+func (mm *MemoryStateManager) ReleaseArrayRuns(parentID string, maxActive int, now time.Time) ([]Run, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if parent, ok := mm.runs[parentID]; !ok || parent.TaskType != ArrayTaskType {
+		return nil, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Array run with id %s not found", parentID)}
+	}
+
+	var held []Run
+	active := 0
+	for _, r := range mm.runs {
+		if r.ArrayParentID == nil || *r.ArrayParentID != parentID {
+			continue
+		}
+		switch {
+		case IsHeldArrayRun(r):
+			held = append(held, r)
+		case (r.Status != StatusStopped && r.QueuedAt != nil) || r.RetryState == RetryStateScheduled:
+			active++
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return *held[i].ArrayIndex < *held[j].ArrayIndex })
+
+	limit := len(held)
+	if maxActive > 0 && maxActive-active < limit {
+		limit = maxActive - active
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	released := held[:limit]
+	for i := range released {
+		released[i].QueuedAt = &now
+		mm.runs[released[i].RunID] = released[i]
+	}
+	return released, nil
+}
+
+//
+// ClaimIdempotencyKey stores k unless its owner used the same key within
+// window, and returns the stored key
+//
+func (mm *MemoryStateManager) ClaimIdempotencyKey(k IdempotencyKey, window time.Duration) (IdempotencyKey, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	id := idempotencyKeyID(k)
+	if stored, ok := mm.idempotencyKeys[id]; ok && !stored.CreatedAt.Before(k.CreatedAt.Add(-window)) {
+		return stored, nil
+	}
+	mm.idempotencyKeys[id] = k
+	return k, nil
+}
+
+//
+// ReleaseIdempotencyKey deletes k if it is still held by k's run
+//
+func (mm *MemoryStateManager) ReleaseIdempotencyKey(k IdempotencyKey) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	id := idempotencyKeyID(k)
+	if stored, ok := mm.idempotencyKeys[id]; ok && stored.RunID == k.RunID {
+		delete(mm.idempotencyKeys, id)
+	}
+	return nil
+}
+
+func idempotencyKeyID(k IdempotencyKey) string {
+	return fmt.Sprintf("%s/%s", k.OwnerID, k.Key)
+}
+
+//
+// PutQuota stores a quota, replacing the limits of an existing quota with
+// the same scope and name
+//
+func (mm *MemoryStateManager) PutQuota(q Quota) (Quota, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	now := time.Now()
+	q.CreatedAt = &now
+	if existing, ok := mm.quotas[q.Key()]; ok {
+		q.CreatedAt = existing.CreatedAt
+	}
+	q.UpdatedAt = &now
+	mm.quotas[q.Key()] = q
+	return q, nil
+}
+
+//
+// GetQuota gets a quota by scope and name
+//
+func (mm *MemoryStateManager) GetQuota(scope string, name string) (Quota, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	q, ok := mm.quotas[(&Quota{Scope: scope, Name: name}).Key()]
+	if !ok {
+		return q, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Quota %s/%s not found", scope, name)}
+	}
+	return q, nil
+}
+
+//
+// ListQuotas returns a QuotaList
+//
+func (mm *MemoryStateManager) ListQuotas(limit int, offset int, sortBy string, order string, filters map[string][]string) (QuotaList, error) {
+	var result QuotaList
+
+	if err := mm.validateOrder(&Quota{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	parsed, err := parseFilters(quotaFilterColumns, filters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, q := range mm.quotas {
+		if mm.matchesFilters(q, quotaColumns, parsed) {
+			matched = append(matched, q)
+		}
+	}
+	mm.sortByColumn(matched, quotaColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, q := range matched[start:end] {
+		result.Quotas = append(result.Quotas, q.(Quota))
+	}
+	return result, nil
+}
+
+//
+// DeleteQuota deletes a quota
+//
+func (mm *MemoryStateManager) DeleteQuota(scope string, name string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	key := (&Quota{Scope: scope, Name: name}).Key()
+	if _, ok := mm.quotas[key]; !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Quota %s/%s not found", scope, name)}
+	}
+	delete(mm.quotas, key)
+	return nil
+}
+
+//
+// GetQuotaUsage sums the PENDING and RUNNING runs matching q and counts the
+// QUEUED runs matching q that are held back by quotas
+//
+func (mm *MemoryStateManager) GetQuotaUsage(q Quota) (QuotaUsage, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var usage QuotaUsage
+	for _, r := range mm.runs {
+		if !q.Matches(r) {
+			continue
+		}
+		switch {
+		case r.Status == StatusPending || r.Status == StatusRunning:
+			usage.Add(r)
+		case r.Status == StatusQueued && r.QuotaHeldAt != nil:
+			usage.Held++
+		}
+	}
+	return usage, nil
+}
+
+//
+// ListQuotaHeldRuns returns up to limit queued runs held back by quotas,
+// oldest first
+//
+func (mm *MemoryStateManager) ListQuotaHeldRuns(limit int) ([]Run, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var held []Run
+	for _, r := range mm.runs {
+		if r.Status == StatusQueued && r.QuotaHeldAt != nil {
+			held = append(held, r)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].QueuedAt == nil || held[j].QueuedAt == nil {
+			return held[i].QueuedAt != nil
+		}
+		return held[i].QueuedAt.Before(*held[j].QueuedAt)
+	})
+	if limit >= 0 && len(held) > limit {
+		held = held[:limit]
+	}
+	return held, nil
+}
+
+//
+// HoldRunForQuota marks a queued run held back by quotas
+//
+func (mm *MemoryStateManager) HoldRunForQuota(runID string, heldAt time.Time) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if r, ok := mm.runs[runID]; ok && r.Status == StatusQueued {
+		r.QuotaHeldAt = &heldAt
+		mm.runs[runID] = r
+	}
+	return nil
+}
+
+//
+// ReleaseQuotaHold clears the quota hold of a run and returns whether it was
+// held
+//
+func (mm *MemoryStateManager) ReleaseQuotaHold(runID string) (bool, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	r, ok := mm.runs[runID]
+	if !ok || r.QuotaHeldAt == nil {
+		return false, nil
+	}
+	r.QuotaHeldAt = nil
+	mm.runs[runID] = r
+	return true, nil
+}
+
+//
+// CreateWebhook stores a new webhook
+//
+func (mm *MemoryStateManager) CreateWebhook(w Webhook) (Webhook, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	now := time.Now()
+	w.CreatedAt = &now
+	mm.webhooks[w.WebhookID] = w
+	return w, nil
+}
+
+//
+// GetWebhook gets a webhook by id
+//
+func (mm *MemoryStateManager) GetWebhook(webhookID string) (Webhook, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	w, ok := mm.webhooks[webhookID]
+	if !ok {
+		return w, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Webhook with id %s not found", webhookID)}
+	}
+	return w, nil
+}
+
+//
+// ListWebhooks returns a WebhookList
+//
+func (mm *MemoryStateManager) ListWebhooks(limit int, offset int, sortBy string, order string, filters map[string][]string) (WebhookList, error) {
+	var result WebhookList
+
+	if err := mm.validateOrder(&Webhook{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	parsed, err := parseFilters(webhookFilterColumns, filters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, w := range mm.webhooks {
+		if mm.matchesFilters(w, webhookColumns, parsed) {
+			matched = append(matched, w)
+		}
+	}
+	mm.sortByColumn(matched, webhookColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, w := range matched[start:end] {
+		result.Webhooks = append(result.Webhooks, w.(Webhook))
+	}
+	return result, nil
+}
+
+//
+// DeleteWebhook deletes a webhook along with its deliveries
+//
+func (mm *MemoryStateManager) DeleteWebhook(webhookID string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.webhooks[webhookID]; !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Webhook with id %s not found", webhookID)}
+	}
+	delete(mm.webhooks, webhookID)
+	for id, d := range mm.deliveries {
+		if d.WebhookID == webhookID {
+			delete(mm.deliveries, id)
+		}
+	}
+	return nil
+}
+
+//
+// ListRunWebhooks returns the webhooks whose scope covers run, oldest first
+//
+func (mm *MemoryStateManager) ListRunWebhooks(run Run) ([]Webhook, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var hooks []Webhook
+	for _, w := range mm.webhooks {
+		if w.Matches(run) {
+			hooks = append(hooks, w)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(*hooks[j].CreatedAt) })
+	return hooks, nil
+}
+
+//
+// CreateWebhookDelivery queues a delivery and returns whether it was queued;
+// a delivery of an event the webhook already got for the same transition of
+// the run is dropped
+//
+func (mm *MemoryStateManager) CreateWebhookDelivery(d WebhookDelivery) (bool, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if d.Event != WebhookEventTest {
+		for _, existing := range mm.deliveries {
+			if existing.WebhookID == d.WebhookID && existing.RunID == d.RunID && existing.Event == d.Event &&
+				existing.Sequence == d.Sequence {
+				return false, nil
+			}
+		}
+	}
+	now := time.Now()
+	d.CreatedAt = &now
+	mm.deliveries[d.DeliveryID] = d
+	return true, nil
+}
+
+//
+// ClaimWebhookDeliveries returns up to limit pending deliveries due at or
+// before now and leases them for lease
+//
+func (mm *MemoryStateManager) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var due []WebhookDelivery
+	for _, d := range mm.deliveries {
+		if d.Status == WebhookDeliveryPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(*due[j].NextAttemptAt) })
+	if limit >= 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	leasedUntil := now.Add(lease)
+	for _, d := range due {
+		d.NextAttemptAt = &leasedUntil
+		mm.deliveries[d.DeliveryID] = d
+	}
+	return due, nil
+}
+
+//
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+//
+func (mm *MemoryStateManager) UpdateWebhookDelivery(d WebhookDelivery) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	existing, ok := mm.deliveries[d.DeliveryID]
+	if !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Webhook delivery with id %s not found", d.DeliveryID)}
+	}
+	existing.Status = d.Status
+	existing.Attempts = d.Attempts
+	existing.LastError = d.LastError
+	existing.NextAttemptAt = d.NextAttemptAt
+	existing.DeliveredAt = d.DeliveredAt
+	mm.deliveries[d.DeliveryID] = existing
+	return nil
+}
+
+//
+// ListWebhookDeliveries returns the deliveries of a webhook, newest first
+//
+func (mm *MemoryStateManager) ListWebhookDeliveries(webhookID string, limit int, offset int) (WebhookDeliveryList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var result WebhookDeliveryList
+	var matched []WebhookDelivery
+	for _, d := range mm.deliveries {
+		if d.WebhookID == webhookID {
+			matched = append(matched, d)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].CreatedAt.After(*matched[j].CreatedAt) })
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	result.Deliveries = matched[start:end]
+	return result, nil
+}
+
+//
+// ListExitReasonRules returns the stored exit reason rules in order
+//
+func (mm *MemoryStateManager) ListExitReasonRules() (ExitReasonRules, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return append(ExitReasonRules(nil), mm.exitReasonRules...), nil
+}
+
+//
+// PutExitReasonRules replaces the stored exit reason rules with rules
+//
+func (mm *MemoryStateManager) PutExitReasonRules(rules ExitReasonRules) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.exitReasonRules = append(ExitReasonRules(nil), rules...)
+	return nil
+}
+
+//
+// CountRunsByExitCategory counts the runs matching filters in each exit
+// category, most common first; runs without a category aren't counted
+//
+func (mm *MemoryStateManager) CountRunsByExitCategory(filters map[string][]string) ([]ExitCategoryCount, error) {
+	fieldFilters, labelFilters := splitLabelFilters(filters)
+	parsed, err := parseFilters(runFilterColumns, fieldFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	byCategory := make(map[string]int)
+	for _, r := range mm.runs {
+		if r.ExitCategory != nil && mm.matchesFilters(r, runColumns, parsed) && mm.matchesLabelFilters(r.Labels, labelFilters) {
+			byCategory[*r.ExitCategory]++
+		}
+	}
+
+	counts := []ExitCategoryCount{}
+	for category, count := range byCategory {
+		counts = append(counts, ExitCategoryCount{Category: category, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Category < counts[j].Category
+	})
+	return counts, nil
+}
+
+//
+// CreateWorkflow stores a workflow
+//
+func (mm *MemoryStateManager) CreateWorkflow(w Workflow) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, ok := mm.workflows[w.WorkflowID]; ok {
+		return exceptions.ConflictingResource{
+			ErrorString: fmt.Sprintf("Workflow with id %s already exists", w.WorkflowID)}
+	}
+	now := time.Now()
+	w.CreatedAt = &now
+	w.UpdatedAt = &now
+	mm.workflows[w.WorkflowID] = copyWorkflow(w)
+	return nil
+}
+
+//
+// GetWorkflow gets a workflow by id
+//
+func (mm *MemoryStateManager) GetWorkflow(workflowID string) (Workflow, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	w, ok := mm.workflows[workflowID]
+	if !ok {
+		return w, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Workflow with id %s not found", workflowID)}
+	}
+	return copyWorkflow(w), nil
+}
+
+//
+// ListWorkflows returns a WorkflowList
+//
+func (mm *MemoryStateManager) ListWorkflows(limit int, offset int, sortBy string, order string, filters map[string][]string) (WorkflowList, error) {
+	var result WorkflowList
+
+	if err := mm.validateOrder(&Workflow{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	parsed, err := parseFilters(workflowFilterColumns, filters)
+	if err != nil {
+		return result, err
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var matched []interface{}
+	for _, w := range mm.workflows {
+		if mm.matchesFilters(w, workflowColumns, parsed) {
+			matched = append(matched, w)
+		}
+	}
+	mm.sortByColumn(matched, workflowColumns[sortBy], order)
+
+	result.Total = len(matched)
+	start, end := paginate(len(matched), limit, offset)
+	for _, w := range matched[start:end] {
+		result.Workflows = append(result.Workflows, copyWorkflow(w.(Workflow)))
+	}
+	return result, nil
+}
+
+//
+// ClaimActiveWorkflows leases up to limit running workflows that aren't
+// leased until now+lease
+//
+func (mm *MemoryStateManager) ClaimActiveWorkflows(now time.Time, lease time.Duration, limit int) ([]Workflow, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var active []Workflow
+	for id, w := range mm.workflows {
+		if until, leased := mm.leases[id]; w.Status != WorkflowStatusRunning || (leased && until.After(now)) {
+			continue
+		}
+		active = append(active, copyWorkflow(w))
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].UpdatedAt.Before(*active[j].UpdatedAt) })
+	if limit >= 0 && len(active) > limit {
+		active = active[:limit]
+	}
+
+	for _, w := range active {
+		mm.leases[w.WorkflowID] = now.Add(lease)
+	}
+	return active, nil
+}
+
+//
+// UpdateWorkflow saves the status and nodes of a workflow and releases its
+// lease
+//
+func (mm *MemoryStateManager) UpdateWorkflow(w Workflow) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	existing, ok := mm.workflows[w.WorkflowID]
+	if !ok {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Workflow with id %s not found", w.WorkflowID)}
+	}
+	now := time.Now()
+	existing.Status = w.Status
+	existing.Nodes = w.Nodes
+	existing.FinishedAt = w.FinishedAt
+	existing.UpdatedAt = &now
+	mm.workflows[w.WorkflowID] = copyWorkflow(existing)
+	delete(mm.leases, w.WorkflowID)
+	return nil
+}
+
+//
+// RequestWorkflowCancel flags a running workflow for cancellation
+//
+func (mm *MemoryStateManager) RequestWorkflowCancel(workflowID string) (Workflow, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	w, ok := mm.workflows[workflowID]
+	if !ok {
+		return w, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Workflow with id %s not found", workflowID)}
+	}
+	if w.Status == WorkflowStatusRunning {
+		now := time.Now()
+		w.CancelRequested = true
+		w.UpdatedAt = &now
+		mm.workflows[workflowID] = w
+	}
+	return copyWorkflow(w), nil
+}
+
+//
+// copyWorkflow copies the nodes of a workflow so callers can't modify a
+// stored workflow in place
+//
+func copyWorkflow(w Workflow) Workflow {
+	nodes := make(WorkflowNodes, len(w.Nodes))
+	copy(nodes, w.Nodes)
+	w.Nodes = nodes
+	return w
+}
+
+//
+// distinctMatching returns the sorted, de-duplicated values containing name
+//
+func distinctMatching(values []string, limit int, offset int, name *string) ([]string, int) {
+	seen := make(map[string]bool)
+	var matched []string
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		if name != nil && len(*name) > 0 && !strings.Contains(v, *name) {
+			continue
+		}
+		matched = append(matched, v)
+	}
+	sort.Strings(matched)
+	start, end := paginate(len(matched), limit, offset)
+	return matched[start:end], len(matched)
+}
+
+//
+// ListGroups returns a list of the existing group names.
+//
+func (mm *MemoryStateManager) ListGroups(limit int, offset int, name *string) (GroupsList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var groups []string
+	for _, d := range mm.definitions {
+		groups = append(groups, d.GroupName)
+	}
+
+	var result GroupsList
+	result.Groups, result.Total = distinctMatching(groups, limit, offset, name)
+	return result, nil
+}
+
+//
+// ListTags returns a list of the existing tags.
+//
+func (mm *MemoryStateManager) ListTags(limit int, offset int, name *string) (TagsList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var tags []string
+	for _, d := range mm.definitions {
+		if d.Tags != nil {
+			tags = append(tags, *d.Tags...)
+		}
+	}
+
+	var result TagsList
+	result.Tags, result.Total = distinctMatching(tags, limit, offset, name)
+	return result, nil
+}
+
+//
+// ListWorkers returns list of workers
+//
+func (mm *MemoryStateManager) ListWorkers(engine string) (WorkersList, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var result WorkersList
+	for _, w := range mm.workers {
+		if w.Engine == engine {
+			result.Workers = append(result.Workers, w)
+		}
+	}
+	result.Total = len(result.Workers)
+	return result, nil
+}
+
+//
+// GetWorker returns data for a single worker.
+//
+func (mm *MemoryStateManager) GetWorker(workerType string, engine string) (Worker, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	for _, w := range mm.workers {
+		if w.WorkerType == workerType && w.Engine == engine {
+			return w, nil
+		}
+	}
+	return Worker{}, exceptions.MissingResource{
+		ErrorString: fmt.Sprintf("Worker of type %s not found", workerType)}
+}
+
+//
+// UpdateWorker updates a single worker.
+//
+func (mm *MemoryStateManager) UpdateWorker(workerType string, updates Worker) (Worker, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for i, w := range mm.workers {
+		if w.WorkerType == workerType && w.Engine == DefaultEngine {
+			w.UpdateWith(updates)
+			mm.workers[i] = w
+			return w, nil
+		}
+	}
+	return Worker{}, exceptions.MissingResource{
+		ErrorString: fmt.Sprintf("Worker of type %s not found", workerType)}
+}
+
+//
+// BatchUpdateWorkers updates multiple workers.
+//
+func (mm *MemoryStateManager) BatchUpdateWorkers(updates []Worker) (WorkersList, error) {
+	var existing WorkersList
+
+	for _, w := range updates {
+		if _, err := mm.UpdateWorker(w.WorkerType, w); err != nil {
+			return existing, err
+		}
+	}
+
+	return mm.ListWorkers(DefaultEngine)
+}
+
+//
+// GetExecutableByTypeAndID returns a single executable by id.
+//
+func (mm *MemoryStateManager) GetExecutableByTypeAndID(t ExecutableType, id string) (Executable, error) {
+	switch t {
+	case ExecutableTypeDefinition:
+		return mm.GetDefinition(id)
+	case ExecutableTypeTemplate:
+		return mm.GetTemplateByID(id)
+	default:
+		return nil, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("executable type of [%s] not valid.", t),
+		}
+	}
+}
+
+// GetTemplateByID returns a single template by id.
+func (mm *MemoryStateManager) GetTemplateByID(templateID string) (Template, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	tpl, ok := mm.templates[templateID]
+	if !ok {
+		return tpl, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Template with ID %s not found", templateID)}
+	}
+	return tpl, nil
+}
+
+// GetLatestTemplateByTemplateName returns the latest version of a template
+// of a specific template name.
+func (mm *MemoryStateManager) GetLatestTemplateByTemplateName(templateName string) (bool, Template, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var (
+		latest Template
+		found  bool
+	)
+	for _, t := range mm.templates {
+		if t.TemplateName == templateName && (!found || t.Version > latest.Version) {
+			latest = t
+			found = true
+		}
+	}
+	return found, latest, nil
+}
+
+// GetTemplateByVersion returns a specific version of a template.
+func (mm *MemoryStateManager) GetTemplateByVersion(templateName string, templateVersion int64) (bool, Template, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	for _, t := range mm.templates {
+		if t.TemplateName == templateName && t.Version == templateVersion {
+			return true, t, nil
+		}
+	}
+	return false, Template{}, nil
+}
+
+// ListTemplates returns list of templates.
+func (mm *MemoryStateManager) ListTemplates(limit int, offset int, sortBy string, order string) (TemplateList, error) {
+	var result TemplateList
+
+	if err := mm.validateOrder(&Template{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	var all []interface{}
+	for _, t := range mm.templates {
+		all = append(all, t)
+	}
+	mm.sortByColumn(all, templateColumns[sortBy], order)
+
+	result.Total = len(all)
+	start, end := paginate(len(all), limit, offset)
+	for _, t := range all[start:end] {
+		result.Templates = append(result.Templates, t.(Template))
+	}
+	return result, nil
+}
+
+// ListTemplatesLatestOnly returns the latest version of each distinct
+// template name.
+func (mm *MemoryStateManager) ListTemplatesLatestOnly(limit int, offset int, sortBy string, order string) (TemplateList, error) {
+	var result TemplateList
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	latest := make(map[string]Template)
+	for _, t := range mm.templates {
+		if prev, ok := latest[t.TemplateName]; !ok || t.Version > prev.Version {
+			latest[t.TemplateName] = t
+		}
+	}
+
+	var all []interface{}
+	for _, t := range latest {
+		all = append(all, t)
+	}
+	mm.sortByColumn(all, templateColumns["template_name"], "asc")
+
+	result.Total = len(all)
+	start, end := paginate(len(all), limit, offset)
+	for _, t := range all[start:end] {
+		result.Templates = append(result.Templates, t.(Template))
+	}
+	return result, nil
+}
+
+// CreateTemplate creates a new template.
+func (mm *MemoryStateManager) CreateTemplate(t Template) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for _, existing := range mm.templates {
+		if existing.TemplateName == t.TemplateName && existing.Version == t.Version {
+			return errors.Errorf(
+				"issue creating new template with template_name [%s] and version [%d]: already exists", t.TemplateName, t.Version)
+		}
+	}
+	mm.templates[t.TemplateID] = t
+	return nil
+}
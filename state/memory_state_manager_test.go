@@ -0,0 +1,819 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+func setUpMemory(t *testing.T) Manager {
+	sm := &MemoryStateManager{}
+	if err := sm.Initialize(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := int64(1024)
+	for _, d := range []Definition{
+		{DefinitionID: "A", Alias: "aliasA", GroupName: "groupZ", ExecutableResources: ExecutableResources{
+			Image: "imageA", Memory: &mem, Env: &EnvList{{Name: "E_A1", Value: "V_A1"}}, Tags: &Tags{"tagA"}}},
+		{DefinitionID: "B", Alias: "aliasB", GroupName: "groupY", ExecutableResources: ExecutableResources{
+			Image: "imageB", Memory: &mem, Tags: &Tags{"tagA", "tagB"}}},
+		{DefinitionID: "C", Alias: "aliasC", GroupName: "groupX", ExecutableResources: ExecutableResources{
+			Image: "imageC", Memory: &mem}},
+	} {
+		if err := sm.CreateDefinition(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine := DefaultEngine
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, r := range []Run{
+		{RunID: "run0", DefinitionID: "A", ClusterName: "clusta", Status: StatusStopped,
+			Env: &EnvList{{Name: "E0", Value: "V0"}}, Labels: RunLabels{"pipeline": "nightly"}},
+		{RunID: "run1", DefinitionID: "B", ClusterName: "clusta", Status: StatusRunning,
+			Env:    &EnvList{{Name: "E1", Value: "V1"}, {Name: "E2", Value: "V2"}},
+			Labels: RunLabels{"pipeline": "hourly", "team": "data"}},
+		{RunID: "run2", DefinitionID: "B", ClusterName: "clustb", Status: StatusQueued},
+	} {
+		startedAt := t0.Add(time.Duration(i) * time.Hour)
+		r.StartedAt = &startedAt
+		r.Engine = &engine
+		if err := sm.CreateRun(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sm
+}
+
+func TestMemoryStateManager_ListDefinitions(t *testing.T) {
+	sm := setUpMemory(t)
+
+	dl, err := sm.ListDefinitions(2, 0, "group_name", "asc", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Total != 3 {
+		t.Errorf("Expected total to be 3 but was %v", dl.Total)
+	}
+	if len(dl.Definitions) != 2 || dl.Definitions[0].DefinitionID != "C" {
+		t.Errorf("Expected first of 2 definitions to be C, got %v", dl.Definitions)
+	}
+
+	dl, _ = sm.ListDefinitions(10, 0, "alias", "asc", map[string][]string{"image": {"geB"}}, nil)
+	if dl.Total != 1 || dl.Definitions[0].DefinitionID != "B" {
+		t.Errorf("Expected like filter on image to return B, got %v", dl.Definitions)
+	}
+
+	dl, _ = sm.ListDefinitions(10, 0, "alias", "asc", nil, map[string]string{"E_A1": "V_A1"})
+	if dl.Total != 1 || dl.Definitions[0].DefinitionID != "A" {
+		t.Errorf("Expected env filter to return A, got %v", dl.Definitions)
+	}
+
+	dl, _ = sm.ListDefinitions(10, 0, "alias", "asc", map[string][]string{"tag": {"tagB"}}, nil)
+	if dl.Total != 1 || dl.Definitions[0].DefinitionID != "B" {
+		t.Errorf("Expected tag filter to return B, got %v", dl.Definitions)
+	}
+
+	if _, err = sm.ListDefinitions(10, 0, "nonexistent_field", "asc", nil, nil); err == nil {
+		t.Errorf("Sorting by [nonexistent_field] did not produce an error")
+	}
+}
+
+func TestMemoryStateManager_ListRuns(t *testing.T) {
+	sm := setUpMemory(t)
+
+	rl, err := sm.ListRuns(1, 0, "started_at", "desc", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Total != 3 {
+		t.Errorf("Expected total to be 3 but was %v", rl.Total)
+	}
+	if len(rl.Runs) != 1 || rl.Runs[0].RunID != "run2" {
+		t.Errorf("Expected run2 first when ordering by started_at desc, got %v", rl.Runs)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"status": {StatusRunning, StatusQueued}}, nil, nil)
+	if rl.Total != 2 {
+		t.Errorf("Expected 2 runs matching multiple statuses but was %v", rl.Total)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"started_at_since": {"2020-01-01T00:30:00Z"}}, nil, nil)
+	if rl.Total != 2 || rl.Runs[0].RunID != "run1" {
+		t.Errorf("Expected started_at_since to exclude run0, got %v", rl.Runs)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc", nil, map[string]string{"E2": "V2"}, nil)
+	if rl.Total != 1 || rl.Runs[0].RunID != "run1" {
+		t.Errorf("Expected env filter to return run1, got %v", rl.Runs)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"label.pipeline": {"nightly", "hourly"}, "label.team": {"data"}}, nil, nil)
+	if rl.Total != 1 || rl.Runs[0].RunID != "run1" {
+		t.Errorf("Expected label filters to return run1, got %v", rl.Runs)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"label.pipeline": {"nightly"}}, nil, nil)
+	if rl.Total != 1 || rl.Runs[0].RunID != "run0" {
+		t.Errorf("Expected label filter to return run0, got %v", rl.Runs)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"status_not_in": {StatusStopped}, "cluster_name": {"clusta"}}, nil, nil)
+	if rl.Total != 1 || rl.Runs[0].RunID != "run1" {
+		t.Errorf("Expected status_not_in to return run1, got %v", rl.Runs)
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"exit_code_is_null": {"true"}}, nil, nil)
+	if rl.Total != 3 {
+		t.Errorf("Expected all runs to have a null exit code but was %v", rl.Total)
+	}
+
+	if _, err = sm.ListRuns(10, 0, "run_id", "asc",
+		map[string][]string{"exit_code_gt": {"zero"}}, nil, nil); err == nil {
+		t.Errorf("Expected a non numeric exit_code_gt to produce an error")
+	}
+
+	rl, _ = sm.ListRuns(10, 0, "run_id", "asc", nil, nil, []string{EKSSparkEngine})
+	if rl.Total != 0 {
+		t.Errorf("Expected no runs for engine %s but was %v", EKSSparkEngine, rl.Total)
+	}
+}
+
+func TestMemoryStateManager_ListRunsByCursor(t *testing.T) {
+	sm := setUpMemory(t)
+
+	var seen []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		rl, err := sm.ListRunsByCursor(2, cursor, "cluster_name", "asc", nil, nil, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rl.Total != 3 {
+			t.Errorf("Expected total to be 3 but was %v", rl.Total)
+		}
+		for _, r := range rl.Runs {
+			seen = append(seen, r.RunID)
+		}
+		if rl.NextCursor == nil {
+			break
+		}
+		cursor = *rl.NextCursor
+	}
+
+	expected := []string{"run0", "run1", "run2"}
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected to page through %v but got %v", expected, seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Errorf("Expected run %d to be %s but was %s", i, expected[i], seen[i])
+		}
+	}
+
+	if _, err := sm.ListRunsByCursor(2, cursor, "started_at", "asc", nil, nil, nil, false); err == nil {
+		t.Errorf("Expected a cursor reused with a different sort_by to produce an error")
+	}
+	if _, err := sm.ListRunsByCursor(2, "garbage", "cluster_name", "asc", nil, nil, nil, false); err == nil {
+		t.Errorf("Expected an invalid cursor to produce an error")
+	}
+}
+
+func TestMemoryStateManager_UpdateRun(t *testing.T) {
+	sm := setUpMemory(t)
+
+	exitCode := int64(1)
+	updated, err := sm.UpdateRun("run1", Run{Status: StatusStopped, ExitCode: &exitCode}, TransitionSourceAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != StatusStopped || *updated.ExitCode != exitCode {
+		t.Errorf("Expected run1 to be stopped with exit code 1, got %v", updated)
+	}
+
+	if _, err = sm.UpdateRun("nope", Run{}, TransitionSourceAPI); err == nil {
+		t.Errorf("Expected updating a missing run to produce an error")
+	}
+}
+
+func TestMemoryStateManager_RunTransitions(t *testing.T) {
+	sm := setUpMemory(t)
+
+	if _, err := sm.UpdateRun("run0", Run{Status: StatusRunning}, TransitionSourceStatusWorker); !IsIllegalTransition(err) {
+		t.Errorf("Expected moving a stopped run back to running to be rejected, got %v", err)
+	}
+	if IsIllegalTransition(exceptions.ConflictingResource{ErrorString: "conflict"}) {
+		t.Errorf("Expected other conflicts not to be illegal transitions")
+	}
+
+	for _, status := range []string{StatusPending, StatusRunning, StatusRunning, StatusStopped} {
+		if _, err := sm.UpdateRun("run2", Run{Status: status}, TransitionSourceStatusWorker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transitions, err := sm.ListRunTransitions("run2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][3]string{
+		{"", StatusQueued, TransitionSourceAPI},
+		{StatusQueued, StatusPending, TransitionSourceStatusWorker},
+		{StatusPending, StatusRunning, TransitionSourceStatusWorker},
+		{StatusRunning, StatusStopped, TransitionSourceStatusWorker},
+	}
+	if transitions.Total != len(expected) {
+		t.Fatalf("Expected %d transitions but got %v", len(expected), transitions.Transitions)
+	}
+	for i, e := range expected {
+		tr := transitions.Transitions[i]
+		if tr.FromStatus != e[0] || tr.ToStatus != e[1] || tr.Source != e[2] {
+			t.Errorf("Expected transition %d to be %s -> %s by %s, got %v", i, e[0], e[1], e[2], tr)
+		}
+	}
+}
+
+func TestMemoryStateManager_Templates(t *testing.T) {
+	sm := setUpMemory(t)
+
+	for _, tpl := range []Template{
+		{TemplateID: "tpl-a1", TemplateName: "tpl-a", Version: 1},
+		{TemplateID: "tpl-a2", TemplateName: "tpl-a", Version: 2},
+		{TemplateID: "tpl-b1", TemplateName: "tpl-b", Version: 1},
+	} {
+		if err := sm.CreateTemplate(tpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, latest, err := sm.GetLatestTemplateByTemplateName("tpl-a")
+	if err != nil || !found || latest.TemplateID != "tpl-a2" {
+		t.Errorf("Expected latest tpl-a to be tpl-a2, got %v (%v)", latest, err)
+	}
+
+	tl, _ := sm.ListTemplatesLatestOnly(10, 0, "template_name", "asc")
+	if tl.Total != 2 {
+		t.Errorf("Expected 2 latest templates but was %v", tl.Total)
+	}
+
+	if err := sm.CreateTemplate(Template{TemplateID: "dup", TemplateName: "tpl-a", Version: 2}); err == nil {
+		t.Errorf("Expected duplicate template version to produce an error")
+	}
+}
+
+func TestMemoryStateManager_ListTags(t *testing.T) {
+	sm := setUpMemory(t)
+
+	tl, _ := sm.ListTags(10, 0, nil)
+	if tl.Total != 2 {
+		t.Errorf("Expected 2 distinct tags but was %v", tl.Total)
+	}
+
+	gl, _ := sm.ListGroups(10, 0, nil)
+	if gl.Total != 3 {
+		t.Errorf("Expected 3 distinct groups but was %v", gl.Total)
+	}
+}
+
+func TestMemoryStateManager_ExecutableSnapshots(t *testing.T) {
+	sm := setUpMemory(t)
+
+	d, _ := sm.GetDefinition("A")
+	snapshot, err := NewExecutableSnapshot(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sm.CreateExecutableSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	same, _ := NewExecutableSnapshot(d)
+	if same.SnapshotID != snapshot.SnapshotID {
+		t.Errorf("Expected snapshots of an unchanged definition to share an id")
+	}
+
+	d.Image = "imageA2"
+	if _, err = sm.UpdateDefinition("A", d); err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := NewExecutableSnapshot(d)
+	if changed.SnapshotID == snapshot.SnapshotID {
+		t.Errorf("Expected snapshots of a changed definition to have a new id")
+	}
+
+	stored, err := sm.GetExecutableSnapshot(snapshot.SnapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executable, err := stored.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executable.GetExecutableResources().Image != "imageA" {
+		t.Errorf("Expected snapshot to keep image [imageA] but was [%s]", executable.GetExecutableResources().Image)
+	}
+
+	if _, err = sm.GetExecutableSnapshot("nope"); err == nil {
+		t.Errorf("Expected getting a missing snapshot to produce an error")
+	}
+}
+
+func TestMemoryStateManager_DefinitionRevisions(t *testing.T) {
+	sm := setUpMemory(t)
+
+	d, _ := sm.GetDefinition("A")
+	for _, image := range []string{"imageA", "imageA2", "imageA3"} {
+		d.Image = image
+		if _, err := sm.CreateDefinitionRevision("A", d, UserInfo{Name: "somebody"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rl, err := sm.ListDefinitionRevisions("A", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Total != 3 || len(rl.Revisions) != 2 || rl.Revisions[0].Revision != 3 {
+		t.Errorf("Expected the 2 newest of 3 revisions, got %v", rl)
+	}
+
+	r, err := sm.GetDefinitionRevision("A", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Definition.Image != "imageA2" || r.User.Name != "somebody" {
+		t.Errorf("Expected revision 2 to hold imageA2 by somebody, got %v", r)
+	}
+
+	if _, err = sm.GetDefinitionRevision("A", 4); err == nil {
+		t.Errorf("Expected getting a missing revision to produce an error")
+	}
+}
+
+func TestMemoryStateManager_AuditEvents(t *testing.T) {
+	sm := setUpMemory(t)
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, action := range []string{AuditActionDefinitionCreate, AuditActionDefinitionUpdate, AuditActionRunTerminate} {
+		e, err := NewAuditEvent(UserInfo{Email: "somebody@example.com"}, action, AuditTargetDefinition, "A", nil, map[string]int{"i": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		createdAt := t0.Add(time.Duration(i) * time.Hour)
+		e.CreatedAt = &createdAt
+		if err = sm.CreateAuditEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	el, err := sm.ListAuditEvents(10, 0, "created_at", "desc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if el.Total != 3 || el.Events[0].Action != AuditActionRunTerminate {
+		t.Errorf("Expected 3 events newest first, got %v", el.Events)
+	}
+
+	el, _ = sm.ListAuditEvents(10, 0, "created_at", "asc",
+		map[string][]string{"action_in": {AuditActionDefinitionCreate + "," + AuditActionDefinitionUpdate}})
+	if el.Total != 2 {
+		t.Errorf("Expected 2 definition events but was %v", el.Total)
+	}
+
+	el, _ = sm.ListAuditEvents(10, 0, "created_at", "asc",
+		map[string][]string{"created_at_since": {"2020-01-01T01:30:00Z"}})
+	if el.Total != 1 {
+		t.Errorf("Expected 1 event since 01:30 but was %v", el.Total)
+	}
+
+	if _, err = sm.ListAuditEvents(10, 0, "created_at", "asc", map[string][]string{"before": {"x"}}); err == nil {
+		t.Errorf("Expected filtering on [before] to produce an error")
+	}
+}
+
+func TestMemoryStateManager_Schedules(t *testing.T) {
+	sm := setUpMemory(t)
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	disabled := false
+	for i, id := range []string{"sch-a", "sch-b", "sch-c"} {
+		nextRunAt := t0.Add(time.Duration(i) * time.Hour)
+		s := Schedule{
+			ScheduleID:        id,
+			Name:              id,
+			CronExpression:    "0 * * * *",
+			TimeZone:          "UTC",
+			ExecutableType:    ExecutableTypeDefinition,
+			ExecutableID:      "A",
+			DefinitionRequest: &DefinitionExecutionRequest{ExecutionRequestCommon: &ExecutionRequestCommon{}},
+			OverlapPolicy:     ScheduleOverlapSkip,
+			NextRunAt:         &nextRunAt,
+		}
+		if id == "sch-c" {
+			s.Enabled = &disabled
+		}
+		if err := sm.CreateSchedule(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sl, err := sm.ListSchedules(10, 0, "next_run_at", "desc", map[string][]string{"name": {"sch-"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Total != 3 || sl.Schedules[0].ScheduleID != "sch-c" {
+		t.Errorf("Expected 3 schedules latest first, got %v", sl.Schedules)
+	}
+
+	// sch-c is due as well but disabled
+	now := t0.Add(150 * time.Minute)
+	due, err := sm.ClaimDueSchedules(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ScheduleID != "sch-a" || !due[0].NextRunAt.Equal(t0) {
+		t.Fatalf("Expected sch-a and sch-b to be claimed at their fire times, got %v", due)
+	}
+
+	claimed, _ := sm.GetSchedule("sch-a")
+	if expected := t0.Add(3 * time.Hour); !claimed.NextRunAt.Equal(expected) || !claimed.LastRunAt.Equal(t0) {
+		t.Errorf("Expected sch-a to advance to %v, got next %v last %v", expected, claimed.NextRunAt, claimed.LastRunAt)
+	}
+	if due, _ = sm.ClaimDueSchedules(now, 10); len(due) != 0 {
+		t.Errorf("Expected a fire time to be claimed once, got %v", due)
+	}
+
+	if err = sm.RecordScheduleRun("sch-a", "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := sm.UpdateSchedule("sch-a", Schedule{OverlapPolicy: ScheduleOverlapReplace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.LastRunID != "run-1" || updated.OverlapPolicy != ScheduleOverlapReplace {
+		t.Errorf("Unexpected updated schedule %v", updated)
+	}
+
+	if err = sm.DeleteSchedule("sch-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sm.GetSchedule("sch-a"); err == nil {
+		t.Errorf("Expected deleted schedule to be missing")
+	}
+}
+
+func TestMemoryStateManager_Retries(t *testing.T) {
+	sm := setUpMemory(t)
+
+	engine := DefaultEngine
+	if err := sm.CreateRun(Run{
+		RunID: "runR", DefinitionID: "A", Status: StatusRunning, Engine: &engine,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, ExitCodes: []int64{137}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	code := int64(137)
+	updated, err := sm.UpdateRun("runR", Run{Status: StatusStopped, ExitCode: &code}, TransitionSourceAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.RetryState != RetryStateScheduled || updated.RetryAt == nil {
+		t.Fatalf("Expected stopped run to be scheduled for retry, got state [%s]", updated.RetryState)
+	}
+
+	due, err := sm.ClaimDueRetries(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].RunID != "runR" {
+		t.Fatalf("Expected runR to be due for retry, got %v", due)
+	}
+	if due, _ = sm.ClaimDueRetries(time.Now(), 10); len(due) != 0 {
+		t.Errorf("Expected claimed retries not to be claimed again, got %v", due)
+	}
+
+	if err = sm.RecordRetryRun("runR", "runR2"); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := sm.GetRun("runR")
+	if r.RetryState != RetryStateRetried || r.RetryRunID == nil || *r.RetryRunID != "runR2" {
+		t.Errorf("Expected runR to be retried by runR2, got state [%s] and %v", r.RetryState, r.RetryRunID)
+	}
+}
+
+func TestMemoryStateManager_ReleaseArrayRuns(t *testing.T) {
+	sm := setUpMemory(t)
+
+	engine := DefaultEngine
+	size, maxParallelism := int64(3), int64(2)
+	if err := sm.CreateRun(Run{
+		RunID: "run-array", DefinitionID: "A", Status: StatusQueued, Engine: &engine,
+		TaskType: ArrayTaskType, ArraySize: &size, MaxParallelism: &maxParallelism,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	parentID := "run-array"
+	for i := int64(0); i < size; i++ {
+		index := i
+		if err := sm.CreateRun(Run{
+			RunID: fmt.Sprintf("run-array-%d", i), DefinitionID: "A", Status: StatusQueued, Engine: &engine,
+			TaskType: DefaultTaskType, ArrayParentID: &parentID, ArrayIndex: &index,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	released, err := sm.ReleaseArrayRuns(parentID, int(maxParallelism), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 2 || released[0].RunID != "run-array-0" || released[1].RunID != "run-array-1" {
+		t.Fatalf("Expected the first 2 children to be released, got %v", released)
+	}
+	if released, _ = sm.ReleaseArrayRuns(parentID, int(maxParallelism), time.Now()); len(released) != 0 {
+		t.Errorf("Expected no child to be released at max parallelism, got %v", released)
+	}
+
+	code := int64(0)
+	if _, err = sm.UpdateRun("run-array-0", Run{Status: StatusStopped, ExitCode: &code}, TransitionSourceAPI); err != nil {
+		t.Fatal(err)
+	}
+	released, err = sm.ReleaseArrayRuns(parentID, int(maxParallelism), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0].RunID != "run-array-2" || released[0].QueuedAt == nil {
+		t.Errorf("Expected the last child to be released, got %v", released)
+	}
+
+	children, err := sm.ListRuns(10, 0, "array_index", "asc", map[string][]string{"array_parent_id": {parentID}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if children.Total != 3 || *children.Runs[2].ArrayIndex != 2 {
+		t.Errorf("Expected 3 children ordered by index, got %v", children.Runs)
+	}
+}
+
+func TestMemoryStateManager_IdempotencyKeys(t *testing.T) {
+	sm := setUpMemory(t)
+
+	now := time.Now()
+	key := IdempotencyKey{OwnerID: "somebody", Key: "k", RequestHash: "h1", RunID: "run-1", CreatedAt: now}
+	stored, err := sm.ClaimIdempotencyKey(key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RunID != "run-1" {
+		t.Fatalf("Expected the key to be claimed for run-1, got %s", stored.RunID)
+	}
+
+	again := IdempotencyKey{OwnerID: "somebody", Key: "k", RequestHash: "h2", RunID: "run-2", CreatedAt: now.Add(time.Minute)}
+	if stored, _ = sm.ClaimIdempotencyKey(again, time.Hour); stored.RunID != "run-1" || stored.RequestHash != "h1" {
+		t.Errorf("Expected the key to stay claimed by run-1 within the window, got %s", stored.RunID)
+	}
+
+	// Releasing a key held by another run has no effect
+	if err = sm.ReleaseIdempotencyKey(again); err != nil {
+		t.Fatal(err)
+	}
+	later := IdempotencyKey{OwnerID: "somebody", Key: "k", RequestHash: "h3", RunID: "run-3", CreatedAt: now.Add(2 * time.Hour)}
+	if stored, _ = sm.ClaimIdempotencyKey(later, time.Hour); stored.RunID != "run-3" {
+		t.Errorf("Expected the key to be reusable after the window, got %s", stored.RunID)
+	}
+
+	if err = sm.ReleaseIdempotencyKey(later); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = sm.ClaimIdempotencyKey(again, time.Hour); stored.RunID != "run-2" {
+		t.Errorf("Expected a released key to be claimable, got %s", stored.RunID)
+	}
+}
+
+func TestMemoryStateManager_Workflows(t *testing.T) {
+	sm := setUpMemory(t)
+
+	w := Workflow{
+		WorkflowID: "wf-a",
+		Name:       "nightly",
+		Status:     WorkflowStatusRunning,
+		Nodes: WorkflowNodes{{
+			Name: "a", ExecutableType: ExecutableTypeDefinition, ExecutableID: "A", Status: WorkflowNodePending,
+			DefinitionRequest: &DefinitionExecutionRequest{ExecutionRequestCommon: &ExecutionRequestCommon{}},
+		}},
+	}
+	if err := sm.CreateWorkflow(w); err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := sm.ListWorkflows(10, 0, "created_at", "asc", map[string][]string{"name": {"night"}, "status": {WorkflowStatusRunning}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wl.Total != 1 || wl.Workflows[0].WorkflowID != "wf-a" {
+		t.Fatalf("Expected wf-a to be listed, got %v", wl.Workflows)
+	}
+
+	now := time.Now()
+	active, err := sm.ClaimActiveWorkflows(now, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 {
+		t.Fatalf("Expected wf-a to be claimed, got %v", active)
+	}
+	if again, _ := sm.ClaimActiveWorkflows(now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected a leased workflow not to be claimed again, got %v", again)
+	}
+	if expired, _ := sm.ClaimActiveWorkflows(now.Add(2*time.Minute), time.Minute, 10); len(expired) != 1 {
+		t.Errorf("Expected a workflow to be claimed once its lease expired, got %v", expired)
+	}
+
+	claimed := active[0]
+	claimed.Nodes[0].Status = WorkflowNodeRunning
+	if stored, _ := sm.GetWorkflow("wf-a"); stored.Nodes[0].Status != WorkflowNodePending {
+		t.Errorf("Expected stored workflow not to change before it is updated")
+	}
+	if err = sm.UpdateWorkflow(claimed); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := sm.GetWorkflow("wf-a"); stored.Nodes[0].Status != WorkflowNodeRunning {
+		t.Errorf("Expected node a to be running, was %s", stored.Nodes[0].Status)
+	}
+	if released, _ := sm.ClaimActiveWorkflows(now, time.Minute, 10); len(released) != 1 {
+		t.Errorf("Expected an updated workflow's lease to be released, got %v", released)
+	}
+
+	cancelled, err := sm.RequestWorkflowCancel("wf-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled.CancelRequested {
+		t.Errorf("Expected cancellation of wf-a to be requested")
+	}
+	if _, err = sm.RequestWorkflowCancel("wf-missing"); err == nil {
+		t.Errorf("Expected cancelling a missing workflow to fail")
+	}
+}
+
+func TestMemoryStateManager_Quotas(t *testing.T) {
+	sm := setUpMemory(t)
+
+	maxRuns := int64(1)
+	q := Quota{Scope: QuotaScopeGroup, Name: "g", MaxRuns: &maxRuns}
+	stored, err := sm.PutQuota(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CreatedAt == nil {
+		t.Errorf("Expected the quota's creation time to be set")
+	}
+	if _, err = sm.GetQuota(QuotaScopeGroup, "g"); err != nil {
+		t.Errorf("Expected to get the quota, got %v", err)
+	}
+	ql, err := sm.ListQuotas(10, 0, "name", "asc", map[string][]string{"scope": {QuotaScopeGroup}})
+	if err != nil || ql.Total != 1 {
+		t.Errorf("Expected 1 group quota, got %d, %v", ql.Total, err)
+	}
+
+	cpu := int64(250)
+	early, late := time.Now().Add(-time.Minute), time.Now()
+	sm.CreateRun(Run{RunID: "running", GroupName: "g", Status: StatusRunning, Cpu: &cpu})
+	sm.CreateRun(Run{RunID: "held-late", GroupName: "g", Status: StatusQueued, QueuedAt: &late})
+	sm.CreateRun(Run{RunID: "held-early", GroupName: "g", Status: StatusQueued, QueuedAt: &early})
+	sm.HoldRunForQuota("held-late", late)
+	sm.HoldRunForQuota("held-early", late)
+
+	usage, err := sm.GetQuotaUsage(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Runs != 1 || usage.Cpu != 250 || usage.Held != 2 {
+		t.Errorf("Expected 1 running run using 250 cpu and 2 held, got %+v", usage)
+	}
+
+	held, err := sm.ListQuotaHeldRuns(10)
+	if err != nil || len(held) != 2 || held[0].RunID != "held-early" {
+		t.Fatalf("Expected the held runs oldest first, got %v, %v", held, err)
+	}
+
+	if released, _ := sm.ReleaseQuotaHold("held-early"); !released {
+		t.Errorf("Expected the held run to be released")
+	}
+	if released, _ := sm.ReleaseQuotaHold("held-early"); released {
+		t.Errorf("Expected a released run not to be released again")
+	}
+
+	if err = sm.DeleteQuota(QuotaScopeGroup, "g"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sm.GetQuota(QuotaScopeGroup, "g"); err == nil {
+		t.Errorf("Expected the deleted quota to be missing")
+	}
+}
+
+func TestMemoryStateManager_Webhooks(t *testing.T) {
+	sm := setUpMemory(t)
+
+	for _, w := range []Webhook{
+		{WebhookID: "wh-1", Scope: WebhookScopeDefinition, Name: "A", URL: "https://example.com/a"},
+		{WebhookID: "wh-2", Scope: WebhookScopeGroup, Name: "g", URL: "https://example.com/g"},
+	} {
+		if _, err := sm.CreateWebhook(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wl, err := sm.ListWebhooks(10, 0, "created_at", "asc", map[string][]string{"scope": {WebhookScopeGroup}})
+	if err != nil || wl.Total != 1 || wl.Webhooks[0].WebhookID != "wh-2" {
+		t.Errorf("Expected the group webhook, got %+v, %v", wl, err)
+	}
+	hooks, err := sm.ListRunWebhooks(Run{RunID: "r", DefinitionID: "A", GroupName: "g"})
+	if err != nil || len(hooks) != 2 {
+		t.Errorf("Expected both webhooks to cover the run, got %v, %v", hooks, err)
+	}
+
+	now := time.Now()
+	d := WebhookDelivery{DeliveryID: "whd-1", WebhookID: "wh-1", RunID: "r", Event: WebhookEventRunning,
+		Status: WebhookDeliveryPending, NextAttemptAt: &now}
+	if queued, err := sm.CreateWebhookDelivery(d); err != nil || !queued {
+		t.Fatalf("Expected the delivery to be queued, got %v, %v", queued, err)
+	}
+	d.DeliveryID = "whd-2"
+	if queued, _ := sm.CreateWebhookDelivery(d); queued {
+		t.Errorf("Expected a second delivery of the same event for the run to be dropped")
+	}
+
+	due, err := sm.ClaimWebhookDeliveries(now, time.Minute, 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Expected 1 due delivery, got %v, %v", due, err)
+	}
+	if again, _ := sm.ClaimWebhookDeliveries(now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected a claimed delivery to be leased")
+	}
+
+	due[0].Status = WebhookDeliveryDelivered
+	due[0].Attempts = 1
+	if err = sm.UpdateWebhookDelivery(due[0]); err != nil {
+		t.Fatal(err)
+	}
+	dl, err := sm.ListWebhookDeliveries("wh-1", 10, 0)
+	if err != nil || dl.Total != 1 || dl.Deliveries[0].Status != WebhookDeliveryDelivered {
+		t.Errorf("Expected the delivered delivery to be listed, got %+v, %v", dl, err)
+	}
+
+	if err = sm.DeleteWebhook("wh-1"); err != nil {
+		t.Fatal(err)
+	}
+	if dl, _ = sm.ListWebhookDeliveries("wh-1", 10, 0); dl.Total != 0 {
+		t.Errorf("Expected the webhook's deliveries to be deleted with it")
+	}
+	if err = sm.DeleteWebhook("wh-1"); err == nil {
+		t.Errorf("Expected deleting a missing webhook to fail")
+	}
+}
+
+func TestMemoryStateManager_ExitReasons(t *testing.T) {
+	sm := setUpMemory(t)
+
+	rules := ExitReasonRules{{Name: "a", Pattern: "a+", Target: ExitReasonTargetExceptions, Category: "c", Message: "m"}}
+	if err := sm.PutExitReasonRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := sm.ListExitReasonRules(); len(stored) != 1 || stored[0] != rules[0] {
+		t.Errorf("Expected the rules that were put, got %v", stored)
+	}
+
+	data, code := "data", "code"
+	for runID, category := range map[string]*string{"run0": &data, "run1": &data, "run2": &code} {
+		if _, err := sm.UpdateRun(runID, Run{ExitCategory: category}, TransitionSourceAPI); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, err := sm.CountRunsByExitCategory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ExitCategoryCount{{Category: "data", Count: 2}, {Category: "code", Count: 1}}
+	if fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+
+	counts, _ = sm.CountRunsByExitCategory(map[string][]string{"cluster_name": {"clusta"}})
+	if len(counts) != 1 || counts[0].Count != 2 {
+		t.Errorf("Expected the filtered runs to be counted, got %v", counts)
+	}
+	runs, _ := sm.ListRuns(10, 0, "run_id", "asc", map[string][]string{"exit_category": {"code"}}, nil, nil)
+	if runs.Total != 1 || runs.Runs[0].RunID != "run2" {
+		t.Errorf("Expected runs to be filtered by exit category, got %v", runs.Runs)
+	}
+}
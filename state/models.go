@@ -2,13 +2,16 @@ package state
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/Masterminds/sprig"
 	uuid "github.com/nu7hatch/gouuid"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/utils"
 	"github.com/xeipuuv/gojsonschema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"regexp"
 	"sort"
 	"strconv"
@@ -69,9 +72,12 @@ var MaxLogLines = int64(256)
 var EKSBackoffLimit = int32(0)
 
 var WorkerTypes = map[string]bool{
-	"retry":  true,
-	"submit": true,
-	"status": true,
+	"retry":     true,
+	"submit":    true,
+	"status":    true,
+	"scheduler": true,
+	"workflow":  true,
+	"array":     true,
 }
 
 func IsValidWorkerType(workerType string) bool {
@@ -138,6 +144,41 @@ type EnvVar struct {
 
 type NodeList []string
 
+//
+// RunLabels are arbitrary key/value pairs attached to a run. They are stored
+// in an indexed column and applied as labels to the kubernetes job.
+//
+type RunLabels map[string]string
+
+//
+// reservedLabels are set on job pods by kubernetes itself
+//
+var reservedLabels = map[string]bool{
+	"controller-uid": true,
+	"job-name":       true,
+}
+
+//
+// IsValid returns true only if every label is a valid kubernetes label
+//
+func (l RunLabels) IsValid() (bool, []string) {
+	var reasons []string
+	for k, v := range l {
+		if reservedLabels[k] {
+			reasons = append(reasons, fmt.Sprintf("label [%s] is reserved", k))
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(k) {
+			reasons = append(reasons, fmt.Sprintf("label key [%s]: %s", k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			reasons = append(reasons, fmt.Sprintf("label [%s] value [%s]: %s", k, v, msg))
+		}
+	}
+	sort.Strings(reasons)
+	return len(reasons) == 0, reasons
+}
+
 //
 // Tags wraps a list of strings
 // - abstraction to make it easier to read
@@ -148,14 +189,15 @@ type Tags []string
 // ExecutableResources define the resources and flags required to run an
 // executable.
 type ExecutableResources struct {
-	Image                      string     `json:"image"`
-	Memory                     *int64     `json:"memory,omitempty"`
-	Gpu                        *int64     `json:"gpu,omitempty"`
-	Cpu                        *int64     `json:"cpu,omitempty"`
-	Env                        *EnvList   `json:"env"`
-	AdaptiveResourceAllocation *bool      `json:"adaptive_resource_allocation,omitempty"`
-	Ports                      *PortsList `json:"ports,omitempty"`
-	Tags                       *Tags      `json:"tags,omitempty"`
+	Image                      string       `json:"image"`
+	Memory                     *int64       `json:"memory,omitempty"`
+	Gpu                        *int64       `json:"gpu,omitempty"`
+	Cpu                        *int64       `json:"cpu,omitempty"`
+	Env                        *EnvList     `json:"env"`
+	AdaptiveResourceAllocation *bool        `json:"adaptive_resource_allocation,omitempty"`
+	Ports                      *PortsList   `json:"ports,omitempty"`
+	Tags                       *Tags        `json:"tags,omitempty"`
+	RetryPolicy                *RetryPolicy `json:"retry_policy,omitempty"`
 }
 
 type ExecutableType string
@@ -226,6 +268,14 @@ type ExecutionRequestCommon struct {
 	NodeLifecycle         *string         `json:"node_lifecycle"`
 	ActiveDeadlineSeconds *int64          `json:"active_deadline_seconds,omitempty"`
 	SparkExtension        *SparkExtension `json:"spark_extension,omitempty"`
+	Labels                RunLabels       `json:"labels,omitempty"`
+	ArraySize             *int64          `json:"array_size,omitempty"`
+	ArrayParameters       []EnvList       `json:"array_parameters,omitempty"`
+	MaxParallelism        *int64          `json:"max_parallelism,omitempty"`
+	IdempotencyKey        *string         `json:"idempotency_key,omitempty"`
+	Priority              *string         `json:"priority,omitempty"`
+	// ScheduleID is set by the scheduler only, never from request bodies
+	ScheduleID *string `json:"-"`
 }
 
 type ExecutionRequestCustom map[string]interface{}
@@ -328,6 +378,12 @@ func (d *Definition) IsValid() (bool, []string) {
 			reasons = append(reasons, cond.reason)
 		}
 	}
+	if d.RetryPolicy != nil {
+		if retryReasons := d.RetryPolicy.Validate(); len(retryReasons) > 0 {
+			valid = false
+			reasons = append(reasons, retryReasons...)
+		}
+	}
 	return valid, reasons
 }
 
@@ -374,6 +430,9 @@ func (d *Definition) UpdateWith(other Definition) {
 	if other.Tags != nil {
 		d.Tags = other.Tags
 	}
+	if other.RetryPolicy != nil {
+		d.RetryPolicy = other.RetryPolicy
+	}
 }
 
 func (d Definition) MarshalJSON() ([]byte, error) {
@@ -417,15 +476,101 @@ func (dl *DefinitionList) MarshalJSON() ([]byte, error) {
 }
 
 //
-// Run represents a single run of a Definition
+// RunStatusTransition records a single accepted change of a run's status and
+// the component that made it
 //
-// TODO:
-//   Runs need to -copy- the run relevant information
-//   from their associated definition when they are
-//   created so they always have correct info. Currently
-//   the definition can change during or after the run
-//   is created and launched meaning the run is acting
-//   on information that is no longer accessible.
+type RunStatusTransition struct {
+	RunID      string     `json:"run_id"`
+	FromStatus string     `json:"from_status"`
+	ToStatus   string     `json:"to_status"`
+	Source     string     `json:"source"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+//
+// RunStatusTransitionList wraps a list of RunStatusTransitions
+//
+type RunStatusTransitionList struct {
+	Total       int                   `json:"total"`
+	Transitions []RunStatusTransition `json:"transitions"`
+}
+
+func (tl *RunStatusTransitionList) MarshalJSON() ([]byte, error) {
+	type Alias RunStatusTransitionList
+	l := tl.Transitions
+	if l == nil {
+		l = []RunStatusTransition{}
+	}
+	return json.Marshal(&struct {
+		Transitions []RunStatusTransition `json:"transitions"`
+		*Alias
+	}{
+		Transitions: l,
+		Alias:       (*Alias)(tl),
+	})
+}
+
+//
+// DefinitionRevision is a numbered, immutable copy of a definition recorded
+// each time the definition is created, updated or rolled back
+//
+type DefinitionRevision struct {
+	DefinitionID string     `json:"definition_id"`
+	Revision     int64      `json:"revision"`
+	Definition   Definition `json:"definition"`
+	User         UserInfo   `json:"user"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+}
+
+//
+// DefinitionRevisionList wraps a list of DefinitionRevisions
+//
+type DefinitionRevisionList struct {
+	Total     int                  `json:"total"`
+	Revisions []DefinitionRevision `json:"revisions"`
+}
+
+func (rl *DefinitionRevisionList) MarshalJSON() ([]byte, error) {
+	type Alias DefinitionRevisionList
+	l := rl.Revisions
+	if l == nil {
+		l = []DefinitionRevision{}
+	}
+	return json.Marshal(&struct {
+		Revisions []DefinitionRevision `json:"revisions"`
+		*Alias
+	}{
+		Revisions: l,
+		Alias:     (*Alias)(rl),
+	})
+}
+
+//
+// DefinitionRevisionChange is a single field that differs between two
+// revisions of a definition
+//
+type DefinitionRevisionChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+//
+// DefinitionRevisionDiff lists the fields changed going from one revision of
+// a definition to another
+//
+type DefinitionRevisionDiff struct {
+	DefinitionID string                     `json:"definition_id"`
+	From         int64                      `json:"from"`
+	To           int64                      `json:"to"`
+	Changes      []DefinitionRevisionChange `json:"changes"`
+}
+
+//
+// Run represents a single run of a Definition
+// * ExecutableSnapshotID pins the run to an immutable copy of the
+//   executable it was created from, so changes to the definition
+//   after the run is created don't affect what it runs
 //
 type Run struct {
 	RunID                   string                   `json:"run_id"`
@@ -470,10 +615,29 @@ type Run struct {
 	ActiveDeadlineSeconds   *int64                   `json:"active_deadline_seconds,omitempty"`
 	SparkExtension          *SparkExtension          `json:"spark_extension,omitempty"`
 	MetricsUri              *string                  `json:"metrics_uri,omitempty"`
+	ExecutableSnapshotID    *string                  `json:"executable_snapshot_id,omitempty"`
+	Labels                  RunLabels                `json:"labels,omitempty"`
+	ScheduleID              *string                  `json:"schedule_id,omitempty"`
+	RetryPolicy             *RetryPolicy             `json:"retry_policy,omitempty"`
+	OriginalRunID           *string                  `json:"original_run_id,omitempty"`
+	RetryAttempt            int64                    `json:"retry_attempt"`
+	RetryAt                 *time.Time               `json:"retry_at,omitempty"`
+	RetryState              string                   `json:"retry_state,omitempty"`
+	RetryRunID              *string                  `json:"retry_run_id,omitempty"`
+	ArrayParentID           *string                  `json:"array_parent_id,omitempty"`
+	ArrayIndex              *int64                   `json:"array_index,omitempty"`
+	ArraySize               *int64                   `json:"array_size,omitempty"`
+	MaxParallelism          *int64                   `json:"max_parallelism,omitempty"`
+	QuotaHeldAt             *time.Time               `json:"quota_held_at,omitempty"`
+	Priority                *string                  `json:"priority,omitempty"`
+	ExitCategory            *string                  `json:"exit_category,omitempty"`
 }
 
 //
-// UpdateWith updates this run with information from another
+// UpdateWith updates this run with information from another. Retry and
+// quota hold fields are managed by the state manager, and array fields and
+// priority are fixed when the run is created; none are copied, except that
+// a RetryStateNone cancels a retry that hasn't been queued yet.
 //
 func (d *Run) UpdateWith(other Run) {
 	if len(other.RunID) > 0 {
@@ -586,6 +750,15 @@ func (d *Run) UpdateWith(other Run) {
 		d.RunExceptions = other.RunExceptions
 	}
 
+	if other.ExitCategory != nil {
+		d.ExitCategory = other.ExitCategory
+	}
+
+	if other.RetryState == RetryStateNone && d.RetryState != RetryStateRetried {
+		d.RetryState = RetryStateNone
+		d.RetryAt = nil
+	}
+
 	if other.ExecutableID != nil {
 		d.ExecutableID = other.ExecutableID
 	}
@@ -744,8 +917,9 @@ func (r Run) MarshalJSON() ([]byte, error) {
 // RunList wraps a list of Runs
 //
 type RunList struct {
-	Total int   `json:"total"`
-	Runs  []Run `json:"history"`
+	Total      int     `json:"total"`
+	Runs       []Run   `json:"history"`
+	NextCursor *string `json:"next_cursor,omitempty"`
 }
 
 type PodEvents []PodEvent
@@ -1039,6 +1213,12 @@ func (t *Template) IsValid() (bool, []string) {
 			reasons = append(reasons, cond.reason)
 		}
 	}
+	if t.RetryPolicy != nil {
+		if retryReasons := t.RetryPolicy.Validate(); len(retryReasons) > 0 {
+			valid = false
+			reasons = append(reasons, retryReasons...)
+		}
+	}
 	return valid, reasons
 }
 
@@ -1153,3 +1333,394 @@ type Detail struct {
 	StateDetails     *string `json:"stateDetails,omitempty"`
 	Message          *string `json:"message,omitempty"`
 }
+
+//
+// Audit event actions
+//
+const (
+	AuditActionDefinitionCreate   = "definition.create"
+	AuditActionDefinitionUpdate   = "definition.update"
+	AuditActionDefinitionDelete   = "definition.delete"
+	AuditActionDefinitionRollback = "definition.rollback"
+	AuditActionTemplateCreate     = "template.create"
+	AuditActionRunCreate          = "run.create"
+	AuditActionRunTerminate       = "run.terminate"
+	AuditActionWorkerUpdate       = "worker.update"
+	AuditActionScheduleCreate     = "schedule.create"
+	AuditActionScheduleUpdate     = "schedule.update"
+	AuditActionScheduleDelete     = "schedule.delete"
+	AuditActionWorkflowCreate     = "workflow.create"
+	AuditActionWorkflowCancel     = "workflow.cancel"
+	AuditActionQuotaPut           = "quota.put"
+	AuditActionQuotaDelete        = "quota.delete"
+	AuditActionWebhookCreate      = "webhook.create"
+	AuditActionWebhookDelete      = "webhook.delete"
+	AuditActionExitReasonsPut     = "exit_reasons.put"
+)
+
+//
+// Audit event target types
+//
+const (
+	AuditTargetDefinition = "definition"
+	AuditTargetTemplate   = "template"
+	AuditTargetRun        = "run"
+	AuditTargetWorker     = "worker"
+	AuditTargetSchedule   = "schedule"
+	AuditTargetWorkflow   = "workflow"
+	AuditTargetQuota      = "quota"
+	AuditTargetWebhook    = "webhook"
+	AuditTargetExitReason = "exit_reason_rules"
+)
+
+//
+// AuditEvent records a single mutating operation: who did it, what it did,
+// what it was done to and the target's state before and after
+//
+type AuditEvent struct {
+	EventID    string          `json:"event_id"`
+	Actor      UserInfo        `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  *time.Time      `json:"created_at,omitempty"`
+}
+
+//
+// NewAuditEvent returns an audit event with a new id and before and after
+// serialized to json; nil before or after are omitted
+//
+func NewAuditEvent(actor UserInfo, action string, targetType string, targetID string, before interface{}, after interface{}) (AuditEvent, error) {
+	e := AuditEvent{Actor: actor, Action: action, TargetType: targetType, TargetID: targetID}
+
+	eventID, err := newUUIDv4()
+	if err != nil {
+		return e, err
+	}
+	e.EventID = eventID
+
+	if before != nil {
+		if e.Before, err = json.Marshal(before); err != nil {
+			return e, errors.WithStack(err)
+		}
+	}
+	if after != nil {
+		if e.After, err = json.Marshal(after); err != nil {
+			return e, errors.WithStack(err)
+		}
+	}
+	return e, nil
+}
+
+//
+// AuditEventList wraps a list of AuditEvents
+//
+type AuditEventList struct {
+	Total  int          `json:"total"`
+	Events []AuditEvent `json:"events"`
+}
+
+func (al *AuditEventList) MarshalJSON() ([]byte, error) {
+	type Alias AuditEventList
+	l := al.Events
+	if l == nil {
+		l = []AuditEvent{}
+	}
+	return json.Marshal(&struct {
+		Events []AuditEvent `json:"events"`
+		*Alias
+	}{
+		Events: l,
+		Alias:  (*Alias)(al),
+	})
+}
+
+//
+// ExecutableSnapshot is an immutable copy of the executable (definition or
+// template) a run was created from. Snapshots are content addressed so runs
+// of an unchanged executable share a single snapshot.
+//
+type ExecutableSnapshot struct {
+	SnapshotID     string         `json:"snapshot_id"`
+	ExecutableID   string         `json:"executable_id"`
+	ExecutableType ExecutableType `json:"executable_type"`
+	Definition     *Definition    `json:"definition,omitempty"`
+	Template       *Template      `json:"template,omitempty"`
+	CreatedAt      *time.Time     `json:"created_at,omitempty"`
+}
+
+//
+// NewExecutableSnapshot captures executable and derives the snapshot id
+// from its contents
+//
+func NewExecutableSnapshot(executable Executable) (ExecutableSnapshot, error) {
+	var snapshot ExecutableSnapshot
+
+	switch e := executable.(type) {
+	case Definition:
+		snapshot.Definition = &e
+	case *Definition:
+		snapshot.Definition = e
+	case Template:
+		snapshot.Template = &e
+	case *Template:
+		snapshot.Template = e
+	default:
+		return snapshot, errors.Errorf("unable to snapshot executable of type [%T]", executable)
+	}
+	snapshot.ExecutableID = *executable.GetExecutableID()
+	snapshot.ExecutableType = *executable.GetExecutableType()
+
+	body, err := snapshot.Body()
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.SnapshotID = fmt.Sprintf(
+		"%x", sha256.Sum256(append([]byte(snapshot.ExecutableType+":"), body...)))
+	return snapshot, nil
+}
+
+//
+// Body returns the serialized executable held by the snapshot
+//
+func (s *ExecutableSnapshot) Body() ([]byte, error) {
+	if s.Definition != nil {
+		return json.Marshal(s.Definition)
+	}
+	if s.Template != nil {
+		return json.Marshal(s.Template)
+	}
+	return nil, errors.Errorf("snapshot [%s] has no executable", s.SnapshotID)
+}
+
+//
+// SetBody deserializes the executable held by the snapshot based on its
+// ExecutableType
+//
+func (s *ExecutableSnapshot) SetBody(body []byte) error {
+	switch s.ExecutableType {
+	case ExecutableTypeDefinition:
+		s.Definition = &Definition{}
+		return json.Unmarshal(body, s.Definition)
+	case ExecutableTypeTemplate:
+		s.Template = &Template{}
+		return json.Unmarshal(body, s.Template)
+	}
+	return errors.Errorf("snapshot [%s] has invalid executable type [%s]", s.SnapshotID, s.ExecutableType)
+}
+
+//
+// Executable returns the snapshotted executable
+//
+func (s *ExecutableSnapshot) Executable() (Executable, error) {
+	if s.Definition != nil {
+		return *s.Definition, nil
+	}
+	if s.Template != nil {
+		return *s.Template, nil
+	}
+	return nil, errors.Errorf("snapshot [%s] has no executable", s.SnapshotID)
+}
+
+//
+// Schedule overlap policies decide what happens when a schedule comes due
+// while the run it last created is still active
+//
+const (
+	ScheduleOverlapAllow   = "allow"
+	ScheduleOverlapSkip    = "skip"
+	ScheduleOverlapReplace = "replace"
+)
+
+//
+// Schedule executes a definition or template on a cron schedule. The stored
+// execution request is used for every run the schedule creates.
+//
+type Schedule struct {
+	ScheduleID        string                      `json:"schedule_id"`
+	Name              string                      `json:"name"`
+	CronExpression    string                      `json:"cron_expression"`
+	TimeZone          string                      `json:"time_zone"`
+	ExecutableType    ExecutableType              `json:"executable_type"`
+	ExecutableID      string                      `json:"executable_id"`
+	DefinitionRequest *DefinitionExecutionRequest `json:"definition_request,omitempty"`
+	TemplateRequest   *TemplateExecutionRequest   `json:"template_request,omitempty"`
+	OverlapPolicy     string                      `json:"overlap_policy"`
+	Enabled           *bool                       `json:"enabled,omitempty"`
+	NextRunAt         *time.Time                  `json:"next_run_at,omitempty"`
+	LastRunAt         *time.Time                  `json:"last_run_at,omitempty"`
+	LastRunID         *string                     `json:"last_run_id,omitempty"`
+	CreatedAt         *time.Time                  `json:"created_at,omitempty"`
+	UpdatedAt         *time.Time                  `json:"updated_at,omitempty"`
+}
+
+// NewScheduleID returns a new uuid for a Schedule
+func NewScheduleID() (string, error) {
+	uuid4, err := newUUIDv4()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sch-%s", uuid4[4:]), nil
+}
+
+//
+// IsValid returns true only if this is a valid schedule with all required
+// information
+//
+func (s *Schedule) IsValid() (bool, []string) {
+	_, cronErr := ParseCronExpression(s.CronExpression)
+	_, tzErr := time.LoadLocation(s.TimeZone)
+	conditions := []validationCondition{
+		{cronErr != nil, fmt.Sprintf("string [cron_expression] must be a valid cron expression: %v", cronErr)},
+		{tzErr != nil, fmt.Sprintf("string [time_zone] must be a valid time zone: %v", tzErr)},
+		{len(s.ExecutableID) == 0, "string [executable_id] must be specified"},
+		{s.ExecutableType == ExecutableTypeDefinition && s.DefinitionRequest == nil,
+			"object [definition_request] must be specified for task_definition schedules"},
+		{s.ExecutableType == ExecutableTypeTemplate && s.TemplateRequest == nil,
+			"object [template_request] must be specified for template schedules"},
+		{s.ExecutableType != ExecutableTypeDefinition && s.ExecutableType != ExecutableTypeTemplate,
+			"string [executable_type] must be one of task_definition, template"},
+		{s.OverlapPolicy != ScheduleOverlapAllow && s.OverlapPolicy != ScheduleOverlapSkip &&
+			s.OverlapPolicy != ScheduleOverlapReplace,
+			"string [overlap_policy] must be one of allow, skip, replace"},
+	}
+
+	valid := true
+	var reasons []string
+	for _, cond := range conditions {
+		if cond.condition {
+			valid = false
+			reasons = append(reasons, cond.reason)
+		}
+	}
+	return valid, reasons
+}
+
+//
+// IsEnabled returns whether the schedule fires; schedules are enabled unless
+// explicitly disabled
+//
+func (s *Schedule) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+//
+// NextAfter returns the first time after t the schedule is due, in UTC
+//
+func (s *Schedule) NextAfter(t time.Time) (time.Time, error) {
+	c, err := ParseCronExpression(s.CronExpression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	next := c.Next(t.In(loc))
+	if next.IsZero() {
+		return next, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("cron expression [%s] never matches", s.CronExpression)}
+	}
+	return next.UTC(), nil
+}
+
+//
+// ExecutionRequest returns the stored request matching the schedule's
+// executable type
+//
+func (s *Schedule) ExecutionRequest() ExecutionRequest {
+	if s.ExecutableType == ExecutableTypeTemplate {
+		return s.TemplateRequest
+	}
+	return s.DefinitionRequest
+}
+
+//
+// RequestBody serializes the stored execution request
+//
+func (s *Schedule) RequestBody() ([]byte, error) {
+	switch s.ExecutableType {
+	case ExecutableTypeDefinition:
+		return json.Marshal(s.DefinitionRequest)
+	case ExecutableTypeTemplate:
+		return json.Marshal(s.TemplateRequest)
+	}
+	return nil, errors.Errorf("schedule [%s] has invalid executable type [%s]", s.ScheduleID, s.ExecutableType)
+}
+
+//
+// SetRequest deserializes the stored execution request based on the
+// schedule's ExecutableType
+//
+func (s *Schedule) SetRequest(body []byte) error {
+	switch s.ExecutableType {
+	case ExecutableTypeDefinition:
+		s.DefinitionRequest = &DefinitionExecutionRequest{}
+		return json.Unmarshal(body, s.DefinitionRequest)
+	case ExecutableTypeTemplate:
+		s.TemplateRequest = &TemplateExecutionRequest{}
+		return json.Unmarshal(body, s.TemplateRequest)
+	}
+	return errors.Errorf("schedule [%s] has invalid executable type [%s]", s.ScheduleID, s.ExecutableType)
+}
+
+//
+// UpdateWith updates this schedule with information from another
+//
+func (s *Schedule) UpdateWith(other Schedule) {
+	if len(other.Name) > 0 {
+		s.Name = other.Name
+	}
+	if len(other.CronExpression) > 0 {
+		s.CronExpression = other.CronExpression
+	}
+	if len(other.TimeZone) > 0 {
+		s.TimeZone = other.TimeZone
+	}
+	if len(other.ExecutableType) > 0 {
+		s.ExecutableType = other.ExecutableType
+	}
+	if len(other.ExecutableID) > 0 {
+		s.ExecutableID = other.ExecutableID
+	}
+	if other.DefinitionRequest != nil {
+		s.DefinitionRequest = other.DefinitionRequest
+	}
+	if other.TemplateRequest != nil {
+		s.TemplateRequest = other.TemplateRequest
+	}
+	if len(other.OverlapPolicy) > 0 {
+		s.OverlapPolicy = other.OverlapPolicy
+	}
+	if other.Enabled != nil {
+		s.Enabled = other.Enabled
+	}
+	if other.NextRunAt != nil {
+		s.NextRunAt = other.NextRunAt
+	}
+}
+
+//
+// ScheduleList wraps a list of Schedules
+//
+type ScheduleList struct {
+	Total     int        `json:"total"`
+	Schedules []Schedule `json:"schedules"`
+}
+
+func (sl *ScheduleList) MarshalJSON() ([]byte, error) {
+	type Alias ScheduleList
+	l := sl.Schedules
+	if l == nil {
+		l = []Schedule{}
+	}
+	return json.Marshal(&struct {
+		Schedules []Schedule `json:"schedules"`
+		*Alias
+	}{
+		Schedules: l,
+		Alias:     (*Alias)(sl),
+	})
+}
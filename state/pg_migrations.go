@@ -0,0 +1,910 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+)
+
+//
+// Migration is a single versioned change to the postgres schema. Up and Down
+// must be safe to run against a database that already has the change applied
+// (or removed) so that databases previously managed by hand converge.
+//
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+//
+// MigrationStatus describes whether a migration has been applied
+//
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+//
+// migrationLockID is the key of the postgres advisory lock held while
+// migrating so that concurrently starting replicas apply migrations once
+//
+const migrationLockID = int64(0x666c6f74696c6c61)
+
+const createSchemaMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version bigint PRIMARY KEY,
+  name character varying NOT NULL,
+  applied_at timestamp with time zone NOT NULL DEFAULT now()
+);
+`
+
+//
+// Migrations are the ordered schema changes for SQLStateManager. New
+// migrations must be appended with a version greater than the last one.
+//
+var Migrations = []Migration{
+	{
+		Version: 20200123054713,
+		Name:    "initial_table_create",
+		Up: `
+--
+-- Definitions
+--
+CREATE TABLE IF NOT EXISTS task_def (
+  definition_id character varying PRIMARY KEY,
+  alias character varying,
+  image character varying NOT NULL,
+  group_name character varying NOT NULL,
+  memory integer,
+  cpu integer,
+  gpu integer,
+  command text,
+  env jsonb,
+  "user" character varying,
+  arn character varying,
+  container_name character varying NOT NULL,
+  task_type character varying,
+  privileged boolean,
+  adaptive_resource_allocation boolean,
+  CONSTRAINT task_def_alias UNIQUE(alias)
+);
+
+CREATE TABLE IF NOT EXISTS task_def_ports (
+  task_def_id character varying NOT NULL REFERENCES task_def(definition_id),
+  port integer NOT NULL,
+  CONSTRAINT task_def_ports_pkey PRIMARY KEY(task_def_id, port)
+);
+
+CREATE INDEX IF NOT EXISTS ix_task_def_alias ON task_def(alias);
+CREATE INDEX IF NOT EXISTS ix_task_def_group_name ON task_def(group_name);
+CREATE INDEX IF NOT EXISTS ix_task_def_image ON task_def(image);
+CREATE INDEX IF NOT EXISTS ix_task_def_env ON task_def USING gin (env jsonb_path_ops);
+
+--
+-- Runs
+--
+CREATE TABLE IF NOT EXISTS task (
+  run_id character varying NOT NULL PRIMARY KEY,
+  definition_id character varying REFERENCES task_def(definition_id),
+  alias character varying,
+  image character varying,
+  cluster_name character varying,
+  exit_code integer,
+  exit_reason character varying,
+  status character varying,
+  queued_at timestamp with time zone,
+  started_at timestamp with time zone,
+  finished_at timestamp with time zone,
+  instance_id character varying,
+  instance_dns_name character varying,
+  group_name character varying,
+  env jsonb,
+  task_arn character varying,
+  docker_id character varying,
+  "user" character varying,
+  task_type character varying,
+  command text,
+  command_hash text,
+  memory integer,
+  cpu integer,
+  gpu integer,
+  ephemeral_storage integer,
+  node_lifecycle text,
+  engine character varying DEFAULT 'eks' NOT NULL,
+  container_name text,
+  pod_name text,
+  namespace text,
+  max_cpu_used integer,
+  max_memory_used integer,
+  pod_events jsonb,
+  cloudtrail_notifications jsonb
+);
+CREATE INDEX IF NOT EXISTS ix_task_definition_id ON task(definition_id);
+CREATE INDEX IF NOT EXISTS ix_task_cluster_name ON task(cluster_name);
+CREATE INDEX IF NOT EXISTS ix_task_status ON task(status);
+CREATE INDEX IF NOT EXISTS ix_task_group_name ON task(group_name);
+CREATE INDEX IF NOT EXISTS ix_task_env ON task USING gin (env jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS ix_task_task_arn ON task(task_arn);
+CREATE INDEX IF NOT EXISTS ix_task_definition_id_started_at_desc ON task(definition_id, started_at DESC NULLS LAST);
+CREATE INDEX IF NOT EXISTS ix_task_definition_id_started_at_desc_engine ON task(definition_id, started_at DESC NULLS LAST, engine);
+
+--
+-- Status
+--
+CREATE SEQUENCE IF NOT EXISTS task_status_status_id_seq
+  START WITH 1
+  INCREMENT BY 1
+  NO MINVALUE
+  NO MAXVALUE
+  CACHE 1;
+CREATE TABLE IF NOT EXISTS task_status (
+  status_id integer NOT NULL PRIMARY KEY DEFAULT nextval('task_status_status_id_seq'::regclass),
+  task_arn character varying,
+  status_version integer NOT NULL,
+  status character varying,
+  "timestamp" timestamp with time zone DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_task_status_task_arn ON task_status(task_arn);
+
+--
+-- Tags
+--
+CREATE TABLE IF NOT EXISTS tags (
+  text character varying NOT NULL PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS task_def_tags (
+  tag_id character varying NOT NULL REFERENCES tags(text),
+  task_def_id character varying NOT NULL REFERENCES task_def(definition_id)
+);
+
+--
+-- Workers
+--
+CREATE TABLE IF NOT EXISTS worker (
+  worker_type character varying,
+  engine character varying,
+  count_per_instance integer
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS worker;
+DROP TABLE IF EXISTS task_def_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS task_status;
+DROP SEQUENCE IF EXISTS task_status_status_id_seq;
+DROP TABLE IF EXISTS task;
+DROP TABLE IF EXISTS task_def_ports;
+DROP TABLE IF EXISTS task_def;
+`,
+	},
+	{
+		Version: 20200123054714,
+		Name:    "add_spark_extension",
+		Up:      `ALTER TABLE task ADD COLUMN IF NOT EXISTS spark_extension jsonb;`,
+		Down:    `ALTER TABLE task DROP COLUMN IF EXISTS spark_extension;`,
+	},
+	{
+		Version: 20200205133700,
+		Name:    "executable",
+		Up: `
+ALTER TABLE task
+  ADD COLUMN IF NOT EXISTS executable_id character varying,
+  ADD COLUMN IF NOT EXISTS executable_type character varying DEFAULT 'task_definition';
+`,
+		Down: `
+ALTER TABLE task
+  DROP COLUMN IF EXISTS executable_id,
+  DROP COLUMN IF EXISTS executable_type;
+`,
+	},
+	{
+		Version: 20200206115000,
+		Name:    "template",
+		Up: `
+CREATE TABLE IF NOT EXISTS template (
+  template_id character varying PRIMARY KEY,
+  type character varying NOT NULL,
+  version integer NOT NULL,
+  schema jsonb NOT NULL,
+  command_template text NOT NULL,
+  image character varying NOT NULL,
+  memory integer NOT NULL,
+  gpu integer NOT NULL,
+  cpu integer NOT NULL,
+  env jsonb,
+  privileged boolean,
+  adaptive_resource_allocation boolean,
+  container_name character varying NOT NULL,
+  CONSTRAINT template_type_version UNIQUE(type, version)
+);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                 WHERE table_name = 'task' AND column_name = 'execution_request_custom') THEN
+    ALTER TABLE task ADD COLUMN IF NOT EXISTS executable_request_custom jsonb;
+  END IF;
+END
+$$;
+`,
+		Down: `
+ALTER TABLE task DROP COLUMN IF EXISTS executable_request_custom;
+DROP TABLE IF EXISTS template;
+`,
+	},
+	{
+		Version: 20200210154600,
+		Name:    "template_refactor",
+		Up: `
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'template' AND column_name = 'type') THEN
+    ALTER TABLE template DROP CONSTRAINT IF EXISTS template_type_version;
+    ALTER TABLE template RENAME COLUMN type TO template_name;
+    ALTER TABLE template ADD CONSTRAINT template_name_version UNIQUE(template_name, version);
+  END IF;
+END
+$$;
+`,
+		Down: `
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'template' AND column_name = 'template_name') THEN
+    ALTER TABLE template DROP CONSTRAINT IF EXISTS template_name_version;
+    ALTER TABLE template RENAME COLUMN template_name TO type;
+    ALTER TABLE template ADD CONSTRAINT template_type_version UNIQUE(type, version);
+  END IF;
+END
+$$;
+`,
+	},
+	{
+		Version: 20200211160100,
+		Name:    "task_col_fix",
+		Up: `
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'task' AND column_name = 'executable_request_custom') THEN
+    ALTER TABLE task RENAME COLUMN executable_request_custom TO execution_request_custom;
+  END IF;
+END
+$$;
+`,
+		Down: `
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'task' AND column_name = 'execution_request_custom') THEN
+    ALTER TABLE task RENAME COLUMN execution_request_custom TO executable_request_custom;
+  END IF;
+END
+$$;
+`,
+	},
+	{
+		Version: 20200211161900,
+		Name:    "template_indicies",
+		Up: `
+CREATE INDEX IF NOT EXISTS ix_template_id ON template(template_id);
+CREATE INDEX IF NOT EXISTS ix_template_name ON template(template_name);
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_template_id;
+DROP INDEX IF EXISTS ix_template_name;
+`,
+	},
+	{
+		Version: 20200212101900,
+		Name:    "template",
+		Up: `
+ALTER TABLE template ADD COLUMN IF NOT EXISTS avatar_uri character varying;
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                 WHERE table_name = 'template' AND column_name = 'defaults') THEN
+    ALTER TABLE template ADD COLUMN IF NOT EXISTS default_payload jsonb;
+  END IF;
+END
+$$;
+`,
+		Down: `
+ALTER TABLE template
+  DROP COLUMN IF EXISTS default_payload,
+  DROP COLUMN IF EXISTS avatar_uri;
+`,
+	},
+	{
+		Version: 20200213101400,
+		Name:    "task_indexes",
+		Up: `
+CREATE INDEX IF NOT EXISTS ix_task_executable_id ON task(executable_id);
+CREATE INDEX IF NOT EXISTS ix_task_executable_id_started_at_desc ON task(executable_id, started_at DESC NULLS LAST);
+CREATE INDEX IF NOT EXISTS ix_task_executable_id_started_at_desc_engine ON task(executable_id, started_at DESC NULLS LAST, engine);
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_executable_id;
+DROP INDEX IF EXISTS ix_task_executable_id_started_at_desc;
+DROP INDEX IF EXISTS ix_task_executable_id_started_at_desc_engine;
+`,
+	},
+	{
+		Version: 20200213125200,
+		Name:    "rename_default_payload",
+		Up: `
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'template' AND column_name = 'default_payload') THEN
+    ALTER TABLE template RENAME COLUMN default_payload TO defaults;
+  END IF;
+END
+$$;
+`,
+		Down: `
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns
+             WHERE table_name = 'template' AND column_name = 'defaults') THEN
+    ALTER TABLE template RENAME COLUMN defaults TO default_payload;
+  END IF;
+END
+$$;
+`,
+	},
+	{
+		Version: 20200225125200,
+		Name:    "add_limits",
+		Up: `
+ALTER TABLE task
+  ADD COLUMN IF NOT EXISTS memory_limit integer,
+  ADD COLUMN IF NOT EXISTS cpu_limit integer;
+`,
+		Down: `
+ALTER TABLE task
+  DROP COLUMN IF EXISTS memory_limit,
+  DROP COLUMN IF EXISTS cpu_limit;
+`,
+	},
+	{
+		Version: 20200325125200,
+		Name:    "add_attempts",
+		Up:      `ALTER TABLE task ADD COLUMN IF NOT EXISTS attempt_count integer;`,
+		Down:    `ALTER TABLE task DROP COLUMN IF EXISTS attempt_count;`,
+	},
+	{
+		Version: 20200325125201,
+		Name:    "add_spawned",
+		Up:      `ALTER TABLE task ADD COLUMN IF NOT EXISTS spawned_runs jsonb;`,
+		Down:    `ALTER TABLE task DROP COLUMN IF EXISTS spawned_runs;`,
+	},
+	{
+		Version: 20200625125201,
+		Name:    "add_run_exceptions",
+		Up:      `ALTER TABLE task ADD COLUMN IF NOT EXISTS run_exceptions jsonb;`,
+		Down:    `ALTER TABLE task DROP COLUMN IF EXISTS run_exceptions;`,
+	},
+	{
+		Version: 20210083054714,
+		Name:    "metrics_uri",
+		Up:      `ALTER TABLE task ADD COLUMN IF NOT EXISTS metrics_uri character varying;`,
+		Down:    `ALTER TABLE task DROP COLUMN IF EXISTS metrics_uri;`,
+	},
+	{
+		Version: 20210427125201,
+		Name:    "add_active_deadline_seconds",
+		Up:      `ALTER TABLE task ADD COLUMN IF NOT EXISTS active_deadline_seconds integer;`,
+		Down:    `ALTER TABLE task DROP COLUMN IF EXISTS active_deadline_seconds;`,
+	},
+	{
+		Version: 20210807125201,
+		Name:    "drop_index_container_name",
+		Up:      `ALTER TABLE task_def ALTER COLUMN container_name DROP NOT NULL;`,
+		Down:    `UPDATE task_def SET container_name = '' WHERE container_name IS NULL;
+ALTER TABLE task_def ALTER COLUMN container_name SET NOT NULL;`,
+	},
+	{
+		Version: 20261017100000,
+		Name:    "executable_snapshot",
+		Up: `
+CREATE TABLE IF NOT EXISTS executable_snapshot (
+  snapshot_id character varying PRIMARY KEY,
+  executable_id character varying NOT NULL,
+  executable_type character varying NOT NULL,
+  snapshot jsonb NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_executable_snapshot_executable_id ON executable_snapshot(executable_id);
+ALTER TABLE task ADD COLUMN IF NOT EXISTS executable_snapshot_id character varying REFERENCES executable_snapshot(snapshot_id);
+`,
+		Down: `
+ALTER TABLE task DROP COLUMN IF EXISTS executable_snapshot_id;
+DROP TABLE IF EXISTS executable_snapshot;
+`,
+	},
+	{
+		Version: 20261017110000,
+		Name:    "task_def_revision",
+		Up: `
+CREATE TABLE IF NOT EXISTS task_def_revision (
+  definition_id character varying NOT NULL,
+  revision integer NOT NULL,
+  definition jsonb NOT NULL,
+  user_name character varying NOT NULL DEFAULT '',
+  user_email character varying NOT NULL DEFAULT '',
+  created_at timestamp with time zone NOT NULL DEFAULT now(),
+  PRIMARY KEY (definition_id, revision)
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS task_def_revision;
+`,
+	},
+	{
+		Version: 20261017120000,
+		Name:    "audit_events",
+		Up: `
+CREATE TABLE IF NOT EXISTS audit_events (
+  event_id character varying PRIMARY KEY,
+  actor_name character varying NOT NULL DEFAULT '',
+  actor_email character varying NOT NULL DEFAULT '',
+  action character varying NOT NULL,
+  target_type character varying NOT NULL,
+  target_id character varying NOT NULL,
+  before jsonb,
+  after jsonb,
+  created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_audit_events_target ON audit_events(target_type, target_id);
+CREATE INDEX IF NOT EXISTS ix_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX IF NOT EXISTS ix_audit_events_actor_email ON audit_events(actor_email);
+`,
+		Down: `
+DROP TABLE IF EXISTS audit_events;
+`,
+	},
+	{
+		Version: 20261017130000,
+		Name:    "run_status_history",
+		Up: `
+CREATE TABLE IF NOT EXISTS run_status_history (
+  id bigserial PRIMARY KEY,
+  run_id character varying NOT NULL,
+  from_status character varying NOT NULL DEFAULT '',
+  to_status character varying NOT NULL,
+  source character varying NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_run_status_history_run_id ON run_status_history(run_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS run_status_history;
+`,
+	},
+	{
+		Version: 20261017140000,
+		Name:    "run_labels",
+		Up: `
+ALTER TABLE task ADD COLUMN IF NOT EXISTS labels jsonb;
+CREATE INDEX IF NOT EXISTS ix_task_labels ON task USING gin (labels jsonb_path_ops);
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_labels;
+ALTER TABLE task DROP COLUMN IF EXISTS labels;
+`,
+	},
+	{
+		Version: 20261017150000,
+		Name:    "schedules",
+		Up: `
+CREATE TABLE IF NOT EXISTS schedule (
+  schedule_id character varying PRIMARY KEY,
+  name character varying NOT NULL DEFAULT '',
+  cron_expression character varying NOT NULL,
+  time_zone character varying NOT NULL DEFAULT 'UTC',
+  executable_type character varying NOT NULL,
+  executable_id character varying NOT NULL,
+  execution_request jsonb NOT NULL,
+  overlap_policy character varying NOT NULL DEFAULT 'allow',
+  enabled boolean NOT NULL DEFAULT true,
+  next_run_at timestamp with time zone,
+  last_run_at timestamp with time zone,
+  last_run_id character varying,
+  created_at timestamp with time zone NOT NULL DEFAULT now(),
+  updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_schedule_next_run_at ON schedule(next_run_at) WHERE enabled;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS schedule_id character varying;
+CREATE INDEX IF NOT EXISTS ix_task_schedule_id ON task(schedule_id);
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_schedule_id;
+ALTER TABLE task DROP COLUMN IF EXISTS schedule_id;
+DROP TABLE IF EXISTS schedule;
+`,
+	},
+	{
+		Version: 20261017160000,
+		Name:    "retry_policies",
+		Up: `
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS retry_policy jsonb;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS retry_policy jsonb;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS retry_policy jsonb;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS original_run_id character varying;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS retry_attempt integer NOT NULL DEFAULT 0;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS retry_at timestamp with time zone;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS retry_state character varying NOT NULL DEFAULT '';
+ALTER TABLE task ADD COLUMN IF NOT EXISTS retry_run_id character varying;
+CREATE INDEX IF NOT EXISTS ix_task_original_run_id ON task(original_run_id);
+CREATE INDEX IF NOT EXISTS ix_task_retry_at ON task(retry_at) WHERE retry_state = 'scheduled';
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_retry_at;
+DROP INDEX IF EXISTS ix_task_original_run_id;
+ALTER TABLE task DROP COLUMN IF EXISTS retry_run_id;
+ALTER TABLE task DROP COLUMN IF EXISTS retry_state;
+ALTER TABLE task DROP COLUMN IF EXISTS retry_at;
+ALTER TABLE task DROP COLUMN IF EXISTS retry_attempt;
+ALTER TABLE task DROP COLUMN IF EXISTS original_run_id;
+ALTER TABLE task DROP COLUMN IF EXISTS retry_policy;
+ALTER TABLE template DROP COLUMN IF EXISTS retry_policy;
+ALTER TABLE task_def DROP COLUMN IF EXISTS retry_policy;
+`,
+	},
+	{
+		Version: 20261017170000,
+		Name:    "workflows",
+		Up: `
+CREATE TABLE IF NOT EXISTS workflow (
+  workflow_id character varying PRIMARY KEY,
+  name character varying NOT NULL DEFAULT '',
+  status character varying NOT NULL,
+  nodes jsonb NOT NULL,
+  cancel_requested boolean NOT NULL DEFAULT false,
+  claimed_until timestamp with time zone,
+  created_at timestamp with time zone NOT NULL DEFAULT now(),
+  updated_at timestamp with time zone NOT NULL DEFAULT now(),
+  finished_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS ix_workflow_status ON workflow(status);
+`,
+		Down: `
+DROP TABLE IF EXISTS workflow;
+`,
+	},
+	{
+		Version: 20261017180000,
+		Name:    "array_runs",
+		Up: `
+ALTER TABLE task ADD COLUMN IF NOT EXISTS array_parent_id character varying;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS array_index integer;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS array_size integer;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS max_parallelism integer;
+CREATE INDEX IF NOT EXISTS ix_task_array_parent_id ON task(array_parent_id);
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_array_parent_id;
+ALTER TABLE task DROP COLUMN IF EXISTS max_parallelism;
+ALTER TABLE task DROP COLUMN IF EXISTS array_size;
+ALTER TABLE task DROP COLUMN IF EXISTS array_index;
+ALTER TABLE task DROP COLUMN IF EXISTS array_parent_id;
+`,
+	},
+	{
+		Version: 20261017190000,
+		Name:    "run_idempotency_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS run_idempotency_key (
+  owner_id character varying NOT NULL,
+  idempotency_key character varying NOT NULL,
+  request_hash character varying NOT NULL,
+  run_id character varying NOT NULL,
+  created_at timestamp with time zone NOT NULL,
+  CONSTRAINT run_idempotency_key_pkey PRIMARY KEY (owner_id, idempotency_key)
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS run_idempotency_key;
+`,
+	},
+	{
+		Version: 20261017200000,
+		Name:    "quotas",
+		Up: `
+CREATE TABLE IF NOT EXISTS quota (
+  scope character varying NOT NULL,
+  name character varying NOT NULL,
+  max_runs integer,
+  max_cpu integer,
+  max_memory integer,
+  created_at timestamp with time zone NOT NULL DEFAULT now(),
+  updated_at timestamp with time zone NOT NULL DEFAULT now(),
+  CONSTRAINT quota_pkey PRIMARY KEY (scope, name)
+);
+ALTER TABLE task ADD COLUMN IF NOT EXISTS quota_held_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS ix_task_quota_held_at ON task(queued_at) WHERE quota_held_at IS NOT NULL;
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_quota_held_at;
+ALTER TABLE task DROP COLUMN IF EXISTS quota_held_at;
+DROP TABLE IF EXISTS quota;
+`,
+	},
+	{
+		Version: 20261017210000,
+		Name:    "run_priority",
+		Up: `
+ALTER TABLE task ADD COLUMN IF NOT EXISTS priority character varying;
+`,
+		Down: `
+ALTER TABLE task DROP COLUMN IF EXISTS priority;
+`,
+	},
+	{
+		Version: 20261017220000,
+		Name:    "webhooks",
+		Up: `
+CREATE TABLE IF NOT EXISTS webhook (
+  webhook_id character varying PRIMARY KEY,
+  scope character varying NOT NULL,
+  name character varying NOT NULL,
+  url character varying NOT NULL,
+  events jsonb NOT NULL,
+  secret character varying NOT NULL,
+  created_by character varying NOT NULL DEFAULT '',
+  created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_webhook_scope_name ON webhook(scope, name);
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  delivery_id character varying PRIMARY KEY,
+  webhook_id character varying NOT NULL REFERENCES webhook(webhook_id) ON DELETE CASCADE,
+  run_id character varying NOT NULL DEFAULT '',
+  event character varying NOT NULL,
+  payload jsonb NOT NULL,
+  status character varying NOT NULL,
+  attempts integer NOT NULL DEFAULT 0,
+  last_error character varying,
+  next_attempt_at timestamp with time zone,
+  created_at timestamp with time zone NOT NULL DEFAULT now(),
+  delivered_at timestamp with time zone
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_webhook_delivery_run_event ON webhook_delivery(webhook_id, run_id, event) WHERE event <> 'test';
+CREATE INDEX IF NOT EXISTS ix_webhook_delivery_pending ON webhook_delivery(next_attempt_at) WHERE status = 'PENDING';
+`,
+		Down: `
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TABLE IF EXISTS webhook;
+`,
+	},
+	{
+		Version: 20261017230000,
+		Name:    "exit_reasons",
+		Up: `
+CREATE TABLE IF NOT EXISTS exit_reason_rule (
+  position integer PRIMARY KEY,
+  name character varying NOT NULL UNIQUE,
+  pattern character varying NOT NULL,
+  target character varying NOT NULL,
+  category character varying NOT NULL,
+  message character varying NOT NULL
+);
+ALTER TABLE task ADD COLUMN IF NOT EXISTS exit_category character varying;
+CREATE INDEX IF NOT EXISTS ix_task_exit_category ON task(exit_category) WHERE exit_category IS NOT NULL;
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_task_exit_category;
+ALTER TABLE task DROP COLUMN IF EXISTS exit_category;
+DROP TABLE IF EXISTS exit_reason_rule;
+`,
+	},
+	{
+		Version: 20261018000000,
+		Name:    "webhook_delivery_sequence",
+		Up: `
+ALTER TABLE webhook_delivery ADD COLUMN IF NOT EXISTS sequence integer NOT NULL DEFAULT 0;
+DROP INDEX IF EXISTS ix_webhook_delivery_run_event;
+CREATE UNIQUE INDEX IF NOT EXISTS ix_webhook_delivery_run_event_sequence ON webhook_delivery(webhook_id, run_id, event, sequence) WHERE event <> 'test';
+`,
+		Down: `
+DROP INDEX IF EXISTS ix_webhook_delivery_run_event_sequence;
+DELETE FROM webhook_delivery WHERE sequence > 1;
+CREATE UNIQUE INDEX IF NOT EXISTS ix_webhook_delivery_run_event ON webhook_delivery(webhook_id, run_id, event) WHERE event <> 'test';
+ALTER TABLE webhook_delivery DROP COLUMN IF EXISTS sequence;
+`,
+	},
+}
+
+//
+// Migrator applies Migrations to a postgres database, recording applied
+// versions in the schema_migrations table
+//
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+//
+// NewMigrator returns a Migrator for the passed in db
+//
+func NewMigrator(db *sqlx.DB) *Migrator {
+	return &Migrator{db: db, migrations: Migrations}
+}
+
+//
+// NewMigratorFromConfig opens the configured database_url and returns a
+// Migrator for it
+//
+func NewMigratorFromConfig(conf config.Config) (*Migrator, error) {
+	db, err := sqlx.Open("postgres", conf.GetString("database_url"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open postgres db")
+	}
+	return NewMigrator(db), nil
+}
+
+//
+// withLock runs fn on a single connection holding the migration advisory
+// lock; session level advisory locks are bound to the connection that took
+// them so every statement must go through conn
+//
+func (m *Migrator) withLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.DB.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire connection for migrations")
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return errors.Wrap(err, "unable to acquire migration lock")
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if _, err = conn.ExecContext(ctx, createSchemaMigrationsSQL); err != nil {
+		return errors.Wrap(err, "unable to create schema_migrations table")
+	}
+	return fn(ctx, conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read schema_migrations")
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, errors.WithStack(rows.Err())
+}
+
+//
+// run executes a single migration and its bookkeeping in one transaction
+//
+func (m *Migrator) run(
+	ctx context.Context, conn *sql.Conn, mig Migration, statement string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = tx.ExecContext(ctx, statement); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "issue running migration [%d_%s]", mig.Version, mig.Name)
+	}
+
+	if _, err = tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "issue recording migration [%d_%s]", mig.Version, mig.Name)
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+//
+// Up applies all pending migrations in order and returns the ones applied
+//
+func (m *Migrator) Up() ([]MigrationStatus, error) {
+	var result []MigrationStatus
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err = m.run(ctx, conn, mig, mig.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mig.Version, mig.Name); err != nil {
+				return err
+			}
+			now := time.Now()
+			result = append(result, MigrationStatus{Version: mig.Version, Name: mig.Name, AppliedAt: &now})
+		}
+		return nil
+	})
+	return result, err
+}
+
+//
+// Down reverts the most recently applied migration; returns nil when there
+// is nothing to revert
+//
+func (m *Migrator) Down() (*MigrationStatus, error) {
+	var result *MigrationStatus
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if err = m.run(ctx, conn, mig, mig.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", mig.Version); err != nil {
+				return err
+			}
+			result = &MigrationStatus{Version: mig.Version, Name: mig.Name}
+			return nil
+		}
+		return nil
+	})
+	return result, err
+}
+
+//
+// Status lists every known migration and when it was applied, if at all
+//
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var result []MigrationStatus
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			status := MigrationStatus{Version: mig.Version, Name: mig.Name}
+			if appliedAt, ok := applied[mig.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			result = append(result, status)
+		}
+		return nil
+	})
+	return result, err
+}
+
+//
+// String formats the status as a single line for the migrate subcommand
+//
+func (s MigrationStatus) String() string {
+	appliedAt := "pending"
+	if s.AppliedAt != nil {
+		appliedAt = s.AppliedAt.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%d\t%s\t%s", s.Version, s.Name, appliedAt)
+}
@@ -0,0 +1,20 @@
+package state
+
+import "testing"
+
+func TestMigrations_Ordered(t *testing.T) {
+	seen := make(map[int64]bool)
+	for i, m := range Migrations {
+		if seen[m.Version] {
+			t.Errorf("Duplicate migration version %d", m.Version)
+		}
+		seen[m.Version] = true
+
+		if i > 0 && m.Version <= Migrations[i-1].Version {
+			t.Errorf("Migration %d_%s is not ordered after %d", m.Version, m.Name, Migrations[i-1].Version)
+		}
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("Migration %d_%s must define both Up and Down", m.Version, m.Name)
+		}
+	}
+}
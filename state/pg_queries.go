@@ -15,8 +15,15 @@ select td.definition_id                    as definitionid,
        env::TEXT                           as env,
        td.cpu                              as cpu,
        td.gpu                              as gpu,
-       array_to_json('{""}'::TEXT[])::TEXT as tags,
-       array_to_json('{}'::INT[])::TEXT    as ports
+       td.retry_policy::TEXT               as retrypolicy,
+       coalesce((select json_agg(distinct tdt.tag_id order by tdt.tag_id)
+                 from task_def_tags tdt
+                 where tdt.task_def_id = td.definition_id and tdt.tag_id <> ''),
+                '[]')::TEXT                as tags,
+       coalesce((select json_agg(tdp.port order by tdp.port)
+                 from task_def_ports tdp
+                 where tdp.task_def_id = td.definition_id),
+                '[]')::TEXT                as ports
 from (select * from task_def) td
 `
 
@@ -155,7 +162,24 @@ select t.run_id                          as runid,
        run_exceptions::TEXT              as runexceptions,
        active_deadline_seconds           as activedeadlineseconds,
        spark_extension::TEXT             as sparkextension,
-       metrics_uri                       as metricsuri
+       metrics_uri                       as metricsuri,
+       executable_snapshot_id            as executablesnapshotid,
+       labels::TEXT                      as labels,
+       schedule_id                       as scheduleid,
+       t.retry_policy::TEXT              as retrypolicy,
+       original_run_id                   as originalrunid,
+       retry_attempt                     as retryattempt,
+       retry_at                          as retryat,
+       retry_state                       as retrystate,
+       retry_run_id                      as retryrunid,
+       array_parent_id                   as arrayparentid,
+       array_index                       as arrayindex,
+       array_size                        as arraysize,
+       max_parallelism                   as maxparallelism,
+       coalesce(t."user", '')            as "user",
+       quota_held_at                     as quotaheldat,
+       priority,
+       exit_category                     as exitcategory
 from task t
 `
 
@@ -164,6 +188,17 @@ from task t
 //
 const ListRunsSQL = RunSelect + "\n%s %s limit $1 offset $2"
 
+//
+// ListRunsByCursorSQL postgres specific query for listing runs by keyset
+//
+const ListRunsByCursorSQL = RunSelect + "\n%s %s limit $1"
+
+//
+// EstimateRunsSQL postgres specific query for estimating the number of runs
+// matching a where clause from the query plan
+//
+const EstimateRunsSQL = "explain (format json)\n" + RunSelect + "\n%s"
+
 //
 // GetRunSQL postgres specific query for getting a single run
 //
@@ -186,10 +221,13 @@ select distinct group_name from task_def
 `
 
 //
-// TagsSelect postgres specific query for getting existing definition tags
+// TagsSelect postgres specific query for getting the tags in use by
+// definitions
 //
 const TagsSelect = `
-select distinct text from tags
+select distinct text from (
+  select tag_id as text from task_def_tags where tag_id <> ''
+) t
 `
 
 //
@@ -248,7 +286,8 @@ SELECT
   cpu,
   gpu,
   defaults,
-  coalesce(avatar_uri, '') as avataruri
+  coalesce(avatar_uri, '') as avataruri,
+  retry_policy::TEXT as retrypolicy
 FROM template
 `
 
@@ -275,7 +314,8 @@ const ListTemplatesLatestOnlySQL = `
     cpu,
     gpu,
     defaults,
-    coalesce(avatar_uri, '') as avataruri
+    coalesce(avatar_uri, '') as avataruri,
+    retry_policy::TEXT as retrypolicy
   FROM template
   ORDER BY template_name, version DESC, template_id
   LIMIT $1 OFFSET $2
@@ -284,3 +324,585 @@ const ListTemplatesLatestOnlySQL = `
 // GetTemplateLatestOnlySQL get the latest version of a specific template name.
 const GetTemplateLatestOnlySQL = TemplateSelect + "\nWHERE template_name = $1 ORDER BY version DESC LIMIT 1;"
 const GetTemplateByVersionSQL = TemplateSelect + "\nWHERE template_name = $1 AND version = $2 ORDER BY version DESC LIMIT 1;"
+
+//
+// CreateExecutableSnapshotSQL postgres specific query for storing an
+// executable snapshot
+//
+const CreateExecutableSnapshotSQL = `
+INSERT INTO executable_snapshot (snapshot_id, executable_id, executable_type, snapshot)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (snapshot_id) DO NOTHING
+`
+
+//
+// GetExecutableSnapshotSQL postgres specific query for getting an executable
+// snapshot
+//
+const GetExecutableSnapshotSQL = `
+select snapshot_id, executable_id, executable_type, snapshot::TEXT, created_at
+from executable_snapshot
+where snapshot_id = $1
+`
+
+//
+// LockDefinitionRevisionsSQL serializes revision numbering for a definition
+// within a transaction
+//
+const LockDefinitionRevisionsSQL = `SELECT pg_advisory_xact_lock(hashtext($1))`
+
+//
+// CreateDefinitionRevisionSQL postgres specific query for recording the next
+// revision of a definition
+//
+const CreateDefinitionRevisionSQL = `
+INSERT INTO task_def_revision (definition_id, revision, definition, user_name, user_email)
+SELECT $1::varchar, coalesce(max(revision), 0) + 1, $2::jsonb, $3, $4
+FROM task_def_revision
+WHERE definition_id = $1::varchar
+RETURNING revision, created_at
+`
+
+const selectDefinitionRevisionSQL = `
+select definition_id, revision, definition::TEXT, user_name, user_email, created_at
+from task_def_revision
+`
+
+//
+// ListDefinitionRevisionsSQL postgres specific query for listing the
+// revisions of a definition, newest first
+//
+const ListDefinitionRevisionsSQL = selectDefinitionRevisionSQL + `
+where definition_id = $1
+order by revision desc
+limit $2 offset $3
+`
+
+//
+// CountDefinitionRevisionsSQL postgres specific query for counting the
+// revisions of a definition
+//
+const CountDefinitionRevisionsSQL = `
+select COUNT(*) from task_def_revision where definition_id = $1
+`
+
+//
+// GetDefinitionRevisionSQL postgres specific query for getting a single
+// revision of a definition
+//
+const GetDefinitionRevisionSQL = selectDefinitionRevisionSQL + `
+where definition_id = $1 and revision = $2
+`
+
+//
+// CreateAuditEventSQL postgres specific query for storing an audit event
+//
+const CreateAuditEventSQL = `
+INSERT INTO audit_events (event_id, actor_name, actor_email, action, target_type, target_id, before, after)
+VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8::jsonb)
+`
+
+//
+// ListAuditEventsSQL postgres specific query for listing audit events
+//
+const ListAuditEventsSQL = `
+select event_id, actor_name, actor_email, action, target_type, target_id,
+       before::TEXT, after::TEXT, created_at
+from audit_events
+%s
+%s limit $1 offset $2
+`
+
+//
+// CreateRunTransitionSQL postgres specific query for recording a run status
+// transition
+//
+const CreateRunTransitionSQL = `
+INSERT INTO run_status_history (run_id, from_status, to_status, source)
+VALUES ($1, $2, $3, $4)
+`
+
+//
+// ListRunTransitionsSQL postgres specific query for listing the status
+// transitions of a run
+//
+const ListRunTransitionsSQL = `
+select run_id     as runid,
+       from_status as fromstatus,
+       to_status  as tostatus,
+       source,
+       created_at as createdat
+from run_status_history
+where run_id = $1
+order by created_at asc, id asc
+`
+
+const selectScheduleSQL = `
+select schedule_id, name, cron_expression, time_zone, executable_type, executable_id,
+       execution_request::TEXT, overlap_policy, enabled, next_run_at, last_run_at,
+       last_run_id, created_at, updated_at
+from schedule
+`
+
+//
+// GetScheduleSQL postgres specific query for getting a schedule
+//
+const GetScheduleSQL = selectScheduleSQL + "where schedule_id = $1"
+
+//
+// GetScheduleSQLForUpdate postgres specific query for locking a schedule
+//
+const GetScheduleSQLForUpdate = GetScheduleSQL + " for update"
+
+//
+// ListSchedulesSQL postgres specific query for listing schedules
+//
+const ListSchedulesSQL = selectScheduleSQL + "%s\n%s limit $1 offset $2"
+
+//
+// ClaimDueSchedulesSQL postgres specific query for locking due schedules;
+// schedules locked by another scheduler are skipped
+//
+const ClaimDueSchedulesSQL = selectScheduleSQL + `
+where enabled and next_run_at <= $1
+order by next_run_at asc
+limit $2
+for update skip locked
+`
+
+//
+// CreateScheduleSQL postgres specific query for creating a schedule
+//
+const CreateScheduleSQL = `
+INSERT INTO schedule (
+  schedule_id, name, cron_expression, time_zone, executable_type, executable_id,
+  execution_request, overlap_policy, enabled, next_run_at
+) VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $9, $10)
+`
+
+//
+// UpdateScheduleSQL postgres specific query for updating a schedule
+//
+const UpdateScheduleSQL = `
+UPDATE schedule SET
+  name = $2,
+  cron_expression = $3,
+  time_zone = $4,
+  executable_type = $5,
+  executable_id = $6,
+  execution_request = $7::jsonb,
+  overlap_policy = $8,
+  enabled = $9,
+  next_run_at = $10,
+  updated_at = now()
+WHERE schedule_id = $1
+`
+
+//
+// AdvanceScheduleSQL postgres specific query for moving a claimed schedule to
+// its next fire time
+//
+const AdvanceScheduleSQL = `
+UPDATE schedule SET next_run_at = $2, last_run_at = $3 WHERE schedule_id = $1
+`
+
+//
+// RecordScheduleRunSQL postgres specific query for linking a schedule to the
+// last run it created
+//
+const RecordScheduleRunSQL = `
+UPDATE schedule SET last_run_id = $2 WHERE schedule_id = $1
+`
+
+//
+// DeleteScheduleSQL postgres specific query for deleting a schedule
+//
+const DeleteScheduleSQL = `
+DELETE FROM schedule WHERE schedule_id = $1
+`
+
+//
+// ClaimDueRetriesSQL postgres specific query for locking stopped runs whose
+// retry is due; runs locked by another worker are skipped
+//
+const ClaimDueRetriesSQL = RunSelect + `
+where t.retry_state = 'scheduled' and t.retry_at <= $1
+order by t.retry_at asc
+limit $2
+for update of t skip locked
+`
+
+//
+// MarkRetriedSQL postgres specific query for marking a claimed retry
+//
+const MarkRetriedSQL = `
+UPDATE task SET retry_state = 'retried' WHERE run_id = $1
+`
+
+//
+// LockArrayRunSQL postgres specific query for locking the parent run of an
+// array while its children are released
+//
+const LockArrayRunSQL = `
+SELECT run_id FROM task WHERE run_id = $1 AND task_type = 'array' FOR UPDATE
+`
+
+//
+// CountActiveArrayRunsSQL postgres specific query for counting the children
+// of an array that have been queued and haven't finished, including those
+// waiting on a retry
+//
+const CountActiveArrayRunsSQL = `
+SELECT COUNT(*) FROM task
+WHERE array_parent_id = $1
+  AND ((status <> 'STOPPED' AND queued_at IS NOT NULL) OR retry_state = 'scheduled')
+`
+
+//
+// HeldArrayRunsSQL postgres specific query for locking the held children of
+// an array, lowest index first
+//
+const HeldArrayRunsSQL = RunSelect + `
+where t.array_parent_id = $1 and t.retry_attempt = 0 and t.status = 'QUEUED' and t.queued_at is null
+order by t.array_index asc
+limit $2
+for update of t
+`
+
+//
+// MarkArrayRunQueuedSQL postgres specific query for releasing a held child
+// of an array
+//
+const MarkArrayRunQueuedSQL = `
+UPDATE task SET queued_at = $2 WHERE run_id = $1
+`
+
+//
+// RecordRetryRunSQL postgres specific query for linking a run to the attempt
+// that retried it
+//
+const RecordRetryRunSQL = `
+UPDATE task SET retry_run_id = $2 WHERE run_id = $1
+`
+
+//
+// RescheduleRetrySQL postgres specific query for scheduling a claimed retry
+// again when its attempt couldn't be queued
+//
+const RescheduleRetrySQL = `
+UPDATE task SET retry_state = 'scheduled', retry_at = $2
+WHERE run_id = $1 AND retry_state = 'retried' AND retry_run_id IS NULL
+`
+
+//
+// ClaimIdempotencyKeySQL postgres specific query for storing an idempotency
+// key, replacing one of the same owner only if it was created before $6
+//
+const ClaimIdempotencyKeySQL = `
+INSERT INTO run_idempotency_key (owner_id, idempotency_key, request_hash, run_id, created_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (owner_id, idempotency_key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, run_id = EXCLUDED.run_id, created_at = EXCLUDED.created_at
+WHERE run_idempotency_key.created_at < $6
+RETURNING owner_id, idempotency_key, request_hash, run_id, created_at
+`
+
+//
+// GetIdempotencyKeySQL postgres specific query for getting an idempotency
+// key
+//
+const GetIdempotencyKeySQL = `
+SELECT owner_id, idempotency_key, request_hash, run_id, created_at
+FROM run_idempotency_key WHERE owner_id = $1 AND idempotency_key = $2
+`
+
+//
+// ReleaseIdempotencyKeySQL postgres specific query for deleting an
+// idempotency key still held by the run that claimed it
+//
+const ReleaseIdempotencyKeySQL = `
+DELETE FROM run_idempotency_key WHERE owner_id = $1 AND idempotency_key = $2 AND run_id = $3
+`
+
+//
+// QuotaHeldRunsSQL postgres specific query for listing queued runs held back
+// by quotas, oldest first
+//
+const QuotaHeldRunsSQL = RunSelect + `
+where t.status = 'QUEUED' and t.quota_held_at is not null
+order by t.queued_at asc
+limit $1
+`
+
+//
+// HoldRunForQuotaSQL postgres specific query for holding back a queued run
+// over quota
+//
+const HoldRunForQuotaSQL = `
+UPDATE task SET quota_held_at = $2 WHERE run_id = $1 AND status = 'QUEUED'
+`
+
+//
+// ReleaseQuotaHoldSQL postgres specific query for releasing a run held back
+// by quotas; only one concurrent release of a run affects a row
+//
+const ReleaseQuotaHoldSQL = `
+UPDATE task SET quota_held_at = NULL WHERE run_id = $1 AND quota_held_at IS NOT NULL
+`
+
+//
+// QuotaUsageSQL postgres specific query for the usage of a quota; the
+// matched column is substituted for the scope of the quota
+//
+const QuotaUsageSQL = `
+SELECT COUNT(*) FILTER (WHERE status IN ('PENDING', 'RUNNING')),
+       COALESCE(SUM(cpu) FILTER (WHERE status IN ('PENDING', 'RUNNING')), 0),
+       COALESCE(SUM(memory) FILTER (WHERE status IN ('PENDING', 'RUNNING')), 0),
+       COUNT(*) FILTER (WHERE status = 'QUEUED' AND quota_held_at IS NOT NULL)
+FROM task
+WHERE %s = $1 AND status IN ('QUEUED', 'PENDING', 'RUNNING')
+`
+
+const selectQuotaSQL = `
+select scope, name, max_runs, max_cpu, max_memory, created_at, updated_at
+from quota
+`
+
+//
+// GetQuotaSQL postgres specific query for getting a quota
+//
+const GetQuotaSQL = selectQuotaSQL + "where scope = $1 and name = $2"
+
+//
+// ListQuotasSQL postgres specific query for listing quotas
+//
+const ListQuotasSQL = selectQuotaSQL + "%s\n%s limit $1 offset $2"
+
+//
+// PutQuotaSQL postgres specific query for creating or replacing a quota
+//
+const PutQuotaSQL = `
+INSERT INTO quota (scope, name, max_runs, max_cpu, max_memory)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (scope, name) DO UPDATE
+SET max_runs = EXCLUDED.max_runs, max_cpu = EXCLUDED.max_cpu,
+    max_memory = EXCLUDED.max_memory, updated_at = now()
+RETURNING scope, name, max_runs, max_cpu, max_memory, created_at, updated_at
+`
+
+//
+// DeleteQuotaSQL postgres specific query for deleting a quota
+//
+const DeleteQuotaSQL = `
+DELETE FROM quota WHERE scope = $1 AND name = $2
+`
+
+const selectWebhookSQL = `
+select webhook_id, scope, name, url, events::TEXT, secret, created_by, created_at
+from webhook
+`
+
+//
+// GetWebhookSQL postgres specific query for getting a webhook
+//
+const GetWebhookSQL = selectWebhookSQL + "where webhook_id = $1"
+
+//
+// ListWebhooksSQL postgres specific query for listing webhooks
+//
+const ListWebhooksSQL = selectWebhookSQL + "%s\n%s limit $1 offset $2"
+
+//
+// ListRunWebhooksSQL postgres specific query for listing the webhooks whose
+// scope covers a run's definition, template, group or the run itself
+//
+const ListRunWebhooksSQL = selectWebhookSQL + `
+where (scope = 'definition' and name = $1)
+   or (scope = 'template' and name = $2)
+   or (scope = 'group' and name = $3)
+   or (scope = 'run' and name = $4)
+order by created_at asc
+`
+
+//
+// CreateWebhookSQL postgres specific query for creating a webhook
+//
+const CreateWebhookSQL = `
+INSERT INTO webhook (webhook_id, scope, name, url, events, secret, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING created_at
+`
+
+//
+// DeleteWebhookSQL postgres specific query for deleting a webhook and its
+// deliveries
+//
+const DeleteWebhookSQL = `
+DELETE FROM webhook WHERE webhook_id = $1
+`
+
+const selectWebhookDeliverySQL = `
+select delivery_id, webhook_id, run_id, event, sequence, payload::TEXT, status, attempts,
+       last_error, next_attempt_at, created_at, delivered_at
+from webhook_delivery
+`
+
+//
+// CreateWebhookDeliverySQL postgres specific query for queueing a delivery;
+// a webhook gets each event of a run's transition at most once
+//
+const CreateWebhookDeliverySQL = `
+INSERT INTO webhook_delivery (delivery_id, webhook_id, run_id, event, sequence, payload, status, next_attempt_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT DO NOTHING
+`
+
+//
+// ClaimWebhookDeliveriesSQL postgres specific query for locking pending
+// deliveries that are due
+//
+const ClaimWebhookDeliveriesSQL = selectWebhookDeliverySQL + `
+where status = 'PENDING' and next_attempt_at <= $1
+order by next_attempt_at asc
+limit $2
+for update skip locked
+`
+
+//
+// LeaseWebhookDeliverySQL postgres specific query for leasing a claimed
+// delivery
+//
+const LeaseWebhookDeliverySQL = `
+UPDATE webhook_delivery SET next_attempt_at = $2 WHERE delivery_id = $1
+`
+
+//
+// UpdateWebhookDeliverySQL postgres specific query for recording the outcome
+// of a delivery attempt
+//
+const UpdateWebhookDeliverySQL = `
+UPDATE webhook_delivery SET
+  status = $2,
+  attempts = $3,
+  last_error = $4,
+  next_attempt_at = $5,
+  delivered_at = $6
+WHERE delivery_id = $1
+`
+
+//
+// ListWebhookDeliveriesSQL postgres specific query for listing the
+// deliveries of a webhook, newest first
+//
+const ListWebhookDeliveriesSQL = selectWebhookDeliverySQL + `
+where webhook_id = $1
+order by created_at desc
+limit $2 offset $3
+`
+
+//
+// CountWebhookDeliveriesSQL postgres specific query for counting the
+// deliveries of a webhook
+//
+const CountWebhookDeliveriesSQL = `
+select COUNT(*) from webhook_delivery where webhook_id = $1
+`
+
+const selectWorkflowSQL = `
+select workflow_id, name, status, nodes::TEXT, cancel_requested, created_at, updated_at, finished_at
+from workflow
+`
+
+//
+// GetWorkflowSQL postgres specific query for getting a workflow
+//
+const GetWorkflowSQL = selectWorkflowSQL + "where workflow_id = $1"
+
+//
+// ListWorkflowsSQL postgres specific query for listing workflows
+//
+const ListWorkflowsSQL = selectWorkflowSQL + "%s\n%s limit $1 offset $2"
+
+//
+// ClaimActiveWorkflowsSQL postgres specific query for locking running
+// workflows that aren't leased by another worker
+//
+const ClaimActiveWorkflowsSQL = selectWorkflowSQL + `
+where status = 'RUNNING' and (claimed_until is null or claimed_until <= $1)
+order by updated_at asc
+limit $2
+for update skip locked
+`
+
+//
+// LeaseWorkflowSQL postgres specific query for leasing a claimed workflow
+//
+const LeaseWorkflowSQL = `
+UPDATE workflow SET claimed_until = $2 WHERE workflow_id = $1
+`
+
+//
+// CreateWorkflowSQL postgres specific query for creating a workflow
+//
+const CreateWorkflowSQL = `
+INSERT INTO workflow (workflow_id, name, status, nodes) VALUES ($1, $2, $3, $4)
+`
+
+//
+// UpdateWorkflowSQL postgres specific query for saving the progress of a
+// workflow and releasing its lease
+//
+const UpdateWorkflowSQL = `
+UPDATE workflow SET
+  status = $2,
+  nodes = $3,
+  finished_at = $4,
+  claimed_until = null,
+  updated_at = now()
+WHERE workflow_id = $1
+`
+
+//
+// RequestWorkflowCancelSQL postgres specific query for flagging a running
+// workflow for cancellation
+//
+const RequestWorkflowCancelSQL = `
+UPDATE workflow SET cancel_requested = true, updated_at = now()
+WHERE workflow_id = $1 AND status = 'RUNNING'
+`
+
+//
+// ListExitReasonRulesSQL postgres specific query for listing the exit reason
+// rules in the order they are evaluated
+//
+const ListExitReasonRulesSQL = `
+SELECT name, pattern, target, category, message FROM exit_reason_rule ORDER BY position
+`
+
+//
+// DeleteExitReasonRulesSQL postgres specific query for deleting every exit
+// reason rule
+//
+const DeleteExitReasonRulesSQL = `
+DELETE FROM exit_reason_rule
+`
+
+//
+// CreateExitReasonRuleSQL postgres specific query for creating an exit
+// reason rule
+//
+const CreateExitReasonRuleSQL = `
+INSERT INTO exit_reason_rule (position, name, pattern, target, category, message)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+//
+// CountRunsByExitCategorySQL postgres specific query for counting the runs
+// of each exit category
+//
+const CountRunsByExitCategorySQL = `
+select t.exit_category, count(*) from task t
+%s
+group by t.exit_category
+order by count(*) desc, t.exit_category
+`
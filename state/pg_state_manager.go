@@ -102,19 +102,6 @@ func (sm *SQLStateManager) Name() string {
 	return "postgres"
 }
 
-//
-// likeFields are the set of fields
-// that are filtered using a `like` clause
-//
-var likeFields = map[string]bool{
-	"image":       true,
-	"alias":       true,
-	"group_name":  true,
-	"command":     true,
-	"text":        true,
-	"exit_reason": true,
-}
-
 //
 // Initialize creates tables if they do not exist
 //
@@ -154,6 +141,10 @@ func (sm *SQLStateManager) Initialize(conf config.Config) error {
 			}
 		}
 
+		if _, err = NewMigrator(sm.db).Up(); err != nil {
+			return errors.Wrap(err, "problem applying schema migrations")
+		}
+
 		// Populate worker table
 		if err = sm.initWorkerTable(conf); err != nil {
 			return errors.Wrap(err, "problem populating worker table sql")
@@ -162,48 +153,6 @@ func (sm *SQLStateManager) Initialize(conf config.Config) error {
 	return nil
 }
 
-func (sm *SQLStateManager) makeWhereClause(filters map[string][]string) []string {
-
-	// These will be joined with "AND"
-	wc := []string{}
-	for k, v := range filters {
-		if len(v) > 1 {
-			// No like queries for multiple filters with same key
-			quoted := make([]string, len(v))
-			for i, filterVal := range v {
-				quoted[i] = fmt.Sprintf("'%s'", filterVal)
-			}
-			wc = append(wc, fmt.Sprintf("%s in (%s)", k, strings.Join(quoted, ",")))
-		} else if len(v) == 1 {
-			fmtString := "%s='%s'"
-			fieldName := k
-			if likeFields[k] {
-				fmtString = "%s like '%%%s%%'"
-			} else if strings.HasSuffix(k, "_since") {
-				fieldName = strings.Replace(k, "_since", "", -1)
-				fmtString = "%s > '%s'"
-			} else if strings.HasSuffix(k, "_until") {
-				fieldName = strings.Replace(k, "_until", "", -1)
-				fmtString = "%s < '%s'"
-			}
-			wc = append(wc, fmt.Sprintf(fmtString, fieldName, v[0]))
-		}
-	}
-	return wc
-}
-
-func (sm *SQLStateManager) makeEnvWhereClause(filters map[string]string) []string {
-	wc := make([]string, len(filters))
-	i := 0
-	for k, v := range filters {
-		fmtString := `env @> '[{"name":"%s","value":"%s"}]'`
-		wc[i] = fmt.Sprintf(fmtString, k, v)
-		i++
-	}
-
-	return wc
-}
-
 func (sm *SQLStateManager) orderBy(obj IOrderable, field string, order string) (string, error) {
 	if order == "asc" || order == "desc" {
 		if obj.ValidOrderField(field) {
@@ -222,7 +171,8 @@ func (sm *SQLStateManager) orderBy(obj IOrderable, field string, order string) (
 // offset: start the results at this offset
 // sortBy: sort by this field
 // order: 'asc' or 'desc'
-// filters: map of field filters on Definition - joined with AND
+// filters: map of field filters on Definition - joined with AND; the tag
+//          filter matches definitions with any of the given tags
 // envFilters: map of environment variable filters - joined with AND
 //
 func (sm *SQLStateManager) ListDefinitions(
@@ -232,25 +182,33 @@ func (sm *SQLStateManager) ListDefinitions(
 
 	var err error
 	var result DefinitionList
-	var whereClause, orderQuery string
-	where := append(sm.makeWhereClause(filters), sm.makeEnvWhereClause(envFilters)...)
-	if len(where) > 0 {
-		whereClause = fmt.Sprintf("where %s", strings.Join(where, " and "))
+	var orderQuery string
+
+	filters, tagFilters := splitTagFilters(filters)
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(definitionFilterColumns, 2)
+	if err = where.addFilters(filters); err != nil {
+		return result, err
 	}
+	if err = where.addEnvFilters("td.env", envFilters); err != nil {
+		return result, errors.WithStack(err)
+	}
+	where.addTagFilters(tagFilters)
 
 	orderQuery, err = sm.orderBy(&Definition{}, sortBy, order)
 	if err != nil {
 		return result, errors.WithStack(err)
 	}
 
-	sql := fmt.Sprintf(ListDefinitionsSQL, whereClause, orderQuery)
+	sql := fmt.Sprintf(ListDefinitionsSQL, where, orderQuery)
 	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", sql)
 
-	err = sm.db.Select(&result.Definitions, sql, limit, offset)
+	err = sm.db.Select(&result.Definitions, sql, append([]interface{}{limit, offset}, where.args...)...)
 	if err != nil {
 		return result, errors.Wrap(err, "issue running list definitions sql")
 	}
-	err = sm.db.Get(&result.Total, countSQL, nil, 0)
+	err = sm.db.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
 	if err != nil {
 		return result, errors.Wrap(err, "issue running list definitions count sql")
 	}
@@ -309,7 +267,25 @@ func (sm *SQLStateManager) UpdateDefinition(definitionID string, updates Definit
 	}
 
 	existing.UpdateWith(updates)
+	return sm.saveDefinition(definitionID, existing)
+}
 
+//
+// ReplaceDefinition replaces the contents of a definition wholesale; fields
+// that are unset in replacement are cleared
+//
+func (sm *SQLStateManager) ReplaceDefinition(definitionID string, replacement Definition) (Definition, error) {
+	existing, err := sm.GetDefinition(definitionID)
+	if err != nil {
+		return existing, errors.WithStack(err)
+	}
+	replacement.DefinitionID = existing.DefinitionID
+	replacement.GroupName = existing.GroupName
+	return sm.saveDefinition(definitionID, replacement)
+}
+
+func (sm *SQLStateManager) saveDefinition(definitionID string, existing Definition) (Definition, error) {
+	var err error
 	selectForUpdate := `SELECT * FROM task_def WHERE definition_id = $1 FOR UPDATE;`
 	deletePorts := `DELETE FROM task_def_ports WHERE task_def_id = $1;`
 	deleteTags := `DELETE FROM task_def_tags WHERE task_def_id = $1`
@@ -356,7 +332,8 @@ func (sm *SQLStateManager) UpdateDefinition(definitionID string, updates Definit
       env = $6,
       cpu = $7,
       gpu = $8,
-      adaptive_resource_allocation = $9
+      adaptive_resource_allocation = $9,
+      retry_policy = $10
     WHERE definition_id = $1;
     `
 	if _, err = tx.Exec(
@@ -369,7 +346,8 @@ func (sm *SQLStateManager) UpdateDefinition(definitionID string, updates Definit
 		existing.Env,
 		existing.Cpu,
 		existing.Gpu,
-		existing.AdaptiveResourceAllocation); err != nil {
+		existing.AdaptiveResourceAllocation,
+		existing.RetryPolicy); err != nil {
 		return existing, errors.Wrapf(err, "issue updating definition [%s]", definitionID)
 	}
 
@@ -384,6 +362,9 @@ func (sm *SQLStateManager) UpdateDefinition(definitionID string, updates Definit
 
 	if existing.Tags != nil {
 		for _, t := range *existing.Tags {
+			if len(t) == 0 {
+				continue
+			}
 			if _, err = tx.Exec(insertTags, t, t); err != nil {
 				tx.Rollback()
 				return existing, errors.WithStack(err)
@@ -440,9 +421,10 @@ func (sm *SQLStateManager) CreateDefinition(d Definition) error {
       env,
       cpu,
       gpu,
-      adaptive_resource_allocation
+      adaptive_resource_allocation,
+      retry_policy
     )
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
     `
 
 	if _, err = tx.Exec(insert,
@@ -455,7 +437,8 @@ func (sm *SQLStateManager) CreateDefinition(d Definition) error {
 		d.Env,
 		d.Cpu,
 		d.Gpu,
-		d.AdaptiveResourceAllocation); err != nil {
+		d.AdaptiveResourceAllocation,
+		d.RetryPolicy); err != nil {
 		tx.Rollback()
 		return errors.Wrapf(
 			err, "issue creating new task definition with alias [%s] and id [%s]", d.DefinitionID, d.Alias)
@@ -472,6 +455,9 @@ func (sm *SQLStateManager) CreateDefinition(d Definition) error {
 
 	if d.Tags != nil {
 		for _, t := range *d.Tags {
+			if len(t) == 0 {
+				continue
+			}
 			if _, err = tx.Exec(insertTags, t, t); err != nil {
 				tx.Rollback()
 				return errors.WithStack(err)
@@ -498,6 +484,7 @@ func (sm *SQLStateManager) DeleteDefinition(definitionID string) error {
 	statements := []string{
 		"DELETE FROM task_def_ports WHERE task_def_id = $1",
 		"DELETE FROM task_def_tags WHERE task_def_id = $1",
+		"DELETE FROM run_status_history WHERE run_id IN (SELECT run_id FROM task WHERE definition_id = $1)",
 		"DELETE FROM task WHERE definition_id = $1",
 		"DELETE FROM task_def WHERE definition_id = $1",
 	}
@@ -520,6 +507,87 @@ func (sm *SQLStateManager) DeleteDefinition(definitionID string) error {
 	return nil
 }
 
+//
+// CreateDefinitionRevision records d as the next revision of the definition.
+// Revisions of a definition are numbered under an advisory lock so
+// concurrent updates can't claim the same number.
+//
+func (sm *SQLStateManager) CreateDefinitionRevision(definitionID string, d Definition, userInfo UserInfo) (DefinitionRevision, error) {
+	r := DefinitionRevision{DefinitionID: definitionID, Definition: d, User: userInfo}
+	body, err := json.Marshal(d)
+	if err != nil {
+		return r, errors.WithStack(err)
+	}
+
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return r, errors.WithStack(err)
+	}
+	if _, err = tx.Exec(LockDefinitionRevisionsSQL, definitionID); err != nil {
+		tx.Rollback()
+		return r, errors.WithStack(err)
+	}
+	if err = tx.QueryRow(CreateDefinitionRevisionSQL,
+		definitionID, string(body), userInfo.Name, userInfo.Email).Scan(&r.Revision, &r.CreatedAt); err != nil {
+		tx.Rollback()
+		return r, errors.Wrapf(err, "issue creating revision of definition [%s]", definitionID)
+	}
+	return r, errors.WithStack(tx.Commit())
+}
+
+//
+// ListDefinitionRevisions returns the revisions of a definition, newest first
+//
+func (sm *SQLStateManager) ListDefinitionRevisions(definitionID string, limit int, offset int) (DefinitionRevisionList, error) {
+	var result DefinitionRevisionList
+
+	rows, err := sm.db.Query(ListDefinitionRevisionsSQL, definitionID, limit, offset)
+	if err != nil {
+		return result, errors.Wrapf(err, "issue listing revisions of definition [%s]", definitionID)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanDefinitionRevision(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Revisions = append(result.Revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	err = sm.db.Get(&result.Total, CountDefinitionRevisionsSQL, definitionID)
+	return result, errors.WithStack(err)
+}
+
+//
+// GetDefinitionRevision gets a single revision of a definition
+//
+func (sm *SQLStateManager) GetDefinitionRevision(definitionID string, revision int64) (DefinitionRevision, error) {
+	r, err := scanDefinitionRevision(sm.db.QueryRow(GetDefinitionRevisionSQL, definitionID, revision))
+	if err == sql.ErrNoRows {
+		return r, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Revision %d of definition %s not found", revision, definitionID)}
+	}
+	return r, err
+}
+
+func scanDefinitionRevision(row interface{ Scan(...interface{}) error }) (DefinitionRevision, error) {
+	var (
+		r    DefinitionRevision
+		body string
+	)
+	if err := row.Scan(&r.DefinitionID, &r.Revision, &body, &r.User.Name, &r.User.Email, &r.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return r, err
+		}
+		return r, errors.WithStack(err)
+	}
+	return r, errors.WithStack(json.Unmarshal([]byte(body), &r.Definition))
+}
+
 //
 // ListRuns returns a RunList
 // limit: limit the result to this many runs
@@ -533,7 +601,7 @@ func (sm *SQLStateManager) ListRuns(limit int, offset int, sortBy string, order
 
 	var err error
 	var result RunList
-	var whereClause, orderQuery string
+	var orderQuery string
 
 	if filters == nil {
 		filters = make(map[string][]string)
@@ -545,9 +613,18 @@ func (sm *SQLStateManager) ListRuns(limit int, offset int, sortBy string, order
 		filters["engine"] = []string{DefaultEngine}
 	}
 
-	where := append(sm.makeWhereClause(filters), sm.makeEnvWhereClause(envFilters)...)
-	if len(where) > 0 {
-		whereClause = fmt.Sprintf("where %s", strings.Join(where, " and "))
+	filters, labelFilters := splitLabelFilters(filters)
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(runFilterColumns, 2)
+	if err = where.addFilters(filters); err != nil {
+		return result, err
+	}
+	if err = where.addEnvFilters("t.env", envFilters); err != nil {
+		return result, errors.WithStack(err)
+	}
+	if err = where.addLabelFilters("t.labels", labelFilters); err != nil {
+		return result, errors.WithStack(err)
 	}
 
 	orderQuery, err = sm.orderBy(&Run{}, sortBy, order)
@@ -555,14 +632,14 @@ func (sm *SQLStateManager) ListRuns(limit int, offset int, sortBy string, order
 		return result, errors.WithStack(err)
 	}
 
-	sql := fmt.Sprintf(ListRunsSQL, whereClause, orderQuery)
+	sql := fmt.Sprintf(ListRunsSQL, where, orderQuery)
 	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", sql)
 
-	err = sm.db.Select(&result.Runs, sql, limit, offset)
+	err = sm.db.Select(&result.Runs, sql, append([]interface{}{limit, offset}, where.args...)...)
 	if err != nil {
 		return result, errors.Wrap(err, "issue running list runs sql")
 	}
-	err = sm.db.Get(&result.Total, countSQL, nil, 0)
+	err = sm.db.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
 	if err != nil {
 		return result, errors.Wrap(err, "issue running list runs count sql")
 	}
@@ -570,6 +647,102 @@ func (sm *SQLStateManager) ListRuns(limit int, offset int, sortBy string, order
 	return result, nil
 }
 
+//
+// ListRunsByCursor returns a page of runs after cursor, ordered by sortBy and
+// then run_id. An empty cursor returns the first page. NextCursor is set on
+// the result when there are more runs. When approximateTotal is set, Total is
+// the planner's estimate of the number of matching runs rather than an exact
+// count.
+//
+func (sm *SQLStateManager) ListRunsByCursor(limit int, cursor string, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string, approximateTotal bool) (RunList, error) {
+	var err error
+	var result RunList
+
+	if limit <= 0 {
+		return result, exceptions.MalformedInput{ErrorString: "limit must be positive when paginating by cursor"}
+	}
+	if _, err = sm.orderBy(&Run{}, sortBy, order); err != nil {
+		return result, errors.WithStack(err)
+	}
+	filters = withEngines(filters, engines)
+	fieldFilters, labelFilters := splitLabelFilters(filters)
+
+	// $1 is limit
+	where := newWhereBuilder(runFilterColumns, 1)
+	if err = where.addFilters(fieldFilters); err != nil {
+		return result, err
+	}
+	if err = where.addEnvFilters("t.env", envFilters); err != nil {
+		return result, errors.WithStack(err)
+	}
+	if err = where.addLabelFilters("t.labels", labelFilters); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	if approximateTotal {
+		if result.Total, err = sm.estimateRuns(filters, envFilters); err != nil {
+			return result, err
+		}
+	}
+
+	sortExpr := runFilterColumns[sortBy].expr
+	if len(cursor) > 0 {
+		c, value, err := decodeRunCursor(cursor, sortBy, order)
+		if err != nil {
+			return result, err
+		}
+		where.addKeyset(sortExpr, "t.run_id", order, value, c.RunID)
+	}
+
+	orderQuery := fmt.Sprintf("order by %s %s NULLS LAST, t.run_id %s", sortExpr, order, order)
+	sql := fmt.Sprintf(ListRunsByCursorSQL, where, orderQuery)
+
+	err = sm.db.Select(&result.Runs, sql, append([]interface{}{limit + 1}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list runs by cursor sql")
+	}
+
+	if len(result.Runs) > limit {
+		result.Runs = result.Runs[:limit]
+		next := encodeRunCursor(sortBy, order, result.Runs[limit-1])
+		result.NextCursor = &next
+	}
+	return result, nil
+}
+
+//
+// estimateRuns returns the planner's row estimate for runs matching filters,
+// which is far cheaper than a count(*) on a large task table
+//
+func (sm *SQLStateManager) estimateRuns(filters map[string][]string, envFilters map[string]string) (int, error) {
+	fieldFilters, labelFilters := splitLabelFilters(filters)
+	where := newWhereBuilder(runFilterColumns, 0)
+	if err := where.addFilters(fieldFilters); err != nil {
+		return 0, err
+	}
+	if err := where.addEnvFilters("t.env", envFilters); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if err := where.addLabelFilters("t.labels", labelFilters); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var plan string
+	if err := sm.db.Get(&plan, fmt.Sprintf(EstimateRunsSQL, where), where.args...); err != nil {
+		return 0, errors.Wrap(err, "issue running estimate runs sql")
+	}
+
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &explained); err != nil || len(explained) == 0 {
+		return 0, errors.Errorf("unable to parse query plan [%s]", plan)
+	}
+	return int(explained[0].Plan.Rows), nil
+}
+
 //
 // GetRun gets run by id
 //
@@ -619,9 +792,12 @@ func (sm *SQLStateManager) GetResources(runID string) (Run, error) {
 }
 
 //
-// UpdateRun updates run with updates - can be partial
+// UpdateRun updates run with updates - can be partial. The run is locked
+// while the status transition is checked so concurrent updates can't move it
+// through an illegal transition; accepted transitions are recorded in
+// run_status_history along with their source.
 //
-func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
+func (sm *SQLStateManager) UpdateRun(runID string, updates Run, source string) (Run, error) {
 	start := time.Now()
 	var (
 		err      error
@@ -681,13 +857,43 @@ func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
 			&existing.RunExceptions,
 			&existing.ActiveDeadlineSeconds,
 			&existing.SparkExtension,
-			&existing.MetricsUri)
+			&existing.MetricsUri,
+			&existing.ExecutableSnapshotID,
+			&existing.Labels,
+			&existing.ScheduleID,
+			&existing.RetryPolicy,
+			&existing.OriginalRunID,
+			&existing.RetryAttempt,
+			&existing.RetryAt,
+			&existing.RetryState,
+			&existing.RetryRunID,
+			&existing.ArrayParentID,
+			&existing.ArrayIndex,
+			&existing.ArraySize,
+			&existing.MaxParallelism,
+			&existing.User,
+			&existing.QuotaHeldAt,
+			&existing.Priority,
+			&existing.ExitCategory)
 	}
 	if err != nil {
+		tx.Rollback()
 		return existing, errors.WithStack(err)
 	}
+	if len(existing.RunID) == 0 {
+		tx.Rollback()
+		return existing, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Run with id %s not found", runID)}
+	}
+
+	transition, err := checkTransition(existing, updates, source)
+	if err != nil {
+		tx.Rollback()
+		return existing, err
+	}
 
 	existing.UpdateWith(updates)
+	scheduleRetry(&existing, time.Now())
 
 	update := `
     UPDATE task SET
@@ -728,7 +934,10 @@ func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
 		run_exceptions = $36,
 		active_deadline_seconds = $37,
 		spark_extension = $38,
-		metrics_uri = $39
+		metrics_uri = $39,
+		retry_at = $40,
+		retry_state = $41,
+		exit_category = $42
     WHERE run_id = $1;
     `
 
@@ -772,11 +981,22 @@ func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
 		existing.RunExceptions,
 		existing.ActiveDeadlineSeconds,
 		existing.SparkExtension,
-		existing.MetricsUri); err != nil {
+		existing.MetricsUri,
+		existing.RetryAt,
+		existing.RetryState,
+		existing.ExitCategory); err != nil {
 		tx.Rollback()
 		return existing, errors.WithStack(err)
 	}
 
+	if transition != nil {
+		if _, err = tx.Exec(CreateRunTransitionSQL,
+			transition.RunID, transition.FromStatus, transition.ToStatus, transition.Source); err != nil {
+			tx.Rollback()
+			return existing, errors.Wrapf(err, "issue recording status transition of run [%s]", runID)
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		return existing, errors.WithStack(err)
 	}
@@ -832,7 +1052,19 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 		task_type,
 		command_hash,
 		spark_extension,
-		metrics_uri
+		metrics_uri,
+		executable_snapshot_id,
+		labels,
+		schedule_id,
+		retry_policy,
+		original_run_id,
+		retry_attempt,
+		array_parent_id,
+		array_index,
+		array_size,
+		max_parallelism,
+		"user",
+		priority
     ) VALUES (
         $1,
 		$2,
@@ -873,7 +1105,19 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 		$37,
 		MD5($16),
 		$38,
-		$39
+		$39,
+		$40,
+		$41,
+		$42,
+		$43,
+		$44,
+		$45,
+		$46,
+		$47,
+		$48,
+		$49,
+		$50,
+		$51
 	);
     `
 
@@ -921,11 +1165,28 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 		r.ActiveDeadlineSeconds,
 		r.TaskType,
 		r.SparkExtension,
-		r.MetricsUri); err != nil {
+		r.MetricsUri,
+		r.ExecutableSnapshotID,
+		r.Labels,
+		r.ScheduleID,
+		r.RetryPolicy,
+		r.OriginalRunID,
+		r.RetryAttempt,
+		r.ArrayParentID,
+		r.ArrayIndex,
+		r.ArraySize,
+		r.MaxParallelism,
+		r.User,
+		r.Priority); err != nil {
 		tx.Rollback()
 		return errors.Wrapf(err, "issue creating new task run with id [%s]", r.RunID)
 	}
 
+	if _, err = tx.Exec(CreateRunTransitionSQL, r.RunID, "", r.Status, TransitionSourceAPI); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "issue recording status transition of run [%s]", r.RunID)
+	}
+
 	if err = tx.Commit(); err != nil {
 		return errors.WithStack(err)
 	}
@@ -934,148 +1195,1281 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 }
 
 //
-// ListGroups returns a list of the existing group names.
+// ListRunTransitions returns the status transitions of a run, oldest first
 //
-func (sm *SQLStateManager) ListGroups(limit int, offset int, name *string) (GroupsList, error) {
-	var (
-		err         error
-		result      GroupsList
-		whereClause string
-	)
-	if name != nil && len(*name) > 0 {
-		whereClause = fmt.Sprintf("where %s", strings.Join(
-			sm.makeWhereClause(map[string][]string{"group_name": {*name}}), " and "))
+func (sm *SQLStateManager) ListRunTransitions(runID string) (RunStatusTransitionList, error) {
+	var result RunStatusTransitionList
+	if err := sm.readonlyDB.Select(&result.Transitions, ListRunTransitionsSQL, runID); err != nil {
+		return result, errors.Wrapf(err, "issue listing status transitions of run [%s]", runID)
 	}
+	result.Total = len(result.Transitions)
+	return result, nil
+}
 
-	sql := fmt.Sprintf(ListGroupsSQL, whereClause)
-	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", sql)
+//
+// ClaimDueRetries locks up to limit stopped runs whose retry is due at or
+// before now and marks them retried. Rows locked by a concurrent claim are
+// skipped so each run is retried by exactly one caller.
+//
+func (sm *SQLStateManager) ClaimDueRetries(now time.Time, limit int) ([]Run, error) {
+	var due []Run
 
-	err = sm.db.Select(&result.Groups, sql, limit, offset)
+	tx, err := sm.db.Beginx()
 	if err != nil {
-		return result, errors.Wrap(err, "issue running list groups sql")
+		return due, errors.WithStack(err)
 	}
-	err = sm.db.Get(&result.Total, countSQL, nil, 0)
-	if err != nil {
-		return result, errors.Wrap(err, "issue running list groups count sql")
+
+	if err = tx.Select(&due, ClaimDueRetriesSQL, now, limit); err != nil {
+		tx.Rollback()
+		return due, errors.Wrap(err, "issue claiming due retries")
 	}
 
-	return result, nil
+	for _, r := range due {
+		if _, err = tx.Exec(MarkRetriedSQL, r.RunID); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "issue marking run [%s] retried", r.RunID)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return due, nil
 }
 
 //
-// ListTags returns a list of the existing tags.
+// RecordRetryRun links a run to the attempt that retried it
 //
-func (sm *SQLStateManager) ListTags(limit int, offset int, name *string) (TagsList, error) {
-	var (
-		err         error
-		result      TagsList
-		whereClause string
-	)
-	if name != nil && len(*name) > 0 {
-		whereClause = fmt.Sprintf("where %s", strings.Join(
-			sm.makeWhereClause(map[string][]string{"text": {*name}}), " and "))
+func (sm *SQLStateManager) RecordRetryRun(runID string, retryRunID string) error {
+	if _, err := sm.db.Exec(RecordRetryRunSQL, runID, retryRunID); err != nil {
+		return errors.Wrapf(err, "issue recording retry [%s] of run [%s]", retryRunID, runID)
 	}
+	return nil
+}
 
-	sql := fmt.Sprintf(ListTagsSQL, whereClause)
-	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", sql)
-
-	err = sm.db.Select(&result.Tags, sql, limit, offset)
-	if err != nil {
-		return result, errors.Wrap(err, "issue running list tags sql")
-	}
-	err = sm.db.Get(&result.Total, countSQL, nil, 0)
-	if err != nil {
-		return result, errors.Wrap(err, "issue running list tags count sql")
+//
+// RescheduleRetry schedules the retry of a run claimed by ClaimDueRetries
+// again at retryAt, for when its attempt couldn't be queued. Runs whose
+// attempt has been recorded are left alone.
+//
+func (sm *SQLStateManager) RescheduleRetry(runID string, retryAt time.Time) error {
+	if _, err := sm.db.Exec(RescheduleRetrySQL, runID, retryAt); err != nil {
+		return errors.Wrapf(err, "issue rescheduling retry of run [%s]", runID)
 	}
-
-	return result, nil
+	return nil
 }
 
 //
-// initWorkerTable initializes the `worker` table with values from the config
+// ReleaseArrayRuns marks held children of the array run parentID queued,
+// lowest index first, until maxActive children are queued or running; a
+// maxActive of zero releases every held child. The parent is locked so
+// concurrent releases never exceed maxActive. Released runs are returned for
+// the caller to enqueue.
 //
-func (sm *SQLStateManager) initWorkerTable(c config.Config) error {
-	// Get worker count from configuration (set to 1 as default)
+func (sm *SQLStateManager) ReleaseArrayRuns(parentID string, maxActive int, now time.Time) ([]Run, error) {
+	var released []Run
 
-	for _, engine := range Engines {
-		retryCount := int64(1)
-		if c.IsSet(fmt.Sprintf("worker.%s.retry_worker_count_per_instance", engine)) {
-			retryCount = int64(c.GetInt("worker.ecs.retry_worker_count_per_instance"))
-		}
-		submitCount := int64(1)
-		if c.IsSet(fmt.Sprintf("worker.%s.submit_worker_count_per_instance", engine)) {
-			submitCount = int64(c.GetInt("worker.ecs.submit_worker_count_per_instance"))
-		}
-		statusCount := int64(1)
-		if c.IsSet(fmt.Sprintf("worker.%s.status_worker_count_per_instance", engine)) {
-			statusCount = int64(c.GetInt("worker.ecs.status_worker_count_per_instance"))
-		}
+	tx, err := sm.db.Beginx()
+	if err != nil {
+		return released, errors.WithStack(err)
+	}
 
-		var err error
-		insert := `
-		INSERT INTO worker (worker_type, count_per_instance, engine)
-		VALUES ('retry', $1, $4), ('submit', $2, $4), ('status', $3, $4);
-	`
+	var locked []string
+	if err = tx.Select(&locked, LockArrayRunSQL, parentID); err != nil {
+		tx.Rollback()
+		return released, errors.Wrapf(err, "issue locking array run [%s]", parentID)
+	}
+	if len(locked) == 0 {
+		tx.Rollback()
+		return released, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Array run with id %s not found", parentID)}
+	}
 
-		tx, err := sm.db.Begin()
-		if err != nil {
-			return errors.WithStack(err)
+	limit := MaxArraySize
+	if maxActive > 0 {
+		var active int
+		if err = tx.Get(&active, CountActiveArrayRunsSQL, parentID); err != nil {
+			tx.Rollback()
+			return released, errors.Wrapf(err, "issue counting active runs of array [%s]", parentID)
 		}
-
-		if _, err = tx.Exec(insert, retryCount, submitCount, statusCount, engine); err != nil {
+		if limit = maxActive - active; limit <= 0 {
 			tx.Rollback()
-			return errors.Wrapf(err, "issue populating worker table")
+			return released, nil
 		}
+	}
 
-		err = tx.Commit()
+	if err = tx.Select(&released, HeldArrayRunsSQL, parentID, limit); err != nil {
+		tx.Rollback()
+		return released, errors.Wrapf(err, "issue listing held runs of array [%s]", parentID)
+	}
 
-		if err != nil {
-			return errors.WithStack(err)
+	for i := range released {
+		if _, err = tx.Exec(MarkArrayRunQueuedSQL, released[i].RunID, now); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "issue releasing run [%s]", released[i].RunID)
 		}
+		released[i].QueuedAt = &now
 	}
 
-	return nil
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return released, nil
 }
 
 //
-// ListWorkers returns list of workers
+// ClaimIdempotencyKey stores k unless its owner used the same key within
+// window, and returns the stored key; the claim succeeded when the stored
+// key's run is k's. The key's primary key makes concurrent claims race
+// safely.
 //
-func (sm *SQLStateManager) ListWorkers(engine string) (WorkersList, error) {
-	var err error
-	var result WorkersList
-
-	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", ListWorkersSQL)
-
-	err = sm.readonlyDB.Select(&result.Workers, GetWorkerEngine, engine)
+func (sm *SQLStateManager) ClaimIdempotencyKey(k IdempotencyKey, window time.Duration) (IdempotencyKey, error) {
+	var stored IdempotencyKey
+	err := sm.db.QueryRow(ClaimIdempotencyKeySQL,
+		k.OwnerID, k.Key, k.RequestHash, k.RunID, k.CreatedAt, k.CreatedAt.Add(-window)).Scan(
+		&stored.OwnerID, &stored.Key, &stored.RequestHash, &stored.RunID, &stored.CreatedAt)
+	if err == sql.ErrNoRows {
+		err = sm.db.QueryRow(GetIdempotencyKeySQL, k.OwnerID, k.Key).Scan(
+			&stored.OwnerID, &stored.Key, &stored.RequestHash, &stored.RunID, &stored.CreatedAt)
+	}
 	if err != nil {
-		return result, errors.Wrap(err, "issue running list workers sql")
+		return stored, errors.Wrapf(err, "issue claiming idempotency key [%s]", k.Key)
 	}
+	return stored, nil
+}
 
-	err = sm.readonlyDB.Get(&result.Total, countSQL)
-	if err != nil {
-		return result, errors.Wrap(err, "issue running list workers count sql")
+//
+// ReleaseIdempotencyKey deletes k if it is still held by k's run, so the key
+// can be used again after its run failed to be created
+//
+func (sm *SQLStateManager) ReleaseIdempotencyKey(k IdempotencyKey) error {
+	if _, err := sm.db.Exec(ReleaseIdempotencyKeySQL, k.OwnerID, k.Key, k.RunID); err != nil {
+		return errors.Wrapf(err, "issue releasing idempotency key [%s]", k.Key)
 	}
+	return nil
+}
 
-	return result, nil
+//
+// quotaScopeColumns are the task columns runs are matched to quotas by
+//
+var quotaScopeColumns = map[string]string{
+	QuotaScopeGroup:      "group_name",
+	QuotaScopeOwner:      `"user"`,
+	QuotaScopeDefinition: "definition_id",
 }
 
 //
-// GetWorker returns data for a single worker.
+// PutQuota creates the passed in quota or replaces the limits of an existing
+// quota with the same scope and name
 //
-func (sm *SQLStateManager) GetWorker(workerType string, engine string) (w Worker, err error) {
-	if err := sm.readonlyDB.Get(&w, GetWorkerSQL, workerType, engine); err != nil {
-		if err == sql.ErrNoRows {
-			err = exceptions.MissingResource{
-				ErrorString: fmt.Sprintf("Worker of type %s not found", workerType)}
-		} else {
-			err = errors.Wrapf(err, "issue getting worker of type [%s]", workerType)
-		}
+func (sm *SQLStateManager) PutQuota(q Quota) (Quota, error) {
+	stored, err := scanQuota(sm.db.QueryRow(PutQuotaSQL, q.Scope, q.Name, q.MaxRuns, q.MaxCpu, q.MaxMemory))
+	if err != nil {
+		return stored, errors.Wrapf(err, "issue putting quota [%s]", q.Key())
 	}
-	return
+	return stored, nil
 }
 
 //
-// UpdateWorker updates a single worker.
+// GetQuota gets a quota by scope and name
+//
+func (sm *SQLStateManager) GetQuota(scope string, name string) (Quota, error) {
+	q, err := scanQuota(sm.db.QueryRow(GetQuotaSQL, scope, name))
+	if err == sql.ErrNoRows {
+		return q, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Quota %s/%s not found", scope, name)}
+	}
+	if err != nil {
+		return q, errors.Wrapf(err, "issue getting quota [%s/%s]", scope, name)
+	}
+	return q, nil
+}
+
+//
+// ListQuotas returns a QuotaList
+// limit: limit the result to this many quotas
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on Quota - joined with AND
+//
+func (sm *SQLStateManager) ListQuotas(limit int, offset int, sortBy string, order string, filters map[string][]string) (QuotaList, error) {
+	var result QuotaList
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(quotaFilterColumns, 2)
+	if err := where.addFilters(filters); err != nil {
+		return result, err
+	}
+
+	orderQuery, err := sm.orderBy(&Quota{}, sortBy, order)
+	if err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	listSQL := fmt.Sprintf(ListQuotasSQL, where, orderQuery)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", listSQL)
+
+	rows, err := sm.readonlyDB.Query(listSQL, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list quotas sql")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		q, err := scanQuota(rows)
+		if err != nil {
+			return result, errors.WithStack(err)
+		}
+		result.Quotas = append(result.Quotas, q)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	err = sm.readonlyDB.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list quotas count sql")
+	}
+	return result, nil
+}
+
+//
+// DeleteQuota deletes a quota; runs it held back are released by the submit
+// worker
+//
+func (sm *SQLStateManager) DeleteQuota(scope string, name string) error {
+	result, err := sm.db.Exec(DeleteQuotaSQL, scope, name)
+	if err != nil {
+		return errors.Wrapf(err, "issue deleting quota [%s/%s]", scope, name)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Quota %s/%s not found", scope, name)}
+	}
+	return nil
+}
+
+//
+// GetQuotaUsage sums the PENDING and RUNNING runs matching q and counts the
+// QUEUED runs matching q that are held back by quotas
+//
+func (sm *SQLStateManager) GetQuotaUsage(q Quota) (QuotaUsage, error) {
+	var usage QuotaUsage
+
+	column, ok := quotaScopeColumns[q.Scope]
+	if !ok {
+		return usage, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("invalid quota scope [%s]", q.Scope)}
+	}
+	if err := sm.readonlyDB.QueryRow(fmt.Sprintf(QuotaUsageSQL, column), q.Name).Scan(
+		&usage.Runs, &usage.Cpu, &usage.Memory, &usage.Held); err != nil {
+		return usage, errors.Wrapf(err, "issue getting usage of quota [%s]", q.Key())
+	}
+	return usage, nil
+}
+
+//
+// ListQuotaHeldRuns returns up to limit queued runs held back by quotas,
+// oldest first
+//
+func (sm *SQLStateManager) ListQuotaHeldRuns(limit int) ([]Run, error) {
+	var held []Run
+	if err := sm.db.Select(&held, QuotaHeldRunsSQL, limit); err != nil {
+		return held, errors.Wrap(err, "issue listing runs held by quotas")
+	}
+	return held, nil
+}
+
+//
+// HoldRunForQuota marks a queued run held back by quotas
+//
+func (sm *SQLStateManager) HoldRunForQuota(runID string, heldAt time.Time) error {
+	if _, err := sm.db.Exec(HoldRunForQuotaSQL, runID, heldAt); err != nil {
+		return errors.Wrapf(err, "issue holding run [%s] for quota", runID)
+	}
+	return nil
+}
+
+//
+// ReleaseQuotaHold clears the quota hold of a run and returns whether it was
+// held; of concurrent releases of the same run, exactly one returns true
+//
+func (sm *SQLStateManager) ReleaseQuotaHold(runID string) (bool, error) {
+	result, err := sm.db.Exec(ReleaseQuotaHoldSQL, runID)
+	if err != nil {
+		return false, errors.Wrapf(err, "issue releasing quota hold of run [%s]", runID)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return n > 0, nil
+}
+
+func scanQuota(row interface{ Scan(...interface{}) error }) (Quota, error) {
+	var q Quota
+	err := row.Scan(&q.Scope, &q.Name, &q.MaxRuns, &q.MaxCpu, &q.MaxMemory, &q.CreatedAt, &q.UpdatedAt)
+	return q, err
+}
+
+//
+// CreateWebhook stores a new webhook
+//
+func (sm *SQLStateManager) CreateWebhook(w Webhook) (Webhook, error) {
+	if err := sm.db.QueryRow(CreateWebhookSQL,
+		w.WebhookID, w.Scope, w.Name, w.URL, w.Events, w.Secret, w.CreatedBy).Scan(&w.CreatedAt); err != nil {
+		return w, errors.Wrapf(err, "issue creating webhook [%s]", w.WebhookID)
+	}
+	return w, nil
+}
+
+//
+// GetWebhook gets a webhook by id
+//
+func (sm *SQLStateManager) GetWebhook(webhookID string) (Webhook, error) {
+	w, err := scanWebhook(sm.db.QueryRow(GetWebhookSQL, webhookID))
+	if err == sql.ErrNoRows {
+		return w, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Webhook with id %s not found", webhookID)}
+	}
+	if err != nil {
+		return w, errors.Wrapf(err, "issue getting webhook with id [%s]", webhookID)
+	}
+	return w, nil
+}
+
+//
+// ListWebhooks returns a WebhookList
+// limit: limit the result to this many webhooks
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on Webhook - joined with AND
+//
+func (sm *SQLStateManager) ListWebhooks(limit int, offset int, sortBy string, order string, filters map[string][]string) (WebhookList, error) {
+	var result WebhookList
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(webhookFilterColumns, 2)
+	if err := where.addFilters(filters); err != nil {
+		return result, err
+	}
+
+	orderQuery, err := sm.orderBy(&Webhook{}, sortBy, order)
+	if err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	listSQL := fmt.Sprintf(ListWebhooksSQL, where, orderQuery)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", listSQL)
+
+	rows, err := sm.readonlyDB.Query(listSQL, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list webhooks sql")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return result, errors.WithStack(err)
+		}
+		result.Webhooks = append(result.Webhooks, w)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	err = sm.readonlyDB.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list webhooks count sql")
+	}
+	return result, nil
+}
+
+//
+// DeleteWebhook deletes a webhook along with its deliveries
+//
+func (sm *SQLStateManager) DeleteWebhook(webhookID string) error {
+	result, err := sm.db.Exec(DeleteWebhookSQL, webhookID)
+	if err != nil {
+		return errors.Wrapf(err, "issue deleting webhook [%s]", webhookID)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Webhook with id %s not found", webhookID)}
+	}
+	return nil
+}
+
+//
+// ListRunWebhooks returns the webhooks whose scope covers run, oldest first
+//
+func (sm *SQLStateManager) ListRunWebhooks(run Run) ([]Webhook, error) {
+	var templateID string
+	if run.ExecutableType != nil && *run.ExecutableType == ExecutableTypeTemplate && run.ExecutableID != nil {
+		templateID = *run.ExecutableID
+	}
+
+	rows, err := sm.db.Query(ListRunWebhooksSQL, run.DefinitionID, templateID, run.GroupName, run.RunID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "issue listing webhooks of run [%s]", run.RunID)
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, errors.WithStack(rows.Err())
+}
+
+//
+// CreateWebhookDelivery queues a delivery and returns whether it was queued;
+// a delivery of an event the webhook already got for the same transition of
+// the run is dropped
+//
+func (sm *SQLStateManager) CreateWebhookDelivery(d WebhookDelivery) (bool, error) {
+	result, err := sm.db.Exec(CreateWebhookDeliverySQL,
+		d.DeliveryID, d.WebhookID, d.RunID, d.Event, d.Sequence, []byte(d.Payload), d.Status, d.NextAttemptAt)
+	if err != nil {
+		return false, errors.Wrapf(err, "issue creating webhook delivery [%s]", d.DeliveryID)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return n > 0, nil
+}
+
+//
+// ClaimWebhookDeliveries locks up to limit pending deliveries due at or
+// before now and leases them for lease; replicas skip each other's claims
+//
+func (sm *SQLStateManager) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ClaimWebhookDeliveriesSQL, now, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "issue claiming webhook deliveries")
+	}
+
+	var due []WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, errors.WithStack(err)
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, errors.WithStack(err)
+	}
+
+	for _, d := range due {
+		if _, err = tx.Exec(LeaseWebhookDeliverySQL, d.DeliveryID, now.Add(lease)); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "issue leasing webhook delivery [%s]", d.DeliveryID)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return due, nil
+}
+
+//
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+//
+func (sm *SQLStateManager) UpdateWebhookDelivery(d WebhookDelivery) error {
+	if _, err := sm.db.Exec(UpdateWebhookDeliverySQL,
+		d.DeliveryID, d.Status, d.Attempts, d.LastError, d.NextAttemptAt, d.DeliveredAt); err != nil {
+		return errors.Wrapf(err, "issue updating webhook delivery [%s]", d.DeliveryID)
+	}
+	return nil
+}
+
+//
+// ListWebhookDeliveries returns the deliveries of a webhook, newest first
+//
+func (sm *SQLStateManager) ListWebhookDeliveries(webhookID string, limit int, offset int) (WebhookDeliveryList, error) {
+	var result WebhookDeliveryList
+
+	rows, err := sm.readonlyDB.Query(ListWebhookDeliveriesSQL, webhookID, limit, offset)
+	if err != nil {
+		return result, errors.Wrapf(err, "issue listing deliveries of webhook [%s]", webhookID)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return result, errors.WithStack(err)
+		}
+		result.Deliveries = append(result.Deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	if err = sm.readonlyDB.Get(&result.Total, CountWebhookDeliveriesSQL, webhookID); err != nil {
+		return result, errors.Wrapf(err, "issue counting deliveries of webhook [%s]", webhookID)
+	}
+	return result, nil
+}
+
+//
+// ListExitReasonRules returns the stored exit reason rules in order
+//
+func (sm *SQLStateManager) ListExitReasonRules() (ExitReasonRules, error) {
+	rows, err := sm.readonlyDB.Query(ListExitReasonRulesSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "issue listing exit reason rules")
+	}
+	defer rows.Close()
+
+	var rules ExitReasonRules
+	for rows.Next() {
+		var r ExitReasonRule
+		if err = rows.Scan(&r.Name, &r.Pattern, &r.Target, &r.Category, &r.Message); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, errors.WithStack(rows.Err())
+}
+
+//
+// PutExitReasonRules replaces the stored exit reason rules with rules
+//
+func (sm *SQLStateManager) PutExitReasonRules(rules ExitReasonRules) error {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = tx.Exec(DeleteExitReasonRulesSQL); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "issue deleting exit reason rules")
+	}
+	for i, r := range rules {
+		if _, err = tx.Exec(CreateExitReasonRuleSQL, i, r.Name, r.Pattern, r.Target, r.Category, r.Message); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "issue creating exit reason rule [%s]", r.Name)
+		}
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+//
+// CountRunsByExitCategory counts the runs matching filters in each exit
+// category, most common first; runs without a category aren't counted
+//
+func (sm *SQLStateManager) CountRunsByExitCategory(filters map[string][]string) ([]ExitCategoryCount, error) {
+	filters, labelFilters := splitLabelFilters(filters)
+	where := newWhereBuilder(runFilterColumns, 0)
+	if err := where.addFilters(filters); err != nil {
+		return nil, err
+	}
+	if err := where.addLabelFilters("t.labels", labelFilters); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	where.clauses = append(where.clauses, "t.exit_category is not null")
+
+	rows, err := sm.readonlyDB.Query(fmt.Sprintf(CountRunsByExitCategorySQL, where), where.args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "issue counting runs by exit category")
+	}
+	defer rows.Close()
+
+	counts := []ExitCategoryCount{}
+	for rows.Next() {
+		var c ExitCategoryCount
+		if err = rows.Scan(&c.Category, &c.Count); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, errors.WithStack(rows.Err())
+}
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.WebhookID, &w.Scope, &w.Name, &w.URL, &w.Events, &w.Secret, &w.CreatedBy, &w.CreatedAt)
+	return w, err
+}
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	err := row.Scan(&d.DeliveryID, &d.WebhookID, &d.RunID, &d.Event, &d.Sequence, &payload, &d.Status, &d.Attempts,
+		&d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt)
+	d.Payload = payload
+	return d, err
+}
+
+//
+// CreateExecutableSnapshot stores an executable snapshot; snapshots are
+// content addressed so storing an existing snapshot is a no-op
+//
+func (sm *SQLStateManager) CreateExecutableSnapshot(s ExecutableSnapshot) error {
+	body, err := s.Body()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = sm.db.Exec(CreateExecutableSnapshotSQL,
+		s.SnapshotID, s.ExecutableID, s.ExecutableType, string(body)); err != nil {
+		return errors.Wrapf(err, "issue creating executable snapshot [%s]", s.SnapshotID)
+	}
+	return nil
+}
+
+//
+// GetExecutableSnapshot gets an executable snapshot by id
+//
+func (sm *SQLStateManager) GetExecutableSnapshot(snapshotID string) (ExecutableSnapshot, error) {
+	var (
+		s    ExecutableSnapshot
+		body string
+	)
+	err := sm.db.QueryRow(GetExecutableSnapshotSQL, snapshotID).Scan(
+		&s.SnapshotID, &s.ExecutableID, &s.ExecutableType, &body, &s.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s, exceptions.MissingResource{
+				ErrorString: fmt.Sprintf("Executable snapshot with id %s not found", snapshotID)}
+		}
+		return s, errors.Wrapf(err, "issue getting executable snapshot with id [%s]", snapshotID)
+	}
+	return s, errors.WithStack(s.SetBody([]byte(body)))
+}
+
+//
+// CreateAuditEvent stores an audit event
+//
+func (sm *SQLStateManager) CreateAuditEvent(e AuditEvent) error {
+	if _, err := sm.db.Exec(CreateAuditEventSQL,
+		e.EventID, e.Actor.Name, e.Actor.Email, e.Action, e.TargetType, e.TargetID,
+		nullableJSON(e.Before), nullableJSON(e.After)); err != nil {
+		return errors.Wrapf(err, "issue creating audit event [%s] for %s [%s]", e.Action, e.TargetType, e.TargetID)
+	}
+	return nil
+}
+
+//
+// ListAuditEvents returns an AuditEventList
+// limit: limit the result to this many events
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on AuditEvent - joined with AND
+//
+func (sm *SQLStateManager) ListAuditEvents(limit int, offset int, sortBy string, order string, filters map[string][]string) (AuditEventList, error) {
+	var result AuditEventList
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(auditFilterColumns, 2)
+	if err := where.addFilters(filters); err != nil {
+		return result, err
+	}
+
+	orderQuery, err := sm.orderBy(&AuditEvent{}, sortBy, order)
+	if err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	listSQL := fmt.Sprintf(ListAuditEventsSQL, where, orderQuery)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", listSQL)
+
+	rows, err := sm.readonlyDB.Query(listSQL, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list audit events sql")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e             AuditEvent
+			before, after sql.NullString
+		)
+		if err = rows.Scan(&e.EventID, &e.Actor.Name, &e.Actor.Email, &e.Action,
+			&e.TargetType, &e.TargetID, &before, &after, &e.CreatedAt); err != nil {
+			return result, errors.WithStack(err)
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		result.Events = append(result.Events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	err = sm.readonlyDB.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list audit events count sql")
+	}
+	return result, nil
+}
+
+//
+// CreateSchedule creates the passed in schedule
+//
+func (sm *SQLStateManager) CreateSchedule(s Schedule) error {
+	body, err := s.RequestBody()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = sm.db.Exec(CreateScheduleSQL,
+		s.ScheduleID, s.Name, s.CronExpression, s.TimeZone, s.ExecutableType, s.ExecutableID,
+		string(body), s.OverlapPolicy, s.IsEnabled(), s.NextRunAt); err != nil {
+		return errors.Wrapf(err, "issue creating schedule [%s]", s.ScheduleID)
+	}
+	return nil
+}
+
+//
+// GetSchedule gets a schedule by id
+//
+func (sm *SQLStateManager) GetSchedule(scheduleID string) (Schedule, error) {
+	s, err := scanSchedule(sm.db.QueryRow(GetScheduleSQL, scheduleID))
+	if err == sql.ErrNoRows {
+		return s, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+	}
+	if err != nil {
+		return s, errors.Wrapf(err, "issue getting schedule with id [%s]", scheduleID)
+	}
+	return s, nil
+}
+
+//
+// ListSchedules returns a ScheduleList
+// limit: limit the result to this many schedules
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on Schedule - joined with AND
+//
+func (sm *SQLStateManager) ListSchedules(limit int, offset int, sortBy string, order string, filters map[string][]string) (ScheduleList, error) {
+	var result ScheduleList
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(scheduleFilterColumns, 2)
+	if err := where.addFilters(filters); err != nil {
+		return result, err
+	}
+
+	orderQuery, err := sm.orderBy(&Schedule{}, sortBy, order)
+	if err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	listSQL := fmt.Sprintf(ListSchedulesSQL, where, orderQuery)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", listSQL)
+
+	rows, err := sm.readonlyDB.Query(listSQL, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list schedules sql")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Schedules = append(result.Schedules, s)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	err = sm.readonlyDB.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list schedules count sql")
+	}
+	return result, nil
+}
+
+//
+// UpdateSchedule applies updates to a schedule
+//
+func (sm *SQLStateManager) UpdateSchedule(scheduleID string, updates Schedule) (Schedule, error) {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return Schedule{}, errors.WithStack(err)
+	}
+
+	existing, err := scanSchedule(tx.QueryRow(GetScheduleSQLForUpdate, scheduleID))
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return existing, exceptions.MissingResource{
+				ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+		}
+		return existing, errors.Wrapf(err, "issue getting schedule with id [%s]", scheduleID)
+	}
+
+	existing.UpdateWith(updates)
+	body, err := existing.RequestBody()
+	if err != nil {
+		tx.Rollback()
+		return existing, errors.WithStack(err)
+	}
+
+	if _, err = tx.Exec(UpdateScheduleSQL,
+		scheduleID, existing.Name, existing.CronExpression, existing.TimeZone, existing.ExecutableType,
+		existing.ExecutableID, string(body), existing.OverlapPolicy, existing.IsEnabled(), existing.NextRunAt); err != nil {
+		tx.Rollback()
+		return existing, errors.Wrapf(err, "issue updating schedule [%s]", scheduleID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return existing, errors.WithStack(err)
+	}
+	return existing, nil
+}
+
+//
+// DeleteSchedule deletes a schedule; runs it created are kept
+//
+func (sm *SQLStateManager) DeleteSchedule(scheduleID string) error {
+	result, err := sm.db.Exec(DeleteScheduleSQL, scheduleID)
+	if err != nil {
+		return errors.Wrapf(err, "issue deleting schedule [%s]", scheduleID)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Schedule with id %s not found", scheduleID)}
+	}
+	return nil
+}
+
+//
+// ClaimDueSchedules locks up to limit enabled schedules due at or before now
+// and advances each to its next fire time. Rows locked by a concurrent claim
+// are skipped so each fire time is claimed by exactly one caller. The
+// returned schedules carry the fire time that was claimed in NextRunAt.
+//
+func (sm *SQLStateManager) ClaimDueSchedules(now time.Time, limit int) ([]Schedule, error) {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ClaimDueSchedulesSQL, now, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "issue claiming due schedules")
+	}
+
+	var due []Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		due = append(due, s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, errors.WithStack(err)
+	}
+
+	for _, s := range due {
+		// Missed fire times (eg. while no scheduler was running) collapse into
+		// the one being claimed
+		var next *time.Time
+		if n, err := s.NextAfter(now); err == nil {
+			next = &n
+		}
+		if _, err = tx.Exec(AdvanceScheduleSQL, s.ScheduleID, next, s.NextRunAt); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "issue advancing schedule [%s]", s.ScheduleID)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return due, nil
+}
+
+//
+// RecordScheduleRun links a schedule to the last run it created
+//
+func (sm *SQLStateManager) RecordScheduleRun(scheduleID string, runID string) error {
+	if _, err := sm.db.Exec(RecordScheduleRunSQL, scheduleID, runID); err != nil {
+		return errors.Wrapf(err, "issue recording run [%s] of schedule [%s]", runID, scheduleID)
+	}
+	return nil
+}
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (Schedule, error) {
+	var (
+		s       Schedule
+		enabled bool
+		body    string
+	)
+	if err := row.Scan(&s.ScheduleID, &s.Name, &s.CronExpression, &s.TimeZone, &s.ExecutableType,
+		&s.ExecutableID, &body, &s.OverlapPolicy, &enabled, &s.NextRunAt, &s.LastRunAt,
+		&s.LastRunID, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return s, err
+		}
+		return s, errors.WithStack(err)
+	}
+	s.Enabled = &enabled
+	return s, errors.WithStack(s.SetRequest([]byte(body)))
+}
+
+//
+// CreateWorkflow creates the passed in workflow
+//
+func (sm *SQLStateManager) CreateWorkflow(w Workflow) error {
+	if _, err := sm.db.Exec(CreateWorkflowSQL, w.WorkflowID, w.Name, w.Status, w.Nodes); err != nil {
+		return errors.Wrapf(err, "issue creating workflow [%s]", w.WorkflowID)
+	}
+	return nil
+}
+
+//
+// GetWorkflow gets a workflow by id
+//
+func (sm *SQLStateManager) GetWorkflow(workflowID string) (Workflow, error) {
+	w, err := scanWorkflow(sm.db.QueryRow(GetWorkflowSQL, workflowID))
+	if err == sql.ErrNoRows {
+		return w, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Workflow with id %s not found", workflowID)}
+	}
+	if err != nil {
+		return w, errors.Wrapf(err, "issue getting workflow with id [%s]", workflowID)
+	}
+	return w, nil
+}
+
+//
+// ListWorkflows returns a WorkflowList
+// limit: limit the result to this many workflows
+// offset: start the results at this offset
+// sortBy: sort by this field
+// order: 'asc' or 'desc'
+// filters: map of field filters on Workflow - joined with AND
+//
+func (sm *SQLStateManager) ListWorkflows(limit int, offset int, sortBy string, order string, filters map[string][]string) (WorkflowList, error) {
+	var result WorkflowList
+
+	// $1 and $2 are limit and offset
+	where := newWhereBuilder(workflowFilterColumns, 2)
+	if err := where.addFilters(filters); err != nil {
+		return result, err
+	}
+
+	orderQuery, err := sm.orderBy(&Workflow{}, sortBy, order)
+	if err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	listSQL := fmt.Sprintf(ListWorkflowsSQL, where, orderQuery)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", listSQL)
+
+	rows, err := sm.readonlyDB.Query(listSQL, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list workflows sql")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		w, err := scanWorkflow(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Workflows = append(result.Workflows, w)
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.WithStack(err)
+	}
+
+	err = sm.readonlyDB.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list workflows count sql")
+	}
+	return result, nil
+}
+
+//
+// ClaimActiveWorkflows leases up to limit running workflows until now+lease.
+// Workflows leased by another caller are skipped until their lease expires
+// or is released by UpdateWorkflow, so each workflow is advanced by one
+// caller at a time.
+//
+func (sm *SQLStateManager) ClaimActiveWorkflows(now time.Time, lease time.Duration, limit int) ([]Workflow, error) {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ClaimActiveWorkflowsSQL, now, limit)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "issue claiming active workflows")
+	}
+
+	var active []Workflow
+	for rows.Next() {
+		w, err := scanWorkflow(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		active = append(active, w)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, errors.WithStack(err)
+	}
+
+	for _, w := range active {
+		if _, err = tx.Exec(LeaseWorkflowSQL, w.WorkflowID, now.Add(lease)); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "issue leasing workflow [%s]", w.WorkflowID)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return active, nil
+}
+
+//
+// UpdateWorkflow saves the status and nodes of a workflow and releases its
+// lease
+//
+func (sm *SQLStateManager) UpdateWorkflow(w Workflow) error {
+	if _, err := sm.db.Exec(UpdateWorkflowSQL, w.WorkflowID, w.Status, w.Nodes, w.FinishedAt); err != nil {
+		return errors.Wrapf(err, "issue updating workflow [%s]", w.WorkflowID)
+	}
+	return nil
+}
+
+//
+// RequestWorkflowCancel flags a running workflow for cancellation; finished
+// workflows are left untouched
+//
+func (sm *SQLStateManager) RequestWorkflowCancel(workflowID string) (Workflow, error) {
+	if _, err := sm.db.Exec(RequestWorkflowCancelSQL, workflowID); err != nil {
+		return Workflow{}, errors.Wrapf(err, "issue cancelling workflow [%s]", workflowID)
+	}
+	return sm.GetWorkflow(workflowID)
+}
+
+func scanWorkflow(row interface{ Scan(...interface{}) error }) (Workflow, error) {
+	var w Workflow
+	if err := row.Scan(&w.WorkflowID, &w.Name, &w.Status, &w.Nodes, &w.CancelRequested,
+		&w.CreatedAt, &w.UpdatedAt, &w.FinishedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return w, err
+		}
+		return w, errors.WithStack(err)
+	}
+	return w, nil
+}
+
+//
+// nullableJSON maps empty json to a sql NULL
+//
+func nullableJSON(b json.RawMessage) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+//
+// ListGroups returns a list of the existing group names.
+//
+func (sm *SQLStateManager) ListGroups(limit int, offset int, name *string) (GroupsList, error) {
+	var (
+		err    error
+		result GroupsList
+	)
+	where := newWhereBuilder(groupFilterColumns, 2)
+	if name != nil && len(*name) > 0 {
+		if err = where.addFilters(map[string][]string{"group_name": {*name}}); err != nil {
+			return result, err
+		}
+	}
+
+	sql := fmt.Sprintf(ListGroupsSQL, where)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", sql)
+
+	err = sm.db.Select(&result.Groups, sql, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list groups sql")
+	}
+	err = sm.db.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list groups count sql")
+	}
+
+	return result, nil
+}
+
+//
+// ListTags returns a list of the existing tags.
+//
+func (sm *SQLStateManager) ListTags(limit int, offset int, name *string) (TagsList, error) {
+	var (
+		err    error
+		result TagsList
+	)
+	where := newWhereBuilder(tagFilterColumns, 2)
+	if name != nil && len(*name) > 0 {
+		if err = where.addFilters(map[string][]string{"text": {*name}}); err != nil {
+			return result, err
+		}
+	}
+
+	sql := fmt.Sprintf(ListTagsSQL, where)
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", sql)
+
+	err = sm.db.Select(&result.Tags, sql, append([]interface{}{limit, offset}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list tags sql")
+	}
+	err = sm.db.Get(&result.Total, countSQL, append([]interface{}{nil, 0}, where.args...)...)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list tags count sql")
+	}
+
+	return result, nil
+}
+
+//
+// initWorkerTable initializes the `worker` table with values from the config
+//
+func (sm *SQLStateManager) initWorkerTable(c config.Config) error {
+	// Get worker count from configuration (set to 1 as default)
+
+	for _, engine := range Engines {
+		retryCount := int64(1)
+		if c.IsSet(fmt.Sprintf("worker.%s.retry_worker_count_per_instance", engine)) {
+			retryCount = int64(c.GetInt("worker.ecs.retry_worker_count_per_instance"))
+		}
+		submitCount := int64(1)
+		if c.IsSet(fmt.Sprintf("worker.%s.submit_worker_count_per_instance", engine)) {
+			submitCount = int64(c.GetInt("worker.ecs.submit_worker_count_per_instance"))
+		}
+		statusCount := int64(1)
+		if c.IsSet(fmt.Sprintf("worker.%s.status_worker_count_per_instance", engine)) {
+			statusCount = int64(c.GetInt("worker.ecs.status_worker_count_per_instance"))
+		}
+		schedulerCount := int64(1)
+		if key := fmt.Sprintf("worker.%s.scheduler_worker_count_per_instance", engine); c.IsSet(key) {
+			schedulerCount = int64(c.GetInt(key))
+		}
+		workflowCount := int64(1)
+		if key := fmt.Sprintf("worker.%s.workflow_worker_count_per_instance", engine); c.IsSet(key) {
+			workflowCount = int64(c.GetInt(key))
+		}
+		arrayCount := int64(1)
+		if key := fmt.Sprintf("worker.%s.array_worker_count_per_instance", engine); c.IsSet(key) {
+			arrayCount = int64(c.GetInt(key))
+		}
+		webhookCount := int64(1)
+		if key := fmt.Sprintf("worker.%s.webhook_worker_count_per_instance", engine); c.IsSet(key) {
+			webhookCount = int64(c.GetInt(key))
+		}
+
+		var err error
+		insert := `
+		INSERT INTO worker (worker_type, count_per_instance, engine)
+		VALUES ('retry', $1, $4), ('submit', $2, $4), ('status', $3, $4), ('scheduler', $5, $4), ('workflow', $6, $4), ('array', $7, $4), ('webhook', $8, $4);
+	`
+
+		tx, err := sm.db.Begin()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if _, err = tx.Exec(insert, retryCount, submitCount, statusCount, engine, schedulerCount, workflowCount, arrayCount, webhookCount); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "issue populating worker table")
+		}
+
+		err = tx.Commit()
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+//
+// ListWorkers returns list of workers
+//
+func (sm *SQLStateManager) ListWorkers(engine string) (WorkersList, error) {
+	var err error
+	var result WorkersList
+
+	countSQL := fmt.Sprintf("select COUNT(*) from (%s) as sq", ListWorkersSQL)
+
+	err = sm.readonlyDB.Select(&result.Workers, GetWorkerEngine, engine)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list workers sql")
+	}
+
+	err = sm.readonlyDB.Get(&result.Total, countSQL)
+	if err != nil {
+		return result, errors.Wrap(err, "issue running list workers count sql")
+	}
+
+	return result, nil
+}
+
+//
+// GetWorker returns data for a single worker.
+//
+func (sm *SQLStateManager) GetWorker(workerType string, engine string) (w Worker, err error) {
+	if err := sm.readonlyDB.Get(&w, GetWorkerSQL, workerType, engine); err != nil {
+		if err == sql.ErrNoRows {
+			err = exceptions.MissingResource{
+				ErrorString: fmt.Sprintf("Worker of type %s not found", workerType)}
+		} else {
+			err = errors.Wrapf(err, "issue getting worker of type [%s]", workerType)
+		}
+	}
+	return
+}
+
+//
+// UpdateWorker updates a single worker.
 //
 func (sm *SQLStateManager) UpdateWorker(workerType string, updates Worker) (Worker, error) {
 	var (
@@ -1178,13 +2572,98 @@ func (r *Run) ValidOrderField(field string) bool {
 }
 
 func (r *Run) ValidOrderFields() []string {
-	return []string{"run_id", "cluster_name", "status", "started_at", "finished_at", "group_name"}
+	return []string{"run_id", "cluster_name", "status", "started_at", "finished_at", "group_name", "retry_attempt", "array_index"}
 }
 
 func (r *Run) DefaultOrderField() string {
 	return "group_name"
 }
 
+func (e *AuditEvent) ValidOrderField(field string) bool {
+	for _, f := range e.ValidOrderFields() {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *AuditEvent) ValidOrderFields() []string {
+	return []string{"created_at", "action", "target_type", "target_id", "actor_email"}
+}
+
+func (e *AuditEvent) DefaultOrderField() string {
+	return "created_at"
+}
+
+func (s *Schedule) ValidOrderField(field string) bool {
+	for _, f := range s.ValidOrderFields() {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) ValidOrderFields() []string {
+	return []string{"name", "next_run_at", "last_run_at", "created_at"}
+}
+
+func (s *Schedule) DefaultOrderField() string {
+	return "name"
+}
+
+func (w *Workflow) ValidOrderField(field string) bool {
+	for _, f := range w.ValidOrderFields() {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Workflow) ValidOrderFields() []string {
+	return []string{"name", "status", "created_at", "updated_at", "finished_at"}
+}
+
+func (w *Workflow) DefaultOrderField() string {
+	return "created_at"
+}
+
+func (w *Webhook) ValidOrderField(field string) bool {
+	for _, f := range w.ValidOrderFields() {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhook) ValidOrderFields() []string {
+	return []string{"scope", "name", "created_at"}
+}
+
+func (w *Webhook) DefaultOrderField() string {
+	return "created_at"
+}
+
+func (q *Quota) ValidOrderField(field string) bool {
+	for _, f := range q.ValidOrderFields() {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Quota) ValidOrderFields() []string {
+	return []string{"scope", "name", "created_at", "updated_at"}
+}
+
+func (q *Quota) DefaultOrderField() string {
+	return "name"
+}
+
 func (t *Template) ValidOrderField(field string) bool {
 	for _, f := range t.ValidOrderFields() {
 		if field == f {
@@ -1218,6 +2697,75 @@ func (e EnvList) Value() (driver.Value, error) {
 	return res, nil
 }
 
+// Scan from db
+func (e *RunLabels) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &e)
+	}
+	return nil
+}
+
+// Value to db; empty labels are stored as NULL
+func (e RunLabels) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return nil, nil
+	}
+	res, _ := json.Marshal(e)
+	return res, nil
+}
+
+// Scan from db
+func (p *RetryPolicy) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &p)
+	}
+	return nil
+}
+
+// Value to db
+func (p *RetryPolicy) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	res, _ := json.Marshal(p)
+	return res, nil
+}
+
+// Scan from db
+func (n *WorkflowNodes) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &n)
+	}
+	return nil
+}
+
+// Value to db
+func (n WorkflowNodes) Value() (driver.Value, error) {
+	res, _ := json.Marshal(n)
+	return res, nil
+}
+
+// Scan from db
+func (l *WebhookEventList) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &l)
+	}
+	return nil
+}
+
+// Value to db
+func (l WebhookEventList) Value() (driver.Value, error) {
+	if l == nil {
+		l = WebhookEventList{}
+	}
+	res, _ := json.Marshal(l)
+	return res, nil
+}
+
 // Scan from db
 func (e *PodEvents) Scan(value interface{}) error {
 	if value != nil {
@@ -1462,9 +3010,9 @@ func (sm *SQLStateManager) CreateTemplate(t Template) error {
 	insert := `
     INSERT INTO template(
 			template_id, template_name, version, schema, command_template,
-			adaptive_resource_allocation, image, memory, env, cpu, gpu, defaults, avatar_uri
+			adaptive_resource_allocation, image, memory, env, cpu, gpu, defaults, avatar_uri, retry_policy
     )
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15);
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);
     `
 
 	tx, err := sm.db.Begin()
@@ -1475,7 +3023,7 @@ func (sm *SQLStateManager) CreateTemplate(t Template) error {
 	if _, err = tx.Exec(insert,
 		t.TemplateID, t.TemplateName, t.Version, t.Schema, t.CommandTemplate,
 		t.AdaptiveResourceAllocation, t.Image, t.Memory, t.Env,
-		t.Cpu, t.Gpu, t.Defaults, t.AvatarURI); err != nil {
+		t.Cpu, t.Gpu, t.Defaults, t.AvatarURI, t.RetryPolicy); err != nil {
 		tx.Rollback()
 		return errors.Wrapf(
 			err, "issue creating new template with template_name [%s] and version [%d]", t.TemplateName, t.Version)
@@ -114,6 +114,12 @@ func tearDown() {
 		DELETE FROM task;
 		DELETE FROM task_def;
 		DELETE FROM tags;
+		DELETE FROM schedule;
+		DELETE FROM workflow;
+		DELETE FROM run_idempotency_key;
+		DELETE FROM quota;
+		DELETE FROM webhook_delivery;
+		DELETE FROM webhook;
   `)
 }
 
@@ -176,6 +182,12 @@ func TestSQLStateManager_ListDefinitions(t *testing.T) {
 			`Expected environment variable filters (E_B1:V_B1 AND E_B2:V_B2) to yield
             definition B, but was %s`, dl.Definitions[0].DefinitionID)
 	}
+
+	// Test filtering on tags
+	dl, _ = sm.ListDefinitions(10, 0, "alias", "asc", map[string][]string{"tag": {"tagC", "tagB"}}, nil)
+	if dl.Total != 2 || dl.Definitions[0].DefinitionID != "A" || dl.Definitions[1].DefinitionID != "B" {
+		t.Errorf("Expected tag filters (tagC OR tagB) to yield definitions A and B, got %v", dl.Definitions)
+	}
 }
 
 func TestSQLStateManager_GetDefinition(t *testing.T) {
@@ -191,6 +203,15 @@ func TestSQLStateManager_GetDefinition(t *testing.T) {
 		t.Errorf("Expected empty environment but got %s", *dE.Env)
 	}
 
+	if dE.Ports == nil || len(*dE.Ports) != 2 || (*dE.Ports)[0] != 10003 || (*dE.Ports)[1] != 10004 {
+		t.Errorf("Expected ports [10003 10004] but got %v", dE.Ports)
+	}
+
+	dA, _ := sm.GetDefinition("A")
+	if dA.Tags == nil || len(*dA.Tags) != 2 || (*dA.Tags)[0] != "tagA" || (*dA.Tags)[1] != "tagC" {
+		t.Errorf("Expected tags [tagA tagC] but got %v", dA.Tags)
+	}
+
 	_, err := sm.GetDefinition("Z")
 	if err == nil {
 		t.Errorf("Expected get for non-existent definition Z to return error, was nil")
@@ -251,7 +272,9 @@ func TestSQLStateManager_CreateDefinition(t *testing.T) {
 
 	if f.Alias != d.Alias ||
 		len(*f.Env) != len(*d.Env) ||
-		*f.Memory != *d.Memory {
+		*f.Memory != *d.Memory ||
+		len(*f.Ports) != len(*d.Ports) ||
+		len(*f.Tags) != len(*d.Tags) {
 		t.Errorf("Expected created definition to match the one passed in for creation")
 	}
 }
@@ -305,6 +328,14 @@ func TestSQLStateManager_UpdateDefinition(t *testing.T) {
 	if matches != len(env) {
 		t.Errorf("Not all updated env vars match")
 	}
+
+	if len(*d.Tags) != 1 || (*d.Tags)[0] != "cupcake" {
+		t.Errorf("Expected tags to be updated to [cupcake] but were %v", *d.Tags)
+	}
+
+	if len(*d.Ports) != 0 {
+		t.Errorf("Expected ports to be emptied but were %v", *d.Ports)
+	}
 }
 
 func TestSQLStateManager_DeleteDefinition(t *testing.T) {
@@ -607,7 +638,7 @@ func TestSQLStateManager_UpdateRun(t *testing.T) {
 	u2 := Run{
 		Status: StatusNeedsRetry,
 	}
-	sm.UpdateRun("run3", u)
+	sm.UpdateRun("run3", u, TransitionSourceAPI)
 
 	r, _ := sm.GetRun("run3")
 	if *r.ExitCode != ec {
@@ -650,9 +681,357 @@ func TestSQLStateManager_UpdateRun(t *testing.T) {
 		t.Errorf("Not all updated env vars match")
 	}
 
-	sm.UpdateRun("run3", u2)
+	if _, err := sm.UpdateRun("run3", u2, TransitionSourceAPI); !IsIllegalTransition(err) {
+		t.Errorf("Expected moving a stopped run to %s to be rejected, got %v", u2.Status, err)
+	}
 	r, _ = sm.GetRun("run3")
-	if r.Status != u2.Status {
-		t.Errorf("Expected to update status to %s but was %s", u2.Status, r.Status)
+	if r.Status != u.Status {
+		t.Errorf("Expected status to remain %s but was %s", u.Status, r.Status)
+	}
+
+	transitions, err := sm.ListRunTransitions("run3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions.Transitions) != 1 ||
+		transitions.Transitions[0].FromStatus != StatusQueued ||
+		transitions.Transitions[0].ToStatus != StatusStopped ||
+		transitions.Transitions[0].Source != TransitionSourceAPI {
+		t.Errorf("Expected a single QUEUED -> STOPPED transition from the api, got %v", transitions.Transitions)
+	}
+}
+
+func TestSQLStateManager_ClaimDueSchedules(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := Schedule{
+		ScheduleID:        "sch-a",
+		Name:              "hourly",
+		CronExpression:    "0 * * * *",
+		TimeZone:          "UTC",
+		ExecutableType:    ExecutableTypeDefinition,
+		ExecutableID:      "A",
+		DefinitionRequest: &DefinitionExecutionRequest{ExecutionRequestCommon: &ExecutionRequestCommon{OwnerID: "somebody"}},
+		OverlapPolicy:     ScheduleOverlapAllow,
+		NextRunAt:         &t0,
+	}
+	if err := sm.CreateSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+
+	now := t0.Add(90 * time.Minute)
+	due, err := sm.ClaimDueSchedules(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].DefinitionRequest.OwnerID != "somebody" {
+		t.Fatalf("Expected sch-a with its stored request to be claimed, got %v", due)
+	}
+	if due, _ = sm.ClaimDueSchedules(now, 10); len(due) != 0 {
+		t.Errorf("Expected a fire time to be claimed once, got %v", due)
+	}
+
+	claimed, _ := sm.GetSchedule("sch-a")
+	if expected := t0.Add(2 * time.Hour); !claimed.NextRunAt.Equal(expected) {
+		t.Errorf("Expected sch-a to advance to %v, was %v", expected, claimed.NextRunAt)
+	}
+}
+
+func TestSQLStateManager_ClaimDueRetries(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	engine := DefaultEngine
+	if err := sm.CreateRun(Run{
+		RunID: "run-retry", DefinitionID: "A", Status: StatusRunning, Engine: &engine,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, ExitCodes: []int64{137}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	code := int64(137)
+	updated, err := sm.UpdateRun("run-retry", Run{Status: StatusStopped, ExitCode: &code}, TransitionSourceAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.RetryState != RetryStateScheduled {
+		t.Fatalf("Expected run-retry to be scheduled for retry, got state [%s]", updated.RetryState)
+	}
+
+	due, err := sm.ClaimDueRetries(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].RetryPolicy == nil || due[0].RetryPolicy.MaxAttempts != 2 {
+		t.Fatalf("Expected run-retry with its policy to be claimed, got %v", due)
+	}
+	if due, _ = sm.ClaimDueRetries(time.Now(), 10); len(due) != 0 {
+		t.Errorf("Expected a retry to be claimed once, got %v", due)
+	}
+}
+
+func TestSQLStateManager_ReleaseArrayRuns(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	engine := DefaultEngine
+	size, maxParallelism := int64(3), int64(2)
+	if err := sm.CreateRun(Run{
+		RunID: "run-array", DefinitionID: "A", Status: StatusQueued, Engine: &engine,
+		TaskType: ArrayTaskType, ArraySize: &size, MaxParallelism: &maxParallelism,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	parentID := "run-array"
+	for i := int64(0); i < size; i++ {
+		index := i
+		if err := sm.CreateRun(Run{
+			RunID: fmt.Sprintf("run-array-%d", i), DefinitionID: "A", Status: StatusQueued, Engine: &engine,
+			TaskType: DefaultTaskType, ArrayParentID: &parentID, ArrayIndex: &index,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	released, err := sm.ReleaseArrayRuns(parentID, int(maxParallelism), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 2 || released[0].RunID != "run-array-0" || released[1].RunID != "run-array-1" {
+		t.Fatalf("Expected the first 2 children to be released, got %v", released)
+	}
+	if released, _ = sm.ReleaseArrayRuns(parentID, int(maxParallelism), time.Now()); len(released) != 0 {
+		t.Errorf("Expected no child to be released at max parallelism, got %v", released)
+	}
+
+	code := int64(0)
+	if _, err = sm.UpdateRun("run-array-0", Run{Status: StatusStopped, ExitCode: &code}, TransitionSourceAPI); err != nil {
+		t.Fatal(err)
+	}
+	released, err = sm.ReleaseArrayRuns(parentID, int(maxParallelism), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0].RunID != "run-array-2" || released[0].QueuedAt == nil {
+		t.Errorf("Expected the last child to be released, got %v", released)
+	}
+
+	children, err := sm.ListRuns(10, 0, "array_index", "asc", map[string][]string{"array_parent_id": {parentID}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if children.Total != 3 || *children.Runs[2].ArrayIndex != 2 {
+		t.Errorf("Expected 3 children ordered by index, got %v", children.Runs)
+	}
+}
+
+func TestSQLStateManager_IdempotencyKeys(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	now := time.Now()
+	key := IdempotencyKey{OwnerID: "somebody", Key: "k", RequestHash: "h1", RunID: "run-1", CreatedAt: now}
+	stored, err := sm.ClaimIdempotencyKey(key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RunID != "run-1" {
+		t.Fatalf("Expected the key to be claimed for run-1, got %s", stored.RunID)
+	}
+
+	again := IdempotencyKey{OwnerID: "somebody", Key: "k", RequestHash: "h2", RunID: "run-2", CreatedAt: now.Add(time.Minute)}
+	if stored, err = sm.ClaimIdempotencyKey(again, time.Hour); err != nil || stored.RunID != "run-1" {
+		t.Errorf("Expected the key to stay claimed by run-1 within the window, got %s, %v", stored.RunID, err)
+	}
+
+	later := IdempotencyKey{OwnerID: "somebody", Key: "k", RequestHash: "h3", RunID: "run-3", CreatedAt: now.Add(2 * time.Hour)}
+	if stored, err = sm.ClaimIdempotencyKey(later, time.Hour); err != nil || stored.RunID != "run-3" {
+		t.Errorf("Expected the key to be reusable after the window, got %s, %v", stored.RunID, err)
+	}
+
+	if err = sm.ReleaseIdempotencyKey(later); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err = sm.ClaimIdempotencyKey(again, time.Hour); err != nil || stored.RunID != "run-2" {
+		t.Errorf("Expected a released key to be claimable, got %s, %v", stored.RunID, err)
+	}
+}
+
+func TestSQLStateManager_Workflows(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	w := Workflow{
+		WorkflowID: "wf-a",
+		Name:       "nightly",
+		Status:     WorkflowStatusRunning,
+		Nodes: WorkflowNodes{{
+			Name: "a", ExecutableType: ExecutableTypeDefinition, ExecutableID: "A", Status: WorkflowNodePending,
+			DefinitionRequest: &DefinitionExecutionRequest{ExecutionRequestCommon: &ExecutionRequestCommon{OwnerID: "somebody"}},
+		}},
+	}
+	if err := sm.CreateWorkflow(w); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	active, err := sm.ClaimActiveWorkflows(now, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Nodes[0].DefinitionRequest.OwnerID != "somebody" {
+		t.Fatalf("Expected wf-a with its nodes to be claimed, got %v", active)
+	}
+	if again, _ := sm.ClaimActiveWorkflows(now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected a leased workflow not to be claimed again, got %v", again)
+	}
+
+	claimed := active[0]
+	claimed.Nodes[0].Status = WorkflowNodeSucceeded
+	claimed.RefreshStatus(now)
+	if err = sm.UpdateWorkflow(claimed); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := sm.GetWorkflow("wf-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != WorkflowStatusSucceeded || stored.FinishedAt == nil || stored.Nodes[0].Status != WorkflowNodeSucceeded {
+		t.Errorf("Expected wf-a to have succeeded, got %v", stored)
+	}
+	if cancelled, _ := sm.RequestWorkflowCancel("wf-a"); cancelled.CancelRequested {
+		t.Errorf("Expected a finished workflow not to be flagged for cancellation")
+	}
+}
+
+func TestSQLStateManager_Quotas(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	maxRuns := int64(1)
+	stored, err := sm.PutQuota(Quota{Scope: QuotaScopeOwner, Name: "somebody", MaxRuns: &maxRuns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxRuns = 2
+	if stored, err = sm.PutQuota(Quota{Scope: QuotaScopeOwner, Name: "somebody", MaxRuns: &maxRuns}); err != nil || *stored.MaxRuns != 2 {
+		t.Errorf("Expected putting the quota again to replace its limits, got %v, %v", stored.MaxRuns, err)
+	}
+	ql, err := sm.ListQuotas(10, 0, "name", "asc", map[string][]string{"scope": {QuotaScopeOwner}})
+	if err != nil || ql.Total != 1 {
+		t.Errorf("Expected 1 owner quota, got %d, %v", ql.Total, err)
+	}
+
+	engine := DefaultEngine
+	cpu := int64(250)
+	early, late := time.Now().Add(-time.Minute), time.Now()
+	runs := []Run{
+		{RunID: "run-quota-running", DefinitionID: "A", User: "somebody", Status: StatusRunning, Engine: &engine, Cpu: &cpu},
+		{RunID: "run-quota-late", DefinitionID: "A", User: "somebody", Status: StatusQueued, Engine: &engine, QueuedAt: &late},
+		{RunID: "run-quota-early", DefinitionID: "A", User: "somebody", Status: StatusQueued, Engine: &engine, QueuedAt: &early},
+	}
+	for _, r := range runs {
+		if err = sm.CreateRun(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, runID := range []string{"run-quota-late", "run-quota-early"} {
+		if err = sm.HoldRunForQuota(runID, late); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := sm.GetQuotaUsage(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Runs != 1 || usage.Cpu != 250 || usage.Held != 2 {
+		t.Errorf("Expected 1 running run using 250 cpu and 2 held, got %+v", usage)
+	}
+
+	held, err := sm.ListQuotaHeldRuns(10)
+	if err != nil || len(held) != 2 || held[0].RunID != "run-quota-early" {
+		t.Fatalf("Expected the held runs oldest first, got %v, %v", held, err)
+	}
+	if released, _ := sm.ReleaseQuotaHold("run-quota-early"); !released {
+		t.Errorf("Expected the held run to be released")
+	}
+	if released, _ := sm.ReleaseQuotaHold("run-quota-early"); released {
+		t.Errorf("Expected a released run not to be released again")
+	}
+
+	if err = sm.DeleteQuota(QuotaScopeOwner, "somebody"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sm.DeleteQuota(QuotaScopeOwner, "somebody"); err == nil {
+		t.Errorf("Expected deleting a missing quota to fail")
+	}
+}
+
+func TestSQLStateManager_Webhooks(t *testing.T) {
+	defer tearDown()
+	sm := setUp()
+
+	created, err := sm.CreateWebhook(Webhook{
+		WebhookID: "wh-1", Scope: WebhookScopeDefinition, Name: "A", URL: "https://example.com/hook",
+		Events: WebhookEventList{WebhookEventFailed}, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.CreatedAt == nil {
+		t.Errorf("Expected the webhook's creation time to be set")
+	}
+	w, err := sm.GetWebhook("wh-1")
+	if err != nil || w.Secret != "s3cret" || len(w.Events) != 1 {
+		t.Errorf("Expected to get the stored webhook, got %+v, %v", w, err)
+	}
+	wl, err := sm.ListWebhooks(10, 0, "created_at", "asc", map[string][]string{"scope": {WebhookScopeDefinition}})
+	if err != nil || wl.Total != 1 {
+		t.Errorf("Expected 1 definition webhook, got %d, %v", wl.Total, err)
+	}
+	hooks, err := sm.ListRunWebhooks(Run{RunID: "r", DefinitionID: "A"})
+	if err != nil || len(hooks) != 1 {
+		t.Errorf("Expected the webhook to cover runs of definition A, got %v, %v", hooks, err)
+	}
+
+	now := time.Now()
+	d := WebhookDelivery{DeliveryID: "whd-1", WebhookID: "wh-1", RunID: "r", Event: WebhookEventFailed,
+		Payload: []byte(`{"event":"run.failed"}`), Status: WebhookDeliveryPending, NextAttemptAt: &now}
+	if queued, err := sm.CreateWebhookDelivery(d); err != nil || !queued {
+		t.Fatalf("Expected the delivery to be queued, got %v, %v", queued, err)
+	}
+	d.DeliveryID = "whd-2"
+	if queued, _ := sm.CreateWebhookDelivery(d); queued {
+		t.Errorf("Expected a second delivery of the same event for the run to be dropped")
+	}
+
+	due, err := sm.ClaimWebhookDeliveries(now, time.Minute, 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Expected 1 due delivery, got %v, %v", due, err)
+	}
+	if again, _ := sm.ClaimWebhookDeliveries(now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected a claimed delivery to be leased")
+	}
+
+	due[0].Status = WebhookDeliveryDelivered
+	due[0].Attempts = 1
+	due[0].DeliveredAt = &now
+	due[0].NextAttemptAt = nil
+	if err = sm.UpdateWebhookDelivery(due[0]); err != nil {
+		t.Fatal(err)
+	}
+	dl, err := sm.ListWebhookDeliveries("wh-1", 10, 0)
+	if err != nil || dl.Total != 1 || dl.Deliveries[0].Status != WebhookDeliveryDelivered {
+		t.Errorf("Expected the delivered delivery to be listed, got %+v, %v", dl, err)
+	}
+
+	if err = sm.DeleteWebhook("wh-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sm.DeleteWebhook("wh-1"); err == nil {
+		t.Errorf("Expected deleting a missing webhook to fail")
 	}
 }
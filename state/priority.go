@@ -0,0 +1,125 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/stitchfix/flotilla-os/config"
+)
+
+//
+// PriorityTier is a level of run priority. Runs of a tier are queued on the
+// tier's queue; the submit worker receives up to Weight runs from it per
+// poll, so higher tiers are preferred while every tier keeps a share.
+//
+type PriorityTier struct {
+	Name      string
+	Queue     string
+	Weight    int
+	ClassName string
+}
+
+//
+// PriorityTiers are the configured priority tiers, highest first, and the
+// tier of runs that don't request one
+//
+type PriorityTiers struct {
+	Tiers   []PriorityTier
+	Default string
+}
+
+//
+// NewPriorityTiers reads the priority tiers from the eks.priority section of
+// conf; tiers without a queue use defaultQueue. No tiers are configured when
+// eks.priority.tiers is unset.
+//
+func NewPriorityTiers(conf config.Config, defaultQueue string) (PriorityTiers, error) {
+	var p PriorityTiers
+	if conf == nil || !conf.IsSet("eks.priority.tiers") {
+		return p, nil
+	}
+
+	queues := conf.GetStringMapString("eks.priority.queues")
+	weights := conf.GetStringMapString("eks.priority.weights")
+	classNames := conf.GetStringMapString("eks.priority.class_names")
+	for _, name := range conf.GetStringSlice("eks.priority.tiers") {
+		tier := PriorityTier{Name: name, Queue: defaultQueue, Weight: 1, ClassName: classNames[name]}
+		if queue, ok := queues[name]; ok && len(queue) > 0 {
+			tier.Queue = queue
+		}
+		if weight, ok := weights[name]; ok {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w < 1 {
+				return p, fmt.Errorf("invalid eks.priority.weights value [%s] for tier [%s]", weight, name)
+			}
+			tier.Weight = w
+		}
+		p.Tiers = append(p.Tiers, tier)
+	}
+	if len(p.Tiers) == 0 {
+		return p, nil
+	}
+
+	p.Default = p.Tiers[len(p.Tiers)-1].Name
+	if conf.IsSet("eks.priority.default") {
+		p.Default = conf.GetString("eks.priority.default")
+	}
+	if _, ok := p.Get(&p.Default); !ok {
+		return p, fmt.Errorf("eks.priority.default [%s] is not one of eks.priority.tiers", p.Default)
+	}
+	return p, nil
+}
+
+//
+// Get returns the tier named priority, or the default tier when priority is
+// nil; ok is false if there is no such tier
+//
+func (p PriorityTiers) Get(priority *string) (PriorityTier, bool) {
+	name := p.Default
+	if priority != nil {
+		name = *priority
+	}
+	for _, t := range p.Tiers {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return PriorityTier{}, false
+}
+
+//
+// Rank orders runs by priority; the highest tier ranks 0. Runs of unknown
+// tiers rank with the default tier.
+//
+func (p PriorityTiers) Rank(priority *string) int {
+	tier, ok := p.Get(priority)
+	if !ok {
+		tier, _ = p.Get(nil)
+	}
+	for i, t := range p.Tiers {
+		if t.Name == tier.Name {
+			return i
+		}
+	}
+	return 0
+}
+
+//
+// Validate returns the reasons priority isn't a configured tier, if any
+//
+func (p PriorityTiers) Validate(priority *string) []string {
+	if priority == nil {
+		return nil
+	}
+	if len(p.Tiers) == 0 {
+		return []string{"string [priority] is not supported; no priority tiers are configured"}
+	}
+	if _, ok := p.Get(priority); !ok {
+		names := make([]string, len(p.Tiers))
+		for i, t := range p.Tiers {
+			names[i] = t.Name
+		}
+		return []string{fmt.Sprintf("string [priority] must be one of %v", names)}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package state
+
+import (
+	"testing"
+)
+
+type testPriorityConf map[string]interface{}
+
+func (c testPriorityConf) GetString(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+func (c testPriorityConf) GetStringSlice(key string) []string {
+	s, _ := c[key].([]string)
+	return s
+}
+func (c testPriorityConf) GetStringMapString(key string) map[string]string {
+	m, _ := c[key].(map[string]string)
+	return m
+}
+func (c testPriorityConf) GetInt(key string) int         { return 0 }
+func (c testPriorityConf) GetBool(key string) bool       { return false }
+func (c testPriorityConf) GetFloat64(key string) float64 { return 0 }
+func (c testPriorityConf) IsSet(key string) bool {
+	_, ok := c[key]
+	return ok
+}
+
+func TestNewPriorityTiers(t *testing.T) {
+	p, err := NewPriorityTiers(testPriorityConf{}, "q")
+	if err != nil || len(p.Tiers) != 0 {
+		t.Errorf("Expected no tiers when unconfigured, got %v, %v", p, err)
+	}
+
+	conf := testPriorityConf{
+		"eks.priority.tiers":       []string{"high", "normal", "low"},
+		"eks.priority.queues":      map[string]string{"high": "q-high"},
+		"eks.priority.weights":     map[string]string{"high": "4", "normal": "2"},
+		"eks.priority.class_names": map[string]string{"high": "flotilla-high"},
+	}
+	p, err = NewPriorityTiers(conf, "q")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Default != "low" {
+		t.Errorf("Expected default tier [low], got [%s]", p.Default)
+	}
+	expected := []PriorityTier{
+		{Name: "high", Queue: "q-high", Weight: 4, ClassName: "flotilla-high"},
+		{Name: "normal", Queue: "q", Weight: 2},
+		{Name: "low", Queue: "q", Weight: 1},
+	}
+	for i, tier := range expected {
+		if p.Tiers[i] != tier {
+			t.Errorf("Expected tier %v, got %v", tier, p.Tiers[i])
+		}
+	}
+
+	conf["eks.priority.default"] = "normal"
+	if p, err = NewPriorityTiers(conf, "q"); err != nil || p.Default != "normal" {
+		t.Errorf("Expected default tier [normal], got [%s], %v", p.Default, err)
+	}
+	conf["eks.priority.default"] = "urgent"
+	if _, err = NewPriorityTiers(conf, "q"); err == nil {
+		t.Errorf("Expected error for unknown default tier")
+	}
+	conf["eks.priority.default"] = "normal"
+	conf["eks.priority.weights"] = map[string]string{"high": "0"}
+	if _, err = NewPriorityTiers(conf, "q"); err == nil {
+		t.Errorf("Expected error for weight below 1")
+	}
+}
+
+func TestPriorityTiers_RankAndValidate(t *testing.T) {
+	p := PriorityTiers{
+		Tiers:   []PriorityTier{{Name: "high"}, {Name: "normal"}, {Name: "low"}},
+		Default: "normal",
+	}
+	high, low, unknown := "high", "low", "urgent"
+
+	if tier, ok := p.Get(nil); !ok || tier.Name != "normal" {
+		t.Errorf("Expected default tier [normal], got %v", tier)
+	}
+	ranks := []struct {
+		priority *string
+		rank     int
+	}{
+		{&high, 0}, {nil, 1}, {&low, 2}, {&unknown, 1},
+	}
+	for _, r := range ranks {
+		if rank := p.Rank(r.priority); rank != r.rank {
+			t.Errorf("Expected rank %d, got %d", r.rank, rank)
+		}
+	}
+
+	if reasons := p.Validate(&high); len(reasons) != 0 {
+		t.Errorf("Expected [high] to be valid, got %v", reasons)
+	}
+	if reasons := p.Validate(nil); len(reasons) != 0 {
+		t.Errorf("Expected no priority to be valid, got %v", reasons)
+	}
+	if reasons := p.Validate(&unknown); len(reasons) != 1 {
+		t.Errorf("Expected [urgent] to be invalid")
+	}
+	if reasons := (PriorityTiers{}).Validate(&high); len(reasons) != 1 {
+		t.Errorf("Expected priority to be invalid when no tiers are configured")
+	}
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// QuotaScopeGroup limits the runs of a group, matched by GroupName
+var QuotaScopeGroup = "group"
+
+// QuotaScopeOwner limits the runs of an owner, matched by User
+var QuotaScopeOwner = "owner"
+
+// QuotaScopeDefinition limits the runs of a definition, matched by
+// DefinitionID
+var QuotaScopeDefinition = "definition"
+
+// QuotaScopes are the valid quota scopes
+var QuotaScopes = []string{QuotaScopeGroup, QuotaScopeOwner, QuotaScopeDefinition}
+
+//
+// Quota limits the runs in flight - PENDING or RUNNING - for a group, owner
+// or definition. A nil limit is unlimited. Runs over quota stay QUEUED and
+// are released by the submit worker as capacity frees.
+//
+type Quota struct {
+	Scope     string     `json:"scope"`
+	Name      string     `json:"name"`
+	MaxRuns   *int64     `json:"max_runs,omitempty"`
+	MaxCpu    *int64     `json:"max_cpu,omitempty"`
+	MaxMemory *int64     `json:"max_memory,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+//
+// IsValid returns whether the quota is valid and the reasons it isn't
+//
+func (q *Quota) IsValid() (bool, []string) {
+	var reasons []string
+	if !validQuotaScope(q.Scope) {
+		reasons = append(reasons, fmt.Sprintf("string [scope] must be one of %v", QuotaScopes))
+	}
+	if len(q.Name) == 0 {
+		reasons = append(reasons, "string [name] must be specified")
+	}
+	if q.MaxRuns == nil && q.MaxCpu == nil && q.MaxMemory == nil {
+		reasons = append(reasons, "one of [max_runs], [max_cpu] or [max_memory] must be specified")
+	}
+	if q.MaxRuns != nil && *q.MaxRuns < 0 {
+		reasons = append(reasons, "int [max_runs] must not be negative")
+	}
+	if q.MaxCpu != nil && *q.MaxCpu < 0 {
+		reasons = append(reasons, "int [max_cpu] must not be negative")
+	}
+	if q.MaxMemory != nil && *q.MaxMemory < 0 {
+		reasons = append(reasons, "int [max_memory] must not be negative")
+	}
+	return len(reasons) == 0, reasons
+}
+
+func validQuotaScope(scope string) bool {
+	for _, s := range QuotaScopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Key identifies the quota among all scopes
+//
+func (q *Quota) Key() string {
+	return fmt.Sprintf("%s/%s", q.Scope, q.Name)
+}
+
+//
+// Matches returns whether run counts against the quota
+//
+func (q *Quota) Matches(run Run) bool {
+	switch q.Scope {
+	case QuotaScopeGroup:
+		return run.GroupName == q.Name
+	case QuotaScopeOwner:
+		return run.User == q.Name
+	case QuotaScopeDefinition:
+		return run.DefinitionID == q.Name
+	}
+	return false
+}
+
+//
+// Admits returns whether run can start without the quota's usage exceeding
+// its limits
+//
+func (q *Quota) Admits(usage QuotaUsage, run Run) bool {
+	usage.Add(run)
+	if q.MaxRuns != nil && usage.Runs > *q.MaxRuns {
+		return false
+	}
+	if q.MaxCpu != nil && usage.Cpu > *q.MaxCpu {
+		return false
+	}
+	if q.MaxMemory != nil && usage.Memory > *q.MaxMemory {
+		return false
+	}
+	return true
+}
+
+//
+// QuotaUsage is what the runs of a quota's group, owner or definition
+// currently use; Held counts the QUEUED runs held back by quotas
+//
+type QuotaUsage struct {
+	Runs   int64 `json:"runs"`
+	Cpu    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+	Held   int64 `json:"held"`
+}
+
+//
+// Add counts run as in flight
+//
+func (u *QuotaUsage) Add(run Run) {
+	u.Runs++
+	if run.Cpu != nil {
+		u.Cpu += *run.Cpu
+	}
+	if run.Memory != nil {
+		u.Memory += *run.Memory
+	}
+}
+
+//
+// QuotaStatus is a quota with its current usage
+//
+type QuotaStatus struct {
+	Quota
+	Usage QuotaUsage `json:"usage"`
+}
+
+//
+// QuotaList wraps a list of Quotas
+//
+type QuotaList struct {
+	Total  int     `json:"total"`
+	Quotas []Quota `json:"quotas"`
+}
+
+func (ql *QuotaList) MarshalJSON() ([]byte, error) {
+	type Alias QuotaList
+	l := ql.Quotas
+	if l == nil {
+		l = []Quota{}
+	}
+	return json.Marshal(&struct {
+		Quotas []Quota `json:"quotas"`
+		*Alias
+	}{
+		Quotas: l,
+		Alias:  (*Alias)(ql),
+	})
+}
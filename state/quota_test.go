@@ -0,0 +1,52 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestQuota_IsValid(t *testing.T) {
+	one, negative := int64(1), int64(-1)
+	cases := []struct {
+		quota Quota
+		valid bool
+	}{
+		{Quota{Scope: QuotaScopeGroup, Name: "g", MaxRuns: &one}, true},
+		{Quota{Scope: QuotaScopeOwner, Name: "o", MaxCpu: &one, MaxMemory: &one}, true},
+		{Quota{Scope: "team", Name: "t", MaxRuns: &one}, false},
+		{Quota{Scope: QuotaScopeDefinition, MaxRuns: &one}, false},
+		{Quota{Scope: QuotaScopeDefinition, Name: "d"}, false},
+		{Quota{Scope: QuotaScopeGroup, Name: "g", MaxMemory: &negative}, false},
+	}
+	for i, c := range cases {
+		if valid, reasons := c.quota.IsValid(); valid != c.valid {
+			t.Errorf("Case %d: expected valid to be %v, got %v", i, c.valid, reasons)
+		}
+	}
+}
+
+func TestQuota_Admits(t *testing.T) {
+	maxRuns, maxCpu := int64(2), int64(1000)
+	cpu := int64(500)
+	q := Quota{Scope: QuotaScopeOwner, Name: "somebody", MaxRuns: &maxRuns, MaxCpu: &maxCpu}
+	run := Run{User: "somebody", Cpu: &cpu}
+
+	if !q.Matches(run) || q.Matches(Run{User: "somebody-else"}) {
+		t.Errorf("Expected the quota to match runs of its owner only")
+	}
+
+	var usage QuotaUsage
+	if !q.Admits(usage, run) {
+		t.Errorf("Expected an empty quota to admit the run")
+	}
+	usage.Add(run)
+	if !q.Admits(usage, run) {
+		t.Errorf("Expected the quota to admit a run using its full cpu")
+	}
+	usage.Add(run)
+	if q.Admits(usage, Run{User: "somebody"}) {
+		t.Errorf("Expected a full quota to hold back the run")
+	}
+	if usage.Runs != 2 || usage.Cpu != 1000 {
+		t.Errorf("Expected usage of 2 runs and 1000 cpu, got %+v", usage)
+	}
+}
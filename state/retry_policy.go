@@ -0,0 +1,138 @@
+package state
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+//
+// Retry states of a stopped run. Runs stopped on purpose, eg. terminated by
+// a user, are marked RetryStateNone so their policy doesn't retry them.
+//
+const (
+	RetryStateScheduled = "scheduled"
+	RetryStateRetried   = "retried"
+	RetryStateNone      = "none"
+)
+
+// MaxRetryAttempts bounds RetryPolicy.MaxAttempts
+const MaxRetryAttempts = 10
+
+// defaultMaxBackoff caps the retry backoff when the policy doesn't
+var defaultMaxBackoff = time.Hour
+
+//
+// RetryPolicy decides whether a stopped run is retried automatically. A run
+// is retried when it exits with one of ExitCodes or its ExitReason matches
+// one of the ExitReasons regular expressions, until MaxAttempts runs
+// (including the first) have been made. Attempts are spaced by an
+// exponential backoff starting at BackoffSeconds, with jitter.
+//
+type RetryPolicy struct {
+	MaxAttempts       int64    `json:"max_attempts"`
+	BackoffSeconds    int64    `json:"backoff_seconds"`
+	MaxBackoffSeconds int64    `json:"max_backoff_seconds,omitempty"`
+	ExitCodes         []int64  `json:"exit_codes,omitempty"`
+	ExitReasons       []string `json:"exit_reasons,omitempty"`
+}
+
+//
+// Validate returns the reasons the policy is invalid, if any
+//
+func (p *RetryPolicy) Validate() []string {
+	var reasons []string
+	if p.MaxAttempts < 1 || p.MaxAttempts > MaxRetryAttempts {
+		reasons = append(reasons, fmt.Sprintf(
+			"int [retry_policy.max_attempts] must be between 1 and %d", MaxRetryAttempts))
+	}
+	if p.BackoffSeconds < 0 || p.MaxBackoffSeconds < 0 {
+		reasons = append(reasons, "int [retry_policy.backoff_seconds] and [retry_policy.max_backoff_seconds] must not be negative")
+	}
+	if len(p.ExitCodes) == 0 && len(p.ExitReasons) == 0 {
+		reasons = append(reasons, "one of [retry_policy.exit_codes] or [retry_policy.exit_reasons] must be specified")
+	}
+	for _, pattern := range p.ExitReasons {
+		if _, err := regexp.Compile(pattern); err != nil {
+			reasons = append(reasons, fmt.Sprintf("string [retry_policy.exit_reasons] pattern [%s] is invalid: %v", pattern, err))
+		}
+	}
+	return reasons
+}
+
+//
+// ShouldRetry returns whether the stopped run failed in a way the policy
+// retries and has attempts left
+//
+func (p *RetryPolicy) ShouldRetry(run Run) bool {
+	if run.RetryAttempt+1 >= p.MaxAttempts {
+		return false
+	}
+	if run.ExitCode != nil {
+		if *run.ExitCode == 0 {
+			return false
+		}
+		for _, code := range p.ExitCodes {
+			if code == *run.ExitCode {
+				return true
+			}
+		}
+	}
+	if run.ExitReason != nil {
+		for _, pattern := range p.ExitReasons {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(*run.ExitReason) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// Backoff returns the delay before the given retry (1 for the first retry):
+// BackoffSeconds doubled for every earlier retry, capped, with up to half of
+// it replaced by random jitter
+//
+func (p *RetryPolicy) Backoff(retry int64) time.Duration {
+	maxBackoff := defaultMaxBackoff
+	if p.MaxBackoffSeconds > 0 {
+		maxBackoff = time.Duration(p.MaxBackoffSeconds) * time.Second
+	}
+
+	backoff := time.Duration(p.BackoffSeconds) * time.Second
+	for i := int64(1); i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	if half := int64(backoff / 2); half > 0 {
+		backoff = time.Duration(half + rand.Int63n(half+1))
+	}
+	return backoff
+}
+
+//
+// IsAwaitingRetry returns whether a stopped run is waiting for the retry
+// worker to queue its next attempt
+//
+func IsAwaitingRetry(run Run) bool {
+	return run.RetryState == RetryStateScheduled ||
+		(run.RetryState == RetryStateRetried && run.RetryRunID == nil)
+}
+
+//
+// scheduleRetry marks a stopped run for retry when its retry policy matches.
+// The decision is made once; updates to runs already scheduled, retried or
+// marked RetryStateNone are left alone.
+//
+func scheduleRetry(run *Run, now time.Time) {
+	if run.Status != StatusStopped || len(run.RetryState) > 0 ||
+		run.RetryPolicy == nil || !run.RetryPolicy.ShouldRetry(*run) {
+		return
+	}
+	retryAt := now.Add(run.RetryPolicy.Backoff(run.RetryAttempt + 1))
+	run.RetryAt = &retryAt
+	run.RetryState = RetryStateScheduled
+}
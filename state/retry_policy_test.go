@@ -0,0 +1,112 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, ExitCodes: []int64{137}, ExitReasons: []string{"(?i)spot.*interrupt"}}
+	code := func(c int64) *int64 { return &c }
+	reason := func(r string) *string { return &r }
+
+	cases := []struct {
+		run      Run
+		expected bool
+	}{
+		{Run{ExitCode: code(137)}, true},
+		{Run{ExitCode: code(1)}, false},
+		{Run{ExitCode: code(0), ExitReason: reason("Spot instance interrupted")}, false},
+		{Run{ExitReason: reason("Spot instance interrupted")}, true},
+		{Run{ExitCode: code(137), RetryAttempt: 1}, true},
+		{Run{ExitCode: code(137), RetryAttempt: 2}, false},
+		{Run{}, false},
+	}
+	for i, c := range cases {
+		if actual := p.ShouldRetry(c.run); actual != c.expected {
+			t.Errorf("case %d: expected ShouldRetry %v but was %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BackoffSeconds: 10, MaxBackoffSeconds: 60}
+	cases := []struct {
+		retry int64
+		max   time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 60 * time.Second},
+		{9, 60 * time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			backoff := p.Backoff(c.retry)
+			if backoff < c.max/2 || backoff > c.max {
+				t.Errorf("retry %d: expected backoff in [%v, %v] but was %v", c.retry, c.max/2, c.max, backoff)
+			}
+		}
+	}
+
+	if backoff := (&RetryPolicy{}).Backoff(3); backoff != 0 {
+		t.Errorf("Expected no backoff without backoff_seconds but was %v", backoff)
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	valid := RetryPolicy{MaxAttempts: 3, BackoffSeconds: 10, ExitCodes: []int64{1}}
+	if reasons := valid.Validate(); len(reasons) > 0 {
+		t.Errorf("Expected policy to be valid, got %v", reasons)
+	}
+
+	invalid := []RetryPolicy{
+		{MaxAttempts: 0, ExitCodes: []int64{1}},
+		{MaxAttempts: MaxRetryAttempts + 1, ExitCodes: []int64{1}},
+		{MaxAttempts: 3, BackoffSeconds: -1, ExitCodes: []int64{1}},
+		{MaxAttempts: 3},
+		{MaxAttempts: 3, ExitReasons: []string{"("}},
+	}
+	for i, p := range invalid {
+		if reasons := p.Validate(); len(reasons) == 0 {
+			t.Errorf("case %d: expected policy %v to be invalid", i, p)
+		}
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	code := int64(137)
+	run := Run{
+		Status:      StatusStopped,
+		ExitCode:    &code,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, BackoffSeconds: 60, ExitCodes: []int64{137}},
+	}
+
+	scheduleRetry(&run, now)
+	if run.RetryState != RetryStateScheduled || run.RetryAt == nil {
+		t.Fatalf("Expected retry to be scheduled, got state [%s]", run.RetryState)
+	}
+	if run.RetryAt.Before(now.Add(30*time.Second)) || run.RetryAt.After(now.Add(time.Minute)) {
+		t.Errorf("Expected retry within the first backoff, got %v", run.RetryAt)
+	}
+
+	run.RetryState = RetryStateRetried
+	scheduleRetry(&run, now)
+	if run.RetryState != RetryStateRetried {
+		t.Errorf("Expected a retried run not to be rescheduled")
+	}
+
+	terminated := Run{Status: StatusStopped, ExitCode: &code, RetryPolicy: run.RetryPolicy, RetryState: RetryStateNone}
+	scheduleRetry(&terminated, now)
+	if terminated.RetryState != RetryStateNone || terminated.RetryAt != nil {
+		t.Errorf("Expected a run marked not to be retried not to be scheduled")
+	}
+
+	running := Run{Status: StatusRunning, ExitCode: &code, RetryPolicy: run.RetryPolicy}
+	scheduleRetry(&running, now)
+	if len(running.RetryState) > 0 {
+		t.Errorf("Expected only stopped runs to be scheduled for retry")
+	}
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/stitchfix/flotilla-os/clients/metrics"
+)
+
+//
+// Components that change run statuses, recorded with each transition
+//
+const (
+	TransitionSourceAPI              = "api"
+	TransitionSourceSubmitWorker     = "submit_worker"
+	TransitionSourceStatusWorker     = "status_worker"
+	TransitionSourceEventsWorker     = "events_worker"
+	TransitionSourceRetryWorker      = "retry_worker"
+	TransitionSourceCloudtrailWorker = "cloudtrail_worker"
+	TransitionSourceArrayWorker      = "array_worker"
+)
+
+//
+// validTransitions maps each run status to the statuses it may move to.
+// STOPPED is terminal.
+//
+var validTransitions = map[string][]string{
+	StatusQueued:     {StatusPending, StatusRunning, StatusNeedsRetry, StatusStopped},
+	StatusPending:    {StatusRunning, StatusNeedsRetry, StatusStopped},
+	StatusRunning:    {StatusNeedsRetry, StatusStopped},
+	StatusNeedsRetry: {StatusQueued, StatusStopped},
+	StatusStopped:    {},
+}
+
+//
+// IsValidTransition returns whether a run may move from one status to
+// another. Updates that leave the status unchanged are always valid.
+//
+func IsValidTransition(from string, to string) bool {
+	if len(to) == 0 || from == to {
+		return true
+	}
+	next, ok := validTransitions[from]
+	if !ok {
+		// Runs with a status outside the state machine (eg. legacy rows) may
+		// move anywhere
+		return true
+	}
+	for _, s := range next {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// checkTransition rejects updates that would move the run through an illegal
+// status transition and returns the transition to record, if any
+//
+func checkTransition(existing Run, updates Run, source string) (*RunStatusTransition, error) {
+	if !IsValidTransition(existing.Status, updates.Status) {
+		_ = metrics.Increment(metrics.StateIllegalRunTransition, []string{fmt.Sprintf("source:%s", source)}, 1)
+		return nil, IllegalTransition{
+			RunID: existing.RunID, From: existing.Status, To: updates.Status, Source: source}
+	}
+	if len(updates.Status) == 0 || existing.Status == updates.Status {
+		return nil, nil
+	}
+	return &RunStatusTransition{
+		RunID:      existing.RunID,
+		FromStatus: existing.Status,
+		ToStatus:   updates.Status,
+		Source:     source,
+	}, nil
+}
+
+//
+// IllegalTransition is the rejection by UpdateRun of an update that would
+// move a run through an illegal status transition
+//
+type IllegalTransition struct {
+	RunID  string
+	From   string
+	To     string
+	Source string
+}
+
+func (e IllegalTransition) Error() string {
+	return fmt.Sprintf("illegal status transition for run [%s] from %s to %s by %s", e.RunID, e.From, e.To, e.Source)
+}
+
+//
+// IsIllegalTransition returns whether err is the rejection of an illegal
+// status transition by UpdateRun
+//
+func IsIllegalTransition(err error) bool {
+	_, ok := err.(IllegalTransition)
+	return ok
+}
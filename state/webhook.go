@@ -0,0 +1,293 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+//
+// Scopes of a webhook subscription: the runs of a definition, a template or
+// a group, or a single run
+//
+const (
+	WebhookScopeDefinition = "definition"
+	WebhookScopeTemplate   = "template"
+	WebhookScopeGroup      = "group"
+	WebhookScopeRun        = "run"
+)
+
+// WebhookScopes are the valid webhook scopes
+var WebhookScopes = []string{WebhookScopeDefinition, WebhookScopeTemplate, WebhookScopeGroup, WebhookScopeRun}
+
+//
+// Run lifecycle events a webhook can subscribe to
+//
+const (
+	WebhookEventRunning    = "run.running"
+	WebhookEventSucceeded  = "run.succeeded"
+	WebhookEventFailed     = "run.failed"
+	WebhookEventNeedsRetry = "run.needs_retry"
+	WebhookEventTest       = "test"
+)
+
+// WebhookEvents are the run lifecycle events a webhook can subscribe to
+var WebhookEvents = []string{WebhookEventRunning, WebhookEventSucceeded, WebhookEventFailed, WebhookEventNeedsRetry}
+
+//
+// Statuses of a webhook delivery
+//
+const (
+	WebhookDeliveryPending   = "PENDING"
+	WebhookDeliveryDelivered = "DELIVERED"
+	WebhookDeliveryFailed    = "FAILED"
+)
+
+//
+// Webhook subscribes a URL to the lifecycle events of the runs matching its
+// scope and name. Payloads are signed with Secret, which is only returned
+// when the webhook is created.
+//
+type Webhook struct {
+	WebhookID string           `json:"webhook_id"`
+	Scope     string           `json:"scope"`
+	Name      string           `json:"name"`
+	URL       string           `json:"url"`
+	Events    WebhookEventList `json:"events"`
+	Secret    string           `json:"secret,omitempty"`
+	CreatedBy string           `json:"created_by,omitempty"`
+	CreatedAt *time.Time       `json:"created_at,omitempty"`
+}
+
+//
+// WebhookEventList is the list of events a webhook subscribes to
+//
+type WebhookEventList []string
+
+// NewWebhookID returns a new uuid for a Webhook
+func NewWebhookID() (string, error) {
+	uuid4, err := newUUIDv4()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("wh-%s", uuid4[3:]), nil
+}
+
+// NewWebhookDeliveryID returns a new uuid for a WebhookDelivery
+func NewWebhookDeliveryID() (string, error) {
+	uuid4, err := newUUIDv4()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("whd-%s", uuid4[4:]), nil
+}
+
+//
+// IsValid returns whether the webhook is valid and the reasons it isn't
+//
+func (w *Webhook) IsValid() (bool, []string) {
+	var reasons []string
+	if !validWebhookScope(w.Scope) {
+		reasons = append(reasons, fmt.Sprintf("string [scope] must be one of %v", WebhookScopes))
+	}
+	if len(w.Name) == 0 {
+		reasons = append(reasons, "string [name] must be specified")
+	}
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		reasons = append(reasons, "string [url] must be an absolute http or https url")
+	}
+	for _, e := range w.Events {
+		if !validWebhookEvent(e) {
+			reasons = append(reasons, fmt.Sprintf("string [events] must only contain %v", WebhookEvents))
+			break
+		}
+	}
+	return len(reasons) == 0, reasons
+}
+
+func validWebhookScope(scope string) bool {
+	for _, s := range WebhookScopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if event == e {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Matches returns whether the webhook's scope covers run
+//
+func (w *Webhook) Matches(run Run) bool {
+	switch w.Scope {
+	case WebhookScopeDefinition:
+		return run.DefinitionID == w.Name
+	case WebhookScopeTemplate:
+		return run.ExecutableType != nil && *run.ExecutableType == ExecutableTypeTemplate &&
+			run.ExecutableID != nil && *run.ExecutableID == w.Name
+	case WebhookScopeGroup:
+		return run.GroupName == w.Name
+	case WebhookScopeRun:
+		return run.RunID == w.Name
+	}
+	return false
+}
+
+//
+// Subscribes returns whether the webhook subscribes to event; a webhook
+// without events subscribes to all of them
+//
+func (w *Webhook) Subscribes(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// WebhookEventFor returns the lifecycle event of run's current status, if
+// webhooks can subscribe to it
+//
+func WebhookEventFor(run Run) (string, bool) {
+	switch run.Status {
+	case StatusRunning:
+		return WebhookEventRunning, true
+	case StatusNeedsRetry:
+		return WebhookEventNeedsRetry, true
+	case StatusStopped:
+		if run.ExitCode != nil && *run.ExitCode == 0 {
+			return WebhookEventSucceeded, true
+		}
+		return WebhookEventFailed, true
+	}
+	return "", false
+}
+
+//
+// WebhookPayload is the JSON body POSTed to a webhook
+//
+type WebhookPayload struct {
+	DeliveryID string      `json:"delivery_id"`
+	WebhookID  string      `json:"webhook_id"`
+	Event      string      `json:"event"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Run        *WebhookRun `json:"run,omitempty"`
+}
+
+//
+// WebhookRun is the summary of a run sent with its lifecycle events
+//
+type WebhookRun struct {
+	RunID        string     `json:"run_id"`
+	DefinitionID string     `json:"definition_id"`
+	Alias        string     `json:"alias"`
+	GroupName    string     `json:"group_name"`
+	ExecutableID *string    `json:"executable_id,omitempty"`
+	Status       string     `json:"status"`
+	ExitCode     *int64     `json:"exit_code,omitempty"`
+	ExitReason   *string    `json:"exit_reason,omitempty"`
+	QueuedAt     *time.Time `json:"queued_at,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+//
+// NewWebhookRun summarizes run for a webhook payload; the environment and
+// command are left out
+//
+func NewWebhookRun(run Run) *WebhookRun {
+	return &WebhookRun{
+		RunID:        run.RunID,
+		DefinitionID: run.DefinitionID,
+		Alias:        run.Alias,
+		GroupName:    run.GroupName,
+		ExecutableID: run.ExecutableID,
+		Status:       run.Status,
+		ExitCode:     run.ExitCode,
+		ExitReason:   run.ExitReason,
+		QueuedAt:     run.QueuedAt,
+		StartedAt:    run.StartedAt,
+		FinishedAt:   run.FinishedAt,
+	}
+}
+
+//
+// WebhookDelivery is a single event sent, or to be sent, to a webhook. A
+// PENDING delivery is (re)attempted once NextAttemptAt has passed. Sequence
+// counts the run's transitions into the status of the event, so a run that
+// is requeued and starts again gets another run.running.
+//
+type WebhookDelivery struct {
+	DeliveryID    string          `json:"delivery_id"`
+	WebhookID     string          `json:"webhook_id"`
+	RunID         string          `json:"run_id,omitempty"`
+	Event         string          `json:"event"`
+	Sequence      int             `json:"sequence,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     *string         `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt     *time.Time      `json:"created_at,omitempty"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
+
+//
+// WebhookList wraps a list of Webhooks
+//
+type WebhookList struct {
+	Total    int       `json:"total"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+func (wl *WebhookList) MarshalJSON() ([]byte, error) {
+	type Alias WebhookList
+	l := wl.Webhooks
+	if l == nil {
+		l = []Webhook{}
+	}
+	return json.Marshal(&struct {
+		Webhooks []Webhook `json:"webhooks"`
+		*Alias
+	}{
+		Webhooks: l,
+		Alias:    (*Alias)(wl),
+	})
+}
+
+//
+// WebhookDeliveryList wraps a list of WebhookDeliveries
+//
+type WebhookDeliveryList struct {
+	Total      int               `json:"total"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+func (dl *WebhookDeliveryList) MarshalJSON() ([]byte, error) {
+	type Alias WebhookDeliveryList
+	l := dl.Deliveries
+	if l == nil {
+		l = []WebhookDelivery{}
+	}
+	return json.Marshal(&struct {
+		Deliveries []WebhookDelivery `json:"deliveries"`
+		*Alias
+	}{
+		Deliveries: l,
+		Alias:      (*Alias)(dl),
+	})
+}
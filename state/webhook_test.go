@@ -0,0 +1,77 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestWebhook_IsValid(t *testing.T) {
+	cases := []struct {
+		webhook Webhook
+		valid   bool
+	}{
+		{Webhook{Scope: WebhookScopeGroup, Name: "g", URL: "https://example.com/hook"}, true},
+		{Webhook{Scope: WebhookScopeRun, Name: "r", URL: "http://example.com", Events: WebhookEventList{WebhookEventFailed}}, true},
+		{Webhook{Scope: "team", Name: "t", URL: "https://example.com/hook"}, false},
+		{Webhook{Scope: WebhookScopeDefinition, URL: "https://example.com/hook"}, false},
+		{Webhook{Scope: WebhookScopeDefinition, Name: "d", URL: "/hook"}, false},
+		{Webhook{Scope: WebhookScopeDefinition, Name: "d", URL: "ftp://example.com"}, false},
+		{Webhook{Scope: WebhookScopeGroup, Name: "g", URL: "https://example.com", Events: WebhookEventList{WebhookEventTest}}, false},
+	}
+	for i, c := range cases {
+		if valid, reasons := c.webhook.IsValid(); valid != c.valid {
+			t.Errorf("Case %d: expected valid to be %v, got %v", i, c.valid, reasons)
+		}
+	}
+}
+
+func TestWebhook_Matches(t *testing.T) {
+	templateType, templateID := ExecutableTypeTemplate, "tpl-1"
+	run := Run{RunID: "r", DefinitionID: "d", GroupName: "g", ExecutableType: &templateType, ExecutableID: &templateID}
+
+	cases := []struct {
+		webhook Webhook
+		matches bool
+	}{
+		{Webhook{Scope: WebhookScopeDefinition, Name: "d"}, true},
+		{Webhook{Scope: WebhookScopeTemplate, Name: "tpl-1"}, true},
+		{Webhook{Scope: WebhookScopeGroup, Name: "g"}, true},
+		{Webhook{Scope: WebhookScopeRun, Name: "r"}, true},
+		{Webhook{Scope: WebhookScopeGroup, Name: "h"}, false},
+		{Webhook{Scope: WebhookScopeTemplate, Name: "d"}, false},
+	}
+	for i, c := range cases {
+		if matches := c.webhook.Matches(run); matches != c.matches {
+			t.Errorf("Case %d: expected matches to be %v", i, c.matches)
+		}
+	}
+
+	w := Webhook{Events: WebhookEventList{WebhookEventSucceeded}}
+	if !w.Subscribes(WebhookEventSucceeded) || w.Subscribes(WebhookEventFailed) {
+		t.Errorf("Expected the webhook to only subscribe to its events")
+	}
+	if all := (Webhook{}); !all.Subscribes(WebhookEventNeedsRetry) {
+		t.Errorf("Expected a webhook without events to subscribe to all of them")
+	}
+}
+
+func TestWebhookEventFor(t *testing.T) {
+	zero, one := int64(0), int64(1)
+	cases := []struct {
+		run   Run
+		event string
+		ok    bool
+	}{
+		{Run{Status: StatusRunning}, WebhookEventRunning, true},
+		{Run{Status: StatusNeedsRetry}, WebhookEventNeedsRetry, true},
+		{Run{Status: StatusStopped, ExitCode: &zero}, WebhookEventSucceeded, true},
+		{Run{Status: StatusStopped, ExitCode: &one}, WebhookEventFailed, true},
+		{Run{Status: StatusStopped}, WebhookEventFailed, true},
+		{Run{Status: StatusQueued}, "", false},
+		{Run{Status: StatusPending}, "", false},
+	}
+	for i, c := range cases {
+		if event, ok := WebhookEventFor(c.run); event != c.event || ok != c.ok {
+			t.Errorf("Case %d: expected %s, %v but got %s, %v", i, c.event, c.ok, event, ok)
+		}
+	}
+}
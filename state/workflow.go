@@ -0,0 +1,361 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+//
+// Aggregate statuses of a workflow
+//
+const (
+	WorkflowStatusRunning   = "RUNNING"
+	WorkflowStatusSucceeded = "SUCCEEDED"
+	WorkflowStatusFailed    = "FAILED"
+	WorkflowStatusCancelled = "CANCELLED"
+)
+
+//
+// Statuses of a single workflow node
+//
+const (
+	WorkflowNodePending   = "PENDING"
+	WorkflowNodeRunning   = "RUNNING"
+	WorkflowNodeSucceeded = "SUCCEEDED"
+	WorkflowNodeFailed    = "FAILED"
+	WorkflowNodeSkipped   = "SKIPPED"
+	WorkflowNodeCancelled = "CANCELLED"
+)
+
+//
+// Conditions on a dependency edge: a node runs once every dependency it has
+// has finished and met the edge's condition
+//
+const (
+	WorkflowConditionOnSuccess = "on_success"
+	WorkflowConditionOnFailure = "on_failure"
+	WorkflowConditionAlways    = "always"
+)
+
+//
+// Workflow is a DAG of definition and template runs. Nodes start once their
+// dependencies have finished and met the condition on each edge; the
+// workflow finishes when every node has.
+//
+type Workflow struct {
+	WorkflowID      string        `json:"workflow_id"`
+	Name            string        `json:"name"`
+	Status          string        `json:"status"`
+	Nodes           WorkflowNodes `json:"nodes"`
+	CancelRequested bool          `json:"cancel_requested"`
+	CreatedAt       *time.Time    `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time    `json:"updated_at,omitempty"`
+	FinishedAt      *time.Time    `json:"finished_at,omitempty"`
+}
+
+//
+// WorkflowNode is a single run of a definition or template within a
+// workflow. RunID is the latest attempt of the node's run.
+//
+type WorkflowNode struct {
+	Name              string                      `json:"name"`
+	ExecutableType    ExecutableType              `json:"executable_type"`
+	ExecutableID      string                      `json:"executable_id"`
+	DefinitionRequest *DefinitionExecutionRequest `json:"definition_request,omitempty"`
+	TemplateRequest   *TemplateExecutionRequest   `json:"template_request,omitempty"`
+	DependsOn         []WorkflowDependency        `json:"depends_on,omitempty"`
+	Status            string                      `json:"status"`
+	RunID             *string                     `json:"run_id,omitempty"`
+	Message           *string                     `json:"message,omitempty"`
+}
+
+//
+// WorkflowDependency is an edge from the node named Node
+//
+type WorkflowDependency struct {
+	Node      string `json:"node"`
+	Condition string `json:"condition"`
+}
+
+//
+// WorkflowNodes is the list of nodes of a workflow, stored as json
+//
+type WorkflowNodes []WorkflowNode
+
+// NewWorkflowID returns a new uuid for a Workflow
+func NewWorkflowID() (string, error) {
+	uuid4, err := newUUIDv4()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("wf-%s", uuid4[3:]), nil
+}
+
+//
+// ExecutionRequest returns the stored request matching the node's
+// executable type
+//
+func (n *WorkflowNode) ExecutionRequest() ExecutionRequest {
+	if n.ExecutableType == ExecutableTypeTemplate {
+		return n.TemplateRequest
+	}
+	return n.DefinitionRequest
+}
+
+//
+// IsFinished returns whether the node has reached a final status
+//
+func (n *WorkflowNode) IsFinished() bool {
+	switch n.Status {
+	case WorkflowNodeSucceeded, WorkflowNodeFailed, WorkflowNodeSkipped, WorkflowNodeCancelled:
+		return true
+	}
+	return false
+}
+
+//
+// WorkflowNodeStatus returns the status of a node whose current run is run
+//
+func WorkflowNodeStatus(run Run) string {
+	if run.Status != StatusStopped {
+		return WorkflowNodeRunning
+	}
+	if run.ExitCode != nil && *run.ExitCode == 0 {
+		return WorkflowNodeSucceeded
+	}
+	return WorkflowNodeFailed
+}
+
+//
+// IsValid returns true only if this is a valid workflow: node names are
+// unique, every dependency names another node with a valid condition and the
+// dependencies form no cycle
+//
+func (w *Workflow) IsValid() (bool, []string) {
+	var reasons []string
+	if len(w.Nodes) == 0 {
+		reasons = append(reasons, "array [nodes] must not be empty")
+	}
+
+	names := make(map[string]bool)
+	for _, n := range w.Nodes {
+		if len(n.Name) == 0 {
+			reasons = append(reasons, "string [nodes.name] must be specified")
+			continue
+		}
+		if names[n.Name] {
+			reasons = append(reasons, fmt.Sprintf("node name [%s] must be unique", n.Name))
+		}
+		names[n.Name] = true
+	}
+
+	for _, n := range w.Nodes {
+		conditions := []validationCondition{
+			{len(n.ExecutableID) == 0, fmt.Sprintf("string [executable_id] must be specified for node [%s]", n.Name)},
+			{n.ExecutableType == ExecutableTypeDefinition && n.DefinitionRequest == nil,
+				fmt.Sprintf("object [definition_request] must be specified for task_definition node [%s]", n.Name)},
+			{n.ExecutableType == ExecutableTypeTemplate && n.TemplateRequest == nil,
+				fmt.Sprintf("object [template_request] must be specified for template node [%s]", n.Name)},
+			{n.ExecutableType != ExecutableTypeDefinition && n.ExecutableType != ExecutableTypeTemplate,
+				fmt.Sprintf("string [executable_type] of node [%s] must be one of task_definition, template", n.Name)},
+		}
+		for _, cond := range conditions {
+			if cond.condition {
+				reasons = append(reasons, cond.reason)
+			}
+		}
+
+		for _, d := range n.DependsOn {
+			switch {
+			case d.Node == n.Name:
+				reasons = append(reasons, fmt.Sprintf("node [%s] must not depend on itself", n.Name))
+			case !names[d.Node]:
+				reasons = append(reasons, fmt.Sprintf("node [%s] depends on unknown node [%s]", n.Name, d.Node))
+			}
+			if d.Condition != WorkflowConditionOnSuccess && d.Condition != WorkflowConditionOnFailure &&
+				d.Condition != WorkflowConditionAlways {
+				reasons = append(reasons, fmt.Sprintf(
+					"string [depends_on.condition] of node [%s] must be one of on_success, on_failure, always", n.Name))
+			}
+		}
+	}
+
+	if len(reasons) == 0 && w.hasCycle() {
+		reasons = append(reasons, "array [nodes] dependencies must not form a cycle")
+	}
+	return len(reasons) == 0, reasons
+}
+
+//
+// hasCycle removes nodes without unresolved dependencies until none are left;
+// any node that can't be removed is part of a cycle
+//
+func (w *Workflow) hasCycle() bool {
+	remaining := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, n := range w.Nodes {
+		remaining[n.Name] = len(n.DependsOn)
+		for _, d := range n.DependsOn {
+			dependents[d.Node] = append(dependents[d.Node], n.Name)
+		}
+	}
+
+	var ready []string
+	for name, count := range remaining {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+	removed := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		removed++
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	return removed != len(w.Nodes)
+}
+
+//
+// ReadyNodes returns the indexes of pending nodes whose dependencies have all
+// finished and met their conditions. Pending nodes with a dependency that
+// finished without meeting its condition are skipped, which may in turn
+// skip the nodes depending on them.
+//
+func (w *Workflow) ReadyNodes() []int {
+	byName := make(map[string]*WorkflowNode)
+	for i := range w.Nodes {
+		byName[w.Nodes[i].Name] = &w.Nodes[i]
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := range w.Nodes {
+			n := &w.Nodes[i]
+			if n.Status != WorkflowNodePending {
+				continue
+			}
+			if unmet := w.unmetDependency(n, byName); unmet != nil {
+				message := fmt.Sprintf("dependency [%s] finished %s, condition was %s", unmet.Node, byName[unmet.Node].Status, unmet.Condition)
+				n.Status = WorkflowNodeSkipped
+				n.Message = &message
+				changed = true
+			}
+		}
+	}
+
+	var ready []int
+	for i := range w.Nodes {
+		n := &w.Nodes[i]
+		if n.Status != WorkflowNodePending {
+			continue
+		}
+		waiting := false
+		for _, d := range n.DependsOn {
+			if !byName[d.Node].IsFinished() {
+				waiting = true
+				break
+			}
+		}
+		if !waiting {
+			ready = append(ready, i)
+		}
+	}
+	return ready
+}
+
+//
+// unmetDependency returns the first finished dependency of n whose condition
+// can no longer be met
+//
+func (w *Workflow) unmetDependency(n *WorkflowNode, byName map[string]*WorkflowNode) *WorkflowDependency {
+	for i, d := range n.DependsOn {
+		dep := byName[d.Node]
+		if !dep.IsFinished() {
+			continue
+		}
+		met := true
+		switch d.Condition {
+		case WorkflowConditionOnSuccess:
+			met = dep.Status == WorkflowNodeSucceeded
+		case WorkflowConditionOnFailure:
+			met = dep.Status == WorkflowNodeFailed
+		}
+		if !met {
+			return &n.DependsOn[i]
+		}
+	}
+	return nil
+}
+
+//
+// Cancel cancels every node that hasn't finished; the runs of running nodes
+// must be terminated by the caller
+//
+func (w *Workflow) Cancel() {
+	for i := range w.Nodes {
+		if !w.Nodes[i].IsFinished() {
+			w.Nodes[i].Status = WorkflowNodeCancelled
+		}
+	}
+}
+
+//
+// RefreshStatus sets the aggregate status of the workflow from its nodes.
+// A finished workflow is CANCELLED if any node was cancelled, FAILED if any
+// node failed and SUCCEEDED otherwise; skipped nodes don't fail a workflow.
+//
+func (w *Workflow) RefreshStatus(now time.Time) {
+	status := WorkflowStatusSucceeded
+	for _, n := range w.Nodes {
+		switch {
+		case !n.IsFinished():
+			w.Status = WorkflowStatusRunning
+			return
+		case n.Status == WorkflowNodeCancelled:
+			status = WorkflowStatusCancelled
+		case n.Status == WorkflowNodeFailed && status != WorkflowStatusCancelled:
+			status = WorkflowStatusFailed
+		}
+	}
+	w.Status = status
+	if w.FinishedAt == nil {
+		w.FinishedAt = &now
+	}
+}
+
+//
+// IsFinished returns whether the workflow has reached a final status
+//
+func (w *Workflow) IsFinished() bool {
+	return w.Status != WorkflowStatusRunning
+}
+
+//
+// WorkflowList wraps a list of Workflows
+//
+type WorkflowList struct {
+	Total     int        `json:"total"`
+	Workflows []Workflow `json:"workflows"`
+}
+
+func (wl *WorkflowList) MarshalJSON() ([]byte, error) {
+	type Alias WorkflowList
+	l := wl.Workflows
+	if l == nil {
+		l = []Workflow{}
+	}
+	return json.Marshal(&struct {
+		Workflows []Workflow `json:"workflows"`
+		*Alias
+	}{
+		Workflows: l,
+		Alias:     (*Alias)(wl),
+	})
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func workflowNode(name string, deps ...WorkflowDependency) WorkflowNode {
+	return WorkflowNode{
+		Name:              name,
+		ExecutableType:    ExecutableTypeDefinition,
+		ExecutableID:      "A",
+		DefinitionRequest: &DefinitionExecutionRequest{ExecutionRequestCommon: &ExecutionRequestCommon{}},
+		DependsOn:         deps,
+		Status:            WorkflowNodePending,
+	}
+}
+
+func TestWorkflow_IsValid(t *testing.T) {
+	onSuccess := func(node string) WorkflowDependency {
+		return WorkflowDependency{Node: node, Condition: WorkflowConditionOnSuccess}
+	}
+
+	valid := Workflow{Nodes: WorkflowNodes{
+		workflowNode("a"),
+		workflowNode("b", onSuccess("a")),
+		workflowNode("c", onSuccess("a"), WorkflowDependency{Node: "b", Condition: WorkflowConditionAlways}),
+	}}
+	if ok, reasons := valid.IsValid(); !ok {
+		t.Errorf("Expected workflow to be valid, got %v", reasons)
+	}
+
+	invalid := map[string]Workflow{
+		"empty":     {},
+		"duplicate": {Nodes: WorkflowNodes{workflowNode("a"), workflowNode("a")}},
+		"unknown":   {Nodes: WorkflowNodes{workflowNode("a", onSuccess("z"))}},
+		"self":      {Nodes: WorkflowNodes{workflowNode("a", onSuccess("a"))}},
+		"condition": {Nodes: WorkflowNodes{workflowNode("a"), workflowNode("b", WorkflowDependency{Node: "a", Condition: "sometimes"})}},
+		"cycle": {Nodes: WorkflowNodes{
+			workflowNode("a", onSuccess("c")), workflowNode("b", onSuccess("a")), workflowNode("c", onSuccess("b"))}},
+		"request": {Nodes: WorkflowNodes{{Name: "a", ExecutableType: ExecutableTypeTemplate, ExecutableID: "A"}}},
+	}
+	for name, w := range invalid {
+		if ok, _ := w.IsValid(); ok {
+			t.Errorf("Expected %s workflow to be invalid", name)
+		}
+	}
+}
+
+func TestWorkflow_ReadyNodes(t *testing.T) {
+	w := Workflow{Nodes: WorkflowNodes{
+		workflowNode("extract"),
+		workflowNode("load", WorkflowDependency{Node: "extract", Condition: WorkflowConditionOnSuccess}),
+		workflowNode("report", WorkflowDependency{Node: "load", Condition: WorkflowConditionOnSuccess}),
+		workflowNode("alert", WorkflowDependency{Node: "extract", Condition: WorkflowConditionOnFailure}),
+		workflowNode("cleanup", WorkflowDependency{Node: "report", Condition: WorkflowConditionAlways}),
+	}}
+
+	ready := w.ReadyNodes()
+	if len(ready) != 1 || w.Nodes[ready[0]].Name != "extract" {
+		t.Fatalf("Expected only extract to be ready, got %v", ready)
+	}
+
+	w.Nodes[0].Status = WorkflowNodeFailed
+	ready = w.ReadyNodes()
+	if len(ready) != 2 || w.Nodes[ready[0]].Name != "alert" || w.Nodes[ready[1]].Name != "cleanup" {
+		t.Fatalf("Expected alert and cleanup to be ready, got %v", ready)
+	}
+	for _, i := range []int{1, 2} {
+		if w.Nodes[i].Status != WorkflowNodeSkipped || w.Nodes[i].Message == nil {
+			t.Errorf("Expected %s to be skipped with a message, was %s", w.Nodes[i].Name, w.Nodes[i].Status)
+		}
+	}
+
+	w.RefreshStatus(time.Now())
+	if w.Status != WorkflowStatusRunning || w.FinishedAt != nil {
+		t.Errorf("Expected workflow to be running while nodes are pending, was %s", w.Status)
+	}
+
+	w.Nodes[3].Status = WorkflowNodeSucceeded
+	w.Nodes[4].Status = WorkflowNodeSucceeded
+	w.RefreshStatus(time.Now())
+	if w.Status != WorkflowStatusFailed || w.FinishedAt == nil {
+		t.Errorf("Expected workflow with a failed node to fail, was %s", w.Status)
+	}
+}
+
+func TestWorkflow_RefreshStatus(t *testing.T) {
+	w := Workflow{Nodes: WorkflowNodes{workflowNode("a"), workflowNode("b")}}
+	w.Nodes[0].Status = WorkflowNodeSucceeded
+	w.Nodes[1].Status = WorkflowNodeSkipped
+	w.RefreshStatus(time.Now())
+	if w.Status != WorkflowStatusSucceeded {
+		t.Errorf("Expected skipped nodes not to fail a workflow, was %s", w.Status)
+	}
+
+	w = Workflow{Nodes: WorkflowNodes{workflowNode("a"), workflowNode("b")}}
+	w.Nodes[0].Status = WorkflowNodeFailed
+	w.Cancel()
+	w.RefreshStatus(time.Now())
+	if w.Status != WorkflowStatusCancelled || w.Nodes[0].Status != WorkflowNodeFailed {
+		t.Errorf("Expected cancelled workflow keeping finished nodes, was %s with %s", w.Status, w.Nodes[0].Status)
+	}
+}
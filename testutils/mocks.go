@@ -3,11 +3,18 @@ package testutils
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	"github.com/stitchfix/flotilla-os/queue"
 	"github.com/stitchfix/flotilla-os/state"
@@ -33,6 +40,21 @@ type ImplementsAllTheThings struct {
 	Groups                  []string
 	Tags                    []string
 	Templates               map[string]state.Template
+	Snapshots               map[string]state.ExecutableSnapshot
+	Revisions               map[string][]state.DefinitionRevision
+	AuditEvents             []state.AuditEvent
+	AuditError              error // StateManager - error to return when creating audit events
+	CreateRunFailAt         int   // StateManager - CreateRun call, counting from 1, that fails; 0 for none
+	Transitions             map[string][]state.RunStatusTransition
+	Schedules               map[string]state.Schedule
+	Workflows               map[string]state.Workflow
+	IdempotencyKeys         map[string]state.IdempotencyKey
+	Quotas                  map[string]state.Quota
+	Webhooks                map[string]state.Webhook
+	WebhookDeliveries       map[string]state.WebhookDelivery
+	ExitReasonRules         state.ExitReasonRules
+	LogLines                []string // Lines returned by the logs client; the cursor is a line offset
+	LogsError               error    // Error to return from the logs client
 }
 
 func (iatt *ImplementsAllTheThings) LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error {
@@ -40,6 +62,11 @@ func (iatt *ImplementsAllTheThings) LogsText(executable state.Executable, run st
 	return nil
 }
 
+func (iatt *ImplementsAllTheThings) LogsReader(executable state.Executable, run state.Run, role *string, facility *string) (io.ReadCloser, error) {
+	iatt.Calls = append(iatt.Calls, "LogsReader")
+	return ioutil.NopCloser(strings.NewReader(strings.Join(iatt.LogLines, ""))), nil
+}
+
 func (iatt *ImplementsAllTheThings) Log(keyvals ...interface{}) error {
 	iatt.Calls = append(iatt.Calls, "Name")
 	return nil
@@ -128,6 +155,19 @@ func (iatt *ImplementsAllTheThings) UpdateDefinition(definitionID string, update
 	return defn, nil
 }
 
+// ReplaceDefinition - StateManager
+func (iatt *ImplementsAllTheThings) ReplaceDefinition(definitionID string, replacement state.Definition) (state.Definition, error) {
+	iatt.Calls = append(iatt.Calls, "ReplaceDefinition")
+	existing, ok := iatt.Definitions[definitionID]
+	if !ok {
+		return existing, fmt.Errorf("No definition %s", definitionID)
+	}
+	replacement.DefinitionID = existing.DefinitionID
+	replacement.GroupName = existing.GroupName
+	iatt.Definitions[definitionID] = replacement
+	return replacement, nil
+}
+
 // CreateDefinition - StateManager
 func (iatt *ImplementsAllTheThings) CreateDefinition(d state.Definition) error {
 	iatt.Calls = append(iatt.Calls, "CreateDefinition")
@@ -145,6 +185,54 @@ func (iatt *ImplementsAllTheThings) DeleteDefinition(definitionID string) error
 // ListRuns - StateManager
 func (iatt *ImplementsAllTheThings) ListRuns(limit int, offset int, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string) (state.RunList, error) {
 	iatt.Calls = append(iatt.Calls, "ListRuns")
+	if parent, ok := filters["array_parent_id"]; ok {
+		rl := state.RunList{}
+		for _, r := range iatt.Runs {
+			if r.ArrayParentID != nil && *r.ArrayParentID == parent[0] {
+				rl.Runs = append(rl.Runs, r)
+			}
+		}
+		sort.Slice(rl.Runs, func(i, j int) bool { return rl.Runs[i].RunID < rl.Runs[j].RunID })
+		rl.Total = len(rl.Runs)
+		return rl, nil
+	}
+	if taskTypes, ok := filters["task_type"]; ok && taskTypes[0] == state.ArrayTaskType {
+		rl := state.RunList{}
+		for _, r := range iatt.Runs {
+			if r.TaskType == state.ArrayTaskType {
+				rl.Runs = append(rl.Runs, r)
+			}
+		}
+		rl.Total = len(rl.Runs)
+		return rl, nil
+	}
+	if original, ok := filters["original_run_id"]; ok {
+		rl := state.RunList{}
+		for _, r := range iatt.Runs {
+			if r.OriginalRunID != nil && *r.OriginalRunID == original[0] {
+				rl.Runs = append(rl.Runs, r)
+			}
+		}
+		sort.Slice(rl.Runs, func(i, j int) bool { return rl.Runs[i].RetryAttempt < rl.Runs[j].RetryAttempt })
+		rl.Total = len(rl.Runs)
+		return rl, nil
+	}
+	if parent, ok := envFilters["PARENT_FLOTILLA_RUN_ID"]; ok {
+		rl := state.RunList{}
+		for _, r := range iatt.Runs {
+			if r.Env == nil {
+				continue
+			}
+			for _, e := range *r.Env {
+				if e.Name == "PARENT_FLOTILLA_RUN_ID" && e.Value == parent {
+					rl.Runs = append(rl.Runs, r)
+					break
+				}
+			}
+		}
+		rl.Total = len(rl.Runs)
+		return rl, nil
+	}
 	rl := state.RunList{Total: len(iatt.Runs)}
 	for _, r := range iatt.Runs {
 		rl.Runs = append(rl.Runs, r)
@@ -152,6 +240,19 @@ func (iatt *ImplementsAllTheThings) ListRuns(limit int, offset int, sortBy strin
 	return rl, nil
 }
 
+// ListRunsByCursor - StateManager
+func (iatt *ImplementsAllTheThings) ListRunsByCursor(limit int, cursor string, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string, approximateTotal bool) (state.RunList, error) {
+	iatt.Calls = append(iatt.Calls, "ListRunsByCursor")
+	rl := state.RunList{}
+	for _, r := range iatt.Runs {
+		rl.Runs = append(rl.Runs, r)
+	}
+	if approximateTotal {
+		rl.Total = len(iatt.Runs)
+	}
+	return rl, nil
+}
+
 // GetRun - StateManager
 func (iatt *ImplementsAllTheThings) GetRun(runID string) (state.Run, error) {
 	iatt.Calls = append(iatt.Calls, "GetRun")
@@ -176,6 +277,17 @@ func (iatt *ImplementsAllTheThings) GetRunByEMRJobId(emrJobId string) (state.Run
 // CreateRun - StateManager
 func (iatt *ImplementsAllTheThings) CreateRun(r state.Run) error {
 	iatt.Calls = append(iatt.Calls, "CreateRun")
+	if iatt.CreateRunFailAt > 0 {
+		n := 0
+		for _, call := range iatt.Calls {
+			if call == "CreateRun" {
+				n++
+			}
+		}
+		if n == iatt.CreateRunFailAt {
+			return fmt.Errorf("unable to create run %s", r.RunID)
+		}
+	}
 	iatt.Runs[r.RunID] = r
 	return nil
 }
@@ -186,14 +298,596 @@ func (iatt *ImplementsAllTheThings) EstimateRunResources(executableID string, co
 }
 
 // UpdateRun - StateManager
-func (iatt *ImplementsAllTheThings) UpdateRun(runID string, updates state.Run) (state.Run, error) {
+func (iatt *ImplementsAllTheThings) UpdateRun(runID string, updates state.Run, source string) (state.Run, error) {
 	iatt.Calls = append(iatt.Calls, "UpdateRun")
 	run := iatt.Runs[runID]
+	if !state.IsValidTransition(run.Status, updates.Status) {
+		return run, state.IllegalTransition{RunID: runID, From: run.Status, To: updates.Status, Source: source}
+	}
+	if len(updates.Status) > 0 && updates.Status != run.Status {
+		if iatt.Transitions == nil {
+			iatt.Transitions = make(map[string][]state.RunStatusTransition)
+		}
+		iatt.Transitions[runID] = append(iatt.Transitions[runID], state.RunStatusTransition{
+			RunID: runID, FromStatus: run.Status, ToStatus: updates.Status, Source: source})
+	}
 	run.UpdateWith(updates)
 	iatt.Runs[runID] = run
 	return run, nil
 }
 
+// CreateExecutableSnapshot - StateManager
+func (iatt *ImplementsAllTheThings) CreateExecutableSnapshot(s state.ExecutableSnapshot) error {
+	iatt.Calls = append(iatt.Calls, "CreateExecutableSnapshot")
+	if iatt.Snapshots == nil {
+		iatt.Snapshots = make(map[string]state.ExecutableSnapshot)
+	}
+	iatt.Snapshots[s.SnapshotID] = s
+	return nil
+}
+
+// GetExecutableSnapshot - StateManager
+func (iatt *ImplementsAllTheThings) GetExecutableSnapshot(snapshotID string) (state.ExecutableSnapshot, error) {
+	iatt.Calls = append(iatt.Calls, "GetExecutableSnapshot")
+	s, ok := iatt.Snapshots[snapshotID]
+	if !ok {
+		return s, fmt.Errorf("No snapshot %s", snapshotID)
+	}
+	return s, nil
+}
+
+// CreateDefinitionRevision - StateManager
+func (iatt *ImplementsAllTheThings) CreateDefinitionRevision(definitionID string, d state.Definition, userInfo state.UserInfo) (state.DefinitionRevision, error) {
+	iatt.Calls = append(iatt.Calls, "CreateDefinitionRevision")
+	if iatt.Revisions == nil {
+		iatt.Revisions = make(map[string][]state.DefinitionRevision)
+	}
+	r := state.DefinitionRevision{
+		DefinitionID: definitionID,
+		Revision:     int64(len(iatt.Revisions[definitionID]) + 1),
+		Definition:   d,
+		User:         userInfo,
+	}
+	iatt.Revisions[definitionID] = append(iatt.Revisions[definitionID], r)
+	return r, nil
+}
+
+// ListDefinitionRevisions - StateManager
+func (iatt *ImplementsAllTheThings) ListDefinitionRevisions(definitionID string, limit int, offset int) (state.DefinitionRevisionList, error) {
+	iatt.Calls = append(iatt.Calls, "ListDefinitionRevisions")
+	revisions := iatt.Revisions[definitionID]
+	result := state.DefinitionRevisionList{Total: len(revisions)}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		result.Revisions = append(result.Revisions, revisions[i])
+	}
+	return result, nil
+}
+
+// GetDefinitionRevision - StateManager
+func (iatt *ImplementsAllTheThings) GetDefinitionRevision(definitionID string, revision int64) (state.DefinitionRevision, error) {
+	iatt.Calls = append(iatt.Calls, "GetDefinitionRevision")
+	revisions := iatt.Revisions[definitionID]
+	if revision < 1 || revision > int64(len(revisions)) {
+		return state.DefinitionRevision{}, fmt.Errorf("No revision %d of definition %s", revision, definitionID)
+	}
+	return revisions[revision-1], nil
+}
+
+// CreateAuditEvent - StateManager
+func (iatt *ImplementsAllTheThings) CreateAuditEvent(e state.AuditEvent) error {
+	iatt.Calls = append(iatt.Calls, "CreateAuditEvent")
+	if iatt.AuditError != nil {
+		return iatt.AuditError
+	}
+	iatt.AuditEvents = append(iatt.AuditEvents, e)
+	return nil
+}
+
+// ListAuditEvents - StateManager
+func (iatt *ImplementsAllTheThings) ListAuditEvents(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.AuditEventList, error) {
+	iatt.Calls = append(iatt.Calls, "ListAuditEvents")
+	return state.AuditEventList{Total: len(iatt.AuditEvents), Events: iatt.AuditEvents}, nil
+}
+
+// CreateSchedule - StateManager
+func (iatt *ImplementsAllTheThings) CreateSchedule(s state.Schedule) error {
+	iatt.Calls = append(iatt.Calls, "CreateSchedule")
+	if iatt.Schedules == nil {
+		iatt.Schedules = make(map[string]state.Schedule)
+	}
+	iatt.Schedules[s.ScheduleID] = s
+	return nil
+}
+
+// GetSchedule - StateManager
+func (iatt *ImplementsAllTheThings) GetSchedule(scheduleID string) (state.Schedule, error) {
+	iatt.Calls = append(iatt.Calls, "GetSchedule")
+	s, ok := iatt.Schedules[scheduleID]
+	if !ok {
+		return s, exceptions.MissingResource{ErrorString: fmt.Sprintf("No schedule %s", scheduleID)}
+	}
+	return s, nil
+}
+
+// ListSchedules - StateManager
+func (iatt *ImplementsAllTheThings) ListSchedules(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.ScheduleList, error) {
+	iatt.Calls = append(iatt.Calls, "ListSchedules")
+	sl := state.ScheduleList{Total: len(iatt.Schedules)}
+	for _, s := range iatt.Schedules {
+		sl.Schedules = append(sl.Schedules, s)
+	}
+	return sl, nil
+}
+
+// UpdateSchedule - StateManager
+func (iatt *ImplementsAllTheThings) UpdateSchedule(scheduleID string, updates state.Schedule) (state.Schedule, error) {
+	iatt.Calls = append(iatt.Calls, "UpdateSchedule")
+	s, ok := iatt.Schedules[scheduleID]
+	if !ok {
+		return s, exceptions.MissingResource{ErrorString: fmt.Sprintf("No schedule %s", scheduleID)}
+	}
+	s.UpdateWith(updates)
+	iatt.Schedules[scheduleID] = s
+	return s, nil
+}
+
+// DeleteSchedule - StateManager
+func (iatt *ImplementsAllTheThings) DeleteSchedule(scheduleID string) error {
+	iatt.Calls = append(iatt.Calls, "DeleteSchedule")
+	if _, ok := iatt.Schedules[scheduleID]; !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No schedule %s", scheduleID)}
+	}
+	delete(iatt.Schedules, scheduleID)
+	return nil
+}
+
+// ClaimDueSchedules - StateManager
+func (iatt *ImplementsAllTheThings) ClaimDueSchedules(now time.Time, limit int) ([]state.Schedule, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimDueSchedules")
+	var due []state.Schedule
+	for id, s := range iatt.Schedules {
+		if s.IsEnabled() && s.NextRunAt != nil && !s.NextRunAt.After(now) {
+			due = append(due, s)
+			claimed := s
+			claimed.LastRunAt = s.NextRunAt
+			claimed.NextRunAt = nil
+			if next, err := s.NextAfter(now); err == nil {
+				claimed.NextRunAt = &next
+			}
+			iatt.Schedules[id] = claimed
+		}
+	}
+	return due, nil
+}
+
+// RecordScheduleRun - StateManager
+func (iatt *ImplementsAllTheThings) RecordScheduleRun(scheduleID string, runID string) error {
+	iatt.Calls = append(iatt.Calls, "RecordScheduleRun")
+	s, ok := iatt.Schedules[scheduleID]
+	if !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No schedule %s", scheduleID)}
+	}
+	s.LastRunID = &runID
+	iatt.Schedules[scheduleID] = s
+	return nil
+}
+
+// ClaimDueRetries - StateManager
+func (iatt *ImplementsAllTheThings) ClaimDueRetries(now time.Time, limit int) ([]state.Run, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimDueRetries")
+	var due []state.Run
+	for id, r := range iatt.Runs {
+		if r.RetryState == state.RetryStateScheduled && r.RetryAt != nil && !r.RetryAt.After(now) {
+			due = append(due, r)
+			r.RetryState = state.RetryStateRetried
+			iatt.Runs[id] = r
+		}
+	}
+	return due, nil
+}
+
+// RecordRetryRun - StateManager
+func (iatt *ImplementsAllTheThings) RecordRetryRun(runID string, retryRunID string) error {
+	iatt.Calls = append(iatt.Calls, "RecordRetryRun")
+	r, ok := iatt.Runs[runID]
+	if !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No run %s", runID)}
+	}
+	r.RetryRunID = &retryRunID
+	iatt.Runs[runID] = r
+	return nil
+}
+
+// RescheduleRetry - StateManager
+func (iatt *ImplementsAllTheThings) RescheduleRetry(runID string, retryAt time.Time) error {
+	iatt.Calls = append(iatt.Calls, "RescheduleRetry")
+	r, ok := iatt.Runs[runID]
+	if !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No run %s", runID)}
+	}
+	if r.RetryState == state.RetryStateRetried && r.RetryRunID == nil {
+		r.RetryState = state.RetryStateScheduled
+		r.RetryAt = &retryAt
+		iatt.Runs[runID] = r
+	}
+	return nil
+}
+
+// ReleaseArrayRuns - StateManager
+func (iatt *ImplementsAllTheThings) ReleaseArrayRuns(parentID string, maxActive int, now time.Time) ([]state.Run, error) {
+	iatt.Calls = append(iatt.Calls, "ReleaseArrayRuns")
+	var held []state.Run
+	active := 0
+	for _, r := range iatt.Runs {
+		if r.ArrayParentID == nil || *r.ArrayParentID != parentID {
+			continue
+		}
+		if state.IsHeldArrayRun(r) {
+			held = append(held, r)
+		} else if r.Status != state.StatusStopped {
+			active++
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return *held[i].ArrayIndex < *held[j].ArrayIndex })
+	if maxActive > 0 && maxActive-active < len(held) {
+		if maxActive-active <= 0 {
+			return nil, nil
+		}
+		held = held[:maxActive-active]
+	}
+	for i := range held {
+		held[i].QueuedAt = &now
+		iatt.Runs[held[i].RunID] = held[i]
+	}
+	return held, nil
+}
+
+// ClaimIdempotencyKey - StateManager
+func (iatt *ImplementsAllTheThings) ClaimIdempotencyKey(k state.IdempotencyKey, window time.Duration) (state.IdempotencyKey, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimIdempotencyKey")
+	if iatt.IdempotencyKeys == nil {
+		iatt.IdempotencyKeys = make(map[string]state.IdempotencyKey)
+	}
+	id := k.OwnerID + "/" + k.Key
+	if stored, ok := iatt.IdempotencyKeys[id]; ok && !stored.CreatedAt.Before(k.CreatedAt.Add(-window)) {
+		return stored, nil
+	}
+	iatt.IdempotencyKeys[id] = k
+	return k, nil
+}
+
+// ReleaseIdempotencyKey - StateManager
+func (iatt *ImplementsAllTheThings) ReleaseIdempotencyKey(k state.IdempotencyKey) error {
+	iatt.Calls = append(iatt.Calls, "ReleaseIdempotencyKey")
+	id := k.OwnerID + "/" + k.Key
+	if stored, ok := iatt.IdempotencyKeys[id]; ok && stored.RunID == k.RunID {
+		delete(iatt.IdempotencyKeys, id)
+	}
+	return nil
+}
+
+// CreateWorkflow - StateManager
+func (iatt *ImplementsAllTheThings) CreateWorkflow(w state.Workflow) error {
+	iatt.Calls = append(iatt.Calls, "CreateWorkflow")
+	if iatt.Workflows == nil {
+		iatt.Workflows = make(map[string]state.Workflow)
+	}
+	iatt.Workflows[w.WorkflowID] = w
+	return nil
+}
+
+// GetWorkflow - StateManager
+func (iatt *ImplementsAllTheThings) GetWorkflow(workflowID string) (state.Workflow, error) {
+	iatt.Calls = append(iatt.Calls, "GetWorkflow")
+	w, ok := iatt.Workflows[workflowID]
+	if !ok {
+		return w, exceptions.MissingResource{ErrorString: fmt.Sprintf("No workflow %s", workflowID)}
+	}
+	return w, nil
+}
+
+// ListWorkflows - StateManager
+func (iatt *ImplementsAllTheThings) ListWorkflows(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.WorkflowList, error) {
+	iatt.Calls = append(iatt.Calls, "ListWorkflows")
+	wl := state.WorkflowList{Total: len(iatt.Workflows)}
+	for _, w := range iatt.Workflows {
+		wl.Workflows = append(wl.Workflows, w)
+	}
+	return wl, nil
+}
+
+// ClaimActiveWorkflows - StateManager
+func (iatt *ImplementsAllTheThings) ClaimActiveWorkflows(now time.Time, lease time.Duration, limit int) ([]state.Workflow, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimActiveWorkflows")
+	var active []state.Workflow
+	for _, w := range iatt.Workflows {
+		if w.Status == state.WorkflowStatusRunning {
+			nodes := make(state.WorkflowNodes, len(w.Nodes))
+			copy(nodes, w.Nodes)
+			w.Nodes = nodes
+			active = append(active, w)
+		}
+	}
+	return active, nil
+}
+
+// UpdateWorkflow - StateManager
+func (iatt *ImplementsAllTheThings) UpdateWorkflow(w state.Workflow) error {
+	iatt.Calls = append(iatt.Calls, "UpdateWorkflow")
+	existing, ok := iatt.Workflows[w.WorkflowID]
+	if !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No workflow %s", w.WorkflowID)}
+	}
+	existing.Status = w.Status
+	existing.Nodes = w.Nodes
+	existing.FinishedAt = w.FinishedAt
+	iatt.Workflows[w.WorkflowID] = existing
+	return nil
+}
+
+// RequestWorkflowCancel - StateManager
+func (iatt *ImplementsAllTheThings) RequestWorkflowCancel(workflowID string) (state.Workflow, error) {
+	iatt.Calls = append(iatt.Calls, "RequestWorkflowCancel")
+	w, ok := iatt.Workflows[workflowID]
+	if !ok {
+		return w, exceptions.MissingResource{ErrorString: fmt.Sprintf("No workflow %s", workflowID)}
+	}
+	if w.Status == state.WorkflowStatusRunning {
+		w.CancelRequested = true
+		iatt.Workflows[workflowID] = w
+	}
+	return w, nil
+}
+
+// PutQuota - StateManager
+func (iatt *ImplementsAllTheThings) PutQuota(q state.Quota) (state.Quota, error) {
+	iatt.Calls = append(iatt.Calls, "PutQuota")
+	if iatt.Quotas == nil {
+		iatt.Quotas = make(map[string]state.Quota)
+	}
+	iatt.Quotas[q.Key()] = q
+	return q, nil
+}
+
+// GetQuota - StateManager
+func (iatt *ImplementsAllTheThings) GetQuota(scope string, name string) (state.Quota, error) {
+	iatt.Calls = append(iatt.Calls, "GetQuota")
+	q, ok := iatt.Quotas[scope+"/"+name]
+	if !ok {
+		return q, exceptions.MissingResource{ErrorString: fmt.Sprintf("No quota %s/%s", scope, name)}
+	}
+	return q, nil
+}
+
+// ListQuotas - StateManager
+func (iatt *ImplementsAllTheThings) ListQuotas(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.QuotaList, error) {
+	iatt.Calls = append(iatt.Calls, "ListQuotas")
+	ql := state.QuotaList{Total: len(iatt.Quotas)}
+	for _, q := range iatt.Quotas {
+		ql.Quotas = append(ql.Quotas, q)
+	}
+	sort.Slice(ql.Quotas, func(i, j int) bool { return ql.Quotas[i].Key() < ql.Quotas[j].Key() })
+	return ql, nil
+}
+
+// DeleteQuota - StateManager
+func (iatt *ImplementsAllTheThings) DeleteQuota(scope string, name string) error {
+	iatt.Calls = append(iatt.Calls, "DeleteQuota")
+	if _, ok := iatt.Quotas[scope+"/"+name]; !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No quota %s/%s", scope, name)}
+	}
+	delete(iatt.Quotas, scope+"/"+name)
+	return nil
+}
+
+// GetQuotaUsage - StateManager
+func (iatt *ImplementsAllTheThings) GetQuotaUsage(q state.Quota) (state.QuotaUsage, error) {
+	iatt.Calls = append(iatt.Calls, "GetQuotaUsage")
+	var usage state.QuotaUsage
+	for _, r := range iatt.Runs {
+		if !q.Matches(r) {
+			continue
+		}
+		switch {
+		case r.Status == state.StatusPending || r.Status == state.StatusRunning:
+			usage.Add(r)
+		case r.Status == state.StatusQueued && r.QuotaHeldAt != nil:
+			usage.Held++
+		}
+	}
+	return usage, nil
+}
+
+// ListQuotaHeldRuns - StateManager
+func (iatt *ImplementsAllTheThings) ListQuotaHeldRuns(limit int) ([]state.Run, error) {
+	iatt.Calls = append(iatt.Calls, "ListQuotaHeldRuns")
+	var held []state.Run
+	for _, r := range iatt.Runs {
+		if r.Status == state.StatusQueued && r.QuotaHeldAt != nil {
+			held = append(held, r)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].RunID < held[j].RunID })
+	return held, nil
+}
+
+// HoldRunForQuota - StateManager
+func (iatt *ImplementsAllTheThings) HoldRunForQuota(runID string, heldAt time.Time) error {
+	iatt.Calls = append(iatt.Calls, "HoldRunForQuota")
+	if r, ok := iatt.Runs[runID]; ok && r.Status == state.StatusQueued {
+		r.QuotaHeldAt = &heldAt
+		iatt.Runs[runID] = r
+	}
+	return nil
+}
+
+// ReleaseQuotaHold - StateManager
+func (iatt *ImplementsAllTheThings) ReleaseQuotaHold(runID string) (bool, error) {
+	iatt.Calls = append(iatt.Calls, "ReleaseQuotaHold")
+	r, ok := iatt.Runs[runID]
+	if !ok || r.QuotaHeldAt == nil {
+		return false, nil
+	}
+	r.QuotaHeldAt = nil
+	iatt.Runs[runID] = r
+	return true, nil
+}
+
+// CreateWebhook - StateManager
+func (iatt *ImplementsAllTheThings) CreateWebhook(w state.Webhook) (state.Webhook, error) {
+	iatt.Calls = append(iatt.Calls, "CreateWebhook")
+	if iatt.Webhooks == nil {
+		iatt.Webhooks = make(map[string]state.Webhook)
+	}
+	now := time.Now()
+	w.CreatedAt = &now
+	iatt.Webhooks[w.WebhookID] = w
+	return w, nil
+}
+
+// GetWebhook - StateManager
+func (iatt *ImplementsAllTheThings) GetWebhook(webhookID string) (state.Webhook, error) {
+	iatt.Calls = append(iatt.Calls, "GetWebhook")
+	w, ok := iatt.Webhooks[webhookID]
+	if !ok {
+		return w, exceptions.MissingResource{ErrorString: fmt.Sprintf("No webhook %s", webhookID)}
+	}
+	return w, nil
+}
+
+// ListWebhooks - StateManager
+func (iatt *ImplementsAllTheThings) ListWebhooks(limit int, offset int, sortBy string, order string, filters map[string][]string) (state.WebhookList, error) {
+	iatt.Calls = append(iatt.Calls, "ListWebhooks")
+	wl := state.WebhookList{Total: len(iatt.Webhooks)}
+	for _, w := range iatt.Webhooks {
+		wl.Webhooks = append(wl.Webhooks, w)
+	}
+	sort.Slice(wl.Webhooks, func(i, j int) bool { return wl.Webhooks[i].WebhookID < wl.Webhooks[j].WebhookID })
+	return wl, nil
+}
+
+// DeleteWebhook - StateManager
+func (iatt *ImplementsAllTheThings) DeleteWebhook(webhookID string) error {
+	iatt.Calls = append(iatt.Calls, "DeleteWebhook")
+	if _, ok := iatt.Webhooks[webhookID]; !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No webhook %s", webhookID)}
+	}
+	delete(iatt.Webhooks, webhookID)
+	return nil
+}
+
+// ListRunWebhooks - StateManager
+func (iatt *ImplementsAllTheThings) ListRunWebhooks(run state.Run) ([]state.Webhook, error) {
+	iatt.Calls = append(iatt.Calls, "ListRunWebhooks")
+	var hooks []state.Webhook
+	for _, w := range iatt.Webhooks {
+		if w.Matches(run) {
+			hooks = append(hooks, w)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].WebhookID < hooks[j].WebhookID })
+	return hooks, nil
+}
+
+// CreateWebhookDelivery - StateManager
+func (iatt *ImplementsAllTheThings) CreateWebhookDelivery(d state.WebhookDelivery) (bool, error) {
+	iatt.Calls = append(iatt.Calls, "CreateWebhookDelivery")
+	if iatt.WebhookDeliveries == nil {
+		iatt.WebhookDeliveries = make(map[string]state.WebhookDelivery)
+	}
+	if d.Event != state.WebhookEventTest {
+		for _, existing := range iatt.WebhookDeliveries {
+			if existing.WebhookID == d.WebhookID && existing.RunID == d.RunID && existing.Event == d.Event &&
+				existing.Sequence == d.Sequence {
+				return false, nil
+			}
+		}
+	}
+	iatt.WebhookDeliveries[d.DeliveryID] = d
+	return true, nil
+}
+
+// ClaimWebhookDeliveries - StateManager
+func (iatt *ImplementsAllTheThings) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]state.WebhookDelivery, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimWebhookDeliveries")
+	var due []state.WebhookDelivery
+	for _, d := range iatt.WebhookDeliveries {
+		if d.Status == state.WebhookDeliveryPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].DeliveryID < due[j].DeliveryID })
+	return due, nil
+}
+
+// UpdateWebhookDelivery - StateManager
+func (iatt *ImplementsAllTheThings) UpdateWebhookDelivery(d state.WebhookDelivery) error {
+	iatt.Calls = append(iatt.Calls, "UpdateWebhookDelivery")
+	if _, ok := iatt.WebhookDeliveries[d.DeliveryID]; !ok {
+		return exceptions.MissingResource{ErrorString: fmt.Sprintf("No webhook delivery %s", d.DeliveryID)}
+	}
+	iatt.WebhookDeliveries[d.DeliveryID] = d
+	return nil
+}
+
+// ListWebhookDeliveries - StateManager
+func (iatt *ImplementsAllTheThings) ListWebhookDeliveries(webhookID string, limit int, offset int) (state.WebhookDeliveryList, error) {
+	iatt.Calls = append(iatt.Calls, "ListWebhookDeliveries")
+	var dl state.WebhookDeliveryList
+	for _, d := range iatt.WebhookDeliveries {
+		if d.WebhookID == webhookID {
+			dl.Deliveries = append(dl.Deliveries, d)
+		}
+	}
+	sort.Slice(dl.Deliveries, func(i, j int) bool { return dl.Deliveries[i].DeliveryID < dl.Deliveries[j].DeliveryID })
+	dl.Total = len(dl.Deliveries)
+	return dl, nil
+}
+
+// ListExitReasonRules - StateManager
+func (iatt *ImplementsAllTheThings) ListExitReasonRules() (state.ExitReasonRules, error) {
+	iatt.Calls = append(iatt.Calls, "ListExitReasonRules")
+	return iatt.ExitReasonRules, nil
+}
+
+// PutExitReasonRules - StateManager
+func (iatt *ImplementsAllTheThings) PutExitReasonRules(rules state.ExitReasonRules) error {
+	iatt.Calls = append(iatt.Calls, "PutExitReasonRules")
+	iatt.ExitReasonRules = rules
+	return nil
+}
+
+// CountRunsByExitCategory - StateManager; only filters on definition_id
+func (iatt *ImplementsAllTheThings) CountRunsByExitCategory(filters map[string][]string) ([]state.ExitCategoryCount, error) {
+	iatt.Calls = append(iatt.Calls, "CountRunsByExitCategory")
+	byCategory := make(map[string]int)
+	for _, r := range iatt.Runs {
+		if r.ExitCategory == nil {
+			continue
+		}
+		if ids, ok := filters["definition_id"]; ok && len(ids) > 0 && ids[0] != r.DefinitionID {
+			continue
+		}
+		byCategory[*r.ExitCategory]++
+	}
+	counts := []state.ExitCategoryCount{}
+	for category, count := range byCategory {
+		counts = append(counts, state.ExitCategoryCount{Category: category, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Category < counts[j].Category })
+	return counts, nil
+}
+
+// ListRunTransitions - StateManager
+func (iatt *ImplementsAllTheThings) ListRunTransitions(runID string) (state.RunStatusTransitionList, error) {
+	iatt.Calls = append(iatt.Calls, "ListRunTransitions")
+	if _, ok := iatt.Runs[runID]; !ok {
+		return state.RunStatusTransitionList{}, fmt.Errorf("No run %s", runID)
+	}
+	transitions := iatt.Transitions[runID]
+	return state.RunStatusTransitionList{Total: len(transitions), Transitions: transitions}, nil
+}
+
 // ListGroups - StateManager
 func (iatt *ImplementsAllTheThings) ListGroups(limit int, offset int, name *string) (state.GroupsList, error) {
 	iatt.Calls = append(iatt.Calls, "ListGroups")
@@ -260,7 +954,7 @@ func (iatt *ImplementsAllTheThings) ReceiveRun(qURL string) (queue.RunReceipt, e
 	popped := iatt.Queued[0]
 	iatt.Queued = iatt.Queued[1:]
 	receipt := queue.RunReceipt{
-		Run: &state.Run{RunID: popped},
+		Run: &state.Run{RunID: popped, Priority: iatt.Runs[popped].Priority},
 	}
 	receipt.Done = func() error {
 		iatt.Calls = append(iatt.Calls, "RunReceipt.Done")
@@ -362,7 +1056,7 @@ func (iatt *ImplementsAllTheThings) PollRuns() ([]engine.RunReceipt, error) {
 	popped := iatt.Queued[0]
 	iatt.Queued = iatt.Queued[1:]
 	receipt := queue.RunReceipt{
-		Run: &state.Run{RunID: popped},
+		Run: &state.Run{RunID: popped, Priority: iatt.Runs[popped].Priority},
 	}
 	receipt.Done = func() error {
 		iatt.Calls = append(iatt.Calls, "RunReceipt.Done")
@@ -421,7 +1115,20 @@ func (iatt *ImplementsAllTheThings) Deregister(definition state.Definition) erro
 // Logs - Logs Client
 func (iatt *ImplementsAllTheThings) Logs(executable state.Executable, run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
 	iatt.Calls = append(iatt.Calls, "Logs")
-	return "", aws.String(""), nil
+	if iatt.LogsError != nil {
+		return "", nil, iatt.LogsError
+	}
+	if iatt.LogLines == nil {
+		return "", aws.String(""), nil
+	}
+	start := 0
+	if lastSeen != nil && len(*lastSeen) > 0 {
+		start, _ = strconv.Atoi(*lastSeen)
+	}
+	if start > len(iatt.LogLines) {
+		start = len(iatt.LogLines)
+	}
+	return strings.Join(iatt.LogLines[start:], ""), aws.String(strconv.Itoa(len(iatt.LogLines))), nil
 }
 
 // GetExecutableByTypeAndID - StateManager
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/stitchfix/flotilla-os/queue"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/engine"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"gopkg.in/tomb.v2"
+)
+
+// defaultArrayInterval is used when worker.array_interval is unset
+var defaultArrayInterval = 10 * time.Second
+
+// arrayListLimit bounds the array runs advanced per poll
+const arrayListLimit = 100
+
+type arrayWorker struct {
+	sm           state.Manager
+	es           services.ExecutionService
+	conf         config.Config
+	log          flotillaLog.Logger
+	pollInterval time.Duration
+	t            tomb.Tomb
+}
+
+func (aw *arrayWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
+	aw.pollInterval = pollInterval
+	if aw.pollInterval <= 0 {
+		aw.pollInterval = defaultArrayInterval
+	}
+	aw.conf = conf
+	aw.sm = sm
+	aw.es = es
+	aw.log = log
+	aw.log.Log("message", "initialized an array worker")
+	return nil
+}
+
+func (aw *arrayWorker) GetTomb() *tomb.Tomb {
+	return &aw.t
+}
+
+//
+// Run advances unfinished array runs
+//
+func (aw *arrayWorker) Run() error {
+	for {
+		select {
+		case <-aw.t.Dying():
+			aw.log.Log("message", "An array worker was terminated")
+			return nil
+		default:
+			aw.runOnce()
+			time.Sleep(aw.pollInterval)
+		}
+	}
+}
+
+//
+// runOnce lists the parents of unfinished arrays and advances each: held
+// children are queued as max parallelism allows and the parent's status is
+// set from its children. Releasing children locks the parent, so replicas
+// advancing the same array never exceed its max parallelism.
+//
+func (aw *arrayWorker) runOnce() {
+	parents, err := aw.sm.ListRuns(arrayListLimit, 0, "started_at", "asc", map[string][]string{
+		"task_type": {state.ArrayTaskType},
+		"status":    {state.StatusQueued, state.StatusRunning},
+	}, nil, nil)
+	if err != nil {
+		aw.log.Log("message", "Error listing array runs", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+
+	for _, parent := range parents.Runs {
+		if _, err = aw.es.AdvanceArrayRun(parent); err != nil {
+			aw.log.Log("message", "Error advancing array run", "run_id", parent.RunID, "error", fmt.Sprintf("%+v", err))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/config"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+	"os"
+	"testing"
+	"time"
+)
+
+func setUpArrayWorkerTest(t *testing.T) (*arrayWorker, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	logger := flotillaLog.NewLogger(l, nil)
+
+	engine := state.DefaultEngine
+	size, maxParallelism := int64(2), int64(1)
+	parentID := "run-array"
+	now := time.Now()
+	first, second := int64(0), int64(1)
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			parentID: {
+				RunID: parentID, Status: state.StatusQueued, Engine: &engine, QueuedAt: &now,
+				TaskType: state.ArrayTaskType, ArraySize: &size, MaxParallelism: &maxParallelism,
+			},
+			"run-array-0": {
+				RunID: "run-array-0", Status: state.StatusRunning, Engine: &engine, QueuedAt: &now,
+				TaskType: state.DefaultTaskType, ArrayParentID: &parentID, ArrayIndex: &first,
+			},
+			"run-array-1": {
+				RunID: "run-array-1", Status: state.StatusQueued, Engine: &engine,
+				TaskType: state.DefaultTaskType, ArrayParentID: &parentID, ArrayIndex: &second,
+			},
+		},
+		Qurls: map[string]string{
+			"A": "a/",
+		},
+	}
+	es, _ := services.NewExecutionService(c, &imp, &imp, &imp, &imp, &imp)
+	return &arrayWorker{
+		sm:  &imp,
+		es:  es,
+		log: logger,
+	}, &imp
+}
+
+func TestArrayWorker_RunOnce(t *testing.T) {
+	aw, imp := setUpArrayWorkerTest(t)
+
+	aw.runOnce()
+	if len(imp.Queued) != 0 {
+		t.Errorf("Expected no child to be queued at max parallelism, got %v", imp.Queued)
+	}
+	if parent := imp.Runs["run-array"]; parent.Status != state.StatusRunning {
+		t.Errorf("Expected the parent to be running, was %s", parent.Status)
+	}
+
+	code := int64(0)
+	imp.UpdateRun("run-array-0", state.Run{Status: state.StatusStopped, ExitCode: &code}, state.TransitionSourceStatusWorker)
+	aw.runOnce()
+	if len(imp.Queued) != 1 || imp.Queued[0] != "run-array-1" {
+		t.Errorf("Expected the held child to be queued, got %v", imp.Queued)
+	}
+
+	imp.UpdateRun("run-array-1", state.Run{Status: state.StatusStopped, ExitCode: &code}, state.TransitionSourceStatusWorker)
+	aw.runOnce()
+	parent := imp.Runs["run-array"]
+	if parent.Status != state.StatusStopped || parent.ExitCode == nil || *parent.ExitCode != 0 {
+		t.Errorf("Expected the parent to stop successfully, was %s", parent.Status)
+	}
+}
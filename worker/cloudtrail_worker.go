@@ -10,6 +10,7 @@ import (
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
 	"strings"
@@ -28,7 +29,7 @@ type cloudtrailWorker struct {
 	s3Client     *s3.S3
 }
 
-func (ctw *cloudtrailWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
+func (ctw *cloudtrailWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
 	ctw.pollInterval = pollInterval
 	ctw.conf = conf
 	ctw.sm = sm
@@ -122,7 +123,7 @@ func (ctw *cloudtrailWorker) processCloudTrailNotifications(ctn state.CloudTrail
 				rawRecords = append((*run.CloudTrailNotifications).Records, records...)
 			}
 			run.CloudTrailNotifications = &state.CloudTrailNotifications{Records: ctw.makeSet(rawRecords)}
-			_, err = ctw.sm.UpdateRun(runId, run)
+			_, err = ctw.sm.UpdateRun(runId, run, state.TransitionSourceCloudtrailWorker)
 			if err != nil {
 				_ = ctw.log.Log("message", "Error updating run", "error", fmt.Sprintf("%+v", err))
 			}
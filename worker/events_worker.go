@@ -5,10 +5,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,9 +38,11 @@ type eventsWorker struct {
 	emrMaxPodEvents   int
 	eksEngine         engine.Engine
 	emrEngine         engine.Engine
+	webhooks          services.WebhookService
+	broker            stream.Broker
 }
 
-func (ew *eventsWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
+func (ew *eventsWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
 	ew.pollInterval = pollInterval
 	ew.conf = conf
 	ew.sm = sm
@@ -46,6 +50,14 @@ func (ew *eventsWorker) Initialize(conf config.Config, sm state.Manager, eksEngi
 	ew.log = log
 	ew.eksEngine = eksEngine
 	ew.emrEngine = emrEngine
+	webhooks, err := services.NewWebhookService(conf, sm, log)
+	if err != nil {
+		return err
+	}
+	ew.webhooks = webhooks
+	if ew.broker, err = stream.NewBroker(conf); err != nil {
+		return err
+	}
 	eventsQueue, err := ew.qm.QurlFor(conf.GetString("eks.events_queue"), false)
 	emrJobStatusQueue, err := ew.qm.QurlFor(conf.GetString("emr.job_status_queue"), false)
 	ew.emrHistoryServer = conf.GetString("emr.history_server_uri")
@@ -117,6 +129,8 @@ func (ew *eventsWorker) processEventEMR(emrEvent state.EmrEvent) {
 	emrJobId := emrEvent.Detail.ID
 	run, err := ew.sm.GetRunByEMRJobId(*emrJobId)
 	if err == nil {
+		previousStatus := run.Status
+		before := run
 		layout := "2020-08-31T17:27:50Z"
 		timestamp, err := time.Parse(layout, *emrEvent.Time)
 		if err != nil {
@@ -147,8 +161,14 @@ func (ew *eventsWorker) processEventEMR(emrEvent state.EmrEvent) {
 		}
 
 		ew.setEMRMetricsUri(&run)
-		_, err = ew.sm.UpdateRun(run.RunID, run)
+		saved, err := ew.sm.UpdateRun(run.RunID, run, state.TransitionSourceEventsWorker)
 		if err == nil {
+			notifyWebhooks(ew.webhooks, ew.log, saved, previousStatus)
+			publishRunEvents(ew.broker, ew.log, before, saved)
+			_ = emrEvent.Done()
+		} else if state.IsIllegalTransition(err) {
+			// Redelivering the event would be rejected again
+			_ = ew.log.Log("message", "rejected illegal status transition", "run", run.RunID, "error", err.Error())
 			_ = emrEvent.Done()
 		}
 	}
@@ -183,6 +203,7 @@ func (ew *eventsWorker) processEMRPodEvents(kubernetesEvent state.KubernetesEven
 		if emrJobId != nil {
 			run, err := ew.sm.GetRunByEMRJobId(*emrJobId)
 			if err == nil {
+				before := run
 				layout := "2020-08-31T17:27:50Z"
 				timestamp, err := time.Parse(layout, kubernetesEvent.FirstTimestamp)
 				if err != nil {
@@ -213,9 +234,11 @@ func (ew *eventsWorker) processEMRPodEvents(kubernetesEvent state.KubernetesEven
 				}
 				ew.setEMRMetricsUri(&run)
 
-				run, err = ew.sm.UpdateRun(run.RunID, run)
+				run, err = ew.sm.UpdateRun(run.RunID, run, state.TransitionSourceEventsWorker)
 				if err != nil {
 					_ = ew.log.Log("message", "error saving kubernetes events", "emrJobId", emrJobId, "error", fmt.Sprintf("%+v", err))
+				} else {
+					publishRunEvents(ew.broker, ew.log, before, run)
 				}
 
 				if run.PodEvents != nil && len(*run.PodEvents) >= ew.emrMaxPodEvents {
@@ -293,6 +316,8 @@ func (ew *eventsWorker) processEvent(kubernetesEvent state.KubernetesEvent) {
 
 	run, err := ew.sm.GetRun(runId)
 	if err == nil {
+		previousStatus := run.Status
+		before := run
 		event := state.PodEvent{
 			Timestamp:    &timestamp,
 			EventType:    kubernetesEvent.Type,
@@ -333,10 +358,16 @@ func (ew *eventsWorker) processEvent(kubernetesEvent state.KubernetesEvent) {
 			run.FinishedAt = &timestamp
 		}
 		ew.setEKSMetricsUri(&run)
-		run, err = ew.sm.UpdateRun(runId, run)
-		if err != nil {
+		run, err = ew.sm.UpdateRun(runId, run, state.TransitionSourceEventsWorker)
+		if err != nil && state.IsIllegalTransition(err) {
+			// Redelivering the event would be rejected again
+			_ = ew.log.Log("message", "rejected illegal status transition", "run", runId, "error", err.Error())
+			_ = kubernetesEvent.Done()
+		} else if err != nil {
 			_ = ew.log.Log("message", "error saving kubernetes events", "run", runId, "error", fmt.Sprintf("%+v", err))
 		} else {
+			notifyWebhooks(ew.webhooks, ew.log, run, previousStatus)
+			publishRunEvents(ew.broker, ew.log, before, run)
 			_ = kubernetesEvent.Done()
 		}
 	}
@@ -8,24 +8,31 @@ import (
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
 )
 
+// retryRescheduleDelay is how long a retry whose attempt couldn't be queued
+// waits before it's tried again
+var retryRescheduleDelay = time.Minute
+
 type retryWorker struct {
 	sm           state.Manager
 	ee           engine.Engine
+	es           services.ExecutionService
 	conf         config.Config
 	log          flotillaLog.Logger
 	pollInterval time.Duration
 	t            tomb.Tomb
 }
 
-func (rw *retryWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
+func (rw *retryWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
 	rw.pollInterval = pollInterval
 	rw.conf = conf
 	rw.sm = sm
 	rw.ee = eksEngine
+	rw.es = es
 	rw.log = log
 	rw.log.Log("message", "initialized a retry worker")
 	return nil
@@ -36,7 +43,8 @@ func (rw *retryWorker) GetTomb() *tomb.Tomb {
 }
 
 //
-// Run finds tasks that NEED_RETRY and requeues them
+// Run finds tasks that NEED_RETRY and requeues them, and queues new attempts
+// of stopped runs whose retry policy is due
 //
 func (rw *retryWorker) Run() error {
 	for {
@@ -52,6 +60,11 @@ func (rw *retryWorker) Run() error {
 }
 
 func (rw *retryWorker) runOnce() {
+	rw.requeueRuns()
+	rw.retryStoppedRuns()
+}
+
+func (rw *retryWorker) requeueRuns() {
 	// List runs in the StatusNeedsRetry state and requeue them
 	runList, err := rw.sm.ListRuns(25, 0, "started_at", "asc", map[string][]string{"status": {state.StatusNeedsRetry}}, nil,  []string{state.EKSEngine})
 
@@ -66,8 +79,12 @@ func (rw *retryWorker) runOnce() {
 
 	for _, run := range runList.Runs {
 
-		if _, err = rw.sm.UpdateRun(run.RunID, state.Run{Status: state.StatusQueued}); err != nil {
+		if _, err = rw.sm.UpdateRun(run.RunID, state.Run{Status: state.StatusQueued}, state.TransitionSourceRetryWorker); err != nil {
 			rw.log.Log("message", "Error updating run status to StatusQueued", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+			if state.IsIllegalTransition(err) {
+				// The run moved on (eg. it was stopped) since it was listed
+				continue
+			}
 			return
 		}
 
@@ -78,3 +95,30 @@ func (rw *retryWorker) runOnce() {
 	}
 	return
 }
+
+//
+// retryStoppedRuns claims stopped runs whose retry policy scheduled a new
+// attempt that is now due, and queues that attempt
+//
+func (rw *retryWorker) retryStoppedRuns() {
+	due, err := rw.sm.ClaimDueRetries(time.Now(), 25)
+	if err != nil {
+		rw.log.Log("message", "Error claiming due retries", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+
+	for _, run := range due {
+		attempt, err := rw.es.RetryRun(run)
+		if err != nil {
+			rw.log.Log("message", "Error retrying run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+			// Try again later rather than losing the retry
+			if err = rw.sm.RescheduleRetry(run.RunID, time.Now().Add(retryRescheduleDelay)); err != nil {
+				rw.log.Log("message", "Error rescheduling retry of run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+			}
+			continue
+		}
+		if err = rw.sm.RecordRetryRun(run.RunID, attempt.RunID); err != nil {
+			rw.log.Log("message", "Error recording retry of run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		}
+	}
+}
@@ -2,11 +2,14 @@ package worker
 
 import (
 	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/config"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/testutils"
 	"os"
 	"testing"
+	"time"
 )
 
 func setUpRetryWorkerTest(t *testing.T) (*retryWorker, *testutils.ImplementsAllTheThings) {
@@ -45,7 +48,7 @@ func TestRetryWorker_Run(t *testing.T) {
 	// Make sure that the worker resets the status to StatusQueued, and calls the appropriate methods
 	// in order (get runs to retry, get qurls for them, update them to queued status, then enqueue them)
 	//
-	expected := []string{"ListRuns", "UpdateRun", "Enqueue"}
+	expected := []string{"ListRuns", "UpdateRun", "Enqueue", "ClaimDueRetries"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -62,3 +65,66 @@ func TestRetryWorker_Run(t *testing.T) {
 		t.Errorf("Expected retry worker to update run status to Queued")
 	}
 }
+
+func TestRetryWorker_RetryStoppedRuns(t *testing.T) {
+	worker, imp := setUpRetryWorkerTest(t)
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	worker.es, _ = services.NewExecutionService(c, imp, imp, imp, imp, imp)
+
+	engine := state.DefaultEngine
+	code := int64(137)
+	definition := state.ExecutableTypeDefinition
+	definitionID := "A"
+	retryAt := time.Now().Add(-time.Second)
+	imp.Runs["runA"] = state.Run{
+		DefinitionID: "A", ClusterName: "A", GroupName: "A", RunID: "runA",
+		Status: state.StatusStopped, ExitCode: &code, Engine: &engine,
+		ExecutableType: &definition, ExecutableID: &definitionID,
+		RetryPolicy: &state.RetryPolicy{MaxAttempts: 2, ExitCodes: []int64{137}},
+		RetryAt:     &retryAt, RetryState: state.RetryStateScheduled,
+	}
+	worker.runOnce()
+
+	run := imp.Runs["runA"]
+	if run.RetryState != state.RetryStateRetried || run.RetryRunID == nil {
+		t.Fatalf("Expected runA to be retried, got state [%s]", run.RetryState)
+	}
+	retry, ok := imp.Runs[*run.RetryRunID]
+	if !ok {
+		t.Fatalf("Expected retry run %s to be created", *run.RetryRunID)
+	}
+	if retry.Status != state.StatusQueued || retry.RetryAttempt != 1 || *retry.OriginalRunID != "runA" {
+		t.Errorf("Expected a queued first retry of runA, got %v", retry)
+	}
+}
+
+func TestRetryWorker_RetryStoppedRunsFailure(t *testing.T) {
+	worker, imp := setUpRetryWorkerTest(t)
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	worker.es, _ = services.NewExecutionService(c, imp, imp, imp, imp, imp)
+
+	// The run's definition is gone, so its retry can't be queued
+	engine := state.DefaultEngine
+	code := int64(137)
+	definition := state.ExecutableTypeDefinition
+	definitionID := "missing"
+	retryAt := time.Now().Add(-time.Second)
+	imp.Runs["runA"] = state.Run{
+		DefinitionID: "missing", ClusterName: "A", GroupName: "A", RunID: "runA",
+		Status: state.StatusStopped, ExitCode: &code, Engine: &engine,
+		ExecutableType: &definition, ExecutableID: &definitionID,
+		RetryPolicy: &state.RetryPolicy{MaxAttempts: 2, ExitCodes: []int64{137}},
+		RetryAt:     &retryAt, RetryState: state.RetryStateScheduled,
+	}
+	worker.runOnce()
+
+	run := imp.Runs["runA"]
+	if run.RetryState != state.RetryStateScheduled || run.RetryRunID != nil {
+		t.Fatalf("Expected the retry of runA to be scheduled again, got state [%s]", run.RetryState)
+	}
+	if !run.RetryAt.After(time.Now()) {
+		t.Errorf("Expected the retry to be rescheduled later, got %v", run.RetryAt)
+	}
+}
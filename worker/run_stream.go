@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/stitchfix/flotilla-os/clients/stream"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// publishRunEvents publishes the stream events of a persisted update of a run
+// from before to after; failures are logged and don't hold up the update
+//
+func publishRunEvents(b stream.Broker, log flotillaLog.Logger, before state.Run, after state.Run) {
+	if b == nil {
+		return
+	}
+	for _, e := range stream.RunEvents(before, after) {
+		if err := b.Publish(e); err != nil {
+			_ = log.Log("message", "unable to publish run stream event", "run_id", after.RunID, "error", fmt.Sprintf("%+v", err))
+			return
+		}
+	}
+}
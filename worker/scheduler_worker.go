@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/stitchfix/flotilla-os/queue"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/engine"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"gopkg.in/tomb.v2"
+)
+
+// defaultSchedulerInterval is used when worker.scheduler_interval is unset
+var defaultSchedulerInterval = 15 * time.Second
+
+// scheduleClaimLimit bounds the schedules fired per poll
+const scheduleClaimLimit = 25
+
+// schedulerUser is recorded as the actor terminating runs replaced by a
+// schedule
+var schedulerUser = state.UserInfo{Name: "scheduler"}
+
+type schedulerWorker struct {
+	sm           state.Manager
+	es           services.ExecutionService
+	conf         config.Config
+	log          flotillaLog.Logger
+	pollInterval time.Duration
+	t            tomb.Tomb
+}
+
+func (sw *schedulerWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
+	sw.pollInterval = pollInterval
+	if sw.pollInterval <= 0 {
+		sw.pollInterval = defaultSchedulerInterval
+	}
+	sw.conf = conf
+	sw.sm = sm
+	sw.es = es
+	sw.log = log
+	sw.log.Log("message", "initialized a scheduler worker")
+	return nil
+}
+
+func (sw *schedulerWorker) GetTomb() *tomb.Tomb {
+	return &sw.t
+}
+
+//
+// Run fires due schedules
+//
+func (sw *schedulerWorker) Run() error {
+	for {
+		select {
+		case <-sw.t.Dying():
+			sw.log.Log("message", "A scheduler worker was terminated")
+			return nil
+		default:
+			sw.runOnce()
+			time.Sleep(sw.pollInterval)
+		}
+	}
+}
+
+//
+// runOnce claims due schedules and creates a run for each. Claiming advances
+// a schedule to its next fire time, so each fire time is handled by exactly
+// one scheduler across replicas even if creating the run fails.
+//
+func (sw *schedulerWorker) runOnce() {
+	due, err := sw.sm.ClaimDueSchedules(time.Now(), scheduleClaimLimit)
+	if err != nil {
+		sw.log.Log("message", "Error claiming due schedules", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+
+	for _, s := range due {
+		if !sw.resolveOverlap(s) {
+			continue
+		}
+
+		run, err := sw.es.CreateScheduledRun(s)
+		if err != nil {
+			sw.log.Log("message", "Error creating scheduled run", "schedule_id", s.ScheduleID, "error", fmt.Sprintf("%+v", err))
+			continue
+		}
+		if err = sw.sm.RecordScheduleRun(s.ScheduleID, run.RunID); err != nil {
+			sw.log.Log("message", "Error recording scheduled run", "schedule_id", s.ScheduleID, "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		}
+	}
+}
+
+//
+// resolveOverlap applies the schedule's overlap policy when the run it last
+// created is still active and returns whether the schedule should fire
+//
+func (sw *schedulerWorker) resolveOverlap(s state.Schedule) bool {
+	if s.OverlapPolicy == state.ScheduleOverlapAllow || s.LastRunID == nil {
+		return true
+	}
+
+	last, err := sw.sm.GetRun(*s.LastRunID)
+	if err != nil || last.Status == state.StatusStopped {
+		// A missing last run can't overlap
+		return true
+	}
+
+	switch s.OverlapPolicy {
+	case state.ScheduleOverlapSkip:
+		sw.log.Log("message", "Skipping scheduled run, last run is still active", "schedule_id", s.ScheduleID, "run_id", last.RunID)
+		return false
+	case state.ScheduleOverlapReplace:
+		if err = sw.es.Terminate(last.RunID, schedulerUser); err != nil {
+			sw.log.Log("message", "Error terminating replaced run", "schedule_id", s.ScheduleID, "run_id", last.RunID, "error", fmt.Sprintf("%+v", err))
+			return false
+		}
+	}
+	return true
+}
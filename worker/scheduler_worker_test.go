@@ -0,0 +1,88 @@
+package worker
+
+import (
+	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/config"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+	"os"
+	"testing"
+	"time"
+)
+
+func setUpSchedulerWorkerTest(t *testing.T, policy string) (*schedulerWorker, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	logger := flotillaLog.NewLogger(l, nil)
+
+	lastRunID := "runA"
+	due := time.Now().Add(-time.Minute)
+	engine := state.DefaultEngine
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Definitions: map[string]state.Definition{
+			"A": {DefinitionID: "A"},
+		},
+		Runs: map[string]state.Run{
+			"runA": {DefinitionID: "A", RunID: "runA", Status: state.StatusRunning},
+		},
+		Schedules: map[string]state.Schedule{
+			"sch-a": {
+				ScheduleID:     "sch-a",
+				CronExpression: "* * * * *",
+				TimeZone:       "UTC",
+				ExecutableType: state.ExecutableTypeDefinition,
+				ExecutableID:   "A",
+				DefinitionRequest: &state.DefinitionExecutionRequest{
+					ExecutionRequestCommon: &state.ExecutionRequestCommon{OwnerID: "somebody", Engine: &engine},
+				},
+				OverlapPolicy: policy,
+				NextRunAt:     &due,
+				LastRunID:     &lastRunID,
+			},
+		},
+		Qurls: map[string]string{
+			"A": "a/",
+		},
+	}
+	es, _ := services.NewExecutionService(c, &imp, &imp, &imp, &imp, &imp)
+	return &schedulerWorker{
+		sm:  &imp,
+		es:  es,
+		log: logger,
+	}, &imp
+}
+
+func TestSchedulerWorker_RunOnce(t *testing.T) {
+	sw, imp := setUpSchedulerWorkerTest(t, state.ScheduleOverlapAllow)
+	sw.runOnce()
+
+	s := imp.Schedules["sch-a"]
+	if s.LastRunID == nil || *s.LastRunID == "runA" {
+		t.Fatalf("Expected a new run to be recorded on the schedule")
+	}
+	run, ok := imp.Runs[*s.LastRunID]
+	if !ok || run.ScheduleID == nil || *run.ScheduleID != "sch-a" {
+		t.Errorf("Expected the scheduled run to be linked to sch-a, got %v", run)
+	}
+	if !s.NextRunAt.After(time.Now()) {
+		t.Errorf("Expected the schedule to advance past now, was %v", s.NextRunAt)
+	}
+}
+
+func TestSchedulerWorker_RunOnceSkipOverlap(t *testing.T) {
+	sw, imp := setUpSchedulerWorkerTest(t, state.ScheduleOverlapSkip)
+	sw.runOnce()
+
+	for _, call := range imp.Calls {
+		if call == "CreateRun" {
+			t.Errorf("Expected no run to be created while the last run is active")
+		}
+	}
+	if s := imp.Schedules["sch-a"]; !s.NextRunAt.After(time.Now()) {
+		t.Errorf("Expected the skipped fire time to be consumed, next run at %v", s.NextRunAt)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/logs"
 	"github.com/stitchfix/flotilla-os/clients/metrics"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
 	"io/ioutil"
@@ -31,9 +34,13 @@ type statusWorker struct {
 	workerId                 string
 	exceptionExtractorClient *http.Client
 	exceptionExtractorUrl    string
+	logs                     services.LogService
+	exitReasons              services.ExitReasonService
+	webhooks                 services.WebhookService
+	broker                   stream.Broker
 }
 
-func (sw *statusWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
+func (sw *statusWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
 	sw.pollInterval = pollInterval
 	sw.conf = conf
 	sw.sm = sm
@@ -47,6 +54,25 @@ func (sw *statusWorker) Initialize(conf config.Config, sm state.Manager, eksEngi
 		}
 		sw.exceptionExtractorUrl = sw.conf.GetString("eks.exception_extractor_url")
 	}
+	// Without an extractor service exceptions are read from the logs, which
+	// exit reason rules may also match
+	lc, err := logs.NewLogsClient(conf, log, state.EKSEngine)
+	if err != nil {
+		_ = sw.log.Log("message", "unable to initialize logs client, exceptions and log tails won't be read", "error", fmt.Sprintf("%+v", err))
+	} else if sw.logs, err = services.NewLogService(conf, sm, lc); err != nil {
+		return err
+	}
+	if sw.exitReasons, err = services.NewExitReasonService(conf, sm, sw.logs, log); err != nil {
+		return err
+	}
+	webhooks, err := services.NewWebhookService(conf, sm, log)
+	if err != nil {
+		return err
+	}
+	sw.webhooks = webhooks
+	if sw.broker, err = stream.NewBroker(conf); err != nil {
+		return err
+	}
 	sw.setupRedisClient(conf)
 	_ = sw.log.Log("message", "initialized a status worker")
 	return nil
@@ -137,6 +163,10 @@ func (sw *statusWorker) processEKSRun(run state.Run) {
 		// Run was updated by another worker process.
 		return
 	}
+	if err == nil && reloadRun.QuotaHeldAt != nil {
+		// Run is held back by quotas and hasn't been submitted to the cluster.
+		return
+	}
 	start := time.Now()
 	updatedRunWithMetrics, _ := sw.ee.FetchPodMetrics(run)
 	_ = metrics.Timing(metrics.StatusWorkerFetchPodMetrics, time.Since(start), []string{sw.workerId}, 1)
@@ -167,7 +197,13 @@ func (sw *statusWorker) processEKSRun(run state.Run) {
 			updatedRun.Status = state.StatusStopped
 			updatedRun.FinishedAt = &stoppedAt
 			updatedRun.ExitReason = &reason
-			_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun)
+			saved, err := sw.sm.UpdateRun(updatedRun.RunID, updatedRun, state.TransitionSourceStatusWorker)
+			if err != nil {
+				_ = sw.log.Log("message", "unable to stop eks run", "run_id", updatedRun.RunID, "error", fmt.Sprintf("%+v", err))
+			} else {
+				notifyWebhooks(sw.webhooks, sw.log, saved, run.Status)
+				publishRunEvents(sw.broker, sw.log, run, saved)
+			}
 		}
 
 	} else {
@@ -175,10 +211,14 @@ func (sw *statusWorker) processEKSRun(run state.Run) {
 			sw.logStatusUpdate(updatedRun)
 			if updatedRun.ExitCode != nil {
 				go sw.cleanupRun(run.RunID)
+				go sw.analyzeExit(run.RunID)
 			}
-			_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun)
+			saved, err := sw.sm.UpdateRun(updatedRun.RunID, updatedRun, state.TransitionSourceStatusWorker)
 			if err != nil {
 				_ = sw.log.Log("message", "unable to save eks runs", "error", fmt.Sprintf("%+v", err))
+			} else {
+				notifyWebhooks(sw.webhooks, sw.log, saved, run.Status)
+				publishRunEvents(sw.broker, sw.log, run, saved)
 			}
 
 			if updatedRun.Status == state.StatusStopped {
@@ -193,7 +233,10 @@ func (sw *statusWorker) processEKSRun(run state.Run) {
 				updatedRun.Memory != run.Memory ||
 				updatedRun.PodEvents != run.PodEvents ||
 				updatedRun.SpawnedRuns != run.SpawnedRuns {
-				_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun)
+				saved, err := sw.sm.UpdateRun(updatedRun.RunID, updatedRun, state.TransitionSourceStatusWorker)
+				if err == nil {
+					publishRunEvents(sw.broker, sw.log, run, saved)
+				}
 			}
 		}
 	}
@@ -209,26 +252,78 @@ func (sw *statusWorker) cleanupRun(runID string) {
 	}
 }
 
-func (sw *statusWorker) extractExceptions(runID string) {
+//
+// analyzeExit stores the exceptions of a stopped run and, if it failed, the
+// exit reason and category of the first exit reason rule that matches it
+//
+func (sw *statusWorker) analyzeExit(runID string) {
 	//Logs maybe delayed before being persisted to S3.
 	time.Sleep(60 * time.Second)
-	run, err := sw.sm.GetRun(runID)
-	if err == nil {
-		jobUrl := fmt.Sprintf("%s/extract/%s", sw.exceptionExtractorUrl, run.RunID)
-		res, err := sw.exceptionExtractorClient.Get(jobUrl)
-		if err == nil && res != nil && res.Body != nil {
-			body, err := ioutil.ReadAll(res.Body)
-			if body != nil {
-				defer res.Body.Close()
-				runExceptions := state.RunExceptions{}
-				err = json.Unmarshal(body, &runExceptions)
-				if err == nil {
-					run.RunExceptions = &runExceptions
-				}
-			}
-			_, _ = sw.sm.UpdateRun(run.RunID, run)
+	var update state.Run
+	runExceptions, err := sw.extractExceptions(runID)
+	if err != nil {
+		_ = sw.log.Log("message", "unable to extract exceptions", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+	} else if runExceptions != nil {
+		update.RunExceptions = &runExceptions
+	}
+
+	if run, err := sw.sm.GetRun(runID); err != nil {
+		_ = sw.log.Log("message", "unable to classify exit", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+	} else if sw.exitReasons != nil && run.ExitCode != nil && *run.ExitCode != 0 {
+		if update.RunExceptions != nil {
+			run.RunExceptions = update.RunExceptions
+		}
+		match, err := sw.exitReasons.Classify(run)
+		if err != nil {
+			_ = sw.log.Log("message", "unable to classify exit", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+		} else if match != nil {
+			update.ExitReason = &match.Rule.Message
+			update.ExitCategory = &match.Rule.Category
 		}
 	}
+
+	if update.RunExceptions == nil && update.ExitCategory == nil {
+		return
+	}
+	if _, err = sw.sm.UpdateRun(runID, update, state.TransitionSourceStatusWorker); err != nil {
+		_ = sw.log.Log("message", "unable to save exit analysis", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+	}
+}
+
+//
+// extractExceptions returns the exceptions of a stopped run, from the
+// eks.exception_extractor_url service when one is configured and otherwise
+// from the tail of the run's log
+//
+func (sw *statusWorker) extractExceptions(runID string) (state.RunExceptions, error) {
+	if sw.exceptionExtractorClient != nil {
+		return sw.fetchExceptions(runID)
+	}
+	if sw.logs != nil {
+		return sw.logs.Exceptions(runID)
+	}
+	return nil, nil
+}
+
+func (sw *statusWorker) fetchExceptions(runID string) (state.RunExceptions, error) {
+	jobUrl := fmt.Sprintf("%s/extract/%s", sw.exceptionExtractorUrl, runID)
+	res, err := sw.exceptionExtractorClient.Get(jobUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem calling exception extractor")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("exception extractor returned status %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading exception extractor response")
+	}
+	runExceptions := state.RunExceptions{}
+	if err = json.Unmarshal(body, &runExceptions); err != nil {
+		return nil, errors.Wrap(err, "problem decoding exception extractor response")
+	}
+	return runExceptions, nil
 }
 
 func (sw *statusWorker) processEKSRunMetrics(run state.Run) {
@@ -236,7 +331,7 @@ func (sw *statusWorker) processEKSRunMetrics(run state.Run) {
 	if err == nil {
 		if updatedRun.MaxMemoryUsed != run.MaxMemoryUsed ||
 			updatedRun.MaxCpuUsed != run.MaxCpuUsed {
-			_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun)
+			_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun, state.TransitionSourceStatusWorker)
 		}
 	}
 }
@@ -3,15 +3,24 @@ package worker
 import (
 	"fmt"
 	"github.com/go-redis/redis"
+	"github.com/stitchfix/flotilla-os/clients/stream"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
+	"sort"
 	"time"
 )
 
+// quotaListLimit bounds the quotas enforced by the submit worker
+const quotaListLimit = 1000
+
+// quotaHeldRunLimit bounds the runs held back by quotas released per poll
+const quotaHeldRunLimit = 500
+
 type submitWorker struct {
 	sm           state.Manager
 	eksEngine    engine.Engine
@@ -21,15 +30,29 @@ type submitWorker struct {
 	pollInterval time.Duration
 	t            tomb.Tomb
 	redisClient  *redis.Client
+	priorities   state.PriorityTiers
+	broker       stream.Broker
+	webhooks     services.WebhookService
 }
 
-func (sw *submitWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
+func (sw *submitWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
 	sw.pollInterval = pollInterval
 	sw.conf = conf
 	sw.sm = sm
 	sw.eksEngine = eksEngine
 	sw.emrEngine = emrEngine
 	sw.log = log
+	priorities, err := state.NewPriorityTiers(conf, conf.GetString("eks.job_queue"))
+	if err != nil {
+		return err
+	}
+	sw.priorities = priorities
+	if sw.broker, err = stream.NewBroker(conf); err != nil {
+		return err
+	}
+	if sw.webhooks, err = services.NewWebhookService(conf, sm, log); err != nil {
+		return err
+	}
 	sw.redisClient = redis.NewClient(&redis.Options{Addr: conf.GetString("redis_address"), DB: conf.GetInt("redis_db")})
 	_ = sw.log.Log("message", "initialized a submit worker")
 	return nil
@@ -60,12 +83,25 @@ func (sw *submitWorker) runOnce() {
 	var run state.Run
 	var err error
 
+	quotas, err := sw.newQuotaGate()
+	if err != nil {
+		// Without quotas no run can be admitted; leave runs queued
+		sw.log.Log("message", "Error listing quotas", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	sw.releaseQuotaHeldRuns(quotas)
+
 	receipts, err = sw.eksEngine.PollRuns()
 	receiptsEMR, err := sw.emrEngine.PollRuns()
 	receipts = append(receipts, receiptsEMR...)
 	if err != nil {
 		sw.log.Log("message", "Error receiving runs", "error", fmt.Sprintf("%+v", err))
 	}
+
+	// Submit higher priority runs first; runs of a tier keep their order
+	sort.SliceStable(receipts, func(i, j int) bool {
+		return sw.rank(receipts[i]) < sw.rank(receipts[j])
+	})
 	for _, runReceipt := range receipts {
 		if runReceipt.Run == nil {
 			continue
@@ -87,104 +123,250 @@ func (sw *submitWorker) runOnce() {
 		// Only valid to process if it's in the StatusQueued state
 		//
 		if run.Status == state.StatusQueued {
-			var (
-				launched  state.Run
-				retryable bool
-			)
-
-			// 1. Check for existence of run.ExecutableType; set to `task_definition`
-			// if not set.
-			if run.ExecutableType == nil {
-				defaultExecutableType := state.ExecutableTypeDefinition
-				run.ExecutableType = &defaultExecutableType
-			}
-
-			// 2. Check for existence of run.ExecutableID; set to run.DefinitionID if
-			// not set.
-			if run.ExecutableID == nil {
-				defID := run.DefinitionID
-				run.ExecutableID = &defID
-			}
-
-			// 3. Switch by executable type.
-			switch *run.ExecutableType {
-			case state.ExecutableTypeDefinition:
-				var d state.Definition
-				d, err = sw.sm.GetDefinition(*run.ExecutableID)
-
+			if run.QuotaHeldAt != nil {
+				// Already held back; releaseQuotaHeldRuns submits it
+				sw.log.Log("message", "Received run held by quota", "run_id", run.RunID)
+			} else {
+				admitted, err := quotas.admit(run)
 				if err != nil {
-					sw.logFailedToGetExecutableMessage(run, err)
-					if err = runReceipt.Done(); err != nil {
-						sw.log.Log("message", "Acking run failed", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+					// Don't ack; the run is redelivered
+					sw.log.Log("message", "Error checking run quotas", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+					continue
+				}
+				if !admitted {
+					if err = sw.sm.HoldRunForQuota(run.RunID, time.Now()); err != nil {
+						sw.log.Log("message", "Error holding run for quota", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+						continue
 					}
+				} else if !sw.submit(run) {
+					// Don't ack; the run is redelivered
 					continue
 				}
+			}
+		} else {
+			sw.log.Log("message", "Received run that is not runnable", "run_id", run.RunID, "status", run.Status)
+		}
 
-				// Execute the run using the execution engine.
-				if run.Engine == nil || *run.Engine == state.EKSEngine {
-					launched, retryable, err = sw.eksEngine.Execute(d, run, sw.sm)
-				} else {
-					launched, retryable, err = sw.emrEngine.Execute(d, run, sw.sm)
-				}
+		if err = runReceipt.Done(); err != nil {
+			sw.log.Log("message", "Acking run failed", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		}
+	}
+}
 
-				break
-			case state.ExecutableTypeTemplate:
-				var tpl state.Template
-				tpl, err = sw.sm.GetTemplateByID(*run.ExecutableID)
+//
+// releaseQuotaHeldRuns submits runs held back by quotas, oldest first, as
+// their quotas' usage allows. Clearing a run's hold claims it, so a run is
+// submitted by one replica only.
+//
+func (sw *submitWorker) releaseQuotaHeldRuns(quotas *quotaGate) {
+	held, err := sw.sm.ListQuotaHeldRuns(quotaHeldRunLimit)
+	if err != nil {
+		sw.log.Log("message", "Error listing runs held by quota", "error", fmt.Sprintf("%+v", err))
+		return
+	}
 
-				if err != nil {
-					sw.logFailedToGetExecutableMessage(run, err)
-					if err = runReceipt.Done(); err != nil {
-						sw.log.Log("message", "Acking run failed", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
-					}
-					continue
-				}
+	for _, run := range held {
+		admitted, err := quotas.admit(run)
+		if err != nil {
+			sw.log.Log("message", "Error checking run quotas", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+			continue
+		}
+		if !admitted {
+			continue
+		}
 
-				// Execute the run using the execution engine.
-				sw.log.Log("message", "Submitting", "run_id", run.RunID)
-				launched, retryable, err = sw.eksEngine.Execute(tpl, run, sw.sm)
-				break
-			default:
-				// If executable type is invalid; log message and continue processing
-				// other runs.
-				sw.log.Log("message", "submit worker failed", "run_id", run.RunID, "error", "invalid executable type")
-				continue
-			}
+		released, err := sw.sm.ReleaseQuotaHold(run.RunID)
+		if err != nil {
+			sw.log.Log("message", "Error releasing run held by quota", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+			continue
+		}
+		if !released {
+			// Released by another worker
+			continue
+		}
 
-			if err != nil {
-				sw.log.Log("message", "Error executing run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err), "retryable", retryable)
-				if !retryable {
-					// Set status to StatusStopped, and ack
-					launched.Status = state.StatusStopped
-				} else {
-					// Don't change status, don't ack
-					continue
-				}
+		heldAt := *run.QuotaHeldAt
+		run.QuotaHeldAt = nil
+		if !sw.submit(run) {
+			// Hold the run again so it keeps its place
+			if err = sw.sm.HoldRunForQuota(run.RunID, heldAt); err != nil {
+				sw.log.Log("message", "Error holding run for quota", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
 			}
+		}
+	}
+}
 
-			//
-			// Emit event with current definition
-			//
-			err = sw.log.Event("eventClassName", "FlotillaSubmitTask", "executable_id", *run.ExecutableID, "run_id", run.RunID)
-			if err != nil {
-				sw.log.Log("message", "Failed to emit event", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
-			}
+//
+// submit executes a queued run and saves the outcome; it returns false if
+// the run wasn't processed and should be submitted again
+//
+func (sw *submitWorker) submit(run state.Run) bool {
+	var (
+		launched  state.Run
+		retryable bool
+		err       error
+	)
 
-			//
-			// UpdateStatus the status and information of the run;
-			// either the run submitted successfully -or- it did not and is not retryable
-			//
-			if _, err = sw.sm.UpdateRun(run.RunID, launched); err != nil {
-				sw.log.Log("message", "Failed to update run status", "run_id", run.RunID, "status", launched.Status, "error", fmt.Sprintf("%+v", err))
-			}
+	// 1. Check for existence of run.ExecutableType; set to `task_definition`
+	// if not set.
+	if run.ExecutableType == nil {
+		defaultExecutableType := state.ExecutableTypeDefinition
+		run.ExecutableType = &defaultExecutableType
+	}
+
+	// 2. Check for existence of run.ExecutableID; set to run.DefinitionID if
+	// not set.
+	if run.ExecutableID == nil {
+		defID := run.DefinitionID
+		run.ExecutableID = &defID
+	}
+
+	// 3. Switch by executable type.
+	switch *run.ExecutableType {
+	case state.ExecutableTypeDefinition:
+		var d state.Definition
+		d, err = sw.getDefinition(run)
+
+		if err != nil {
+			sw.logFailedToGetExecutableMessage(run, err)
+			return true
+		}
+
+		// Execute the run using the execution engine.
+		if run.Engine == nil || *run.Engine == state.EKSEngine {
+			launched, retryable, err = sw.eksEngine.Execute(d, run, sw.sm)
 		} else {
-			sw.log.Log("message", "Received run that is not runnable", "run_id", run.RunID, "status", run.Status)
+			launched, retryable, err = sw.emrEngine.Execute(d, run, sw.sm)
 		}
 
-		if err = runReceipt.Done(); err != nil {
-			sw.log.Log("message", "Acking run failed", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		break
+	case state.ExecutableTypeTemplate:
+		var tpl state.Template
+		tpl, err = sw.getTemplate(run)
+
+		if err != nil {
+			sw.logFailedToGetExecutableMessage(run, err)
+			return true
+		}
+
+		// Execute the run using the execution engine.
+		sw.log.Log("message", "Submitting", "run_id", run.RunID)
+		launched, retryable, err = sw.eksEngine.Execute(tpl, run, sw.sm)
+		break
+	default:
+		// If executable type is invalid; log message and continue processing
+		// other runs.
+		sw.log.Log("message", "submit worker failed", "run_id", run.RunID, "error", "invalid executable type")
+		return false
+	}
+
+	if err != nil {
+		sw.log.Log("message", "Error executing run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err), "retryable", retryable)
+		if !retryable {
+			// Set status to StatusStopped, and ack
+			launched.Status = state.StatusStopped
+		} else {
+			// Don't change status, don't ack
+			return false
+		}
+	}
+
+	//
+	// Emit event with current definition
+	//
+	err = sw.log.Event("eventClassName", "FlotillaSubmitTask", "executable_id", *run.ExecutableID, "run_id", run.RunID)
+	if err != nil {
+		sw.log.Log("message", "Failed to emit event", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+	}
+
+	//
+	// UpdateStatus the status and information of the run;
+	// either the run submitted successfully -or- it did not and is not retryable
+	//
+	saved, err := sw.sm.UpdateRun(run.RunID, launched, state.TransitionSourceSubmitWorker)
+	if err != nil {
+		sw.log.Log("message", "Failed to update run status", "run_id", run.RunID, "status", launched.Status, "error", fmt.Sprintf("%+v", err))
+	} else {
+		notifyWebhooks(sw.webhooks, sw.log, saved, run.Status)
+		publishRunEvents(sw.broker, sw.log, run, saved)
+	}
+	return true
+}
+
+//
+// quotaGate admits queued runs against the quotas they match. Usage is
+// loaded once per quota per poll and counts the runs admitted since. A quota
+// that holds back a run holds back every later run it matches, so runs are
+// released in the order they were queued.
+//
+type quotaGate struct {
+	sm      state.Manager
+	quotas  []state.Quota
+	usage   map[string]*state.QuotaUsage
+	blocked map[string]bool
+}
+
+func (sw *submitWorker) newQuotaGate() (*quotaGate, error) {
+	ql, err := sw.sm.ListQuotas(quotaListLimit, 0, "name", "asc", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaGate{
+		sm:      sw.sm,
+		quotas:  ql.Quotas,
+		usage:   make(map[string]*state.QuotaUsage),
+		blocked: make(map[string]bool),
+	}, nil
+}
+
+//
+// admit returns whether run fits every quota it matches; an admitted run is
+// counted against those quotas
+//
+func (g *quotaGate) admit(run state.Run) (bool, error) {
+	var matched []*state.QuotaUsage
+	admitted := true
+	for _, q := range g.quotas {
+		if !q.Matches(run) {
+			continue
+		}
+		if g.blocked[q.Key()] {
+			admitted = false
+			continue
+		}
+
+		usage, ok := g.usage[q.Key()]
+		if !ok {
+			loaded, err := g.sm.GetQuotaUsage(q)
+			if err != nil {
+				return false, err
+			}
+			usage = &loaded
+			g.usage[q.Key()] = usage
+		}
+		if !q.Admits(*usage, run) {
+			g.blocked[q.Key()] = true
+			admitted = false
 		}
+		matched = append(matched, usage)
+	}
+
+	if admitted {
+		for _, usage := range matched {
+			usage.Add(run)
+		}
+	}
+	return admitted, nil
+}
+
+//
+// rank returns the priority rank of a received run; the highest tier ranks 0
+//
+func (sw *submitWorker) rank(receipt engine.RunReceipt) int {
+	if receipt.Run == nil {
+		return sw.priorities.Rank(nil)
 	}
+	return sw.priorities.Rank(receipt.Run.Priority)
 }
 
 func (sw *submitWorker) logFailedToGetExecutableMessage(run state.Run, err error) {
@@ -195,3 +377,39 @@ func (sw *submitWorker) logFailedToGetExecutableMessage(run state.Run, err error
 		"executable_type", run.ExecutableType,
 		"error", err.Error())
 }
+
+//
+// getDefinition returns the definition snapshot the run is pinned to, falling
+// back to the current definition for runs created before snapshots existed
+//
+func (sw *submitWorker) getDefinition(run state.Run) (state.Definition, error) {
+	if run.ExecutableSnapshotID == nil {
+		return sw.sm.GetDefinition(*run.ExecutableID)
+	}
+	snapshot, err := sw.sm.GetExecutableSnapshot(*run.ExecutableSnapshotID)
+	if err != nil {
+		return state.Definition{}, err
+	}
+	if snapshot.Definition == nil {
+		return state.Definition{}, fmt.Errorf("snapshot [%s] does not hold a definition", snapshot.SnapshotID)
+	}
+	return *snapshot.Definition, nil
+}
+
+//
+// getTemplate returns the template snapshot the run is pinned to, falling
+// back to the current template for runs created before snapshots existed
+//
+func (sw *submitWorker) getTemplate(run state.Run) (state.Template, error) {
+	if run.ExecutableSnapshotID == nil {
+		return sw.sm.GetTemplateByID(*run.ExecutableID)
+	}
+	snapshot, err := sw.sm.GetExecutableSnapshot(*run.ExecutableSnapshotID)
+	if err != nil {
+		return state.Template{}, err
+	}
+	if snapshot.Template == nil {
+		return state.Template{}, fmt.Errorf("snapshot [%s] does not hold a template", snapshot.SnapshotID)
+	}
+	return *snapshot.Template, nil
+}
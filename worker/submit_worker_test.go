@@ -114,7 +114,7 @@ func TestSubmitWorker_Run(t *testing.T) {
 	worker, imp := setUpSubmitWorkerTest1(t)
 	worker.runOnce()
 
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -132,7 +132,7 @@ func TestSubmitWorker_Run2(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute is NOT called and it -is- acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -151,7 +151,7 @@ func TestSubmitWorker_Run3(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute is NOT called and it -is- acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -173,7 +173,7 @@ func TestSubmitWorker_Run4(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute is called and it -is- acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -201,7 +201,7 @@ func TestSubmitWorker_Run5(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute it called but it is not updated nor is it acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute"}
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -212,3 +212,117 @@ func TestSubmitWorker_Run5(t *testing.T) {
 		}
 	}
 }
+
+func TestSubmitWorker_Run6(t *testing.T) {
+	// Test that a run pinned to a snapshot executes the snapshot rather than
+	// the current definition
+	worker, imp := setUpSubmitWorkerTest1(t)
+
+	snapshot, err := state.NewExecutableSnapshot(imp.Definitions["def:cupcake"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp.CreateExecutableSnapshot(snapshot)
+	delete(imp.Definitions, "def:cupcake")
+
+	run := imp.Runs["run:cupcake"]
+	run.ExecutableSnapshotID = &snapshot.SnapshotID
+	imp.Runs["run:cupcake"] = run
+	imp.Calls = nil
+
+	worker.runOnce()
+
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "GetExecutableSnapshot", "Execute", "UpdateRun", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
+	}
+
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+}
+
+func TestSubmitWorker_Quota(t *testing.T) {
+	// Test that runs over quota are held and released in order as the quota
+	// frees up
+	worker, imp := setUpSubmitWorkerTest1(t)
+	maxRuns := int64(1)
+	imp.Quotas = map[string]state.Quota{
+		"group/g": {Scope: state.QuotaScopeGroup, Name: "g", MaxRuns: &maxRuns},
+	}
+	imp.Runs["run:running"] = state.Run{RunID: "run:running", DefinitionID: "def:cupcake", GroupName: "g", Status: state.StatusRunning}
+	imp.Runs["run:a"] = state.Run{RunID: "run:a", DefinitionID: "def:cupcake", GroupName: "g", Status: state.StatusQueued}
+	imp.Runs["run:b"] = state.Run{RunID: "run:b", DefinitionID: "def:cupcake", GroupName: "g", Status: state.StatusQueued}
+	imp.Queued = []string{"run:a"}
+
+	worker.runOnce()
+
+	expected := []string{"ListQuotas", "ListQuotaHeldRuns", "PollRuns", "PollRuns", "GetRun", "GetQuotaUsage", "HoldRunForQuota", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	if imp.Runs["run:a"].QuotaHeldAt == nil {
+		t.Errorf("Expected run:a to be held by quota")
+	}
+
+	// The quota frees up; the held run is released ahead of the next
+	running := imp.Runs["run:running"]
+	running.Status = state.StatusStopped
+	imp.Runs["run:running"] = running
+	imp.Queued = []string{"run:b"}
+	imp.Calls = nil
+
+	worker.runOnce()
+
+	expected = []string{
+		"ListQuotas", "ListQuotaHeldRuns", "GetQuotaUsage", "ReleaseQuotaHold", "GetDefinition", "Execute", "UpdateRun",
+		"PollRuns", "PollRuns", "GetRun", "HoldRunForQuota", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	if imp.Runs["run:a"].QuotaHeldAt != nil {
+		t.Errorf("Expected run:a to be released")
+	}
+	if imp.Runs["run:b"].QuotaHeldAt == nil {
+		t.Errorf("Expected run:b to be held by quota")
+	}
+}
+
+func TestSubmitWorker_Priority(t *testing.T) {
+	// Test that higher priority runs are submitted first; with room for one
+	// run under the quota, the low priority run received first is held
+	worker, imp := setUpSubmitWorkerTest1(t)
+	worker.priorities = state.PriorityTiers{
+		Tiers:   []state.PriorityTier{{Name: "high", Weight: 1}, {Name: "low", Weight: 1}},
+		Default: "low",
+	}
+	high, low := "high", "low"
+	maxRuns := int64(1)
+	imp.Quotas = map[string]state.Quota{
+		"group/g": {Scope: state.QuotaScopeGroup, Name: "g", MaxRuns: &maxRuns},
+	}
+	imp.Runs["run:low"] = state.Run{RunID: "run:low", DefinitionID: "def:cupcake", GroupName: "g", Status: state.StatusQueued, Priority: &low}
+	imp.Runs["run:high"] = state.Run{RunID: "run:high", DefinitionID: "def:cupcake", GroupName: "g", Status: state.StatusQueued, Priority: &high}
+	imp.Queued = []string{"run:low", "run:high"}
+
+	worker.runOnce()
+
+	if imp.Runs["run:high"].QuotaHeldAt != nil {
+		t.Errorf("Expected run:high to be submitted")
+	}
+	if imp.Runs["run:low"].QuotaHeldAt == nil {
+		t.Errorf("Expected run:low to be held by quota")
+	}
+}
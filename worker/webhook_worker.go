@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/stitchfix/flotilla-os/queue"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/engine"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"gopkg.in/tomb.v2"
+)
+
+// defaultWebhookInterval is used when worker.webhook_interval is unset
+var defaultWebhookInterval = 5 * time.Second
+
+// webhookDeliveryLimit bounds the deliveries attempted per poll
+const webhookDeliveryLimit = 100
+
+// webhookDeliveryLease is how long a claimed delivery is hidden from other
+// replicas; it outlasts an attempt with all of its retries
+const webhookDeliveryLease = 5 * time.Minute
+
+type webhookWorker struct {
+	sm           state.Manager
+	ws           services.WebhookService
+	conf         config.Config
+	log          flotillaLog.Logger
+	pollInterval time.Duration
+	t            tomb.Tomb
+}
+
+func (ww *webhookWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
+	ww.pollInterval = pollInterval
+	if ww.pollInterval <= 0 {
+		ww.pollInterval = defaultWebhookInterval
+	}
+	ws, err := services.NewWebhookService(conf, sm, log)
+	if err != nil {
+		return err
+	}
+	ww.conf = conf
+	ww.sm = sm
+	ww.ws = ws
+	ww.log = log
+	ww.log.Log("message", "initialized a webhook worker")
+	return nil
+}
+
+func (ww *webhookWorker) GetTomb() *tomb.Tomb {
+	return &ww.t
+}
+
+//
+// Run delivers pending webhook deliveries
+//
+func (ww *webhookWorker) Run() error {
+	for {
+		select {
+		case <-ww.t.Dying():
+			ww.log.Log("message", "A webhook worker was terminated")
+			return nil
+		default:
+			ww.runOnce()
+			time.Sleep(ww.pollInterval)
+		}
+	}
+}
+
+//
+// runOnce claims the deliveries that are due and attempts each. Claims are
+// leased, so replicas don't send the same delivery concurrently.
+//
+func (ww *webhookWorker) runOnce() {
+	due, err := ww.sm.ClaimWebhookDeliveries(time.Now(), webhookDeliveryLease, webhookDeliveryLimit)
+	if err != nil {
+		ww.log.Log("message", "Error claiming webhook deliveries", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+
+	for _, d := range due {
+		delivered, err := ww.ws.Deliver(d)
+		if err != nil {
+			ww.log.Log("message", "Error recording webhook delivery", "delivery_id", d.DeliveryID, "error", fmt.Sprintf("%+v", err))
+		} else if delivered.Status != state.WebhookDeliveryDelivered {
+			ww.log.Log("message", "Webhook delivery failed", "delivery_id", d.DeliveryID,
+				"webhook_id", d.WebhookID, "attempts", delivered.Attempts, "status", delivered.Status)
+		}
+	}
+}
+
+//
+// notifyWebhooks queues the webhook deliveries of a run's transition from
+// previousStatus; failures are logged and don't hold up the transition
+//
+func notifyWebhooks(ws services.WebhookService, log flotillaLog.Logger, run state.Run, previousStatus string) {
+	if ws == nil {
+		return
+	}
+	if err := ws.Notify(run, previousStatus); err != nil {
+		_ = log.Log("message", "unable to queue webhook deliveries", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+	}
+}
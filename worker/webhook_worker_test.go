@@ -0,0 +1,67 @@
+package worker
+
+import (
+	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/config"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWebhookWorker_Run(t *testing.T) {
+	// Test that due deliveries are sent and recorded while deliveries that
+	// aren't due yet are left alone
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	logger := flotillaLog.NewLogger(l, nil)
+
+	now := time.Now()
+	later := now.Add(time.Hour)
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Webhooks: map[string]state.Webhook{
+			"wh-1": {WebhookID: "wh-1", Scope: state.WebhookScopeRun, Name: "r", URL: server.URL, Secret: "s"},
+		},
+		WebhookDeliveries: map[string]state.WebhookDelivery{
+			"whd-due": {DeliveryID: "whd-due", WebhookID: "wh-1", RunID: "r", Event: state.WebhookEventRunning,
+				Payload: []byte(`{}`), Status: state.WebhookDeliveryPending, NextAttemptAt: &now},
+			"whd-later": {DeliveryID: "whd-later", WebhookID: "wh-1", RunID: "r", Event: state.WebhookEventFailed,
+				Payload: []byte(`{}`), Status: state.WebhookDeliveryPending, NextAttemptAt: &later},
+		},
+	}
+	// httptest servers listen on loopback
+	os.Setenv("WEBHOOK_ALLOWED_HOSTS", "127.0.0.1")
+	defer os.Unsetenv("WEBHOOK_ALLOWED_HOSTS")
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	ws, _ := services.NewWebhookService(c, &imp, &imp)
+	worker := &webhookWorker{sm: &imp, ws: ws, log: logger}
+
+	worker.runOnce()
+
+	expected := []string{"ClaimWebhookDeliveries", "GetWebhook", "UpdateWebhookDelivery"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	if d := imp.WebhookDeliveries["whd-due"]; d.Status != state.WebhookDeliveryDelivered || d.Attempts != 1 {
+		t.Errorf("Expected the due delivery to be delivered, got %+v", d)
+	}
+	if d := imp.WebhookDeliveries["whd-later"]; d.Status != state.WebhookDeliveryPending || d.Attempts != 0 {
+		t.Errorf("Expected the later delivery to be left pending, got %+v", d)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
 )
@@ -17,7 +18,7 @@ import (
 // Worker defines a background worker process
 //
 type Worker interface {
-	Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error
+	Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error
 	Run() error
 	GetTomb() *tomb.Tomb
 }
@@ -25,7 +26,7 @@ type Worker interface {
 //
 // NewWorker instantiates a new worker.
 //
-func NewWorker(workerType string, log flotillaLog.Logger, conf config.Config, eksEngine engine.Engine, emrEngine engine.Engine, sm state.Manager, qm queue.Manager) (Worker, error) {
+func NewWorker(workerType string, log flotillaLog.Logger, conf config.Config, eksEngine engine.Engine, emrEngine engine.Engine, sm state.Manager, qm queue.Manager, es services.ExecutionService) (Worker, error) {
 	var worker Worker
 
 	switch workerType {
@@ -41,12 +42,20 @@ func NewWorker(workerType string, log flotillaLog.Logger, conf config.Config, ek
 		worker = &cloudtrailWorker{}
 	case "events":
 		worker = &eventsWorker{}
+	case "scheduler":
+		worker = &schedulerWorker{}
+	case "workflow":
+		worker = &workflowWorker{}
+	case "array":
+		worker = &arrayWorker{}
+	case "webhook":
+		worker = &webhookWorker{}
 	default:
 		return nil, errors.Errorf("no workerType [%s] exists", workerType)
 	}
 
 	pollInterval, err := GetPollInterval(workerType, conf)
-	if err = worker.Initialize(conf, sm, eksEngine, emrEngine, log, pollInterval, qm); err != nil {
+	if err = worker.Initialize(conf, sm, eksEngine, emrEngine, log, pollInterval, qm, es); err != nil {
 		return worker, errors.Wrapf(err, "problem initializing worker [%s]", workerType)
 	}
 	return worker, nil
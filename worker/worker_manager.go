@@ -10,6 +10,7 @@ import (
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 )
 
@@ -24,15 +25,17 @@ type workerManager struct {
 	t            tomb.Tomb
 	engine       *string
 	qm           queue.Manager
+	es           services.ExecutionService
 }
 
-func (wm *workerManager) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
+func (wm *workerManager) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
 	wm.conf = conf
 	wm.log = log
 	wm.eksEngine = eksEngine
 	wm.emrEngine = emrEngine
 	wm.sm = sm
 	wm.qm = qm
+	wm.es = es
 	wm.pollInterval = pollInterval
 
 	if err := wm.InitializeWorkers(); err != nil {
@@ -48,7 +51,7 @@ func (wm *workerManager) GetTomb() *tomb.Tomb {
 
 //
 // InitializeWorkers will first check the DB for the total count per instance
-// of each worker type (retry, submit, or status), start each worker's  `Run`
+// of each worker type (retry, submit, status or scheduler), start each worker's  `Run`
 // goroutine via tomb, then append the worker to the appropriate slice.
 //
 func (wm *workerManager) InitializeWorkers() error {
@@ -65,7 +68,7 @@ func (wm *workerManager) InitializeWorkers() error {
 		wm.workers[w.WorkerType] = make([]Worker, w.CountPerInstance)
 		for i := 0; i < w.CountPerInstance; i++ {
 			// Instantiate a new worker.
-			wk, err := NewWorker(w.WorkerType, wm.log, wm.conf, wm.eksEngine, wm.emrEngine, wm.sm, wm.qm)
+			wk, err := NewWorker(w.WorkerType, wm.log, wm.conf, wm.eksEngine, wm.emrEngine, wm.sm, wm.qm, wm.es)
 
 			if err != nil {
 				return err
@@ -157,7 +160,7 @@ func (wm *workerManager) removeWorker(workerType string) error {
 }
 
 func (wm *workerManager) addWorker(workerType string) error {
-	wk, err := NewWorker(workerType, wm.log, wm.conf, wm.eksEngine, wm.emrEngine, wm.sm, wm.qm)
+	wk, err := NewWorker(workerType, wm.log, wm.conf, wm.eksEngine, wm.emrEngine, wm.sm, wm.qm, wm.es)
 
 	if err != nil {
 		return err
@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/stitchfix/flotilla-os/queue"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/engine"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"gopkg.in/tomb.v2"
+)
+
+// defaultWorkflowInterval is used when worker.workflow_interval is unset
+var defaultWorkflowInterval = 10 * time.Second
+
+// workflowLease is how long a claimed workflow is reserved for this worker;
+// a worker that dies mid-advance releases its workflows when the lease ends
+var workflowLease = 5 * time.Minute
+
+// workflowClaimLimit bounds the workflows advanced per poll
+const workflowClaimLimit = 25
+
+// workflowUser is recorded as the actor terminating the runs of cancelled
+// workflows
+var workflowUser = state.UserInfo{Name: "workflow"}
+
+type workflowWorker struct {
+	sm           state.Manager
+	es           services.ExecutionService
+	conf         config.Config
+	log          flotillaLog.Logger
+	pollInterval time.Duration
+	t            tomb.Tomb
+}
+
+func (ww *workflowWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager, es services.ExecutionService) error {
+	ww.pollInterval = pollInterval
+	if ww.pollInterval <= 0 {
+		ww.pollInterval = defaultWorkflowInterval
+	}
+	ww.conf = conf
+	ww.sm = sm
+	ww.es = es
+	ww.log = log
+	ww.log.Log("message", "initialized a workflow worker")
+	return nil
+}
+
+func (ww *workflowWorker) GetTomb() *tomb.Tomb {
+	return &ww.t
+}
+
+//
+// Run advances running workflows
+//
+func (ww *workflowWorker) Run() error {
+	for {
+		select {
+		case <-ww.t.Dying():
+			ww.log.Log("message", "A workflow worker was terminated")
+			return nil
+		default:
+			ww.runOnce()
+			time.Sleep(ww.pollInterval)
+		}
+	}
+}
+
+//
+// runOnce claims running workflows, advances each and saves its progress.
+// Claiming leases a workflow so that only one worker across replicas
+// advances it at a time.
+//
+func (ww *workflowWorker) runOnce() {
+	active, err := ww.sm.ClaimActiveWorkflows(time.Now(), workflowLease, workflowClaimLimit)
+	if err != nil {
+		ww.log.Log("message", "Error claiming active workflows", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+
+	for _, w := range active {
+		ww.advance(&w)
+		if err = ww.sm.UpdateWorkflow(w); err != nil {
+			ww.log.Log("message", "Error updating workflow", "workflow_id", w.WorkflowID, "error", fmt.Sprintf("%+v", err))
+		}
+	}
+}
+
+//
+// advance moves a workflow forward: running nodes pick up the outcome of
+// their runs, then every node whose dependencies are met is started
+//
+func (ww *workflowWorker) advance(w *state.Workflow) {
+	if w.CancelRequested {
+		ww.cancel(w)
+		w.RefreshStatus(time.Now())
+		return
+	}
+
+	for i := range w.Nodes {
+		if w.Nodes[i].Status == state.WorkflowNodeRunning {
+			ww.refreshNode(w, &w.Nodes[i])
+		}
+	}
+
+	// Nodes failing to start may make others ready
+	for ready := w.ReadyNodes(); len(ready) > 0; ready = w.ReadyNodes() {
+		for _, i := range ready {
+			ww.startNode(w, &w.Nodes[i])
+		}
+	}
+	w.RefreshStatus(time.Now())
+}
+
+//
+// refreshNode sets a running node's status from its run. Retries of the run
+// are followed, so a node only fails once its run's retry policy gives up.
+//
+func (ww *workflowWorker) refreshNode(w *state.Workflow, n *state.WorkflowNode) {
+	if n.RunID == nil {
+		return
+	}
+
+	run, err := ww.sm.GetRun(*n.RunID)
+	for err == nil && run.RetryRunID != nil {
+		run, err = ww.sm.GetRun(*run.RetryRunID)
+	}
+	if err != nil {
+		ww.log.Log("message", "Error getting workflow node run", "workflow_id", w.WorkflowID, "node", n.Name, "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	runID := run.RunID
+	n.RunID = &runID
+
+	if state.IsAwaitingRetry(run) {
+		// Wait for the retry worker to queue the next attempt
+		return
+	}
+	n.Status = state.WorkflowNodeStatus(run)
+}
+
+func (ww *workflowWorker) startNode(w *state.Workflow, n *state.WorkflowNode) {
+	run, err := ww.es.CreateWorkflowRun(w.WorkflowID, *n)
+	if err != nil {
+		ww.log.Log("message", "Error creating workflow node run", "workflow_id", w.WorkflowID, "node", n.Name, "error", fmt.Sprintf("%+v", err))
+		message := fmt.Sprintf("unable to create run: %v", err)
+		n.Status = state.WorkflowNodeFailed
+		n.Message = &message
+		return
+	}
+	runID := run.RunID
+	n.RunID = &runID
+	n.Status = state.WorkflowNodeRunning
+}
+
+//
+// cancel terminates the runs of running nodes through the execution service
+// and cancels every node that hasn't finished
+//
+func (ww *workflowWorker) cancel(w *state.Workflow) {
+	for _, n := range w.Nodes {
+		if n.Status != state.WorkflowNodeRunning || n.RunID == nil {
+			continue
+		}
+		if err := ww.es.Terminate(*n.RunID, workflowUser); err != nil {
+			ww.log.Log("message", "Error terminating workflow node run", "workflow_id", w.WorkflowID, "node", n.Name, "run_id", *n.RunID, "error", fmt.Sprintf("%+v", err))
+		}
+	}
+	w.Cancel()
+}
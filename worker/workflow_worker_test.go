@@ -0,0 +1,156 @@
+package worker
+
+import (
+	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/config"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/services"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+	"os"
+	"testing"
+)
+
+func setUpWorkflowWorkerTest(t *testing.T) (*workflowWorker, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	logger := flotillaLog.NewLogger(l, nil)
+
+	engine := state.DefaultEngine
+	node := func(name string, deps ...state.WorkflowDependency) state.WorkflowNode {
+		return state.WorkflowNode{
+			Name:           name,
+			ExecutableType: state.ExecutableTypeDefinition,
+			ExecutableID:   "A",
+			DefinitionRequest: &state.DefinitionExecutionRequest{
+				ExecutionRequestCommon: &state.ExecutionRequestCommon{OwnerID: "somebody", Engine: &engine},
+			},
+			DependsOn: deps,
+			Status:    state.WorkflowNodePending,
+		}
+	}
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Definitions: map[string]state.Definition{
+			"A": {DefinitionID: "A"},
+		},
+		Runs: map[string]state.Run{},
+		Workflows: map[string]state.Workflow{
+			"wf-a": {
+				WorkflowID: "wf-a",
+				Status:     state.WorkflowStatusRunning,
+				Nodes: state.WorkflowNodes{
+					node("extract"),
+					node("load", state.WorkflowDependency{Node: "extract", Condition: state.WorkflowConditionOnSuccess}),
+					node("alert", state.WorkflowDependency{Node: "extract", Condition: state.WorkflowConditionOnFailure}),
+				},
+			},
+		},
+		Qurls: map[string]string{
+			"A": "a/",
+		},
+	}
+	es, _ := services.NewExecutionService(c, &imp, &imp, &imp, &imp, &imp)
+	return &workflowWorker{
+		sm:  &imp,
+		es:  es,
+		log: logger,
+	}, &imp
+}
+
+func stopRun(imp *testutils.ImplementsAllTheThings, runID string, exitCode int64) {
+	run := imp.Runs[runID]
+	run.Status = state.StatusStopped
+	run.ExitCode = &exitCode
+	imp.Runs[runID] = run
+}
+
+func TestWorkflowWorker_RunOnce(t *testing.T) {
+	ww, imp := setUpWorkflowWorkerTest(t)
+
+	ww.runOnce()
+	w := imp.Workflows["wf-a"]
+	extract := w.Nodes[0]
+	if extract.Status != state.WorkflowNodeRunning || extract.RunID == nil {
+		t.Fatalf("Expected extract to be started, was %s", extract.Status)
+	}
+	if w.Nodes[1].Status != state.WorkflowNodePending || w.Nodes[2].Status != state.WorkflowNodePending {
+		t.Errorf("Expected dependent nodes to wait for extract")
+	}
+
+	stopRun(imp, *extract.RunID, 0)
+	ww.runOnce()
+	w = imp.Workflows["wf-a"]
+	load := w.Nodes[1]
+	if w.Nodes[0].Status != state.WorkflowNodeSucceeded {
+		t.Errorf("Expected extract to succeed, was %s", w.Nodes[0].Status)
+	}
+	if load.Status != state.WorkflowNodeRunning || load.RunID == nil {
+		t.Fatalf("Expected load to be started, was %s", load.Status)
+	}
+	if w.Nodes[2].Status != state.WorkflowNodeSkipped {
+		t.Errorf("Expected alert to be skipped, was %s", w.Nodes[2].Status)
+	}
+
+	// A run waiting to be retried keeps its node running
+	stopRun(imp, *load.RunID, 137)
+	run := imp.Runs[*load.RunID]
+	run.RetryState = state.RetryStateScheduled
+	imp.Runs[*load.RunID] = run
+	ww.runOnce()
+	if w = imp.Workflows["wf-a"]; w.Nodes[1].Status != state.WorkflowNodeRunning {
+		t.Errorf("Expected load to wait for its retry, was %s", w.Nodes[1].Status)
+	}
+
+	run.RetryState = ""
+	imp.Runs[*load.RunID] = run
+	ww.runOnce()
+	w = imp.Workflows["wf-a"]
+	if w.Nodes[1].Status != state.WorkflowNodeFailed {
+		t.Errorf("Expected load to fail, was %s", w.Nodes[1].Status)
+	}
+	if w.Status != state.WorkflowStatusFailed || w.FinishedAt == nil {
+		t.Errorf("Expected workflow to fail, was %s", w.Status)
+	}
+}
+
+func TestWorkflowWorker_StartNodeOnce(t *testing.T) {
+	ww, imp := setUpWorkflowWorkerTest(t)
+	pending := imp.Workflows["wf-a"]
+
+	ww.runOnce()
+	started := imp.Workflows["wf-a"].Nodes[0]
+	if started.RunID == nil {
+		t.Fatalf("Expected extract to be started, was %s", started.Status)
+	}
+
+	// The workflow's update is lost, so its nodes are started again
+	imp.Workflows["wf-a"] = pending
+	ww.runOnce()
+	restarted := imp.Workflows["wf-a"].Nodes[0]
+	if restarted.RunID == nil || *restarted.RunID != *started.RunID {
+		t.Errorf("Expected extract to get its first run %s again, got %v", *started.RunID, restarted.RunID)
+	}
+	if len(imp.Runs) != 1 {
+		t.Errorf("Expected a single run, got %d", len(imp.Runs))
+	}
+}
+
+func TestWorkflowWorker_Cancel(t *testing.T) {
+	ww, imp := setUpWorkflowWorkerTest(t)
+	w := imp.Workflows["wf-a"]
+	w.CancelRequested = true
+	imp.Workflows["wf-a"] = w
+
+	ww.runOnce()
+	w = imp.Workflows["wf-a"]
+	if w.Status != state.WorkflowStatusCancelled {
+		t.Errorf("Expected workflow to be cancelled, was %s", w.Status)
+	}
+	for _, n := range w.Nodes {
+		if n.Status != state.WorkflowNodeCancelled || n.RunID != nil {
+			t.Errorf("Expected node %s to be cancelled without a run, was %s", n.Name, n.Status)
+		}
+	}
+}